@@ -6,7 +6,9 @@ import (
 
 	"github.com/urfave/cli"
 
+	"bitbucket.org/udt/wizefs/internal/auth"
 	"bitbucket.org/udt/wizefs/internal/command"
+	"bitbucket.org/udt/wizefs/internal/globals"
 	"bitbucket.org/udt/wizefs/internal/tlog"
 )
 
@@ -32,6 +34,45 @@ var Commands = []cli.Command{
 		Name:    "create",
 		Aliases: []string{"c"},
 		Usage:   "Create new Bucket to Storage",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "fragment-size",
+				Value: 0,
+				Usage: "Store files as fragments of this size in bytes " +
+					"(LZFS design suggests 8192), 0 - store files as a whole",
+			},
+			cli.IntFlag{
+				Name:  "micro-fragment-size",
+				Value: 0,
+				Usage: "Split every fragment into micro-fragments of this size in bytes " +
+					"(LZFS design suggests 2048), it should divide fragment size evenly, " +
+					"0 - turned off",
+			},
+			cli.StringFlag{
+				Name:   "password",
+				Usage:  "Encrypt the Bucket with this password (AES-GCM contents, EME names)",
+				EnvVar: globals.PasswordEnvVar,
+			},
+			cli.StringFlag{
+				Name:  "codec",
+				Usage: "Codec of the LZFS zip archive (see bench-codec), default - deflate",
+			},
+			cli.IntFlag{
+				Name:  "codec-level",
+				Value: 0,
+				Usage: "Compression level of the codec, 0 - the default level",
+			},
+			cli.StringFlag{
+				Name: "auto-mount",
+				Usage: "Let put, get and remove use the Bucket when it's not mounted: " +
+					"keep - mount it until it's idle, once - mount it for the operation, " +
+					"direct - use the origin directory (unencrypted directory Buckets only)",
+			},
+			cli.DurationFlag{
+				Name:  "auto-mount-ttl",
+				Usage: "Idle timeout of the keep auto-mount mode (default 10m)",
+			},
+		},
 		Before: func(c *cli.Context) error {
 			tlog.Debug.Printf("Before create...")
 			return nil
@@ -52,6 +93,21 @@ var Commands = []cli.Command{
 		Name:    "mount",
 		Aliases: []string{"m"},
 		Usage:   "Mount Bucket",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "password",
+				Usage:  "Password of the encrypted Bucket",
+				EnvVar: globals.PasswordEnvVar,
+			},
+			cli.Int64Flag{
+				Name:  "memory-limit",
+				Usage: "Memory limit of a ZipFS Bucket in MiB (default 256)",
+			},
+			cli.DurationFlag{
+				Name:  "idle-ttl",
+				Usage: "Unmount the Bucket after this time without activity, like 10m (default never)",
+			},
+		},
 		Before: func(c *cli.Context) error {
 			tlog.Debug.Printf("Before mount...")
 			return nil
@@ -69,10 +125,92 @@ var Commands = []cli.Command{
 		Action:  command.CmdUnmountFilesystem,
 	},
 	{
-		Name:    "put",
-		Aliases: []string{"p"},
-		Usage:   "Put file to Bucket",
-		Action:  command.CmdPutFile,
+		Name:  "list",
+		Usage: "List Buckets of Storage with their type, mount state, size and file count",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the list as JSON",
+			},
+		},
+		Action: command.CmdListFilesystems,
+	},
+	{
+		Name:  "locks",
+		Usage: "List the locks of Storage and Buckets with the processes holding them",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the list as JSON",
+			},
+		},
+		Action: command.CmdListLocks,
+	},
+	{
+		Name:      "verify",
+		Usage:     "Check the metadata store against the Buckets on disk, their configs and archive CRCs",
+		ArgsUsage: "[ORIGIN]",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the problems as JSON",
+			},
+		},
+		Action: command.CmdVerifyStorage,
+	},
+	{
+		Name:  "clean",
+		Usage: "Repair the problems found by verify",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only show what would be repaired",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the problems as JSON",
+			},
+		},
+		Action: command.CmdCleanStorage,
+	},
+	{
+		Name:      "session",
+		Usage:     "Show the idle timeout of the mounted Bucket and the time until it's unmounted",
+		ArgsUsage: "ORIGIN",
+		Action:    command.CmdSessionFilesystem,
+	},
+	{
+		Name:      "auto-mount",
+		Usage:     "Set the auto-mount mode of the Bucket: keep, once, direct or off",
+		ArgsUsage: "ORIGIN MODE",
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:  "idle-ttl",
+				Usage: "Idle timeout of the keep mode (default 10m)",
+			},
+		},
+		Action: command.CmdAutoMountFilesystem,
+	},
+	{
+		Name:      "put",
+		Aliases:   []string{"p"},
+		Usage:     "Put file to Bucket",
+		ArgsUsage: "FILE ORIGIN [PATH]",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "overwrite",
+				Usage: "Replace the existing file",
+			},
+			cli.StringFlag{
+				Name:  "if-match",
+				Usage: "Replace the file only if its ETag (SHA-256, see stat) is ETAG, * - if it exists",
+			},
+			cli.StringFlag{
+				Name:  "if-none-match",
+				Usage: "Put the file only if its ETag is not ETAG, * - if it doesn't exist",
+			},
+		},
+		Action: command.CmdPutFile,
 	},
 	{
 		Name:    "get",
@@ -86,6 +224,61 @@ var Commands = []cli.Command{
 		Usage:   "Remove file from Bucket",
 		Action:  command.CmdRemoveFile,
 	},
+	{
+		Name:      "ls",
+		Usage:     "List files of Bucket whose paths start with PREFIX",
+		ArgsUsage: "ORIGIN [PREFIX]",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "recursive, R",
+				Usage: "List files of all subdirectories",
+			},
+			cli.BoolFlag{
+				Name:  "long, l",
+				Usage: "Show mode, size and modification time",
+			},
+		},
+		Action: command.CmdListFiles,
+	},
+	{
+		Name:      "stat",
+		Usage:     "Show size, mode, modification time and SHA-256 of file in Bucket",
+		ArgsUsage: "FILE ORIGIN",
+		Action:    command.CmdStatFile,
+	},
+	{
+		Name:      "bench-codec",
+		Usage:     "Report compression ratio and throughput of the LZFS codecs on a sample directory",
+		ArgsUsage: "DIR",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "codec",
+				Usage: "Benchmark only this codec",
+			},
+			cli.IntFlag{
+				Name:  "level",
+				Value: 0,
+				Usage: "Benchmark only this level, 0 - all levels",
+			},
+		},
+		Action: command.CmdBenchCodec,
+	},
+	{
+		Name:      "auth-key",
+		Usage:     "Generate API key of user of REST Service and gRPC Server and print it",
+		ArgsUsage: "USER",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "admin",
+				Usage: "Make the user an admin",
+			},
+			cli.StringFlag{
+				Name:  "config",
+				Usage: "Users config, default is " + auth.ConfigFilename + " in the Storage directory",
+			},
+		},
+		Action: command.CmdAuthKey,
+	},
 }
 
 // CommandNotFound implements action when subcommand not found
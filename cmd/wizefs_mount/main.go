@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"os"
 	"runtime"
+	"time"
 
 	"bitbucket.org/udt/wizefs/internal/core"
 	"bitbucket.org/udt/wizefs/internal/globals"
@@ -15,6 +17,10 @@ import (
 type argContainer struct {
 	fg        bool
 	notifypid int
+	password  string
+	// memoryLimit of ZipFS in MiB
+	memoryLimit int64
+	idleTTL     time.Duration
 }
 
 var flagSet *flag.FlagSet
@@ -28,6 +34,12 @@ func parseCliOpts() (args argContainer) {
 	flagSet.BoolVar(&args.fg, "fg", false, "Stay in the foreground")
 	flagSet.IntVar(&args.notifypid, "notifypid", 0, "Send USR1 to the specified process after "+
 		"successful mount - used internally for daemonization")
+	flagSet.StringVar(&args.password, "password", os.Getenv(globals.PasswordEnvVar),
+		"Password of the encrypted Bucket")
+	flagSet.Int64Var(&args.memoryLimit, "memory-limit", 0,
+		"Memory limit of a ZipFS Bucket in MiB (default 256)")
+	flagSet.DurationVar(&args.idleTTL, "idle-ttl", 0,
+		"Unmount the Bucket after this time without activity (default never)")
 
 	// Actual parsing
 	err = flagSet.Parse(os.Args[1:])
@@ -66,6 +78,10 @@ func main() {
 	// Check that ORIGIN exists
 	//fsinfo, ok := storage.Config.Filesystems[origin]
 	fsinfo, _, err := storage.Config.GetInfoByOrigin(origin)
+	if err != nil && !errors.Is(err, core.ErrNotFound) && !errors.Is(err, core.ErrNotMounted) {
+		tlog.Warn.Printf("%v", err)
+		os.Exit(globals.ExitLoadConf)
+	}
 	if fsinfo.OriginPath == "" {
 		tlog.Warn.Printf("Did not find ORIGIN: %s in common config", origin)
 		os.Exit(globals.ExitOrigin)
@@ -95,9 +111,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	exitCode, err := storage.Mount(origin, args.notifypid)
+	opts := core.MountOptions{
+		Password:    args.password,
+		MemoryLimit: args.memoryLimit << 20,
+		IdleTTL:     args.idleTTL,
+	}
+	exitCode, err := storage.MountWithOptions(origin, args.notifypid, opts)
 	if err != nil {
-		tlog.Warn.Println("Error with ApiMount: [%d] %v", exitCode, err)
+		tlog.Warn.Printf("Error with ApiMount: [%d] %v", exitCode, err)
 		os.Exit(exitCode)
 	}
 }
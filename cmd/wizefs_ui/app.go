@@ -33,7 +33,8 @@ func (app *App) Init() {
 	//if config.CommonConfig == nil {
 	//	config.InitWizeConfig()
 	//}
-	for origin, filesystem := range app.storage.Config.Filesystems {
+	filesystems, _ := app.storage.Config.Snapshot()
+	for origin, filesystem := range filesystems {
 		fs := Filesystem{
 			Index:      len(app.db.Filesystems) + 1,
 			Origin:     origin,
@@ -47,7 +48,8 @@ func (app *App) Init() {
 
 func (app *App) updateModel() {
 	app.storage.Config.Load()
-	for origin, filesystem := range app.storage.Config.Filesystems {
+	filesystems, _ := app.storage.Config.Snapshot()
+	for origin, filesystem := range filesystems {
 		// TODO: HACK simple update by checking all map - is not quick solution
 		if !app.db.HasOrigin(origin) {
 			fs := Filesystem{
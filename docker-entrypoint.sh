@@ -0,0 +1,12 @@
+#!/bin/sh
+# REST Service doesn't start without the users config, so the first start of
+# the node creates the admin user. Its API key is printed to the log once,
+# the config keeps only its SHA-256.
+AUTH_CONFIG=/root/.local/share/wize/fs/auth.conf
+
+if [ ! -f "$AUTH_CONFIG" ]; then
+	echo "Creating user admin in $AUTH_CONFIG, its API key:"
+	./cmd/wizefs_cli/wizefs_cli auth-key --admin --config "$AUTH_CONFIG" admin || exit 1
+fi
+
+exec ./rest/rest_service "$@"
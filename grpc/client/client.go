@@ -2,12 +2,15 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	pb "bitbucket.org/udt/wizefs/grpc/wizefsservice"
 	"bitbucket.org/udt/wizefs/internal/tlog"
@@ -15,6 +18,10 @@ import (
 
 var (
 	serverAddr = flag.String("server_addr", "127.0.0.1:10000", "The server address in the format of host:port")
+	caFile     = flag.String("ca_file", "", "The CA file of the server certificate, enables TLS")
+	certFile   = flag.String("cert_file", "", "The client certificate file for mutual TLS")
+	keyFile    = flag.String("key_file", "", "The client key file for mutual TLS")
+	token      = flag.String("token", "", "The token signed by the wallet of the user")
 )
 
 func readFile(filename string) (content []byte, err error) {
@@ -34,11 +41,110 @@ func readFile(filename string) (content []byte, err error) {
 	return content, nil
 }
 
+// putStream sends the file to the Bucket in chunks
+func putStream(client pb.WizeFsServiceClient, origin, filename, path string) (*pb.PutResponse, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := client.PutStream(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	err = stream.Send(&pb.PutStreamRequest{
+		Data: &pb.PutStreamRequest_Header{
+			Header: &pb.PutStreamHeader{
+				Origin:   origin,
+				Filename: filename,
+				Size:     fi.Size(),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, pb.StreamChunkSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			serr := stream.Send(&pb.PutStreamRequest{
+				Data: &pb.PutStreamRequest_Chunk{Chunk: buf[:n]},
+			})
+			if serr == io.EOF {
+				// the server has already responded, e.g. with an error
+				break
+			}
+			if serr != nil {
+				return nil, serr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stream.CloseAndRecv()
+}
+
+// getStream receives the file from the Bucket in chunks and writes it to w
+func getStream(client pb.WizeFsServiceClient, origin, filename string, w io.Writer) error {
+	stream, err := client.GetStream(context.Background(),
+		&pb.GetRequest{
+			Filename: filename,
+			Origin:   origin,
+		})
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !resp.Executed {
+			return fmt.Errorf("%s", resp.Message)
+		}
+		if _, err = w.Write(resp.Chunk); err != nil {
+			return err
+		}
+	}
+}
+
 func main() {
 	flag.Parse()
 
 	var opts []grpc.DialOption
-	opts = append(opts, grpc.WithInsecure())
+	if *caFile != "" {
+		tlsConfig, err := pb.ClientTLSConfig(*caFile, *certFile, *keyFile)
+		if err != nil {
+			tlog.Fatal.Printf("fail to load TLS config: %v", err)
+			os.Exit(1)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	if *token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(&pb.TokenCredentials{
+			Token:    *token,
+			Insecure: *caFile == "",
+		}))
+	}
 
 	conn, err := grpc.Dial(*serverAddr, opts...)
 	if err != nil {
@@ -97,6 +203,26 @@ func main() {
 
 	time.Sleep(1 * time.Second)
 
+	// PutStream & GetStream
+	if err == nil {
+		tlog.Info.Printf("Request: PutStream. Origin: %s", origin)
+		respPut, err := putStream(client, origin, "test_stream.txt", filepath)
+		tlog.Info.Printf("Response: %v. Error: %v", respPut, err)
+
+		tlog.Info.Printf("Request: GetStream. Origin: %s", origin)
+		err = getStream(client, origin, "test_stream.txt", os.Stdout)
+		tlog.Info.Printf("Error: %v", err)
+
+		respRemove, err := client.Remove(context.Background(),
+			&pb.RemoveRequest{
+				Filename: "test_stream.txt",
+				Origin:   origin,
+			})
+		tlog.Info.Printf("Response: %v. Error: %v", respRemove, err)
+	}
+
+	time.Sleep(1 * time.Second)
+
 	// Remove
 	if err == nil {
 		tlog.Info.Printf("Request: Remove. Origin: %s", origin)
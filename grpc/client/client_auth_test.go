@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+
+	pb "bitbucket.org/udt/wizefs/grpc/wizefsservice"
+	"bitbucket.org/udt/wizefs/internal/auth"
+	"bitbucket.org/udt/wizefs/internal/core"
+)
+
+var (
+	serverAddrAuthTest = "127.0.0.1:10001"
+)
+
+// newTestUser generates the wallet key of the user
+func newTestUser(t *testing.T) (*ecdsa.PrivateKey, *auth.User) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, &auth.User{PublicKey: fmt.Sprintf("%064x%064x", key.X, key.Y)}
+}
+
+func newTestToken(t *testing.T, key *ecdsa.PrivateKey, user string) string {
+	token, err := auth.SignToken(key, user, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// writeCertificate writes the PEM certificate and key signed by the parent
+// (self-signed if it's nil) into dir
+func writeCertificate(t *testing.T, dir, name string, template *x509.Certificate,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// writeCertificates writes the CA, the server certificate and the client
+// certificate of bob
+func writeCertificates(t *testing.T, dir string) {
+	ca, caKey := writeCertificate(t, dir, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "wizefs test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	writeCertificate(t, dir, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "wizefs"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+	writeCertificate(t, dir, "bob", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "bob"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+}
+
+func dialTLS(t *testing.T, dir, clientCert string) *grpc.ClientConn {
+	certFile, keyFile := "", ""
+	if clientCert != "" {
+		certFile = filepath.Join(dir, clientCert+".crt")
+		keyFile = filepath.Join(dir, clientCert+".key")
+	}
+	tlsConfig, err := pb.ClientTLSConfig(filepath.Join(dir, "ca.crt"), certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(serverAddrAuthTest,
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		t.Fatalf("Fail to dial: %v", err)
+	}
+	return conn
+}
+
+func TestAuthorization(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs_grpc_auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeCertificates(t, dir)
+
+	aliceKey, alice := newTestUser(t)
+	bobKey, bob := newTestUser(t)
+	config := &auth.Config{Users: map[string]*auth.User{"alice": alice, "bob": bob}}
+	if err = config.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server requires the client certificates signed by the test CA
+	tlsConfig, err := pb.ServerTLSConfig(filepath.Join(dir, "server.crt"),
+		filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", serverAddrAuthTest)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	wizefsServer := pb.NewServer()
+	opts := append(pb.NewAuthorizer(config, wizefsServer).ServerOptions(),
+		grpc.Creds(credentials.NewTLS(tlsConfig)))
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterWizeFsServiceServer(grpcServer, wizefsServer)
+	go grpcServer.Serve(lis)
+	defer func() {
+		grpcServer.Stop()
+		wizefsServer.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	origin := "GRPCAuthTest"
+
+	// the client without certificate fails the handshake
+	anonymousConn := dialTLS(t, dir, "")
+	defer anonymousConn.Close()
+	anonymous := pb.NewWizeFsServiceClient(anonymousConn)
+	if _, err := anonymous.ListBuckets(ctx, &pb.ListBucketsRequest{}); err == nil {
+		t.Errorf("Call without client certificate succeeded")
+	}
+
+	// bob is identified by the client certificate, alice by the token
+	conn := dialTLS(t, dir, "bob")
+	defer conn.Close()
+	client := pb.NewWizeFsServiceClient(conn)
+	asAlice := grpc.PerRPCCredentials(&pb.TokenCredentials{Token: newTestToken(t, aliceKey, "alice")})
+	asBob := grpc.PerRPCCredentials(&pb.TokenCredentials{Token: newTestToken(t, bobKey, "bob")})
+	asMallory := grpc.PerRPCCredentials(&pb.TokenCredentials{Token: newTestToken(t, aliceKey, "bob")})
+
+	resp, err := client.Create(ctx, &pb.FilesystemRequest{Origin: origin}, asAlice)
+	if err != nil || !resp.Executed {
+		t.Fatalf("Create as alice: %v %v", err, resp)
+	}
+
+	// empty content is an empty file, never a file of the server host
+	if _, err := core.NewStorage().SetAutoMount(origin, core.AutoMountPolicy{Mode: core.AutoMountDirect}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Put(ctx, &pb.PutRequest{Origin: origin, Filename: "/etc/passwd"}, asAlice); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("Put of /etc/passwd without content: %v", err)
+	}
+	if _, err := client.Put(ctx, &pb.PutRequest{Origin: origin, Filename: "empty.txt"}, asAlice); err != nil {
+		t.Errorf("Put without content: %v", err)
+	}
+	stat, err := client.StatFile(ctx, &pb.StatFileRequest{Origin: origin, Filename: "empty.txt"}, asAlice)
+	if err != nil || stat.Info.Size != 0 || stat.Info.IsDir {
+		t.Errorf("Stat of empty file: %v %v", err, stat)
+	}
+
+	if _, err := client.Session(ctx, &pb.FilesystemRequest{Origin: origin}, asMallory); grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("Session with forged token: %v", err)
+	}
+	// the errors of the Storage have the codes of their kinds
+	if _, err := client.Session(ctx, &pb.FilesystemRequest{Origin: origin}, asAlice); grpc.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Session of unmounted Bucket as alice: %v", err)
+	}
+	if _, err := client.Create(ctx, &pb.FilesystemRequest{Origin: origin}, asAlice); grpc.Code(err) != codes.AlreadyExists {
+		t.Errorf("Create of existing Bucket as alice: %v", err)
+	}
+
+	// bob has no access to the Bucket of alice
+	for _, callOpts := range [][]grpc.CallOption{nil, {asBob}} {
+		if _, err := client.Session(ctx, &pb.FilesystemRequest{Origin: origin}, callOpts...); grpc.Code(err) != codes.PermissionDenied {
+			t.Errorf("Session as bob: %v", err)
+		}
+		if _, err := client.Delete(ctx, &pb.FilesystemRequest{Origin: origin}, callOpts...); grpc.Code(err) != codes.PermissionDenied {
+			t.Errorf("Delete as bob: %v", err)
+		}
+	}
+	if _, err := client.Clean(ctx, &pb.CleanRequest{DryRun: true}); grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("Clean as bob: %v", err)
+	}
+	if _, err := client.Verify(ctx, &pb.VerifyRequest{}); grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("Verify of the Storage as bob: %v", err)
+	}
+	if _, err := putStream(client, origin, "test.txt", "test.txt"); grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("PutStream as bob: %v", err)
+	}
+	if err := getStream(client, origin, "test.txt", ioutil.Discard); grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetStream as bob: %v", err)
+	}
+	list, err := client.ListBuckets(ctx, &pb.ListBucketsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bucket := range list.Buckets {
+		if bucket.Origin == origin {
+			t.Errorf("ListBuckets as bob returns the Bucket of alice")
+		}
+	}
+
+	resp, err = client.Delete(ctx, &pb.FilesystemRequest{Origin: origin}, asAlice)
+	if err != nil || !resp.Executed {
+		t.Errorf("Delete as alice: %v %v", err, resp)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"net"
 	"testing"
 	"time"
@@ -9,26 +10,43 @@ import (
 	"google.golang.org/grpc"
 
 	pb "bitbucket.org/udt/wizefs/grpc/wizefsservice"
+	"bitbucket.org/udt/wizefs/internal/auth"
 )
 
 var (
 	serverAddrTest = "127.0.0.1:10000"
+	// testToken is signed by the user of the test server
+	testToken string
 )
 
 func startServer(t *testing.T) {
 	lis, err := net.Listen("tcp", serverAddrTest)
 	if err != nil {
-		t.Fatalf("Failed to listen: %v", err)
+		t.Errorf("Failed to listen: %v", err)
+		return
 	}
 
-	grpcServer := grpc.NewServer()
-	pb.RegisterWizeFsServiceServer(grpcServer, pb.NewServer())
+	key, user := newTestUser(t)
+	config := &auth.Config{Users: map[string]*auth.User{"tester": user}}
+	if err = config.Check(); err != nil {
+		t.Errorf("Invalid auth config: %v", err)
+		return
+	}
+	testToken = newTestToken(t, key, "tester")
+
+	wizefsServer := pb.NewServer()
+	grpcServer := grpc.NewServer(pb.NewAuthorizer(config, wizefsServer).ServerOptions()...)
+	pb.RegisterWizeFsServiceServer(grpcServer, wizefsServer)
 	grpcServer.Serve(lis)
 }
 
 func getConnection(t *testing.T) *grpc.ClientConn {
 	var opts []grpc.DialOption
 	opts = append(opts, grpc.WithInsecure())
+	opts = append(opts, grpc.WithPerRPCCredentials(&pb.TokenCredentials{
+		Token:    testToken,
+		Insecure: true,
+	}))
 
 	conn, err := grpc.Dial(serverAddrTest, opts...)
 	if err != nil {
@@ -151,9 +169,41 @@ func TestFullCircle(t *testing.T) {
 
 	time.Sleep(500 * time.Millisecond)
 
+	// PutStream
+	t.Logf("Request: PutStream. Origin: %s", origin)
+	respPut, err := putStream(client, origin, "test_stream.txt", "test.txt")
+	if err != nil {
+		t.Fatalf("Fail to execute PutStream method: %v", err)
+	}
+	if !respPut.Executed {
+		t.Fatalf("Bad response from PutStream method: %s", respPut.Message)
+	}
+
+	// GetStream
+	t.Logf("Request: GetStream. Origin: %s", origin)
+	var streamContent bytes.Buffer
+	err = getStream(client, origin, "test_stream.txt", &streamContent)
+	if err != nil {
+		t.Fatalf("Fail to execute GetStream method: %v", err)
+	}
+	if !bytes.Equal(streamContent.Bytes(), respGet.Content) {
+		t.Fatalf("Bad content from GetStream method: %s", streamContent.Bytes())
+	}
+
+	respRemove, err := client.Remove(context.Background(),
+		&pb.RemoveRequest{
+			Filename: "test_stream.txt",
+			Origin:   origin,
+		})
+	if err != nil || !respRemove.Executed {
+		t.Fatalf("Fail to execute Remove method: %v %v", err, respRemove)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
 	// Remove
 	t.Logf("Request: Remove. Origin: %s", origin)
-	respRemove, err := client.Remove(context.Background(),
+	respRemove, err = client.Remove(context.Background(),
 		&pb.RemoveRequest{
 			Filename: "test.txt",
 			Origin:   origin,
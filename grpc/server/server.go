@@ -4,15 +4,25 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	pb "bitbucket.org/udt/wizefs/grpc/wizefsservice"
+	"bitbucket.org/udt/wizefs/internal/auth"
 	"bitbucket.org/udt/wizefs/internal/tlog"
 )
 
 var (
-	port = flag.Int("port", 10000, "The server port")
+	port           = flag.Int("port", 10000, "The server port")
+	certFile       = flag.String("cert_file", "", "The TLS certificate file, the server listens without TLS if it's empty")
+	keyFile        = flag.String("key_file", "", "The TLS key file")
+	clientCAFile   = flag.String("client_ca_file", "", "The CA file of the client certificates, enables mutual TLS")
+	authConfigPath = flag.String("auth_config", "", "The config with the users and their keys, "+
+		"default is "+auth.ConfigFilename+" in the Storage directory")
 )
 
 func main() {
@@ -20,9 +30,45 @@ func main() {
 	lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", *port))
 	if err != nil {
 		tlog.Fatal.Printf("failed to listen: %v", err)
+		os.Exit(1)
 	}
 
-	grpcServer := grpc.NewServer()
-	pb.RegisterWizeFsServiceServer(grpcServer, pb.NewServer())
+	wizefsServer := pb.NewServer()
+	if *authConfigPath == "" {
+		*authConfigPath = wizefsServer.ConfigPath(auth.ConfigFilename)
+	}
+	authConfig, err := auth.LoadConfig(*authConfigPath)
+	if err != nil {
+		tlog.Fatal.Printf("failed to load auth config: %v"+
+			" (create the first user with `wizefs_cli auth-key --admin USER`)", err)
+		os.Exit(1)
+	}
+	opts := pb.NewAuthorizer(authConfig, wizefsServer).ServerOptions()
+
+	if *certFile != "" {
+		tlsConfig, err := pb.ServerTLSConfig(*certFile, *keyFile, *clientCAFile)
+		if err != nil {
+			tlog.Fatal.Printf("failed to load TLS config: %v", err)
+			os.Exit(1)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	} else {
+		tlog.Warn.Printf("TLS is disabled, the tokens are sent in plain text")
+	}
+
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterWizeFsServiceServer(grpcServer, wizefsServer)
+
+	// Buckets are mounted inside this process, so we should unmount them
+	// before exit
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-terminate
+		tlog.Info.Println("grpc server exiting")
+		grpcServer.Stop()
+	}()
+
 	grpcServer.Serve(lis)
+	wizefsServer.Close()
 }
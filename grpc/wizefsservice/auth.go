@@ -0,0 +1,218 @@
+package wizefsservice
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"bitbucket.org/udt/wizefs/internal/auth"
+	"bitbucket.org/udt/wizefs/internal/core"
+)
+
+const (
+	servicePrefix = "/wizefsservice.WizeFsService/"
+	// authorizationKey is the metadata key of the "Bearer TOKEN" value
+	authorizationKey = "authorization"
+	// permAuthenticated - any authenticated user may call the method
+	permAuthenticated core.ACLPermission = 0
+)
+
+// methodPermissions are the permissions the methods need on the Bucket of
+// the call
+var methodPermissions = map[string]core.ACLPermission{
+	"Create":      permAuthenticated,
+	"ListBuckets": permAuthenticated,
+	"Delete":      core.ACLOwner,
+	"Mount":       core.ACLWrite,
+	"Unmount":     core.ACLWrite,
+	"Put":         core.ACLWrite,
+	"PutStream":   core.ACLWrite,
+	"Remove":      core.ACLWrite,
+	"Session":     core.ACLRead,
+	"Get":         core.ACLRead,
+	"GetStream":   core.ACLRead,
+	"ListFiles":   core.ACLRead,
+	"StatFile":    core.ACLRead,
+	"Verify":      core.ACLRead,
+	"Clean":       permAuthenticated,
+}
+
+// adminMethods are Storage-wide, only admins may call them
+var adminMethods = map[string]bool{
+	"Clean": true,
+}
+
+// Authorizer authenticates the calls by the tokens in their metadata (or by
+// the verified client certificates) and checks the permissions of the
+// methods against the ACL of the Bucket
+type Authorizer struct {
+	config  *auth.Config
+	storage *core.Storage
+}
+
+// NewAuthorizer returns the Authorizer of the calls to the server
+func NewAuthorizer(config *auth.Config, server *wizefsServer) *Authorizer {
+	return &Authorizer{
+		config:  config,
+		storage: server.storage,
+	}
+}
+
+// ServerOptions return the interceptors of the Authorizer
+func (a *Authorizer) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(a.UnaryInterceptor),
+		grpc.StreamInterceptor(a.StreamInterceptor),
+	}
+}
+
+// UnaryInterceptor authorizes the unary calls
+func (a *Authorizer) UnaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	identity, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.authorize(identity, info.FullMethod, req); err != nil {
+		return nil, err
+	}
+	return handler(auth.NewContext(ctx, identity), req)
+}
+
+// StreamInterceptor authorizes the streaming calls by their first message,
+// it carries the origin of the Bucket
+func (a *Authorizer) StreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	identity, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{
+		ServerStream: ss,
+		ctx:          auth.NewContext(ss.Context(), identity),
+		authorize: func(m interface{}) error {
+			return a.authorize(identity, info.FullMethod, m)
+		},
+	})
+}
+
+func (a *Authorizer) authenticate(ctx context.Context) (*auth.Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md[authorizationKey]; len(values) > 0 {
+		if !strings.HasPrefix(values[0], auth.BearerPrefix) {
+			return nil, status.Errorf(codes.Unauthenticated, "bearer token is required")
+		}
+		identity, err := a.config.VerifyToken(strings.TrimPrefix(values[0], auth.BearerPrefix))
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+		}
+		return identity, nil
+	}
+
+	// the common name of the verified client certificate is the user name
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			name := info.State.VerifiedChains[0][0].Subject.CommonName
+			if user, ok := a.config.Users[name]; ok {
+				return &auth.Identity{Name: name, Admin: user.Admin}, nil
+			}
+		}
+	}
+	return nil, status.Errorf(codes.Unauthenticated, "%v", auth.ErrNoCredentials)
+}
+
+func (a *Authorizer) authorize(identity *auth.Identity, fullMethod string, req interface{}) error {
+	if identity == nil {
+		return status.Errorf(codes.Unauthenticated, "%v", auth.ErrNoCredentials)
+	}
+	// the methods without permissions are denied, even to admins
+	method := strings.TrimPrefix(fullMethod, servicePrefix)
+	perm, ok := methodPermissions[method]
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "unknown method %s", fullMethod)
+	}
+	if identity.Admin {
+		return nil
+	}
+
+	origin := requestOrigin(req)
+	if adminMethods[method] || method == "Verify" && origin == "" {
+		return status.Errorf(codes.PermissionDenied, "%s is allowed only to admins", method)
+	}
+	if perm == permAuthenticated {
+		return nil
+	}
+	acl, _, err := a.storage.ACL(origin)
+	if err != nil || !acl.Allows(identity.Name, perm) {
+		// unknown Buckets are not revealed to the users without access
+		return status.Errorf(codes.PermissionDenied,
+			"access to Bucket %s is denied, %s permission is required", origin, perm)
+	}
+	return nil
+}
+
+// requestOrigin returns the origin of the Bucket the request is for
+func requestOrigin(req interface{}) string {
+	switch r := req.(type) {
+	case *PutStreamRequest:
+		return r.GetHeader().GetOrigin()
+	case interface {
+		GetOrigin() string
+	}:
+		return r.GetOrigin()
+	}
+	return ""
+}
+
+// authorizedStream authorizes the call when its first message is received
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx        context.Context
+	authorize  func(m interface{}) error
+	authorized bool
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil || s.authorized {
+		return err
+	}
+	if err := s.authorize(m); err != nil {
+		return err
+	}
+	s.authorized = true
+	return nil
+}
+
+// TokenCredentials attach the token to every call of the client
+type TokenCredentials struct {
+	Token string
+	// Insecure allows sending the token over the connection without TLS
+	Insecure bool
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (c *TokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{authorizationKey: auth.BearerPrefix + c.Token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials
+func (c *TokenCredentials) RequireTransportSecurity() bool {
+	return !c.Insecure
+}
+
+// allowed reports if the identity of the call has the permission for the
+// Bucket, the calls without identity are denied
+func allowed(ctx context.Context, acl *core.BucketACL, perm core.ACLPermission) bool {
+	identity := auth.FromContext(ctx)
+	return identity != nil && (identity.Admin || acl.Allows(identity.Name, perm))
+}
@@ -0,0 +1,39 @@
+package wizefsservice
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"bitbucket.org/udt/wizefs/internal/auth"
+	"bitbucket.org/udt/wizefs/internal/core"
+)
+
+func TestAuthorizeFailsClosed(t *testing.T) {
+	a := &Authorizer{}
+	admin := &auth.Identity{Name: "admin", Admin: true}
+
+	if err := a.authorize(nil, servicePrefix+"ListBuckets", nil); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Call without identity: %v", err)
+	}
+	for _, method := range []string{servicePrefix + "Nothing", "/other.Service/Create"} {
+		if err := a.authorize(admin, method, nil); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Unknown method %s: %v", method, err)
+		}
+	}
+
+	acl := &core.BucketACL{Owner: "user"}
+	if allowed(context.Background(), acl, core.ACLRead) {
+		t.Errorf("Call without identity is allowed")
+	}
+	ctx := auth.NewContext(context.Background(), &auth.Identity{Name: "user"})
+	if !allowed(ctx, acl, core.ACLOwner) {
+		t.Errorf("Owner is not allowed")
+	}
+	ctx = auth.NewContext(context.Background(), &auth.Identity{Name: "other"})
+	if allowed(ctx, acl, core.ACLRead) {
+		t.Errorf("Other user is allowed")
+	}
+}
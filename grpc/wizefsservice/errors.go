@@ -0,0 +1,41 @@
+package wizefsservice
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"bitbucket.org/udt/wizefs/internal/core"
+)
+
+// errorCodes are the gRPC codes of the kinds of the Storage errors
+var errorCodes = []struct {
+	kind error
+	code codes.Code
+}{
+	{core.ErrNotFound, codes.NotFound},
+	{core.ErrAlreadyExists, codes.AlreadyExists},
+	{core.ErrNotMounted, codes.FailedPrecondition},
+	{core.ErrMounted, codes.FailedPrecondition},
+	{core.ErrPrecondition, codes.FailedPrecondition},
+	{core.ErrBusy, codes.Unavailable},
+	{core.ErrIntegrity, codes.DataLoss},
+	{core.ErrInvalid, codes.InvalidArgument},
+	{core.ErrPassword, codes.PermissionDenied},
+}
+
+// statusError returns the gRPC status error with the code of the kind of
+// the Storage error, the errors of unknown kinds are Internal
+func statusError(err error) error {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.kind) {
+			return status.Error(e.code, err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func bucketNotFound(origin string) error {
+	return status.Errorf(codes.NotFound, "Bucket with ORIGIN: %s is not exist", origin)
+}
@@ -0,0 +1,65 @@
+package wizefsservice
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// ServerTLSConfig returns the TLS config of the server with the PEM
+// certificate and key. If clientCAFile isn't empty the clients should
+// present the certificates signed by it (mutual TLS).
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		if config.ClientCAs, err = loadCertPool(clientCAFile); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientTLSConfig returns the TLS config of the client verifying the server
+// by the PEM CA certificate (the system roots if caFile is empty). The client
+// certificate and key are optional, they are required by the mutual TLS
+// servers.
+func ClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	var err error
+	if caFile != "" {
+		if config.RootCAs, err = loadCertPool(caFile); err != nil {
+			return nil, err
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates in %s", filename)
+	}
+	return pool, nil
+}
@@ -1,34 +1,26 @@
 package wizefsservice
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"os/exec"
-	"runtime"
-	"strconv"
-	"strings"
-	"syscall"
+	"io"
+	"time"
 
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"bitbucket.org/udt/wizefs/internal/auth"
 	"bitbucket.org/udt/wizefs/internal/core"
-	"bitbucket.org/udt/wizefs/internal/tlog"
 )
 
 const (
-	packagePath = "grpc"
-	mountApp    = "cmd/wizefs_mount/wizefs_mount"
+	// StreamChunkSize is the size of content chunks in PutStream/GetStream
+	// messages; it's far below the default 4 MB gRPC message limit.
+	StreamChunkSize = 64 * 1024
 )
 
-var (
-	projectPath = getProjectPath()
-)
-
-func getProjectPath() string {
-	_, testFilename, _, _ := runtime.Caller(0)
-	idx := strings.Index(testFilename, packagePath)
-	return testFilename[0:idx]
-}
-
 type wizefsServer struct {
 	storage *core.Storage
 }
@@ -40,79 +32,129 @@ func NewServer() *wizefsServer {
 	return s
 }
 
+// ConfigPath returns the path of the config file inside the Storage
+// directory
+func (s *wizefsServer) ConfigPath(filename string) string {
+	return s.storage.DirPath + filename
+}
+
+// Close unmounts all Buckets that were mounted by the server
+func (s *wizefsServer) Close() {
+	s.storage.Close()
+}
+
 func (s *wizefsServer) Create(ctx context.Context, request *FilesystemRequest) (response *FilesystemResponse, err error) {
-	origin := request.GetOrigin()
-	response = &FilesystemResponse{
-		Executed: true,
-		Message:  "OK",
+	opts := core.BucketOptions{
+		Password: request.GetPassword(),
 	}
-
-	if exitCode, err := s.storage.Create(origin); err != nil {
-		response.Executed = false
-		response.Message = err.Error()
-		response.Message += " ExitCode: " + strconv.Itoa(exitCode)
+	// the user creating the Bucket owns it
+	if identity := auth.FromContext(ctx); identity != nil {
+		opts.ACL = &core.BucketACL{Owner: identity.Name}
 	}
-	return
+	if _, err := s.storage.CreateWithOptions(request.GetOrigin(), opts); err != nil {
+		return nil, statusError(err)
+	}
+	return &FilesystemResponse{Executed: true, Message: "OK"}, nil
 }
 
 func (s *wizefsServer) Delete(ctx context.Context, request *FilesystemRequest) (response *FilesystemResponse, err error) {
-	origin := request.GetOrigin()
+	if _, err := s.storage.Delete(request.GetOrigin()); err != nil {
+		return nil, statusError(err)
+	}
+	return &FilesystemResponse{Executed: true, Message: "OK"}, nil
+}
 
-	response = &FilesystemResponse{
-		Executed: true,
-		Message:  "OK",
+func (s *wizefsServer) Mount(ctx context.Context, request *FilesystemRequest) (response *FilesystemResponse, err error) {
+	opts := core.MountOptions{
+		Password: request.GetPassword(),
+		IdleTTL:  time.Duration(request.GetIdleTtl()) * time.Second,
 	}
-	if exitCode, err := s.storage.Delete(origin); err != nil {
-		response.Executed = false
-		response.Message = fmt.Sprintf("Error: %s. Exit code: %d", err.Error(), exitCode)
+	if _, err := s.storage.MountManagedWithOptions(request.GetOrigin(), opts); err != nil {
+		return nil, statusError(err)
 	}
-	return
+	return &FilesystemResponse{Executed: true, Message: "OK"}, nil
 }
 
-func (s *wizefsServer) Mount(ctx context.Context, request *FilesystemRequest) (response *FilesystemResponse, err error) {
-	origin := request.GetOrigin()
-	var message string = "OK"
-	response = &FilesystemResponse{
+func (s *wizefsServer) Unmount(ctx context.Context, request *FilesystemRequest) (response *FilesystemResponse, err error) {
+	if _, err := s.storage.Unmount(request.GetOrigin()); err != nil {
+		return nil, statusError(err)
+	}
+	return &FilesystemResponse{Executed: true, Message: "OK"}, nil
+}
+
+func (s *wizefsServer) Session(ctx context.Context, request *FilesystemRequest) (response *SessionResponse, err error) {
+	info, _, err := s.storage.Session(request.GetOrigin())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &SessionResponse{
+		Executed:      true,
+		Message:       "OK",
+		IdleTtl:       int64(info.IdleTTL / time.Second),
+		IdleRemaining: int64(info.Remaining / time.Second),
+	}, nil
+}
+
+func (s *wizefsServer) ListBuckets(ctx context.Context, request *ListBucketsRequest) (response *ListBucketsResponse, err error) {
+	buckets, _, err := s.storage.List()
+	if err != nil {
+		return nil, statusError(err)
+	}
+	response = &ListBucketsResponse{
 		Executed: true,
-		Message:  message,
-	}
-
-	appPath := projectPath + mountApp
-	tlog.Info.Println("appPath:", appPath)
-	c := exec.Command(appPath, origin)
-	cerr := c.Start()
-	if cerr != nil {
-		message = fmt.Sprintf("starting command failed: %v", cerr)
-	} else {
-		cerr = c.Wait()
-		if cerr != nil {
-			if exiterr, ok := cerr.(*exec.ExitError); ok {
-				if waitstat, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-					response.Executed = true
-					response.Message = fmt.Sprintf("wait returned an exit status: %d", waitstat.ExitStatus())
-				}
-			} else {
-				response.Executed = false
-				response.Message = fmt.Sprintf("wait returned an unknown error: %v", cerr)
-			}
+		Message:  "OK",
+	}
+	for _, info := range buckets {
+		if !allowed(ctx, info.ACL, core.ACLRead) {
+			continue
 		}
+		response.Buckets = append(response.Buckets, &BucketInfo{
+			Origin:     info.Origin,
+			Type:       int32(info.Type),
+			OriginPath: info.OriginPath,
+			Mounted:    info.Mounted,
+			Mountpoint: info.Mountpoint,
+			Size:       info.Size,
+			FileCount:  int64(info.FileCount),
+			Created:    info.Created.Unix(),
+		})
 	}
-
 	return
 }
 
-func (s *wizefsServer) Unmount(ctx context.Context, request *FilesystemRequest) (response *FilesystemResponse, err error) {
-	origin := request.GetOrigin()
+func (s *wizefsServer) Verify(ctx context.Context, request *VerifyRequest) (response *VerifyResponse, err error) {
+	problems, _, err := s.storage.Verify(request.GetOrigin())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return newVerifyResponse(problems), nil
+}
 
-	response = &FilesystemResponse{
+func (s *wizefsServer) Clean(ctx context.Context, request *CleanRequest) (response *VerifyResponse, err error) {
+	problems, _, err := s.storage.Clean(request.GetDryRun())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return newVerifyResponse(problems), nil
+}
+
+func newVerifyResponse(problems []core.Problem) *VerifyResponse {
+	response := &VerifyResponse{
 		Executed: true,
 		Message:  "OK",
 	}
-	if exitCode, err := s.storage.Unmount(origin); err != nil {
-		response.Executed = false
-		response.Message = fmt.Sprintf("Error: %s. Exit code: %d", err.Error(), exitCode)
+	for _, p := range problems {
+		response.Problems = append(response.Problems, &Problem{
+			Kind:     p.Kind,
+			Origin:   p.Origin,
+			Path:     p.Path,
+			Detail:   p.Detail,
+			Repair:   p.Repair,
+			Repaired: p.Repaired,
+			Error:    p.Error,
+		})
 	}
-	return
+	return response
 }
 
 func (s *wizefsServer) Put(ctx context.Context, request *PutRequest) (response *PutResponse, err error) {
@@ -122,21 +164,20 @@ func (s *wizefsServer) Put(ctx context.Context, request *PutRequest) (response *
 
 	// TODO: check all request's data
 
-	response = &PutResponse{
-		Executed: true,
-		Message:  "OK",
-	}
 	bucket, ok := s.storage.Bucket(origin)
 	if !ok {
-		response.Executed = false
-		response.Message = fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin)
-		return
+		return nil, bucketNotFound(origin)
 	}
-	if exitCode, err := bucket.PutFile(filename, content); err != nil {
-		response.Executed = false
-		response.Message = fmt.Sprintf("Error: %s. Exit code: %d", err.Error(), exitCode)
+	opts := putOptions(request.GetOptions())
+	if _, err := bucket.PutFileWithOptions(filename, content, opts); err != nil {
+		return nil, statusError(err)
 	}
-	return
+	sum := sha256.Sum256(content)
+	return &PutResponse{
+		Executed: true,
+		Message:  "OK",
+		Checksum: hex.EncodeToString(sum[:]),
+	}, nil
 }
 
 func (s *wizefsServer) Get(ctx context.Context, request *GetRequest) (response *GetResponse, err error) {
@@ -145,45 +186,210 @@ func (s *wizefsServer) Get(ctx context.Context, request *GetRequest) (response *
 
 	// TODO: check all request's data
 
-	response = &GetResponse{
+	bucket, ok := s.storage.Bucket(origin)
+	if !ok {
+		return nil, bucketNotFound(origin)
+	}
+	content, _, err := bucket.GetFile(filename, "", true)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	// GetFile has verified the content by its stored checksum
+	sum := sha256.Sum256(content)
+	return &GetResponse{
 		Executed: true,
 		Message:  "OK",
-		Content:  nil,
+		Content:  content,
+		Checksum: hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+func (s *wizefsServer) PutStream(stream WizeFsService_PutStreamServer) (err error) {
+	request, err := stream.Recv()
+	if err != nil {
+		return err
 	}
-	bucket, ok := s.storage.Bucket(origin)
+	header := request.GetHeader()
+	if header == nil {
+		return status.Errorf(codes.InvalidArgument,
+			"The first message of the stream should carry a header")
+	}
+
+	// TODO: check all request's data
+
+	bucket, ok := s.storage.Bucket(header.GetOrigin())
 	if !ok {
-		response.Executed = false
-		response.Message = fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin)
-		return
+		return bucketNotFound(header.GetOrigin())
+	}
+
+	reader := &putStreamReader{
+		stream: stream,
+		size:   header.GetSize(),
 	}
-	if content, exitCode, err := bucket.GetFile(filename, "", true); err != nil {
-		response.Executed = false
-		response.Message = fmt.Sprintf("Error: %s. Exit code: %d", err.Error(), exitCode)
-	} else {
-		response.Content = content
+	hash := sha256.New()
+	opts := putOptions(header.GetOptions())
+	if _, err := bucket.PutFileStreamWithOptions(header.GetFilename(),
+		io.TeeReader(reader, hash), opts); err != nil {
+		return statusError(err)
 	}
-	return
+	return stream.SendAndClose(&PutResponse{
+		Executed: true,
+		Message:  "OK",
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+	})
 }
 
-func (s *wizefsServer) Remove(ctx context.Context, request *RemoveRequest) (response *RemoveResponse, err error) {
+func (s *wizefsServer) GetStream(request *GetRequest, stream WizeFsService_GetStreamServer) (err error) {
 	filename := request.GetFilename()
 	origin := request.GetOrigin()
 
 	// TODO: check all request's data
 
-	response = &RemoveResponse{
+	bucket, ok := s.storage.Bucket(origin)
+	if !ok {
+		return bucketNotFound(origin)
+	}
+	response := &GetStreamResponse{
 		Executed: true,
 		Message:  "OK",
 	}
+	// the checksum is sent before the content, the reader verifies it. It's
+	// taken from the index, the not indexed files would be read twice.
+	if response.Checksum, _, err = bucket.Checksum(filename); err != nil {
+		return statusError(err)
+	}
+	reader, _, err := bucket.GetFileStream(filename)
+	if err != nil {
+		return statusError(err)
+	}
+	defer reader.Close()
+
+	// the first message is sent even for an empty file
+	buf := make([]byte, StreamChunkSize)
+	for first := true; ; first = false {
+		n, rerr := io.ReadFull(reader, buf)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return statusError(rerr)
+		}
+		if n > 0 || first {
+			response.Chunk = buf[:n]
+			if err = stream.Send(response); err != nil {
+				return err
+			}
+			response.Message = ""
+			response.Checksum = ""
+		}
+		if rerr != nil {
+			return nil
+		}
+	}
+}
+
+func (s *wizefsServer) Remove(ctx context.Context, request *RemoveRequest) (response *RemoveResponse, err error) {
+	filename := request.GetFilename()
+	origin := request.GetOrigin()
+
+	// TODO: check all request's data
+
 	bucket, ok := s.storage.Bucket(origin)
 	if !ok {
-		response.Executed = false
-		response.Message = fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin)
-		return
+		return nil, bucketNotFound(origin)
 	}
-	if exitCode, err := bucket.RemoveFile(filename); err != nil {
-		response.Executed = false
-		response.Message = fmt.Sprintf("Error: %s. Exit code: %d", err.Error(), exitCode)
+	if _, err := bucket.RemoveFile(filename); err != nil {
+		return nil, statusError(err)
+	}
+	return &RemoveResponse{Executed: true, Message: "OK"}, nil
+}
+
+func (s *wizefsServer) ListFiles(ctx context.Context, request *ListFilesRequest) (response *ListFilesResponse, err error) {
+	origin := request.GetOrigin()
+
+	bucket, ok := s.storage.Bucket(origin)
+	if !ok {
+		return nil, bucketNotFound(origin)
+	}
+	files, nextPageToken, _, err := bucket.List(request.GetPrefix(),
+		request.GetRecursive(), request.GetPageToken())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	response = &ListFilesResponse{
+		Executed:      true,
+		Message:       "OK",
+		NextPageToken: nextPageToken,
+	}
+	for _, info := range files {
+		response.Files = append(response.Files, newFileInfo(info))
 	}
 	return
 }
+
+func (s *wizefsServer) StatFile(ctx context.Context, request *StatFileRequest) (response *StatFileResponse, err error) {
+	filename := request.GetFilename()
+	origin := request.GetOrigin()
+
+	bucket, ok := s.storage.Bucket(origin)
+	if !ok {
+		return nil, bucketNotFound(origin)
+	}
+	info, _, err := bucket.Stat(filename)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &StatFileResponse{
+		Executed: true,
+		Message:  "OK",
+		Info:     newFileInfo(info),
+	}, nil
+}
+
+func putOptions(options *PutOptions) core.PutOptions {
+	return core.PutOptions{
+		Overwrite:   options.GetOverwrite(),
+		IfMatch:     options.GetIfMatch(),
+		IfNoneMatch: options.GetIfNoneMatch(),
+	}
+}
+
+func newFileInfo(info core.FileInfo) *FileInfo {
+	return &FileInfo{
+		Name:     info.Name,
+		Size:     info.Size,
+		Mode:     uint32(info.Mode),
+		Mtime:    info.ModTime.UnixNano(),
+		IsDir:    info.IsDir,
+		Checksum: info.Checksum,
+	}
+}
+
+// putStreamReader reads the content chunks of a PutStream call
+type putStreamReader struct {
+	stream WizeFsService_PutStreamServer
+	chunk  []byte
+	size   int64 // expected size, 0 - unknown
+	read   int64
+}
+
+func (r *putStreamReader) Read(p []byte) (n int, err error) {
+	for len(r.chunk) == 0 {
+		request, err := r.stream.Recv()
+		if err == io.EOF {
+			if r.size > 0 && r.read != r.size {
+				return 0, fmt.Errorf("received %d bytes, expected %d", r.read, r.size)
+			}
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		if request.GetHeader() != nil {
+			return 0, fmt.Errorf("unexpected header in the middle of the stream")
+		}
+		r.chunk = request.GetChunk()
+	}
+
+	n = copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	r.read += int64(n)
+	return n, nil
+}
@@ -10,12 +10,29 @@ It is generated from these files:
 It has these top-level messages:
 	FilesystemRequest
 	FilesystemResponse
+	SessionResponse
+	ListBucketsRequest
+	BucketInfo
+	ListBucketsResponse
+	VerifyRequest
+	CleanRequest
+	Problem
+	VerifyResponse
 	PutRequest
+	PutOptions
 	PutResponse
 	GetRequest
 	GetResponse
+	PutStreamHeader
+	PutStreamRequest
+	GetStreamResponse
 	RemoveRequest
 	RemoveResponse
+	FileInfo
+	ListFilesRequest
+	ListFilesResponse
+	StatFileRequest
+	StatFileResponse
 */
 package wizefsservice
 
@@ -40,7 +57,9 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type FilesystemRequest struct {
-	Origin string `protobuf:"bytes,1,opt,name=origin" json:"origin,omitempty"`
+	Origin   string `protobuf:"bytes,1,opt,name=origin" json:"origin,omitempty"`
+	Password string `protobuf:"bytes,2,opt,name=password" json:"password,omitempty"`
+	IdleTtl  int64  `protobuf:"varint,3,opt,name=idle_ttl,json=idleTtl" json:"idle_ttl,omitempty"`
 }
 
 func (m *FilesystemRequest) Reset()                    { *m = FilesystemRequest{} }
@@ -55,6 +74,20 @@ func (m *FilesystemRequest) GetOrigin() string {
 	return ""
 }
 
+func (m *FilesystemRequest) GetPassword() string {
+	if m != nil {
+		return m.Password
+	}
+	return ""
+}
+
+func (m *FilesystemRequest) GetIdleTtl() int64 {
+	if m != nil {
+		return m.IdleTtl
+	}
+	return 0
+}
+
 type FilesystemResponse struct {
 	Executed bool   `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
 	Message  string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
@@ -79,16 +112,297 @@ func (m *FilesystemResponse) GetMessage() string {
 	return ""
 }
 
+type SessionResponse struct {
+	Executed      bool   `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
+	Message       string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	IdleTtl       int64  `protobuf:"varint,3,opt,name=idle_ttl,json=idleTtl" json:"idle_ttl,omitempty"`
+	IdleRemaining int64  `protobuf:"varint,4,opt,name=idle_remaining,json=idleRemaining" json:"idle_remaining,omitempty"`
+}
+
+func (m *SessionResponse) Reset()                    { *m = SessionResponse{} }
+func (m *SessionResponse) String() string            { return proto.CompactTextString(m) }
+func (*SessionResponse) ProtoMessage()               {}
+func (*SessionResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *SessionResponse) GetExecuted() bool {
+	if m != nil {
+		return m.Executed
+	}
+	return false
+}
+
+func (m *SessionResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *SessionResponse) GetIdleTtl() int64 {
+	if m != nil {
+		return m.IdleTtl
+	}
+	return 0
+}
+
+func (m *SessionResponse) GetIdleRemaining() int64 {
+	if m != nil {
+		return m.IdleRemaining
+	}
+	return 0
+}
+
+type ListBucketsRequest struct {
+}
+
+func (m *ListBucketsRequest) Reset()                    { *m = ListBucketsRequest{} }
+func (m *ListBucketsRequest) String() string            { return proto.CompactTextString(m) }
+func (*ListBucketsRequest) ProtoMessage()               {}
+func (*ListBucketsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+type BucketInfo struct {
+	Origin     string `protobuf:"bytes,1,opt,name=origin" json:"origin,omitempty"`
+	Type       int32  `protobuf:"varint,2,opt,name=type" json:"type,omitempty"`
+	OriginPath string `protobuf:"bytes,3,opt,name=origin_path,json=originPath" json:"origin_path,omitempty"`
+	Mounted    bool   `protobuf:"varint,4,opt,name=mounted" json:"mounted,omitempty"`
+	Mountpoint string `protobuf:"bytes,5,opt,name=mountpoint" json:"mountpoint,omitempty"`
+	Size       int64  `protobuf:"varint,6,opt,name=size" json:"size,omitempty"`
+	FileCount  int64  `protobuf:"varint,7,opt,name=file_count,json=fileCount" json:"file_count,omitempty"`
+	Created    int64  `protobuf:"varint,8,opt,name=created" json:"created,omitempty"`
+}
+
+func (m *BucketInfo) Reset()                    { *m = BucketInfo{} }
+func (m *BucketInfo) String() string            { return proto.CompactTextString(m) }
+func (*BucketInfo) ProtoMessage()               {}
+func (*BucketInfo) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *BucketInfo) GetOrigin() string {
+	if m != nil {
+		return m.Origin
+	}
+	return ""
+}
+
+func (m *BucketInfo) GetType() int32 {
+	if m != nil {
+		return m.Type
+	}
+	return 0
+}
+
+func (m *BucketInfo) GetOriginPath() string {
+	if m != nil {
+		return m.OriginPath
+	}
+	return ""
+}
+
+func (m *BucketInfo) GetMounted() bool {
+	if m != nil {
+		return m.Mounted
+	}
+	return false
+}
+
+func (m *BucketInfo) GetMountpoint() string {
+	if m != nil {
+		return m.Mountpoint
+	}
+	return ""
+}
+
+func (m *BucketInfo) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *BucketInfo) GetFileCount() int64 {
+	if m != nil {
+		return m.FileCount
+	}
+	return 0
+}
+
+func (m *BucketInfo) GetCreated() int64 {
+	if m != nil {
+		return m.Created
+	}
+	return 0
+}
+
+type ListBucketsResponse struct {
+	Executed bool          `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
+	Message  string        `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Buckets  []*BucketInfo `protobuf:"bytes,3,rep,name=buckets" json:"buckets,omitempty"`
+}
+
+func (m *ListBucketsResponse) Reset()                    { *m = ListBucketsResponse{} }
+func (m *ListBucketsResponse) String() string            { return proto.CompactTextString(m) }
+func (*ListBucketsResponse) ProtoMessage()               {}
+func (*ListBucketsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *ListBucketsResponse) GetExecuted() bool {
+	if m != nil {
+		return m.Executed
+	}
+	return false
+}
+
+func (m *ListBucketsResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *ListBucketsResponse) GetBuckets() []*BucketInfo {
+	if m != nil {
+		return m.Buckets
+	}
+	return nil
+}
+
+type VerifyRequest struct {
+	Origin string `protobuf:"bytes,1,opt,name=origin" json:"origin,omitempty"`
+}
+
+func (m *VerifyRequest) Reset()                    { *m = VerifyRequest{} }
+func (m *VerifyRequest) String() string            { return proto.CompactTextString(m) }
+func (*VerifyRequest) ProtoMessage()               {}
+func (*VerifyRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *VerifyRequest) GetOrigin() string {
+	if m != nil {
+		return m.Origin
+	}
+	return ""
+}
+
+type CleanRequest struct {
+	DryRun bool `protobuf:"varint,1,opt,name=dry_run,json=dryRun" json:"dry_run,omitempty"`
+}
+
+func (m *CleanRequest) Reset()                    { *m = CleanRequest{} }
+func (m *CleanRequest) String() string            { return proto.CompactTextString(m) }
+func (*CleanRequest) ProtoMessage()               {}
+func (*CleanRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *CleanRequest) GetDryRun() bool {
+	if m != nil {
+		return m.DryRun
+	}
+	return false
+}
+
+type Problem struct {
+	Kind     string `protobuf:"bytes,1,opt,name=kind" json:"kind,omitempty"`
+	Origin   string `protobuf:"bytes,2,opt,name=origin" json:"origin,omitempty"`
+	Path     string `protobuf:"bytes,3,opt,name=path" json:"path,omitempty"`
+	Detail   string `protobuf:"bytes,4,opt,name=detail" json:"detail,omitempty"`
+	Repair   string `protobuf:"bytes,5,opt,name=repair" json:"repair,omitempty"`
+	Repaired bool   `protobuf:"varint,6,opt,name=repaired" json:"repaired,omitempty"`
+	Error    string `protobuf:"bytes,7,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *Problem) Reset()                    { *m = Problem{} }
+func (m *Problem) String() string            { return proto.CompactTextString(m) }
+func (*Problem) ProtoMessage()               {}
+func (*Problem) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *Problem) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+func (m *Problem) GetOrigin() string {
+	if m != nil {
+		return m.Origin
+	}
+	return ""
+}
+
+func (m *Problem) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *Problem) GetDetail() string {
+	if m != nil {
+		return m.Detail
+	}
+	return ""
+}
+
+func (m *Problem) GetRepair() string {
+	if m != nil {
+		return m.Repair
+	}
+	return ""
+}
+
+func (m *Problem) GetRepaired() bool {
+	if m != nil {
+		return m.Repaired
+	}
+	return false
+}
+
+func (m *Problem) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type VerifyResponse struct {
+	Executed bool       `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
+	Message  string     `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Problems []*Problem `protobuf:"bytes,3,rep,name=problems" json:"problems,omitempty"`
+}
+
+func (m *VerifyResponse) Reset()                    { *m = VerifyResponse{} }
+func (m *VerifyResponse) String() string            { return proto.CompactTextString(m) }
+func (*VerifyResponse) ProtoMessage()               {}
+func (*VerifyResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *VerifyResponse) GetExecuted() bool {
+	if m != nil {
+		return m.Executed
+	}
+	return false
+}
+
+func (m *VerifyResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *VerifyResponse) GetProblems() []*Problem {
+	if m != nil {
+		return m.Problems
+	}
+	return nil
+}
+
 type PutRequest struct {
-	Filename string `protobuf:"bytes,1,opt,name=filename" json:"filename,omitempty"`
-	Content  []byte `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
-	Origin   string `protobuf:"bytes,3,opt,name=origin" json:"origin,omitempty"`
+	Filename string      `protobuf:"bytes,1,opt,name=filename" json:"filename,omitempty"`
+	Content  []byte      `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
+	Origin   string      `protobuf:"bytes,3,opt,name=origin" json:"origin,omitempty"`
+	Options  *PutOptions `protobuf:"bytes,4,opt,name=options" json:"options,omitempty"`
 }
 
 func (m *PutRequest) Reset()                    { *m = PutRequest{} }
 func (m *PutRequest) String() string            { return proto.CompactTextString(m) }
 func (*PutRequest) ProtoMessage()               {}
-func (*PutRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*PutRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
 
 func (m *PutRequest) GetFilename() string {
 	if m != nil {
@@ -97,157 +411,618 @@ func (m *PutRequest) GetFilename() string {
 	return ""
 }
 
-func (m *PutRequest) GetContent() []byte {
+func (m *PutRequest) GetContent() []byte {
+	if m != nil {
+		return m.Content
+	}
+	return nil
+}
+
+func (m *PutRequest) GetOrigin() string {
+	if m != nil {
+		return m.Origin
+	}
+	return ""
+}
+
+func (m *PutRequest) GetOptions() *PutOptions {
+	if m != nil {
+		return m.Options
+	}
+	return nil
+}
+
+// what Put does with an existing file, the ETag of a file is the hex SHA-256
+// of its content (FileInfo.checksum)
+type PutOptions struct {
+	Overwrite   bool   `protobuf:"varint,1,opt,name=overwrite" json:"overwrite,omitempty"`
+	IfMatch     string `protobuf:"bytes,2,opt,name=if_match,json=ifMatch" json:"if_match,omitempty"`
+	IfNoneMatch string `protobuf:"bytes,3,opt,name=if_none_match,json=ifNoneMatch" json:"if_none_match,omitempty"`
+}
+
+func (m *PutOptions) Reset()                    { *m = PutOptions{} }
+func (m *PutOptions) String() string            { return proto.CompactTextString(m) }
+func (*PutOptions) ProtoMessage()               {}
+func (*PutOptions) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *PutOptions) GetOverwrite() bool {
+	if m != nil {
+		return m.Overwrite
+	}
+	return false
+}
+
+func (m *PutOptions) GetIfMatch() string {
+	if m != nil {
+		return m.IfMatch
+	}
+	return ""
+}
+
+func (m *PutOptions) GetIfNoneMatch() string {
+	if m != nil {
+		return m.IfNoneMatch
+	}
+	return ""
+}
+
+type PutResponse struct {
+	Executed bool   `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
+	Message  string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Checksum string `protobuf:"bytes,3,opt,name=checksum" json:"checksum,omitempty"`
+}
+
+func (m *PutResponse) Reset()                    { *m = PutResponse{} }
+func (m *PutResponse) String() string            { return proto.CompactTextString(m) }
+func (*PutResponse) ProtoMessage()               {}
+func (*PutResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *PutResponse) GetExecuted() bool {
+	if m != nil {
+		return m.Executed
+	}
+	return false
+}
+
+func (m *PutResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *PutResponse) GetChecksum() string {
+	if m != nil {
+		return m.Checksum
+	}
+	return ""
+}
+
+type GetRequest struct {
+	Filename string `protobuf:"bytes,1,opt,name=filename" json:"filename,omitempty"`
+	Origin   string `protobuf:"bytes,2,opt,name=origin" json:"origin,omitempty"`
+}
+
+func (m *GetRequest) Reset()                    { *m = GetRequest{} }
+func (m *GetRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetRequest) ProtoMessage()               {}
+func (*GetRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *GetRequest) GetFilename() string {
+	if m != nil {
+		return m.Filename
+	}
+	return ""
+}
+
+func (m *GetRequest) GetOrigin() string {
+	if m != nil {
+		return m.Origin
+	}
+	return ""
+}
+
+type GetResponse struct {
+	Executed bool   `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
+	Message  string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Content  []byte `protobuf:"bytes,3,opt,name=content,proto3" json:"content,omitempty"`
+	Checksum string `protobuf:"bytes,4,opt,name=checksum" json:"checksum,omitempty"`
+}
+
+func (m *GetResponse) Reset()                    { *m = GetResponse{} }
+func (m *GetResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetResponse) ProtoMessage()               {}
+func (*GetResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *GetResponse) GetExecuted() bool {
+	if m != nil {
+		return m.Executed
+	}
+	return false
+}
+
+func (m *GetResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *GetResponse) GetContent() []byte {
+	if m != nil {
+		return m.Content
+	}
+	return nil
+}
+
+func (m *GetResponse) GetChecksum() string {
+	if m != nil {
+		return m.Checksum
+	}
+	return ""
+}
+
+type PutStreamHeader struct {
+	Origin   string      `protobuf:"bytes,1,opt,name=origin" json:"origin,omitempty"`
+	Filename string      `protobuf:"bytes,2,opt,name=filename" json:"filename,omitempty"`
+	Size     int64       `protobuf:"varint,3,opt,name=size" json:"size,omitempty"`
+	Options  *PutOptions `protobuf:"bytes,4,opt,name=options" json:"options,omitempty"`
+}
+
+func (m *PutStreamHeader) Reset()                    { *m = PutStreamHeader{} }
+func (m *PutStreamHeader) String() string            { return proto.CompactTextString(m) }
+func (*PutStreamHeader) ProtoMessage()               {}
+func (*PutStreamHeader) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+func (m *PutStreamHeader) GetOrigin() string {
+	if m != nil {
+		return m.Origin
+	}
+	return ""
+}
+
+func (m *PutStreamHeader) GetFilename() string {
+	if m != nil {
+		return m.Filename
+	}
+	return ""
+}
+
+func (m *PutStreamHeader) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *PutStreamHeader) GetOptions() *PutOptions {
+	if m != nil {
+		return m.Options
+	}
+	return nil
+}
+
+type PutStreamRequest struct {
+	// Types that are valid to be assigned to Data:
+	//	*PutStreamRequest_Header
+	//	*PutStreamRequest_Chunk
+	Data isPutStreamRequest_Data `protobuf_oneof:"data"`
+}
+
+func (m *PutStreamRequest) Reset()                    { *m = PutStreamRequest{} }
+func (m *PutStreamRequest) String() string            { return proto.CompactTextString(m) }
+func (*PutStreamRequest) ProtoMessage()               {}
+func (*PutStreamRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+type isPutStreamRequest_Data interface{ isPutStreamRequest_Data() }
+
+type PutStreamRequest_Header struct {
+	Header *PutStreamHeader `protobuf:"bytes,1,opt,name=header,oneof"`
+}
+type PutStreamRequest_Chunk struct {
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+func (*PutStreamRequest_Header) isPutStreamRequest_Data() {}
+func (*PutStreamRequest_Chunk) isPutStreamRequest_Data()  {}
+
+func (m *PutStreamRequest) GetData() isPutStreamRequest_Data {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *PutStreamRequest) GetHeader() *PutStreamHeader {
+	if x, ok := m.GetData().(*PutStreamRequest_Header); ok {
+		return x.Header
+	}
+	return nil
+}
+
+func (m *PutStreamRequest) GetChunk() []byte {
+	if x, ok := m.GetData().(*PutStreamRequest_Chunk); ok {
+		return x.Chunk
+	}
+	return nil
+}
+
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*PutStreamRequest) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
+	return _PutStreamRequest_OneofMarshaler, _PutStreamRequest_OneofUnmarshaler, _PutStreamRequest_OneofSizer, []interface{}{
+		(*PutStreamRequest_Header)(nil),
+		(*PutStreamRequest_Chunk)(nil),
+	}
+}
+
+func _PutStreamRequest_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*PutStreamRequest)
+	// data
+	switch x := m.Data.(type) {
+	case *PutStreamRequest_Header:
+		b.EncodeVarint(1<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Header); err != nil {
+			return err
+		}
+	case *PutStreamRequest_Chunk:
+		b.EncodeVarint(2<<3 | proto.WireBytes)
+		b.EncodeRawBytes(x.Chunk)
+	case nil:
+	default:
+		return fmt.Errorf("PutStreamRequest.Data has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _PutStreamRequest_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*PutStreamRequest)
+	switch tag {
+	case 1: // data.header
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(PutStreamHeader)
+		err := b.DecodeMessage(msg)
+		m.Data = &PutStreamRequest_Header{msg}
+		return true, err
+	case 2: // data.chunk
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeRawBytes(true)
+		m.Data = &PutStreamRequest_Chunk{x}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+func _PutStreamRequest_OneofSizer(msg proto.Message) (n int) {
+	m := msg.(*PutStreamRequest)
+	// data
+	switch x := m.Data.(type) {
+	case *PutStreamRequest_Header:
+		s := proto.Size(x.Header)
+		n += proto.SizeVarint(1<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
+	case *PutStreamRequest_Chunk:
+		n += proto.SizeVarint(2<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(len(x.Chunk)))
+		n += len(x.Chunk)
+	case nil:
+	default:
+		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
+	}
+	return n
+}
+
+type GetStreamResponse struct {
+	Executed bool   `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
+	Message  string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Chunk    []byte `protobuf:"bytes,3,opt,name=chunk,proto3" json:"chunk,omitempty"`
+	Checksum string `protobuf:"bytes,4,opt,name=checksum" json:"checksum,omitempty"`
+}
+
+func (m *GetStreamResponse) Reset()                    { *m = GetStreamResponse{} }
+func (m *GetStreamResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetStreamResponse) ProtoMessage()               {}
+func (*GetStreamResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+func (m *GetStreamResponse) GetExecuted() bool {
+	if m != nil {
+		return m.Executed
+	}
+	return false
+}
+
+func (m *GetStreamResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *GetStreamResponse) GetChunk() []byte {
+	if m != nil {
+		return m.Chunk
+	}
+	return nil
+}
+
+func (m *GetStreamResponse) GetChecksum() string {
+	if m != nil {
+		return m.Checksum
+	}
+	return ""
+}
+
+type RemoveRequest struct {
+	Filename string `protobuf:"bytes,1,opt,name=filename" json:"filename,omitempty"`
+	Origin   string `protobuf:"bytes,2,opt,name=origin" json:"origin,omitempty"`
+}
+
+func (m *RemoveRequest) Reset()                    { *m = RemoveRequest{} }
+func (m *RemoveRequest) String() string            { return proto.CompactTextString(m) }
+func (*RemoveRequest) ProtoMessage()               {}
+func (*RemoveRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+func (m *RemoveRequest) GetFilename() string {
+	if m != nil {
+		return m.Filename
+	}
+	return ""
+}
+
+func (m *RemoveRequest) GetOrigin() string {
+	if m != nil {
+		return m.Origin
+	}
+	return ""
+}
+
+type RemoveResponse struct {
+	Executed bool   `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
+	Message  string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *RemoveResponse) Reset()                    { *m = RemoveResponse{} }
+func (m *RemoveResponse) String() string            { return proto.CompactTextString(m) }
+func (*RemoveResponse) ProtoMessage()               {}
+func (*RemoveResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+func (m *RemoveResponse) GetExecuted() bool {
+	if m != nil {
+		return m.Executed
+	}
+	return false
+}
+
+func (m *RemoveResponse) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+type FileInfo struct {
+	Name     string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Size     int64  `protobuf:"varint,2,opt,name=size" json:"size,omitempty"`
+	Mode     uint32 `protobuf:"varint,3,opt,name=mode" json:"mode,omitempty"`
+	Mtime    int64  `protobuf:"varint,4,opt,name=mtime" json:"mtime,omitempty"`
+	IsDir    bool   `protobuf:"varint,5,opt,name=is_dir,json=isDir" json:"is_dir,omitempty"`
+	Checksum string `protobuf:"bytes,6,opt,name=checksum" json:"checksum,omitempty"`
+}
+
+func (m *FileInfo) Reset()                    { *m = FileInfo{} }
+func (m *FileInfo) String() string            { return proto.CompactTextString(m) }
+func (*FileInfo) ProtoMessage()               {}
+func (*FileInfo) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+func (m *FileInfo) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *FileInfo) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *FileInfo) GetMode() uint32 {
+	if m != nil {
+		return m.Mode
+	}
+	return 0
+}
+
+func (m *FileInfo) GetMtime() int64 {
+	if m != nil {
+		return m.Mtime
+	}
+	return 0
+}
+
+func (m *FileInfo) GetIsDir() bool {
 	if m != nil {
-		return m.Content
+		return m.IsDir
 	}
-	return nil
+	return false
 }
 
-func (m *PutRequest) GetOrigin() string {
+func (m *FileInfo) GetChecksum() string {
 	if m != nil {
-		return m.Origin
+		return m.Checksum
 	}
 	return ""
 }
 
-type PutResponse struct {
-	Executed bool   `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
-	Message  string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+type ListFilesRequest struct {
+	Origin    string `protobuf:"bytes,1,opt,name=origin" json:"origin,omitempty"`
+	Prefix    string `protobuf:"bytes,2,opt,name=prefix" json:"prefix,omitempty"`
+	Recursive bool   `protobuf:"varint,3,opt,name=recursive" json:"recursive,omitempty"`
+	PageToken string `protobuf:"bytes,4,opt,name=page_token,json=pageToken" json:"page_token,omitempty"`
 }
 
-func (m *PutResponse) Reset()                    { *m = PutResponse{} }
-func (m *PutResponse) String() string            { return proto.CompactTextString(m) }
-func (*PutResponse) ProtoMessage()               {}
-func (*PutResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (m *ListFilesRequest) Reset()                    { *m = ListFilesRequest{} }
+func (m *ListFilesRequest) String() string            { return proto.CompactTextString(m) }
+func (*ListFilesRequest) ProtoMessage()               {}
+func (*ListFilesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
 
-func (m *PutResponse) GetExecuted() bool {
+func (m *ListFilesRequest) GetOrigin() string {
 	if m != nil {
-		return m.Executed
+		return m.Origin
 	}
-	return false
+	return ""
 }
 
-func (m *PutResponse) GetMessage() string {
+func (m *ListFilesRequest) GetPrefix() string {
 	if m != nil {
-		return m.Message
+		return m.Prefix
 	}
 	return ""
 }
 
-type GetRequest struct {
-	Filename string `protobuf:"bytes,1,opt,name=filename" json:"filename,omitempty"`
-	Origin   string `protobuf:"bytes,2,opt,name=origin" json:"origin,omitempty"`
-}
-
-func (m *GetRequest) Reset()                    { *m = GetRequest{} }
-func (m *GetRequest) String() string            { return proto.CompactTextString(m) }
-func (*GetRequest) ProtoMessage()               {}
-func (*GetRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
-
-func (m *GetRequest) GetFilename() string {
+func (m *ListFilesRequest) GetRecursive() bool {
 	if m != nil {
-		return m.Filename
+		return m.Recursive
 	}
-	return ""
+	return false
 }
 
-func (m *GetRequest) GetOrigin() string {
+func (m *ListFilesRequest) GetPageToken() string {
 	if m != nil {
-		return m.Origin
+		return m.PageToken
 	}
 	return ""
 }
 
-type GetResponse struct {
-	Executed bool   `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
-	Message  string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
-	Content  []byte `protobuf:"bytes,3,opt,name=content,proto3" json:"content,omitempty"`
+type ListFilesResponse struct {
+	Executed      bool        `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
+	Message       string      `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Files         []*FileInfo `protobuf:"bytes,3,rep,name=files" json:"files,omitempty"`
+	NextPageToken string      `protobuf:"bytes,4,opt,name=next_page_token,json=nextPageToken" json:"next_page_token,omitempty"`
 }
 
-func (m *GetResponse) Reset()                    { *m = GetResponse{} }
-func (m *GetResponse) String() string            { return proto.CompactTextString(m) }
-func (*GetResponse) ProtoMessage()               {}
-func (*GetResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+func (m *ListFilesResponse) Reset()                    { *m = ListFilesResponse{} }
+func (m *ListFilesResponse) String() string            { return proto.CompactTextString(m) }
+func (*ListFilesResponse) ProtoMessage()               {}
+func (*ListFilesResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
 
-func (m *GetResponse) GetExecuted() bool {
+func (m *ListFilesResponse) GetExecuted() bool {
 	if m != nil {
 		return m.Executed
 	}
 	return false
 }
 
-func (m *GetResponse) GetMessage() string {
+func (m *ListFilesResponse) GetMessage() string {
 	if m != nil {
 		return m.Message
 	}
 	return ""
 }
 
-func (m *GetResponse) GetContent() []byte {
+func (m *ListFilesResponse) GetFiles() []*FileInfo {
 	if m != nil {
-		return m.Content
+		return m.Files
 	}
 	return nil
 }
 
-type RemoveRequest struct {
+func (m *ListFilesResponse) GetNextPageToken() string {
+	if m != nil {
+		return m.NextPageToken
+	}
+	return ""
+}
+
+type StatFileRequest struct {
 	Filename string `protobuf:"bytes,1,opt,name=filename" json:"filename,omitempty"`
 	Origin   string `protobuf:"bytes,2,opt,name=origin" json:"origin,omitempty"`
 }
 
-func (m *RemoveRequest) Reset()                    { *m = RemoveRequest{} }
-func (m *RemoveRequest) String() string            { return proto.CompactTextString(m) }
-func (*RemoveRequest) ProtoMessage()               {}
-func (*RemoveRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+func (m *StatFileRequest) Reset()                    { *m = StatFileRequest{} }
+func (m *StatFileRequest) String() string            { return proto.CompactTextString(m) }
+func (*StatFileRequest) ProtoMessage()               {}
+func (*StatFileRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
 
-func (m *RemoveRequest) GetFilename() string {
+func (m *StatFileRequest) GetFilename() string {
 	if m != nil {
 		return m.Filename
 	}
 	return ""
 }
 
-func (m *RemoveRequest) GetOrigin() string {
+func (m *StatFileRequest) GetOrigin() string {
 	if m != nil {
 		return m.Origin
 	}
 	return ""
 }
 
-type RemoveResponse struct {
-	Executed bool   `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
-	Message  string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+type StatFileResponse struct {
+	Executed bool      `protobuf:"varint,1,opt,name=executed" json:"executed,omitempty"`
+	Message  string    `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	Info     *FileInfo `protobuf:"bytes,3,opt,name=info" json:"info,omitempty"`
 }
 
-func (m *RemoveResponse) Reset()                    { *m = RemoveResponse{} }
-func (m *RemoveResponse) String() string            { return proto.CompactTextString(m) }
-func (*RemoveResponse) ProtoMessage()               {}
-func (*RemoveResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+func (m *StatFileResponse) Reset()                    { *m = StatFileResponse{} }
+func (m *StatFileResponse) String() string            { return proto.CompactTextString(m) }
+func (*StatFileResponse) ProtoMessage()               {}
+func (*StatFileResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
 
-func (m *RemoveResponse) GetExecuted() bool {
+func (m *StatFileResponse) GetExecuted() bool {
 	if m != nil {
 		return m.Executed
 	}
 	return false
 }
 
-func (m *RemoveResponse) GetMessage() string {
+func (m *StatFileResponse) GetMessage() string {
 	if m != nil {
 		return m.Message
 	}
 	return ""
 }
 
+func (m *StatFileResponse) GetInfo() *FileInfo {
+	if m != nil {
+		return m.Info
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*FilesystemRequest)(nil), "wizefsservice.FilesystemRequest")
 	proto.RegisterType((*FilesystemResponse)(nil), "wizefsservice.FilesystemResponse")
+	proto.RegisterType((*SessionResponse)(nil), "wizefsservice.SessionResponse")
+	proto.RegisterType((*ListBucketsRequest)(nil), "wizefsservice.ListBucketsRequest")
+	proto.RegisterType((*BucketInfo)(nil), "wizefsservice.BucketInfo")
+	proto.RegisterType((*ListBucketsResponse)(nil), "wizefsservice.ListBucketsResponse")
+	proto.RegisterType((*VerifyRequest)(nil), "wizefsservice.VerifyRequest")
+	proto.RegisterType((*CleanRequest)(nil), "wizefsservice.CleanRequest")
+	proto.RegisterType((*Problem)(nil), "wizefsservice.Problem")
+	proto.RegisterType((*VerifyResponse)(nil), "wizefsservice.VerifyResponse")
 	proto.RegisterType((*PutRequest)(nil), "wizefsservice.PutRequest")
+	proto.RegisterType((*PutOptions)(nil), "wizefsservice.PutOptions")
 	proto.RegisterType((*PutResponse)(nil), "wizefsservice.PutResponse")
 	proto.RegisterType((*GetRequest)(nil), "wizefsservice.GetRequest")
 	proto.RegisterType((*GetResponse)(nil), "wizefsservice.GetResponse")
+	proto.RegisterType((*PutStreamHeader)(nil), "wizefsservice.PutStreamHeader")
+	proto.RegisterType((*PutStreamRequest)(nil), "wizefsservice.PutStreamRequest")
+	proto.RegisterType((*GetStreamResponse)(nil), "wizefsservice.GetStreamResponse")
 	proto.RegisterType((*RemoveRequest)(nil), "wizefsservice.RemoveRequest")
 	proto.RegisterType((*RemoveResponse)(nil), "wizefsservice.RemoveResponse")
+	proto.RegisterType((*FileInfo)(nil), "wizefsservice.FileInfo")
+	proto.RegisterType((*ListFilesRequest)(nil), "wizefsservice.ListFilesRequest")
+	proto.RegisterType((*ListFilesResponse)(nil), "wizefsservice.ListFilesResponse")
+	proto.RegisterType((*StatFileRequest)(nil), "wizefsservice.StatFileRequest")
+	proto.RegisterType((*StatFileResponse)(nil), "wizefsservice.StatFileResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -265,6 +1040,13 @@ type WizeFsServiceClient interface {
 	Delete(ctx context.Context, in *FilesystemRequest, opts ...grpc.CallOption) (*FilesystemResponse, error)
 	Mount(ctx context.Context, in *FilesystemRequest, opts ...grpc.CallOption) (*FilesystemResponse, error)
 	Unmount(ctx context.Context, in *FilesystemRequest, opts ...grpc.CallOption) (*FilesystemResponse, error)
+	// idle timeout of the mounted Bucket and the time until it's unmounted
+	Session(ctx context.Context, in *FilesystemRequest, opts ...grpc.CallOption) (*SessionResponse, error)
+	// all Buckets of the Storage, see Storage.List
+	ListBuckets(ctx context.Context, in *ListBucketsRequest, opts ...grpc.CallOption) (*ListBucketsResponse, error)
+	// problems of the Storage, see Storage.Verify and Storage.Clean
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+	Clean(ctx context.Context, in *CleanRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
 	// potential client-side streaming RPC:
 	// client sends a sequence of messages using a provided stream
 	// server read them and return its response
@@ -273,7 +1055,18 @@ type WizeFsServiceClient interface {
 	// client sends a request and gets a stream to read a sequence of messages
 	// server sends a sequence of messages
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// client-side streaming RPC for large files:
+	// the first message carries the header (origin, filename, size),
+	// all next messages carry chunks of the file content
+	PutStream(ctx context.Context, opts ...grpc.CallOption) (WizeFsService_PutStreamClient, error)
+	// server-side streaming RPC for large files:
+	// the first message carries the result of the request,
+	// all messages carry chunks of the file content
+	GetStream(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (WizeFsService_GetStreamClient, error)
 	Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error)
+	// files of the Bucket page by page, see Bucket.List
+	ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error)
+	StatFile(ctx context.Context, in *StatFileRequest, opts ...grpc.CallOption) (*StatFileResponse, error)
 }
 
 type wizeFsServiceClient struct {
@@ -320,6 +1113,42 @@ func (c *wizeFsServiceClient) Unmount(ctx context.Context, in *FilesystemRequest
 	return out, nil
 }
 
+func (c *wizeFsServiceClient) Session(ctx context.Context, in *FilesystemRequest, opts ...grpc.CallOption) (*SessionResponse, error) {
+	out := new(SessionResponse)
+	err := grpc.Invoke(ctx, "/wizefsservice.WizeFsService/Session", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wizeFsServiceClient) ListBuckets(ctx context.Context, in *ListBucketsRequest, opts ...grpc.CallOption) (*ListBucketsResponse, error) {
+	out := new(ListBucketsResponse)
+	err := grpc.Invoke(ctx, "/wizefsservice.WizeFsService/ListBuckets", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wizeFsServiceClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	out := new(VerifyResponse)
+	err := grpc.Invoke(ctx, "/wizefsservice.WizeFsService/Verify", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wizeFsServiceClient) Clean(ctx context.Context, in *CleanRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	out := new(VerifyResponse)
+	err := grpc.Invoke(ctx, "/wizefsservice.WizeFsService/Clean", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *wizeFsServiceClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
 	out := new(PutResponse)
 	err := grpc.Invoke(ctx, "/wizefsservice.WizeFsService/Put", in, out, c.cc, opts...)
@@ -338,6 +1167,72 @@ func (c *wizeFsServiceClient) Get(ctx context.Context, in *GetRequest, opts ...g
 	return out, nil
 }
 
+func (c *wizeFsServiceClient) PutStream(ctx context.Context, opts ...grpc.CallOption) (WizeFsService_PutStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_WizeFsService_serviceDesc.Streams[0], c.cc, "/wizefsservice.WizeFsService/PutStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &wizeFsServicePutStreamClient{stream}
+	return x, nil
+}
+
+type WizeFsService_PutStreamClient interface {
+	Send(*PutStreamRequest) error
+	CloseAndRecv() (*PutResponse, error)
+	grpc.ClientStream
+}
+
+type wizeFsServicePutStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *wizeFsServicePutStreamClient) Send(m *PutStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *wizeFsServicePutStreamClient) CloseAndRecv() (*PutResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PutResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *wizeFsServiceClient) GetStream(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (WizeFsService_GetStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_WizeFsService_serviceDesc.Streams[1], c.cc, "/wizefsservice.WizeFsService/GetStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &wizeFsServiceGetStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WizeFsService_GetStreamClient interface {
+	Recv() (*GetStreamResponse, error)
+	grpc.ClientStream
+}
+
+type wizeFsServiceGetStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *wizeFsServiceGetStreamClient) Recv() (*GetStreamResponse, error) {
+	m := new(GetStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *wizeFsServiceClient) Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error) {
 	out := new(RemoveResponse)
 	err := grpc.Invoke(ctx, "/wizefsservice.WizeFsService/Remove", in, out, c.cc, opts...)
@@ -347,6 +1242,24 @@ func (c *wizeFsServiceClient) Remove(ctx context.Context, in *RemoveRequest, opt
 	return out, nil
 }
 
+func (c *wizeFsServiceClient) ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error) {
+	out := new(ListFilesResponse)
+	err := grpc.Invoke(ctx, "/wizefsservice.WizeFsService/ListFiles", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wizeFsServiceClient) StatFile(ctx context.Context, in *StatFileRequest, opts ...grpc.CallOption) (*StatFileResponse, error) {
+	out := new(StatFileResponse)
+	err := grpc.Invoke(ctx, "/wizefsservice.WizeFsService/StatFile", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for WizeFsService service
 
 type WizeFsServiceServer interface {
@@ -354,6 +1267,13 @@ type WizeFsServiceServer interface {
 	Delete(context.Context, *FilesystemRequest) (*FilesystemResponse, error)
 	Mount(context.Context, *FilesystemRequest) (*FilesystemResponse, error)
 	Unmount(context.Context, *FilesystemRequest) (*FilesystemResponse, error)
+	// idle timeout of the mounted Bucket and the time until it's unmounted
+	Session(context.Context, *FilesystemRequest) (*SessionResponse, error)
+	// all Buckets of the Storage, see Storage.List
+	ListBuckets(context.Context, *ListBucketsRequest) (*ListBucketsResponse, error)
+	// problems of the Storage, see Storage.Verify and Storage.Clean
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	Clean(context.Context, *CleanRequest) (*VerifyResponse, error)
 	// potential client-side streaming RPC:
 	// client sends a sequence of messages using a provided stream
 	// server read them and return its response
@@ -362,7 +1282,18 @@ type WizeFsServiceServer interface {
 	// client sends a request and gets a stream to read a sequence of messages
 	// server sends a sequence of messages
 	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// client-side streaming RPC for large files:
+	// the first message carries the header (origin, filename, size),
+	// all next messages carry chunks of the file content
+	PutStream(WizeFsService_PutStreamServer) error
+	// server-side streaming RPC for large files:
+	// the first message carries the result of the request,
+	// all messages carry chunks of the file content
+	GetStream(*GetRequest, WizeFsService_GetStreamServer) error
 	Remove(context.Context, *RemoveRequest) (*RemoveResponse, error)
+	// files of the Bucket page by page, see Bucket.List
+	ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error)
+	StatFile(context.Context, *StatFileRequest) (*StatFileResponse, error)
 }
 
 func RegisterWizeFsServiceServer(s *grpc.Server, srv WizeFsServiceServer) {
@@ -441,6 +1372,78 @@ func _WizeFsService_Unmount_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _WizeFsService_Session_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FilesystemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WizeFsServiceServer).Session(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wizefsservice.WizeFsService/Session",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WizeFsServiceServer).Session(ctx, req.(*FilesystemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WizeFsService_ListBuckets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBucketsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WizeFsServiceServer).ListBuckets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wizefsservice.WizeFsService/ListBuckets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WizeFsServiceServer).ListBuckets(ctx, req.(*ListBucketsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WizeFsService_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WizeFsServiceServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wizefsservice.WizeFsService/Verify",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WizeFsServiceServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WizeFsService_Clean_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CleanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WizeFsServiceServer).Clean(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wizefsservice.WizeFsService/Clean",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WizeFsServiceServer).Clean(ctx, req.(*CleanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _WizeFsService_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(PutRequest)
 	if err := dec(in); err != nil {
@@ -477,6 +1480,53 @@ func _WizeFsService_Get_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _WizeFsService_PutStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WizeFsServiceServer).PutStream(&wizeFsServicePutStreamServer{stream})
+}
+
+type WizeFsService_PutStreamServer interface {
+	SendAndClose(*PutResponse) error
+	Recv() (*PutStreamRequest, error)
+	grpc.ServerStream
+}
+
+type wizeFsServicePutStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *wizeFsServicePutStreamServer) SendAndClose(m *PutResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *wizeFsServicePutStreamServer) Recv() (*PutStreamRequest, error) {
+	m := new(PutStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _WizeFsService_GetStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WizeFsServiceServer).GetStream(m, &wizeFsServiceGetStreamServer{stream})
+}
+
+type WizeFsService_GetStreamServer interface {
+	Send(*GetStreamResponse) error
+	grpc.ServerStream
+}
+
+type wizeFsServiceGetStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *wizeFsServiceGetStreamServer) Send(m *GetStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _WizeFsService_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(RemoveRequest)
 	if err := dec(in); err != nil {
@@ -495,6 +1545,42 @@ func _WizeFsService_Remove_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _WizeFsService_ListFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WizeFsServiceServer).ListFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wizefsservice.WizeFsService/ListFiles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WizeFsServiceServer).ListFiles(ctx, req.(*ListFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WizeFsService_StatFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WizeFsServiceServer).StatFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/wizefsservice.WizeFsService/StatFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WizeFsServiceServer).StatFile(ctx, req.(*StatFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _WizeFsService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "wizefsservice.WizeFsService",
 	HandlerType: (*WizeFsServiceServer)(nil),
@@ -515,6 +1601,22 @@ var _WizeFsService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Unmount",
 			Handler:    _WizeFsService_Unmount_Handler,
 		},
+		{
+			MethodName: "Session",
+			Handler:    _WizeFsService_Session_Handler,
+		},
+		{
+			MethodName: "ListBuckets",
+			Handler:    _WizeFsService_ListBuckets_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _WizeFsService_Verify_Handler,
+		},
+		{
+			MethodName: "Clean",
+			Handler:    _WizeFsService_Clean_Handler,
+		},
 		{
 			MethodName: "Put",
 			Handler:    _WizeFsService_Put_Handler,
@@ -527,35 +1629,106 @@ var _WizeFsService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Remove",
 			Handler:    _WizeFsService_Remove_Handler,
 		},
+		{
+			MethodName: "ListFiles",
+			Handler:    _WizeFsService_ListFiles_Handler,
+		},
+		{
+			MethodName: "StatFile",
+			Handler:    _WizeFsService_StatFile_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PutStream",
+			Handler:       _WizeFsService_PutStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetStream",
+			Handler:       _WizeFsService_GetStream_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "wizefs_service.proto",
 }
 
 func init() { proto.RegisterFile("wizefs_service.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 351 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x94, 0x5f, 0x4b, 0x32, 0x41,
-	0x18, 0xc5, 0xfd, 0xf3, 0xbe, 0xab, 0x3d, 0x66, 0xd0, 0x10, 0x61, 0x4b, 0x81, 0xcd, 0x55, 0x10,
-	0x78, 0x51, 0xf7, 0x11, 0x18, 0x0a, 0x41, 0x25, 0x1b, 0x11, 0x04, 0x11, 0x66, 0x47, 0x19, 0x70,
-	0x67, 0x6c, 0x67, 0xd6, 0xca, 0xcf, 0xd2, 0x87, 0x0d, 0x67, 0x5d, 0xdd, 0xd5, 0x8a, 0x60, 0xbd,
-	0x7c, 0x66, 0xce, 0xfc, 0xe6, 0x37, 0x70, 0x76, 0x69, 0xe7, 0x4d, 0x4c, 0xd0, 0xd7, 0x4f, 0x1a,
-	0xc1, 0x58, 0xf4, 0xd0, 0x18, 0x05, 0xca, 0x28, 0x56, 0x8d, 0x56, 0x67, 0x8b, 0xfc, 0x98, 0xb6,
-	0x5b, 0x62, 0x08, 0xfd, 0xa1, 0x0d, 0x7c, 0x0f, 0xaf, 0x21, 0xb4, 0x61, 0xbb, 0xe4, 0xa8, 0x40,
-	0x0c, 0x84, 0xac, 0xe5, 0xeb, 0xf9, 0xa3, 0x0d, 0x6f, 0x36, 0xf1, 0x4b, 0x62, 0xc9, 0xb0, 0x1e,
-	0x29, 0xa9, 0xc1, 0x5c, 0x2a, 0xe3, 0x1d, 0xbd, 0xd0, 0xe0, 0xc5, 0xe6, 0xcb, 0xde, 0x7c, 0x66,
-	0x35, 0x2a, 0xf9, 0xd0, 0xba, 0x3b, 0x40, 0xad, 0x60, 0x51, 0xf1, 0xc8, 0x1f, 0x88, 0x3a, 0xa1,
-	0x89, 0x6f, 0x74, 0xa9, 0xdc, 0x17, 0x43, 0xc8, 0xae, 0x8f, 0xd9, 0x9d, 0xf3, 0x79, 0xca, 0xe8,
-	0x29, 0x69, 0x20, 0x8d, 0x65, 0x6c, 0x7a, 0xf1, 0x98, 0xf0, 0x2c, 0xa6, 0x3c, 0x9b, 0x54, 0xb1,
-	0xec, 0x4c, 0x82, 0xe7, 0x44, 0x6d, 0xfc, 0x49, 0x70, 0xa1, 0x51, 0x48, 0x69, 0x3c, 0x52, 0xc5,
-	0x12, 0xb2, 0x68, 0x24, 0x5f, 0x5f, 0x4c, 0xbd, 0x9e, 0x37, 0xa9, 0xea, 0xc1, 0x57, 0x63, 0x64,
-	0x71, 0x6c, 0xd1, 0x56, 0x0c, 0xc9, 0xa2, 0x79, 0xf2, 0xf9, 0x8f, 0xaa, 0xf7, 0x62, 0x82, 0x96,
-	0xbe, 0x8d, 0x9a, 0xc5, 0x6e, 0xc8, 0x69, 0x06, 0xe8, 0x1a, 0xb0, 0x7a, 0x23, 0xd5, 0xb9, 0xc6,
-	0x4a, 0xe1, 0xdc, 0xc3, 0x5f, 0x12, 0x91, 0x16, 0xcf, 0x4d, 0x81, 0x17, 0x18, 0x62, 0x7d, 0xc0,
-	0x6b, 0xfa, 0x7f, 0xa5, 0x42, 0x69, 0xd6, 0xc5, 0xeb, 0x50, 0xe9, 0x4e, 0xfa, 0xeb, 0x24, 0x9e,
-	0x51, 0xb1, 0x13, 0x1a, 0xb6, 0xb7, 0x94, 0x5d, 0x7c, 0x38, 0xae, 0xfb, 0xdd, 0x56, 0xf2, 0x7c,
-	0x1b, 0xab, 0xe7, 0x17, 0xbd, 0x5e, 0x39, 0x9f, 0x28, 0x2c, 0xcf, 0xb1, 0x36, 0x39, 0x51, 0x3b,
-	0xd8, 0xfe, 0x52, 0x2e, 0xd5, 0x3c, 0xf7, 0xe0, 0x87, 0xdd, 0x18, 0xf4, 0xec, 0xd8, 0x9f, 0xcf,
-	0xe9, 0x57, 0x00, 0x00, 0x00, 0xff, 0xff, 0xa7, 0x9c, 0x24, 0xf1, 0x94, 0x04, 0x00, 0x00,
+	// 1177 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x57, 0xcd, 0x6e, 0xdb, 0x46,
+	0x10, 0x16, 0xad, 0x3f, 0x6a, 0x14, 0xc5, 0xc9, 0xd6, 0x75, 0x14, 0x35, 0x8e, 0x55, 0x02, 0x6d,
+	0x0c, 0x14, 0x35, 0x0a, 0xfb, 0xd2, 0x53, 0x51, 0xc4, 0x89, 0xe5, 0x16, 0x71, 0x22, 0xd0, 0x69,
+	0x7a, 0x14, 0x68, 0x71, 0x64, 0x2d, 0x2c, 0x2e, 0xd5, 0xdd, 0xa5, 0x6d, 0xb9, 0x87, 0xf6, 0xda,
+	0x4b, 0xd3, 0x47, 0xe8, 0xbd, 0x2f, 0xd5, 0x47, 0x29, 0x76, 0x97, 0xa4, 0x28, 0xca, 0x92, 0x8d,
+	0xca, 0xb7, 0x9d, 0xd9, 0xe1, 0xec, 0x37, 0xdf, 0x8c, 0x66, 0x46, 0xb0, 0x71, 0x49, 0xaf, 0x71,
+	0x20, 0x7a, 0x02, 0xf9, 0x05, 0xed, 0xe3, 0xee, 0x98, 0x87, 0x32, 0x24, 0x0d, 0xa3, 0x8d, 0x95,
+	0xce, 0x29, 0x3c, 0x3e, 0xa4, 0x23, 0x14, 0x13, 0x21, 0x31, 0x70, 0xf1, 0x97, 0x08, 0x85, 0x24,
+	0x9b, 0x50, 0x09, 0x39, 0x3d, 0xa3, 0xac, 0x69, 0xb5, 0xad, 0x9d, 0x9a, 0x1b, 0x4b, 0xa4, 0x05,
+	0xf6, 0xd8, 0x13, 0xe2, 0x32, 0xe4, 0x7e, 0x73, 0x4d, 0xdf, 0xa4, 0x32, 0x79, 0x0a, 0x36, 0xf5,
+	0x47, 0xd8, 0x93, 0x72, 0xd4, 0x2c, 0xb6, 0xad, 0x9d, 0xa2, 0x5b, 0x55, 0xf2, 0x7b, 0x39, 0x72,
+	0x7e, 0x04, 0x92, 0x7d, 0x43, 0x8c, 0x43, 0x26, 0x50, 0x39, 0xc3, 0x2b, 0xec, 0x47, 0x12, 0x7d,
+	0xfd, 0x8c, 0xed, 0xa6, 0x32, 0x69, 0x42, 0x35, 0x40, 0x21, 0xbc, 0x33, 0x8c, 0xdf, 0x49, 0x44,
+	0xe7, 0x0f, 0x0b, 0xd6, 0x4f, 0x50, 0x08, 0x1a, 0xb2, 0xd5, 0x3c, 0x2d, 0x01, 0x4c, 0xbe, 0x80,
+	0x87, 0xfa, 0x8a, 0x63, 0xe0, 0x51, 0x46, 0xd9, 0x59, 0xb3, 0xa4, 0x0d, 0x1a, 0x4a, 0xeb, 0x26,
+	0x4a, 0x67, 0x03, 0xc8, 0x1b, 0x2a, 0xe4, 0xcb, 0xa8, 0x7f, 0x8e, 0x52, 0xc4, 0xe4, 0x39, 0xff,
+	0x5a, 0x00, 0x46, 0xf5, 0x03, 0x1b, 0x84, 0x0b, 0xb9, 0x24, 0x50, 0x92, 0x93, 0xb1, 0x41, 0x55,
+	0x76, 0xf5, 0x99, 0x6c, 0x43, 0xdd, 0xdc, 0xf6, 0xc6, 0x9e, 0x1c, 0x6a, 0x54, 0x35, 0x17, 0x8c,
+	0xaa, 0xeb, 0xc9, 0xa1, 0x8e, 0x26, 0x8c, 0x98, 0x0a, 0xb4, 0xa4, 0x03, 0x4d, 0x44, 0xf2, 0x1c,
+	0x40, 0x1f, 0xc7, 0x21, 0x65, 0xb2, 0x59, 0x36, 0x5f, 0x4e, 0x35, 0xea, 0x39, 0x41, 0xaf, 0xb1,
+	0x59, 0xd1, 0x81, 0xe8, 0x33, 0xd9, 0x02, 0x18, 0xd0, 0x11, 0xf6, 0xfa, 0xca, 0xac, 0x59, 0xd5,
+	0x37, 0x35, 0xa5, 0x39, 0x50, 0x0a, 0xf5, 0x58, 0x9f, 0xa3, 0xa7, 0x1e, 0xb3, 0x0d, 0x3f, 0xb1,
+	0xe8, 0xfc, 0x6e, 0xc1, 0x27, 0x33, 0x91, 0xaf, 0x94, 0x88, 0x7d, 0xa8, 0x9e, 0x1a, 0x47, 0xcd,
+	0x62, 0xbb, 0xb8, 0x53, 0xdf, 0x7b, 0xba, 0x3b, 0x53, 0xa3, 0xbb, 0x53, 0x36, 0xdd, 0xc4, 0xd2,
+	0x79, 0x01, 0x8d, 0x0f, 0xc8, 0xe9, 0x60, 0x72, 0x4b, 0xcd, 0x3a, 0x2f, 0xe0, 0xc1, 0xc1, 0x08,
+	0x3d, 0x96, 0xd8, 0x3d, 0x81, 0xaa, 0xcf, 0x27, 0x3d, 0x1e, 0xb1, 0x18, 0x62, 0xc5, 0xe7, 0x13,
+	0x37, 0x62, 0xce, 0x3f, 0x16, 0x54, 0xbb, 0x3c, 0x3c, 0x1d, 0x61, 0xa0, 0xd8, 0x3a, 0xa7, 0xcc,
+	0x8f, 0x5d, 0xe9, 0x73, 0xe6, 0x81, 0xb5, 0x7c, 0x22, 0x33, 0xd9, 0xd2, 0x67, 0x65, 0xeb, 0xa3,
+	0xf4, 0xe8, 0x48, 0xa7, 0xa9, 0xe6, 0xc6, 0x92, 0xd2, 0x73, 0x1c, 0x7b, 0x94, 0xc7, 0x19, 0x8a,
+	0x25, 0x45, 0x9c, 0x39, 0xa1, 0xaf, 0x33, 0x64, 0xbb, 0xa9, 0x4c, 0x36, 0xa0, 0x8c, 0x9c, 0x87,
+	0x5c, 0x27, 0xa8, 0xe6, 0x1a, 0xc1, 0xb9, 0x86, 0x87, 0x49, 0xfc, 0x2b, 0x91, 0xbf, 0x07, 0xf6,
+	0xd8, 0x04, 0x9d, 0xb0, 0xbf, 0x99, 0x63, 0x3f, 0xe6, 0xc4, 0x4d, 0xed, 0x9c, 0x8f, 0x16, 0x40,
+	0x37, 0x92, 0x09, 0xa3, 0x2d, 0xb0, 0x55, 0xd1, 0x30, 0x2f, 0xc0, 0x98, 0xb0, 0x54, 0xd6, 0x35,
+	0x14, 0x32, 0x89, 0x4c, 0xea, 0x87, 0x1f, 0xb8, 0x89, 0x98, 0xa1, 0xb3, 0x38, 0x43, 0xe7, 0x3e,
+	0x54, 0xc3, 0xb1, 0xa4, 0x21, 0x13, 0x9a, 0xbb, 0xf9, 0x6a, 0xe8, 0x46, 0xf2, 0x9d, 0x31, 0x70,
+	0x13, 0x4b, 0x87, 0x02, 0x4c, 0xd5, 0xe4, 0x19, 0xd4, 0xc2, 0x0b, 0xe4, 0x97, 0x9c, 0x4a, 0x8c,
+	0xa9, 0x98, 0x2a, 0xf4, 0xef, 0x7e, 0xd0, 0x0b, 0x3c, 0xd9, 0x1f, 0x26, 0x64, 0xd0, 0xc1, 0xb1,
+	0x12, 0x89, 0x03, 0x0d, 0x3a, 0xe8, 0xb1, 0x90, 0x61, 0x7c, 0x6f, 0xa0, 0xd5, 0xe9, 0xe0, 0x6d,
+	0xc8, 0x50, 0xdb, 0x38, 0x3d, 0xa8, 0xeb, 0xd8, 0x57, 0x62, 0xbd, 0x05, 0x76, 0x7f, 0x88, 0xfd,
+	0x73, 0x11, 0x05, 0xf1, 0x1b, 0xa9, 0xec, 0x7c, 0x0f, 0xd0, 0xc1, 0x3b, 0x91, 0xbb, 0xa0, 0x22,
+	0x9d, 0x09, 0xd4, 0x3b, 0xb8, 0x2a, 0xc4, 0x4c, 0xe6, 0x8a, 0xb3, 0x99, 0xcb, 0x82, 0x2f, 0xe5,
+	0xc0, 0xff, 0x69, 0xc1, 0x7a, 0x37, 0x92, 0x27, 0x92, 0xa3, 0x17, 0x1c, 0xa1, 0xe7, 0x23, 0x5f,
+	0x36, 0x4d, 0xd2, 0xd0, 0xd6, 0x72, 0xa1, 0x25, 0xed, 0xaa, 0x98, 0x69, 0x57, 0xff, 0xab, 0x32,
+	0x46, 0xf0, 0x28, 0xc5, 0x93, 0x70, 0xfa, 0x2d, 0x54, 0x86, 0x1a, 0x9a, 0x06, 0x54, 0xdf, 0x7b,
+	0x3e, 0xef, 0x27, 0x1b, 0xc0, 0x51, 0xc1, 0x8d, 0xed, 0xc9, 0x26, 0x94, 0xfb, 0xc3, 0x88, 0x9d,
+	0x9b, 0x62, 0x3e, 0x2a, 0xb8, 0x46, 0x7c, 0x59, 0x81, 0x92, 0xef, 0x49, 0xcf, 0xf9, 0x15, 0x1e,
+	0x77, 0x30, 0x7d, 0x6d, 0x25, 0xfe, 0x37, 0x92, 0xa7, 0x0c, 0xfb, 0x46, 0x58, 0xca, 0xfd, 0x01,
+	0x34, 0x5c, 0x0c, 0xc2, 0x0b, 0x5c, 0xa5, 0x76, 0x0e, 0xe1, 0x61, 0xe2, 0x64, 0xa5, 0x39, 0xfd,
+	0xd1, 0x02, 0x5b, 0x0d, 0x7d, 0x3d, 0x03, 0x09, 0x94, 0x32, 0x20, 0x4a, 0x33, 0x19, 0x5e, 0xcb,
+	0x64, 0x98, 0x40, 0x29, 0x08, 0x7d, 0x93, 0xf5, 0x86, 0xab, 0xcf, 0x8a, 0x87, 0x40, 0xd2, 0x00,
+	0xe3, 0x11, 0x6c, 0x04, 0xf2, 0x29, 0x54, 0xa8, 0xe8, 0xf9, 0x71, 0x23, 0xb5, 0xdd, 0x32, 0x15,
+	0xaf, 0x4c, 0x1f, 0x4d, 0xe9, 0xa9, 0xe4, 0xe8, 0xf9, 0x0d, 0x1e, 0xa9, 0x99, 0xa5, 0x40, 0x89,
+	0xdb, 0x16, 0x9d, 0x4d, 0xa8, 0x8c, 0x39, 0x0e, 0xe8, 0x55, 0xc2, 0x8e, 0x91, 0x54, 0x67, 0xe1,
+	0xd8, 0x8f, 0xb8, 0xa0, 0x17, 0x06, 0xa5, 0xed, 0x4e, 0x15, 0x6a, 0x9e, 0x8e, 0xbd, 0x33, 0xec,
+	0xc9, 0xf0, 0x1c, 0x59, 0x9c, 0x9e, 0x9a, 0xd2, 0xbc, 0x57, 0x0a, 0xe7, 0x6f, 0x0b, 0x1e, 0x67,
+	0x10, 0xac, 0x54, 0x1d, 0x5f, 0x43, 0x59, 0xa5, 0x32, 0xe9, 0xd9, 0x4f, 0x72, 0x15, 0x9c, 0x30,
+	0xef, 0x1a, 0x2b, 0xf2, 0x25, 0xac, 0x33, 0xbc, 0x92, 0xbd, 0x39, 0x78, 0x0d, 0xa5, 0xee, 0xa6,
+	0x10, 0x5f, 0xc3, 0xfa, 0x89, 0xf4, 0x34, 0xc2, 0x55, 0x8a, 0x28, 0x82, 0x47, 0x53, 0x37, 0x2b,
+	0xc5, 0xf9, 0x15, 0x94, 0x28, 0x1b, 0x84, 0x9a, 0xeb, 0x25, 0x61, 0x6a, 0xa3, 0xbd, 0xbf, 0x6a,
+	0xd0, 0xf8, 0x99, 0x5e, 0xe3, 0xa1, 0x38, 0x31, 0x06, 0xe4, 0x1d, 0x54, 0x0e, 0xf4, 0xce, 0x42,
+	0xda, 0x37, 0x7c, 0x3a, 0xb3, 0xf4, 0xb6, 0x3e, 0x5f, 0x62, 0x61, 0x62, 0x70, 0x0a, 0xca, 0xe1,
+	0x2b, 0x1c, 0xe1, 0xfd, 0x39, 0x7c, 0x0b, 0xe5, 0x63, 0xbd, 0x6d, 0xdd, 0x93, 0xbf, 0x2e, 0x54,
+	0x7f, 0x62, 0xc1, 0x7d, 0x7a, 0x3c, 0x86, 0x6a, 0xbc, 0x70, 0xdf, 0xc1, 0x63, 0xbe, 0x95, 0xe6,
+	0x56, 0x75, 0xa7, 0x40, 0x3e, 0x40, 0x3d, 0xb3, 0x3a, 0x92, 0x3c, 0x84, 0xf9, 0x85, 0xba, 0xe5,
+	0x2c, 0x33, 0x49, 0xfd, 0x76, 0xa0, 0x62, 0x16, 0x22, 0xf2, 0x2c, 0x67, 0x3f, 0xb3, 0x27, 0xb6,
+	0xb6, 0x16, 0xdc, 0xa6, 0x8e, 0x5e, 0x43, 0x59, 0x2f, 0x8c, 0xe4, 0xb3, 0x9c, 0x65, 0x76, 0x8d,
+	0xbc, 0xdd, 0xcd, 0x77, 0x50, 0xec, 0x46, 0x92, 0xdc, 0x30, 0xa3, 0x12, 0x17, 0xad, 0x9b, 0xae,
+	0xb2, 0xdf, 0x77, 0x70, 0xfe, 0xfb, 0x0e, 0x2e, 0xfc, 0x3e, 0x33, 0xf3, 0x9d, 0x02, 0x79, 0x03,
+	0xb5, 0x74, 0x8e, 0x91, 0xed, 0x45, 0x13, 0xee, 0x4e, 0x58, 0x76, 0x2c, 0xe5, 0x2d, 0x1d, 0x6c,
+	0xcb, 0x30, 0xb5, 0xe7, 0xaf, 0x66, 0xa7, 0xa1, 0x53, 0xf8, 0xc6, 0x52, 0xb9, 0x32, 0x43, 0x66,
+	0x2e, 0x57, 0x33, 0x03, 0xac, 0xb5, 0xb5, 0xe0, 0x36, 0x53, 0xed, 0xb5, 0xb4, 0xa3, 0xce, 0x05,
+	0x99, 0xef, 0xf6, 0xad, 0xf6, 0x62, 0x83, 0x4c, 0xb5, 0xdb, 0x49, 0xeb, 0x22, 0x73, 0xc5, 0x3c,
+	0xdb, 0x1a, 0x5b, 0xdb, 0x0b, 0xef, 0x13, 0x77, 0xa7, 0x15, 0xfd, 0xa7, 0x7b, 0xff, 0xbf, 0x01,
+	0x00, 0xa8, 0x99, 0xd5, 0x0b, 0x8c, 0x0f, 0x00, 0x00,
 }
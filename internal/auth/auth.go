@@ -0,0 +1,225 @@
+// Package auth authenticates the requests of the REST Service and the calls
+// of the gRPC Server. A request carries either a JWT signed with the ECDSA
+// P-256 key of the user's wallet (ES256) or a static API key. The users and
+// their keys are kept in the JSON config.
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ConfigFilename is the config of the users inside the Storage directory,
+// it's shared by the REST Service and the gRPC Server, so the names of the
+// users in the Bucket ACLs are the same for both
+const ConfigFilename = "auth.conf"
+
+var (
+	// ErrNoCredentials is returned by Authenticator if the request carries
+	// no credentials of its kind
+	ErrNoCredentials = errors.New("credentials are required")
+)
+
+// User is a principal of the REST service
+type User struct {
+	// PublicKey is the hex X and Y of the ECDSA P-256 public key of the
+	// user's wallet (128 hex digits), it verifies the tokens of the user
+	PublicKey string `json:"publickey,omitempty"`
+	// APIKeys are the hex SHA-256 of the static API keys of the user, the
+	// keys themselves are not kept
+	APIKeys []string `json:"apikeys,omitempty"`
+	// Admin has access to every Bucket and to the Storage-wide routes
+	Admin bool `json:"admin,omitempty"`
+
+	publicKey *ecdsa.PublicKey
+}
+
+// Config keeps the users of the REST service by their names, the names are
+// the principals of the Bucket ACLs
+type Config struct {
+	Users map[string]*User `json:"users"`
+}
+
+// LoadConfig reads the config from the JSON file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	if err = config.Check(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// SaveConfig writes the config to the JSON file, the file is replaced
+// atomically and is readable only by its owner. The directory of the file
+// is created on the first start of the Storage.
+func SaveConfig(path string, config *Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(append(data, '\n')); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// AddAPIKey generates a new API key of the user and adds it to the config
+// file, the file and the user are created if they don't exist. admin makes
+// the user an admin, it never demotes one. The key itself is returned only
+// here, the config keeps its SHA-256.
+func AddAPIKey(path, name string, admin bool) (key string, err error) {
+	if name == "" {
+		return "", fmt.Errorf("user name is empty")
+	}
+	config := &Config{Users: make(map[string]*User)}
+	if _, err = os.Stat(path); err == nil {
+		if config, err = LoadConfig(path); err != nil {
+			return "", err
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if key, err = NewAPIKey(); err != nil {
+		return "", err
+	}
+	user := config.Users[name]
+	if user == nil {
+		user = &User{}
+		config.Users[name] = user
+	}
+	user.APIKeys = append(user.APIKeys, HashAPIKey(key))
+	user.Admin = user.Admin || admin
+	if err = SaveConfig(path, config); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Check validates the users and parses their public keys
+func (c *Config) Check() (err error) {
+	if len(c.Users) == 0 {
+		return fmt.Errorf("config has no users")
+	}
+	for name, user := range c.Users {
+		if user == nil || user.PublicKey == "" && len(user.APIKeys) == 0 {
+			return fmt.Errorf("user %s has neither public key nor API keys", name)
+		}
+		if user.PublicKey != "" {
+			if user.publicKey, err = ParsePublicKey(user.PublicKey); err != nil {
+				return fmt.Errorf("user %s: %v", name, err)
+			}
+		}
+		for _, key := range user.APIKeys {
+			if sum, err := hex.DecodeString(key); err != nil || len(sum) != 32 {
+				return fmt.Errorf("user %s: API key should be hex SHA-256", name)
+			}
+		}
+	}
+	return nil
+}
+
+// ParsePublicKey decodes the public key of the wallet: hex X and Y of the
+// P-256 point, 64 hex digits each
+func ParsePublicKey(s string) (*ecdsa.PublicKey, error) {
+	if len(s) != 128 {
+		return nil, fmt.Errorf("public key should have 128 hex digits")
+	}
+	x, okX := new(big.Int).SetString(s[:64], 16)
+	y, okY := new(big.Int).SetString(s[64:], 16)
+	if !okX || !okY {
+		return nil, fmt.Errorf("public key is not hex")
+	}
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("public key is not a P-256 point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// Identity is the authenticated principal of the request
+type Identity struct {
+	Name  string
+	Admin bool
+}
+
+// Authenticator finds the identity of the request
+type Authenticator interface {
+	// Authenticate returns ErrNoCredentials if the request has no
+	// credentials the Authenticator knows
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Chain tries the Authenticators in order until one finds credentials
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Identity, error) {
+	for _, authenticator := range c {
+		identity, err := authenticator.Authenticate(r)
+		if err != ErrNoCredentials {
+			return identity, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// NewAuthenticator accepts both the tokens and the API keys of the users
+func NewAuthenticator(config *Config) Authenticator {
+	return Chain{NewTokenAuthenticator(config), NewAPIKeyAuthenticator(config)}
+}
+
+type identityKey struct{}
+
+// NewContext returns the context carrying the identity
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity of the authenticated call, nil if it's
+// not authenticated
+func FromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// WithIdentity returns the request carrying the identity
+func WithIdentity(r *http.Request, identity *Identity) *http.Request {
+	return r.WithContext(NewContext(r.Context(), identity))
+}
+
+// FromRequest returns the identity of the authenticated request, nil if
+// it's not authenticated
+func FromRequest(r *http.Request) *Identity {
+	return FromContext(r.Context())
+}
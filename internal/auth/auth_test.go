@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// walletPublicKey encodes the public key like the wallet does
+func walletPublicKey(key *ecdsa.PrivateKey) string {
+	return fmt.Sprintf("%064x%064x", key.X, key.Y)
+}
+
+func TestAuthenticate(t *testing.T) {
+	alice, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Users: map[string]*User{
+		"alice": {PublicKey: walletPublicKey(alice), Admin: true},
+		"bob":   {APIKeys: []string{HashAPIKey("bob-key")}},
+	}}
+	if err = config.Check(); err != nil {
+		t.Fatal(err)
+	}
+	authenticator := NewAuthenticator(config)
+
+	token := func(key *ecdsa.PrivateKey, user string, ttl time.Duration) string {
+		signed, err := SignToken(key, user, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	noExpiry, _ := jwt.NewWithClaims(jwt.SigningMethodES256,
+		&jwt.StandardClaims{Subject: "alice"}).SignedString(alice)
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256,
+		&jwt.StandardClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()}).
+		SignedString([]byte(walletPublicKey(alice)))
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{"token", "Authorization", "Bearer " + token(alice, "alice", time.Hour), "alice"},
+		{"expired token", "Authorization", "Bearer " + token(alice, "alice", -time.Hour), ""},
+		{"token without expiry", "Authorization", "Bearer " + noExpiry, ""},
+		{"token of another key", "Authorization", "Bearer " + token(mallory, "alice", time.Hour), ""},
+		{"token of unknown user", "Authorization", "Bearer " + token(alice, "carol", time.Hour), ""},
+		{"token of user without key", "Authorization", "Bearer " + token(alice, "bob", time.Hour), ""},
+		{"HS256 token", "Authorization", "Bearer " + hmac, ""},
+		{"API key", APIKeyHeader, "bob-key", "bob"},
+		{"wrong API key", APIKeyHeader, "alice-key", ""},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/buckets", nil)
+		r.Header.Set(test.header, test.value)
+		identity, err := authenticator.Authenticate(r)
+		switch {
+		case test.want == "" && err == nil:
+			t.Errorf("%s: authenticated as %+v", test.name, identity)
+		case test.want != "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.want != "" && identity.Name != test.want:
+			t.Errorf("%s: authenticated as %s, want %s", test.name, identity.Name, test.want)
+		}
+		if err == nil && identity.Admin != (identity.Name == "alice") {
+			t.Errorf("%s: admin of %s is %v", test.name, identity.Name, identity.Admin)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", "/buckets", nil)
+	if _, err := authenticator.Authenticate(r); err != ErrNoCredentials {
+		t.Errorf("Request without credentials: %v", err)
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	bad := []map[string]*User{
+		nil,
+		{"alice": {}},
+		{"alice": {PublicKey: "00"}},
+		{"alice": {PublicKey: fmt.Sprintf("%0128x", 1)}},
+		{"alice": {APIKeys: []string{"plain-key"}}},
+	}
+	for _, users := range bad {
+		if err := (&Config{Users: users}).Check(); err == nil {
+			t.Errorf("Config with users %+v is valid", users)
+		}
+	}
+}
+
+func TestAddAPIKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ConfigFilename)
+
+	// the first key creates the config
+	adminKey, err := AddAPIKey(path, "admin", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobKey, err := AddAPIKey(path, "bob", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// admin is never demoted
+	if _, err = AddAPIKey(path, "admin", false); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Config file: %v (%v)", info.Mode(), err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Users["admin"].APIKeys) != 2 {
+		t.Errorf("admin has keys %v", config.Users["admin"].APIKeys)
+	}
+	authenticator := NewAuthenticator(config)
+	for key, want := range map[string]Identity{
+		adminKey: {Name: "admin", Admin: true},
+		bobKey:   {Name: "bob"},
+	} {
+		r, _ := http.NewRequest("GET", "/buckets", nil)
+		r.Header.Set(APIKeyHeader, key)
+		identity, err := authenticator.Authenticate(r)
+		if err != nil || *identity != want {
+			t.Errorf("Key of %s: %+v (%v)", want.Name, identity, err)
+		}
+	}
+
+	if _, err = AddAPIKey(path, "", false); err == nil {
+		t.Errorf("Key of user without name is added")
+	}
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// APIKeyHeader carries the static API key
+	APIKeyHeader = "X-API-Key"
+	// BearerPrefix precedes the token in the Authorization header
+	BearerPrefix = "Bearer "
+)
+
+// VerifyToken verifies the ES256 token signed by the wallet of the user. The
+// subject (sub) of the token is the user name, the token must expire (exp).
+func (c *Config) VerifyToken(token string) (*Identity, error) {
+	claims := &jwt.StandardClaims{}
+	_, err := tokenParser.ParseWithClaims(token, claims,
+		func(token *jwt.Token) (interface{}, error) {
+			user, ok := c.Users[claims.Subject]
+			if !ok || user.publicKey == nil {
+				return nil, fmt.Errorf("unknown subject %q", claims.Subject)
+			}
+			return user.publicKey, nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+	// the claims are verified only if they are present
+	if claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("invalid token: it has no expiration time")
+	}
+	return &Identity{Name: claims.Subject, Admin: c.Users[claims.Subject].Admin}, nil
+}
+
+var tokenParser = &jwt.Parser{ValidMethods: []string{jwt.SigningMethodES256.Alg()}}
+
+// tokenAuthenticator verifies the tokens of the users
+type tokenAuthenticator struct {
+	config *Config
+}
+
+// NewTokenAuthenticator accepts the "Authorization: Bearer TOKEN" requests
+func NewTokenAuthenticator(config *Config) Authenticator {
+	return &tokenAuthenticator{config: config}
+}
+
+func (a *tokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, BearerPrefix) {
+		return nil, ErrNoCredentials
+	}
+	return a.config.VerifyToken(strings.TrimPrefix(header, BearerPrefix))
+}
+
+// SignToken returns the token of the user signed by the private key of the
+// wallet, it expires after ttl
+func SignToken(key *ecdsa.PrivateKey, user string, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, &jwt.StandardClaims{
+		Subject:   user,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	})
+	return token.SignedString(key)
+}
+
+// apiKeyAuthenticator compares the SHA-256 of the API key with the keys of
+// the users
+type apiKeyAuthenticator struct {
+	config *Config
+}
+
+// NewAPIKeyAuthenticator accepts the requests with the X-API-Key header
+func NewAPIKeyAuthenticator(config *Config) Authenticator {
+	return &apiKeyAuthenticator{config: config}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	for name, user := range a.config.Users {
+		for _, stored := range user.APIKeys {
+			want, _ := hex.DecodeString(stored)
+			if subtle.ConstantTimeCompare(sum[:], want) == 1 {
+				return &Identity{Name: name, Admin: user.Admin}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid API key")
+}
+
+// HashAPIKey returns the form of the API key kept in the config
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey generates a random API key, 256 bits in hex
+func NewAPIKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
@@ -0,0 +1,35 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+
+	"bitbucket.org/udt/wizefs/internal/auth"
+	"bitbucket.org/udt/wizefs/internal/core"
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+// USECASE: wizefs auth-key [--admin] [--config FILE] USER
+func CmdAuthKey(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.NewExitError(
+			fmt.Sprintf("Wrong number of arguments (have %d, want 1)."+
+				" You passed: %s.", c.NArg(), c.Args()),
+			globals.ExitUsage)
+	}
+
+	path := c.String("config")
+	if path == "" {
+		path = core.NewStorage().DirPath + auth.ConfigFilename
+	}
+	key, err := auth.AddAPIKey(path, c.Args()[0], c.Bool("admin"))
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Problem with adding API key: %v", err),
+			globals.ExitChangeConf)
+	}
+	// the key is shown only once, the config keeps its SHA-256
+	fmt.Println(key)
+	return nil
+}
@@ -0,0 +1,60 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+	"bitbucket.org/udt/wizefs/internal/util"
+)
+
+// USECASE: wizefs bench-codec [--codec NAME] [--level LEVEL] DIR
+func CmdBenchCodec(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.NewExitError(
+			fmt.Sprintf("Wrong number of arguments (have %d, want 1)."+
+				" You passed: %s.", c.NArg(), c.Args()),
+			globals.ExitUsage)
+	}
+
+	codecs := util.Codecs()
+	if name := c.String("codec"); name != "" {
+		codec, err := util.LookupCodec(name)
+		if err != nil {
+			return cli.NewExitError(err, globals.ExitUsage)
+		}
+		codecs = []*util.Codec{codec}
+	}
+
+	files, err := util.LoadBenchSample(c.Args()[0])
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Problem with reading sample: %v", err),
+			globals.ExitFile)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "CODEC\tLEVEL\tSIZE\tCOMPRESSED\tRATIO\tCOMPRESS MB/s\tDECOMPRESS MB/s\t")
+	for _, codec := range codecs {
+		// all levels of the codec if the level is not set
+		levels := []int{c.Int("level")}
+		if levels[0] == 0 && len(codec.Levels()) > 1 {
+			levels = codec.Levels()
+		}
+
+		for _, level := range levels {
+			result, err := util.BenchCodec(codec, level, files)
+			if err != nil {
+				w.Flush()
+				return cli.NewExitError(err, globals.ExitUsage)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.2f\t%.1f\t%.1f\t\n",
+				result.Codec, result.Level, result.Size, result.CompressedSize,
+				result.Ratio(), result.CompressSpeed(), result.DecompressSpeed())
+		}
+	}
+	return w.Flush()
+}
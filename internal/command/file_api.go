@@ -2,6 +2,8 @@ package command
 
 import (
 	"fmt"
+	"path/filepath"
+	"time"
 
 	"github.com/urfave/cli"
 
@@ -9,26 +11,38 @@ import (
 	"bitbucket.org/udt/wizefs/internal/globals"
 )
 
-// wizefs load FILE ORIGIN -> load FILE [ORIGIN]
+// wizefs put FILE ORIGIN [PATH]
 // TODO: output result: stdout, JSON
 // TODO: check permissions
 func CmdPutFile(c *cli.Context) (err error) {
-	if c.NArg() != 2 {
+	if c.NArg() < 2 || c.NArg() > 3 {
 		// TEST: TestPutUsage
 		return cli.NewExitError(
-			fmt.Sprintf("Wrong number of arguments (have %d, want 2)."+
+			fmt.Sprintf("Wrong number of arguments (have %d, want 2 or 3)."+
 				" You passed: %s.", c.NArg(), c.Args()),
 			globals.ExitUsage)
 	}
 
 	originalFile := c.Args()[0]
 	origin := c.Args()[1]
+	// PATH inside the Bucket, the base name of FILE by default
+	name := filepath.Base(originalFile)
+	if c.NArg() == 3 {
+		name = c.Args()[2]
+	}
 
 	//exitCode, err := ApiPut(originalFile, origin, nil)
 	var exitCode int
-	bucket, ok := core.NewStorage().Bucket(origin)
+	storage := newFileStorage()
+	defer storage.Close()
+	bucket, ok := storage.Bucket(origin)
 	if ok {
-		exitCode, err = bucket.PutFile(originalFile, nil)
+		opts := core.PutOptions{
+			Overwrite:   c.Bool("overwrite"),
+			IfMatch:     c.String("if-match"),
+			IfNoneMatch: c.String("if-none-match"),
+		}
+		exitCode, err = bucket.PutLocalFile(originalFile, name, opts)
 	} else {
 		err = fmt.Errorf("Bucket with ORIGIN: %s is not exist", origin)
 		exitCode = globals.ExitOrigin
@@ -62,7 +76,9 @@ func CmdGetFile(c *cli.Context) (err error) {
 	// we don't need content, it's only for gRPC methods
 	//_, exitCode, err := ApiGet(originalFile, origin, "", false)
 	var exitCode int
-	bucket, ok := core.NewStorage().Bucket(origin)
+	storage := newFileStorage()
+	defer storage.Close()
+	bucket, ok := storage.Bucket(origin)
 	if ok {
 		_, exitCode, err = bucket.GetFile(originalFile, destinationFilePath, false)
 	} else {
@@ -92,7 +108,9 @@ func CmdRemoveFile(c *cli.Context) (err error) {
 
 	//exitCode, err := ApiRemove(originalFile, origin)
 	var exitCode int
-	bucket, ok := core.NewStorage().Bucket(origin)
+	storage := newFileStorage()
+	defer storage.Close()
+	bucket, ok := storage.Bucket(origin)
 	if ok {
 		exitCode, err = bucket.RemoveFile(originalFile)
 	} else {
@@ -105,3 +123,94 @@ func CmdRemoveFile(c *cli.Context) (err error) {
 	}
 	return nil
 }
+
+// wizefs ls ORIGIN [PREFIX]
+func CmdListFiles(c *cli.Context) (err error) {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		return cli.NewExitError(
+			fmt.Sprintf("Wrong number of arguments (have %d, want 1 or 2)."+
+				" You passed: %s.", c.NArg(), c.Args()),
+			globals.ExitUsage)
+	}
+
+	origin := c.Args()[0]
+	prefix := ""
+	if c.NArg() == 2 {
+		prefix = c.Args()[1]
+	}
+
+	storage := newFileStorage()
+	defer storage.Close()
+	bucket, ok := storage.Bucket(origin)
+	if !ok {
+		return cli.NewExitError(
+			fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin),
+			globals.ExitOrigin)
+	}
+
+	pageToken := ""
+	for {
+		files, nextPageToken, exitCode, err := bucket.List(prefix, c.Bool("recursive"), pageToken)
+		if err != nil {
+			return cli.NewExitError(err, exitCode)
+		}
+		for _, info := range files {
+			name := info.Name
+			if info.IsDir {
+				name += "/"
+			}
+			if c.Bool("long") {
+				fmt.Printf("%v %12d %s %s\n", info.Mode, info.Size,
+					info.ModTime.Format(time.RFC3339), name)
+			} else {
+				fmt.Println(name)
+			}
+		}
+		if nextPageToken == "" {
+			return nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+// wizefs stat FILE ORIGIN
+func CmdStatFile(c *cli.Context) (err error) {
+	if c.NArg() != 2 {
+		return cli.NewExitError(
+			fmt.Sprintf("Wrong number of arguments (have %d, want 2)."+
+				" You passed: %s.", c.NArg(), c.Args()),
+			globals.ExitUsage)
+	}
+
+	originalFile := c.Args()[0]
+	origin := c.Args()[1]
+
+	storage := newFileStorage()
+	defer storage.Close()
+	bucket, ok := storage.Bucket(origin)
+	if !ok {
+		return cli.NewExitError(
+			fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin),
+			globals.ExitOrigin)
+	}
+
+	info, exitCode, err := bucket.Stat(originalFile)
+	if err != nil {
+		return cli.NewExitError(err, exitCode)
+	}
+	fmt.Printf("Name: %s\nSize: %d\nMode: %v\nModified: %s\n",
+		info.Name, info.Size, info.Mode, info.ModTime.Format(time.RFC3339))
+	if !info.IsDir {
+		fmt.Printf("SHA-256: %s\n", info.Checksum)
+	}
+	return nil
+}
+
+// newFileStorage returns the Storage of the file commands. The Buckets
+// auto-mounted by a command are unmounted when it exits, so the Buckets in
+// keep mode are refused instead of being mounted for one operation.
+func newFileStorage() *core.Storage {
+	storage := core.NewStorage()
+	storage.ShortLived = true
+	return storage
+}
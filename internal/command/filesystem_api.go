@@ -1,8 +1,11 @@
 package command
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/urfave/cli"
 
@@ -22,7 +25,18 @@ func CmdCreateFilesystem(c *cli.Context) (err error) {
 	}
 
 	origin := c.Args()[0]
-	exitCode, err := core.NewStorage().Create(origin)
+	opts := core.BucketOptions{
+		FragmentSize:      c.Int("fragment-size"),
+		MicroFragmentSize: c.Int("micro-fragment-size"),
+		Password:          c.String("password"),
+		Codec:             c.String("codec"),
+		CodecLevel:        c.Int("codec-level"),
+		AutoMount: core.AutoMountPolicy{
+			Mode:    c.String("auto-mount"),
+			IdleTTL: int64(c.Duration("auto-mount-ttl") / time.Second),
+		},
+	}
+	exitCode, err := core.NewStorage().CreateWithOptions(origin, opts)
 	if err != nil {
 		//tlog.Warn.Println(err)
 		return cli.NewExitError(err, exitCode)
@@ -80,7 +94,12 @@ func CmdMountFilesystem(c *cli.Context) (err error) {
 	notifypid := c.GlobalInt("notifypid")
 
 	//exitCode, err = ApiMount(origin, notifypid)
-	exitCode, err := core.NewStorage().Mount(origin, notifypid)
+	opts := core.MountOptions{
+		Password:    c.String("password"),
+		MemoryLimit: c.Int64("memory-limit") << 20,
+		IdleTTL:     c.Duration("idle-ttl"),
+	}
+	exitCode, err := core.NewStorage().MountWithOptions(origin, notifypid, opts)
 	if err != nil {
 		//tlog.Warn.Println(err)
 		return cli.NewExitError(err, exitCode)
@@ -108,3 +127,219 @@ func CmdUnmountFilesystem(c *cli.Context) (err error) {
 	}
 	return nil
 }
+
+// USECASE: wizefs session ORIGIN
+func CmdSessionFilesystem(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.NewExitError(
+			fmt.Sprintf("Wrong number of arguments (have %d, want 1)."+
+				" You passed: %s.", c.NArg(), c.Args()),
+			globals.ExitUsage)
+	}
+
+	origin := c.Args()[0]
+
+	info, exitCode, err := core.NewStorage().Session(origin)
+	if err != nil {
+		return cli.NewExitError(err, exitCode)
+	}
+	if info.IdleTTL == 0 {
+		fmt.Printf("Bucket %s has no idle timeout\n", origin)
+		return nil
+	}
+	fmt.Printf("Idle TTL: %v\nRemaining: %v\n",
+		info.IdleTTL, info.Remaining.Round(time.Second))
+	return nil
+}
+
+// USECASE: wizefs auto-mount ORIGIN MODE
+func CmdAutoMountFilesystem(c *cli.Context) (err error) {
+	if c.NArg() != 2 {
+		return cli.NewExitError(
+			fmt.Sprintf("Wrong number of arguments (have %d, want 2)."+
+				" You passed: %s.", c.NArg(), c.Args()),
+			globals.ExitUsage)
+	}
+
+	origin := c.Args()[0]
+	policy := core.AutoMountPolicy{
+		Mode:    c.Args()[1],
+		IdleTTL: int64(c.Duration("idle-ttl") / time.Second),
+	}
+	if policy.Mode == "off" {
+		policy.Mode = core.AutoMountOff
+	}
+
+	exitCode, err := core.NewStorage().SetAutoMount(origin, policy)
+	if err != nil {
+		return cli.NewExitError(err, exitCode)
+	}
+	return nil
+}
+
+// USECASE: wizefs list
+func CmdListFilesystems(c *cli.Context) (err error) {
+	if c.NArg() != 0 {
+		return cli.NewExitError(
+			fmt.Sprintf("Wrong number of arguments (have %d, want 0)."+
+				" You passed: %s.", c.NArg(), c.Args()),
+			globals.ExitUsage)
+	}
+
+	buckets, exitCode, err := core.NewStorage().List()
+	if err != nil {
+		return cli.NewExitError(err, exitCode)
+	}
+
+	if c.Bool("json") {
+		if buckets == nil {
+			buckets = []core.BucketInfo{}
+		}
+		js, err := json.MarshalIndent(buckets, "", "  ")
+		if err != nil {
+			return cli.NewExitError(err, globals.ExitUsage)
+		}
+		fmt.Println(string(js))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ORIGIN\tTYPE\tMOUNTED\tSIZE\tFILES\tCREATED\tMOUNTPOINT")
+	for _, info := range buckets {
+		files := "-"
+		if info.FileCount >= 0 {
+			files = fmt.Sprint(info.FileCount)
+		}
+		fmt.Fprintf(w, "%s\t%v\t%v\t%d\t%s\t%s\t%s\n", info.Origin, info.Type,
+			info.Mounted, info.Size, files, info.Created.Format(time.RFC3339), info.Mountpoint)
+	}
+	return w.Flush()
+}
+
+// USECASE: wizefs locks
+func CmdListLocks(c *cli.Context) (err error) {
+	if c.NArg() != 0 {
+		return cli.NewExitError(
+			fmt.Sprintf("Wrong number of arguments (have %d, want 0)."+
+				" You passed: %s.", c.NArg(), c.Args()),
+			globals.ExitUsage)
+	}
+
+	locks, exitCode, err := core.NewStorage().Locks()
+	if err != nil {
+		return cli.NewExitError(err, exitCode)
+	}
+
+	if c.Bool("json") {
+		if locks == nil {
+			locks = []core.LockInfo{}
+		}
+		js, err := json.MarshalIndent(locks, "", "  ")
+		if err != nil {
+			return cli.NewExitError(err, globals.ExitUsage)
+		}
+		fmt.Println(string(js))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "LOCK\tMODE\tPID\tCOMMAND")
+	for _, info := range locks {
+		if len(info.Holders) == 0 {
+			fmt.Fprintf(w, "%s\t-\t-\t-\n", info.Name)
+		}
+		for _, holder := range info.Holders {
+			fmt.Fprintf(w, "%s\t%v\t%d\t%s\n", info.Name, holder.Mode, holder.PID, holder.Command)
+		}
+	}
+	return w.Flush()
+}
+
+// USECASE: wizefs verify [ORIGIN]
+func CmdVerifyStorage(c *cli.Context) (err error) {
+	if c.NArg() > 1 {
+		return cli.NewExitError(
+			fmt.Sprintf("Wrong number of arguments (have %d, want 0 or 1)."+
+				" You passed: %s.", c.NArg(), c.Args()),
+			globals.ExitUsage)
+	}
+
+	problems, exitCode, err := core.NewStorage().Verify(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err, exitCode)
+	}
+	if err = printProblems(problems, c.Bool("json"), false); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return cli.NewExitError(
+			fmt.Sprintf("Found %d problems", len(problems)), globals.ExitVerify)
+	}
+	return nil
+}
+
+// USECASE: wizefs clean [--dry-run]
+func CmdCleanStorage(c *cli.Context) (err error) {
+	if c.NArg() != 0 {
+		return cli.NewExitError(
+			fmt.Sprintf("Wrong number of arguments (have %d, want 0)."+
+				" You passed: %s.", c.NArg(), c.Args()),
+			globals.ExitUsage)
+	}
+
+	dryRun := c.Bool("dry-run")
+	problems, exitCode, err := core.NewStorage().Clean(dryRun)
+	if err != nil {
+		return cli.NewExitError(err, exitCode)
+	}
+	if err = printProblems(problems, c.Bool("json"), !dryRun); err != nil {
+		return err
+	}
+
+	left := 0
+	for _, p := range problems {
+		if !p.Repaired {
+			left++
+		}
+	}
+	if !dryRun && left > 0 {
+		return cli.NewExitError(
+			fmt.Sprintf("%d problems are not repaired", left), globals.ExitVerify)
+	}
+	return nil
+}
+
+// printProblems prints the problems found by verify and clean, repaired
+// adds the result of the repair
+func printProblems(problems []core.Problem, asJSON, repaired bool) error {
+	if asJSON {
+		if problems == nil {
+			problems = []core.Problem{}
+		}
+		js, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			return cli.NewExitError(err, globals.ExitUsage)
+		}
+		fmt.Println(string(js))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PROBLEM\tORIGIN\tPATH\tDETAIL\tREPAIR")
+	for _, p := range problems {
+		origin, repair := p.Origin, p.Repair
+		if origin == "" {
+			origin = "-"
+		}
+		switch {
+		case repair == "":
+			repair = "-"
+		case repaired && p.Repaired:
+			repair += ": done"
+		case repaired:
+			repair += ": " + p.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Kind, origin, p.Path, p.Detail, repair)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,164 @@
+// Package contentenc encrypts and decrypts file blocks.
+//
+// An encrypted file is a header followed by blocks. Every block holds
+// DefaultBS bytes of plaintext (the last one may be shorter) and is stored
+// as nonce + ciphertext + tag. The block number and the file id are
+// authenticated, so blocks can't be moved inside a file or between files.
+// An empty file has no header.
+package contentenc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"bitbucket.org/udt/wizefs/internal/cryptocore"
+)
+
+const (
+	// DefaultBS is the plaintext block size
+	DefaultBS = 4096
+	// HeaderVersion is the version of the file header
+	HeaderVersion = 2
+	// HeaderIDLen is the length of the file id
+	HeaderIDLen = 16
+	// HeaderLen is the length of the file header: version + file id
+	HeaderLen = 2 + HeaderIDLen
+)
+
+// ErrCorruptBlock is returned when the block fails authentication
+var ErrCorruptBlock = errors.New("corrupt block")
+
+// FileHeader is the header of an encrypted file
+type FileHeader struct {
+	Version uint16
+	ID      []byte
+}
+
+// NewFileHeader returns the header with a new random file id
+func NewFileHeader() (*FileHeader, error) {
+	id, err := cryptocore.RandBytes(HeaderIDLen)
+	if err != nil {
+		return nil, err
+	}
+	return &FileHeader{Version: HeaderVersion, ID: id}, nil
+}
+
+// Pack serializes the header
+func (h *FileHeader) Pack() []byte {
+	buf := make([]byte, HeaderLen)
+	binary.BigEndian.PutUint16(buf, h.Version)
+	copy(buf[2:], h.ID)
+	return buf
+}
+
+// ParseHeader deserializes the header
+func ParseHeader(buf []byte) (*FileHeader, error) {
+	if len(buf) != HeaderLen {
+		return nil, fmt.Errorf("invalid header length %d", len(buf))
+	}
+	h := &FileHeader{
+		Version: binary.BigEndian.Uint16(buf),
+		ID:      append([]byte(nil), buf[2:]...),
+	}
+	if h.Version != HeaderVersion {
+		return nil, fmt.Errorf("unsupported header version %d", h.Version)
+	}
+	if bytes.Equal(h.ID, make([]byte, HeaderIDLen)) {
+		return nil, fmt.Errorf("all-zero file id")
+	}
+	return h, nil
+}
+
+// ContentEnc encrypts and decrypts blocks of files
+type ContentEnc struct {
+	cryptoCore *cryptocore.CryptoCore
+	plainBS    uint64
+	cipherBS   uint64
+}
+
+// New returns the block encryptor for the plaintext block size plainBS
+func New(cc *cryptocore.CryptoCore, plainBS uint64) *ContentEnc {
+	return &ContentEnc{
+		cryptoCore: cc,
+		plainBS:    plainBS,
+		cipherBS:   plainBS + cryptocore.IVLen + cryptocore.AuthTagLen,
+	}
+}
+
+// PlainBS returns the plaintext block size
+func (be *ContentEnc) PlainBS() uint64 {
+	return be.plainBS
+}
+
+// CipherBS returns the ciphertext block size
+func (be *ContentEnc) CipherBS() uint64 {
+	return be.cipherBS
+}
+
+// EncryptBlock encrypts the plaintext block number blockNo of the file
+func (be *ContentEnc) EncryptBlock(plain []byte, blockNo uint64, fileID []byte) ([]byte, error) {
+	nonce, err := cryptocore.RandBytes(cryptocore.IVLen)
+	if err != nil {
+		return nil, err
+	}
+	return be.cryptoCore.AEADCipher.Seal(nonce, nonce, plain, blockAAD(blockNo, fileID)), nil
+}
+
+// DecryptBlock decrypts the ciphertext block number blockNo of the file
+func (be *ContentEnc) DecryptBlock(ciphertext []byte, blockNo uint64, fileID []byte) ([]byte, error) {
+	if len(ciphertext) < cryptocore.IVLen+cryptocore.AuthTagLen {
+		return nil, ErrCorruptBlock
+	}
+	nonce := ciphertext[:cryptocore.IVLen]
+	plain, err := be.cryptoCore.AEADCipher.Open(nil, nonce, ciphertext[cryptocore.IVLen:],
+		blockAAD(blockNo, fileID))
+	if err != nil {
+		return nil, ErrCorruptBlock
+	}
+	return plain, nil
+}
+
+func blockAAD(blockNo uint64, fileID []byte) []byte {
+	aad := make([]byte, 8, 8+len(fileID))
+	binary.BigEndian.PutUint64(aad, blockNo)
+	return append(aad, fileID...)
+}
+
+// BlockNoPlainOff returns the number of the block that holds the plaintext
+// offset
+func (be *ContentEnc) BlockNoPlainOff(plainOffset uint64) uint64 {
+	return plainOffset / be.plainBS
+}
+
+// BlockCipherOff returns the ciphertext offset of the block
+func (be *ContentEnc) BlockCipherOff(blockNo uint64) uint64 {
+	return HeaderLen + blockNo*be.cipherBS
+}
+
+// CipherSizeToPlainSize converts the size of the encrypted file to the
+// plaintext size
+func (be *ContentEnc) CipherSizeToPlainSize(cipherSize uint64) uint64 {
+	if cipherSize <= HeaderLen {
+		return 0
+	}
+	cipherSize -= HeaderLen
+	overhead := be.cipherBS - be.plainBS
+	blocks := (cipherSize + be.cipherBS - 1) / be.cipherBS
+	if blocks*overhead > cipherSize {
+		// truncated block, it is reported as corrupt on read
+		return (blocks - 1) * be.plainBS
+	}
+	return cipherSize - blocks*overhead
+}
+
+// PlainSizeToCipherSize converts the plaintext size to the size of the
+// encrypted file
+func (be *ContentEnc) PlainSizeToCipherSize(plainSize uint64) uint64 {
+	if plainSize == 0 {
+		return 0
+	}
+	blocks := (plainSize + be.plainBS - 1) / be.plainBS
+	return HeaderLen + plainSize + blocks*(be.cipherBS-be.plainBS)
+}
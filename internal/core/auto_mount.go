@@ -0,0 +1,207 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+// Auto-mount modes, see AutoMountPolicy
+const (
+	// AutoMountOff - file operations fail if the Bucket is not mounted
+	AutoMountOff = ""
+	// AutoMountKeep mounts the Bucket and leaves it mounted until it's idle
+	// for IdleTTL
+	AutoMountKeep = "keep"
+	// AutoMountOnce mounts the Bucket for the file operation and unmounts
+	// it straight away
+	AutoMountOnce = "once"
+	// AutoMountDirect works in the origin directory without FUSE, it's
+	// supported by unencrypted LoopbackFS Buckets only
+	AutoMountDirect = "direct"
+
+	// DefaultAutoMountIdleTTL is the idle timeout of Buckets mounted in
+	// keep mode
+	DefaultAutoMountIdleTTL = 10 * time.Minute
+)
+
+// AutoMountPolicy lets file operations (Put, Get, Remove) use a Bucket that
+// is not mounted. It's kept in the Storage config, so it works for all types
+// of Buckets.
+type AutoMountPolicy struct {
+	Mode string `json:"mode"`
+	// IdleTTL is the idle timeout of keep mode in seconds,
+	// 0 - DefaultAutoMountIdleTTL
+	IdleTTL int64 `json:"idlettl,omitempty"`
+}
+
+// Check validates the policy
+func (p AutoMountPolicy) Check() error {
+	switch p.Mode {
+	case AutoMountOff, AutoMountKeep, AutoMountOnce, AutoMountDirect:
+	default:
+		return fmt.Errorf("Unknown auto-mount mode %q (want %s, %s or %s)",
+			p.Mode, AutoMountKeep, AutoMountOnce, AutoMountDirect)
+	}
+	if p.IdleTTL != 0 && time.Duration(p.IdleTTL)*time.Second < MinIdleTTL {
+		return fmt.Errorf("Idle TTL should be at least %v", MinIdleTTL)
+	}
+	return nil
+}
+
+func (p AutoMountPolicy) idleTTL() time.Duration {
+	if p.IdleTTL == 0 {
+		return DefaultAutoMountIdleTTL
+	}
+	return time.Duration(p.IdleTTL) * time.Second
+}
+
+// root returns the directory the file operations work in: the mountpoint
+// or, if the Bucket is not mounted, the one selected by its auto-mount
+// policy. The Bucket lock is held in shared mode until release is called
+// after the operation, so other processes can't unmount or delete the
+// Bucket in the middle of it.
+func (b *Bucket) root() (root bucketFS, release func(), exitCode int, err error) {
+	root, mountRelease, exitCode, err := b.autoMountRoot()
+	if err != nil || b.storage == nil {
+		return root, mountRelease, exitCode, err
+	}
+
+	unlock, exitCode, err := b.storage.lockBucket(b.Origin, LockShared, LockShared)
+	if err != nil {
+		mountRelease()
+		return nil, nil, exitCode, err
+	}
+	// the Bucket may be unmounted by another process before we took the lock
+	fsinfo, _ := b.storage.Config.filesystem(b.Origin)
+	if root != bucketFS(dirFS(fsinfo.OriginPath)) {
+		if _, exitCode, err = b.mountpointPath(); err != nil {
+			unlock()
+			mountRelease()
+			return nil, nil, exitCode, err
+		}
+	}
+	return root, func() {
+		unlock()
+		mountRelease()
+	}, 0, nil
+}
+
+// autoMountRoot is root without the Bucket lock, it mounts the Bucket if
+// its auto-mount policy asks for it
+func (b *Bucket) autoMountRoot() (root bucketFS, release func(), exitCode int, err error) {
+	mountpointPath, exitCode, err := b.mountpointPath()
+	if err == nil {
+		return b.storage.mountFS(b.Origin, mountpointPath), func() {}, 0, nil
+	}
+	if exitCode != globals.ExitMountPoint {
+		return nil, nil, exitCode, err
+	}
+
+	fsinfo, _ := b.storage.Config.filesystem(b.Origin)
+	if fsinfo.AutoMount == nil || fsinfo.AutoMount.Mode == AutoMountOff {
+		return nil, nil, exitCode, err
+	}
+	return b.autoMount(fsinfo, *fsinfo.AutoMount)
+}
+
+func (b *Bucket) autoMount(fsinfo FilesystemInfo, policy AutoMountPolicy) (root bucketFS,
+	release func(), exitCode int, err error) {
+
+	switch policy.Mode {
+	case AutoMountDirect:
+		if fsinfo.Type != globals.LoopbackFS || b.Config.Encryption != nil {
+			return nil, nil, globals.ExitType,
+				newError(ErrInvalid, "Direct access is supported by unencrypted directory Buckets only")
+		}
+		tlog.Debug.Printf("Bucket %s is not mounted, using %s", b.Origin, fsinfo.OriginPath)
+		return dirFS(fsinfo.OriginPath), func() {}, 0, nil
+
+	case AutoMountKeep:
+		if b.storage.ShortLived {
+			return nil, nil, globals.ExitUsage,
+				newError(ErrInvalid, "Auto-mount mode %s of Bucket %s needs a long-lived process "+
+					"(REST, gRPC or S3 server). Mount the Bucket or use %s mode.",
+					AutoMountKeep, b.Origin, AutoMountOnce)
+		}
+		b.autoMountMutex.Lock()
+		_, exitCode, err = b.mountManaged(MountOptions{IdleTTL: policy.idleTTL()})
+		b.autoMountMutex.Unlock()
+		if err != nil {
+			return nil, nil, exitCode, err
+		}
+		release = func() {}
+
+	case AutoMountOnce:
+		// concurrent operations share the mount, the last one unmounts it
+		b.autoMountMutex.Lock()
+		if b.autoMountRefs == 0 {
+			b.autoMounted, exitCode, err = b.mountManaged(MountOptions{})
+			if err != nil {
+				b.autoMountMutex.Unlock()
+				return nil, nil, exitCode, err
+			}
+		}
+		b.autoMountRefs++
+		b.autoMountMutex.Unlock()
+		release = b.releaseOnce
+
+	default:
+		return nil, nil, globals.ExitUsage,
+			newError(ErrInvalid, "Unknown auto-mount mode %q", policy.Mode)
+	}
+
+	mountpointPath, exitCode, err := b.mountpointPath()
+	if err != nil {
+		release()
+		return nil, nil, exitCode, err
+	}
+	return b.storage.mountFS(b.Origin, mountpointPath), release, 0, nil
+}
+
+// mountManaged mounts the Bucket in the current process unless another
+// operation has done it already. The caller holds autoMountMutex.
+func (b *Bucket) mountManaged(opts MountOptions) (mounted bool, exitCode int, err error) {
+	if _, _, err = b.mountpointPath(); err == nil {
+		return false, 0, nil
+	}
+	tlog.Info.Printf("Auto-mounting Bucket %s", b.Origin)
+	exitCode, err = b.storage.MountManagedWithOptions(b.Origin, opts)
+	return err == nil, exitCode, err
+}
+
+// releaseOnce unmounts the Bucket mounted in once mode after the last
+// operation
+func (b *Bucket) releaseOnce() {
+	b.autoMountMutex.Lock()
+	defer b.autoMountMutex.Unlock()
+
+	b.autoMountRefs--
+	if b.autoMountRefs > 0 || !b.autoMounted {
+		return
+	}
+	b.autoMounted = false
+	tlog.Info.Printf("Unmounting auto-mounted Bucket %s", b.Origin)
+	if exitCode, err := b.storage.Unmount(b.Origin); err != nil {
+		tlog.Warn.Printf("Unmounting auto-mounted Bucket %s failed: %v. Exit code: %d",
+			b.Origin, err, exitCode)
+	}
+}
+
+// releaseReader calls release when the reader is closed
+type releaseReader struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.release != nil {
+		r.release()
+		r.release = nil
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+func TestAutoMountPolicyCheck(t *testing.T) {
+	tests := []struct {
+		policy AutoMountPolicy
+		valid  bool
+	}{
+		{AutoMountPolicy{}, true},
+		{AutoMountPolicy{Mode: AutoMountKeep, IdleTTL: 60}, true},
+		{AutoMountPolicy{Mode: AutoMountOnce}, true},
+		{AutoMountPolicy{Mode: AutoMountDirect}, true},
+		{AutoMountPolicy{Mode: "always"}, false},
+		{AutoMountPolicy{Mode: AutoMountKeep, IdleTTL: -1}, false},
+	}
+	for _, test := range tests {
+		if err := test.policy.Check(); (err == nil) != test.valid {
+			t.Errorf("Check of %+v: valid = %v, got %v", test.policy, test.valid, err)
+		}
+	}
+}
+
+// newTestBucket creates an unmounted LoopbackFS Bucket with the Storage
+// config in a temporary directory
+func newTestBucket(t *testing.T) (bucket *Bucket, originPath string) {
+	dir := testDir(t, "bucket")
+
+	originPath = filepath.Join(dir, "ORIGIN")
+	if err := os.Mkdir(originPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewStorageConfig(dir)
+	if err := config.CreateFilesystem("ORIGIN", originPath, globals.LoopbackFS, nil); err != nil {
+		t.Fatal(err)
+	}
+	s := &Storage{DirPath: dir + "/", Config: config, buckets: make(map[string]*Bucket)}
+	bucket = &Bucket{Origin: "ORIGIN", Config: &BucketConfig{}, storage: s}
+	s.buckets["ORIGIN"] = bucket
+	return bucket, originPath
+}
+
+// setAutoMount changes the auto-mount policy of the test Bucket
+func setAutoMount(t *testing.T, bucket *Bucket, mode string) {
+	config := bucket.storage.Config
+	if err := config.SetAutoMount(bucket.Origin, &AutoMountPolicy{Mode: mode}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutoMountDirect(t *testing.T) {
+	bucket, originPath := newTestBucket(t)
+
+	// without a policy file operations need a mounted Bucket
+	if exitCode, err := bucket.PutFile("test.txt", []byte("data")); err == nil ||
+		exitCode != globals.ExitMountPoint {
+		t.Fatalf("Put to unmounted Bucket: expected exit code %d, got %d (%v)",
+			globals.ExitMountPoint, exitCode, err)
+	}
+
+	setAutoMount(t, bucket, AutoMountDirect)
+
+	if _, err := bucket.PutFile("test.txt", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(originPath, "test.txt")); err != nil {
+		t.Errorf("File was not written to the origin directory: %v", err)
+	}
+	content, _, err := bucket.GetFile("test.txt", "", true)
+	if err != nil || string(content) != "data" {
+		t.Errorf("Get: %q, %v", content, err)
+	}
+	if _, err := bucket.RemoveFile("test.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// direct access bypasses encryption, so it's refused
+	bucket.Config.Encryption = &BucketEncryption{}
+	if exitCode, err := bucket.PutFile("test.txt", []byte("data")); err == nil ||
+		exitCode != globals.ExitType {
+		t.Errorf("Direct Put to encrypted Bucket: expected exit code %d, got %d (%v)",
+			globals.ExitType, exitCode, err)
+	}
+}
+
+func TestAutoMountKeepShortLived(t *testing.T) {
+	bucket, _ := newTestBucket(t)
+	setAutoMount(t, bucket, AutoMountKeep)
+	bucket.storage.ShortLived = true
+
+	// the Bucket would be unmounted right after the operation
+	exitCode, err := bucket.PutFile("test.txt", []byte("data"))
+	if !errors.Is(err, ErrInvalid) || exitCode != globals.ExitUsage {
+		t.Errorf("Put in keep mode of short-lived Storage: exit code %d (%v)", exitCode, err)
+	}
+}
@@ -1,11 +1,14 @@
 package core
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"bitbucket.org/udt/wizefs/internal/globals"
 	"bitbucket.org/udt/wizefs/internal/tlog"
@@ -13,8 +16,15 @@ import (
 
 type BucketApi interface {
 	PutFile(originalFile string, content []byte) (exitCode int, err error)
+	PutFileWithOptions(originalFile string, content []byte, opts PutOptions) (exitCode int, err error)
+	PutLocalFile(localFile, name string, opts PutOptions) (exitCode int, err error)
 	GetFile(originalFile, destinationFilePath string, getContentOnly bool) (content []byte, exitCode int, err error)
 	RemoveFile(originalFile string) (exitCode int, err error)
+	List(prefix string, recursive bool, pageToken string) (files []FileInfo, nextPageToken string, exitCode int, err error)
+	Stat(originalFile string) (info FileInfo, exitCode int, err error)
+	PutFileStream(originalFile string, reader io.Reader) (exitCode int, err error)
+	PutFileStreamWithOptions(originalFile string, reader io.Reader, opts PutOptions) (exitCode int, err error)
+	GetFileStream(originalFile string) (reader io.ReadCloser, exitCode int, err error)
 }
 
 type Bucket struct {
@@ -23,6 +33,18 @@ type Bucket struct {
 	MountPoint string
 	Config     *BucketConfig
 	mounted    bool
+	// session is the mount session with idle timeout if the Bucket is
+	// served by this process
+	session *mountSession
+
+	// autoMountMutex serializes auto-mounting, autoMountRefs counts the
+	// operations that use the Bucket mounted in once mode
+	autoMountMutex sync.Mutex
+	autoMountRefs  int
+	autoMounted    bool
+
+	// putMutex serializes the checks and the renames of Put
+	putMutex sync.Mutex
 }
 
 func NewBucket(s *Storage, origin, originPath string, fstype globals.FSType) *Bucket {
@@ -34,6 +56,10 @@ func NewBucket(s *Storage, origin, originPath string, fstype globals.FSType) *Bu
 	}
 
 	bucket.Config = NewBucketConfig(origin, originPath, fstype)
+	// ZipFS archives are plain zip or tar files without a Bucket config
+	if fstype == globals.ZipFS {
+		return bucket
+	}
 	err := bucket.Config.Load()
 	if err != nil {
 		bucket.Config.Save()
@@ -43,281 +69,340 @@ func NewBucket(s *Storage, origin, originPath string, fstype globals.FSType) *Bu
 }
 
 func (b *Bucket) IsMounted() bool {
+	b.storage.mutex.RLock()
+	defer b.storage.mutex.RUnlock()
 	return b.mounted
 }
 
 func (b *Bucket) SetMounted(value bool) {
+	b.storage.mutex.Lock()
+	defer b.storage.mutex.Unlock()
 	b.mounted = value
 }
 
+// PutFile stores content as originalFile, a path inside the Bucket, nil
+// content is an empty file. It fails if the file exists.
 func (b *Bucket) PutFile(originalFile string, content []byte) (exitCode int, err error) {
-	// TEST: TestPutNotExistingOrigin, TestPutNotMounted
-	exitCode, err = b.storage.Config.Check(b.Origin, false, false)
+	return b.PutFileWithOptions(originalFile, content, PutOptions{})
+}
+
+// PutFileWithOptions is PutFile that replaces the existing file by opts.
+// It never reads local files, so it's safe for the requests of the daemons
+// (proto3 decodes empty bytes as nil).
+func (b *Bucket) PutFileWithOptions(originalFile string, content []byte,
+	opts PutOptions) (exitCode int, err error) {
+	root, release, exitCode, err := b.root()
 	if err != nil {
 		return
 	}
+	defer release()
 
-	var mountpointPath string
-	//originPath := OriginDir + origin
-
-	// check origin via config file (database) and get mountpoint if it exists
-	// TODO: auto-mount if (Filesystem is not mounted!)?
-
-	// TODO: HACK for gRPC methods
-	if b.storage.Config == nil {
-		tlog.Info.Println("CommonConfig == nil")
-		//config.InitWizeConfig()
-	}
+	return b.putFile(root, originalFile, bytes.NewReader(content), opts)
+}
 
-	mountpointPath, err = b.storage.Config.CheckOriginGetMountpoint(b.Origin)
+// PutLocalFile copies the local file into the Bucket as name, a path inside
+// the Bucket; intermediate directories are created. It's used by the CLI
+// only, the daemons must not read the files of their host for the clients.
+func (b *Bucket) PutLocalFile(localFile, name string, opts PutOptions) (exitCode int, err error) {
+	// check origin via config file (database) and get mountpoint if it
+	// exists, the Bucket is mounted by its auto-mount policy if it's not
+	// TEST: TestPutNotExistingOrigin, TestPutNotMounted
+	root, release, exitCode, err := b.root()
 	if err != nil {
-		// TEST: TestPutFailedMountpointPath
-		return globals.ExitMountPoint,
-			fmt.Errorf("Did not find MOUNTPOINT in common config.")
+		return
 	}
+	defer release()
 
-	// content is used for gRPC methods
-	if content == nil {
-		// check PATH
-		// TEST: TestPutFullFilename, TestPutShortFilename
-		if !filepath.IsAbs(originalFile) {
-			//return cli.NewExitError(
-			//	"FILE argument is not absolute path to file.",
-			//	globals.Other)
-
-			tlog.Debug.Println("HACK: FILE argument is not absolute path to file.")
+	// check PATH
+	// TEST: TestPutFullFilename, TestPutShortFilename
+	if !filepath.IsAbs(localFile) {
+		//return cli.NewExitError(
+		//	"FILE argument is not absolute path to file.",
+		//	globals.Other)
 
-			// HACK: for temporary testing
-			originalFile, _ = filepath.Abs(originalFile)
-		}
-
-		// check original file existing
-		if _, err = os.Stat(originalFile); os.IsNotExist(err) {
-			// TEST: TestPutNotExistingFile
-			return globals.ExitFile,
-				fmt.Errorf("Original FILE (%s) does not exist.", originalFile)
-		}
-	}
-	originalFileBase := filepath.Base(originalFile)
+		tlog.Debug.Println("HACK: FILE argument is not absolute path to file.")
 
-	// check destination file existing
-	destinationFile := mountpointPath + "/" + originalFileBase
-	if _, err = os.Stat(destinationFile); err == nil {
-		// TEST: TestPutExistingDestinationFile
-		return globals.ExitFile,
-			fmt.Errorf("Destination FILE (%s) is exist.", destinationFile)
+		// HACK: for temporary testing
+		localFile, _ = filepath.Abs(localFile)
 	}
 
-	// copy (replace?) file to mountpointPath
-	_, err = b.copyFile(originalFile, destinationFile, content)
+	// check original file existing
+	file, err := os.Open(localFile)
 	if err != nil {
-		// TEST: TestPutFailedCopyFile
+		// TEST: TestPutNotExistingFile
 		return globals.ExitFile,
-			fmt.Errorf("We have a problem with copy file: %v", err)
+			newError(ErrNotFound, "Original FILE (%s) does not exist.", localFile)
 	}
+	defer file.Close()
 
-	return 0, nil
+	// copy file to mountpointPath
+	// TEST: TestPutExistingDestinationFile, TestPutFailedCopyFile
+	return b.putFile(root, name, file, opts)
 }
 
 func (b *Bucket) GetFile(originalFile, destinationFilePath string, getContentOnly bool) (content []byte, exitCode int, err error) {
+	// check origin via config file (database) and get mountpoint if it
+	// exists, the Bucket is mounted by its auto-mount policy if it's not
 	// TEST: TestGetNotExistingOrigin, TestGettNotMounted
-	exitCode, err = b.storage.Config.Check(b.Origin, false, false)
+	root, release, exitCode, err := b.root()
 	if err != nil {
 		return
 	}
+	defer release()
 
-	var mountpointPath string
-	//originPath := OriginDir + origin
-
-	// check origin via config file (database) and get mountpoint if it exists
-	// TODO: auto-mount if (Filesystem is not mounted!)?
-
-	// TODO: HACK for gRPC methods
-	if b.storage.Config == nil {
-		tlog.Info.Println("CommonConfig == nil")
-		//config.InitWizeConfig()
+	// check original file existing
+	// TEST: TestGetFullFilename, TestGetNotExistingFile
+	reader, exitCode, err := b.openFile(root, originalFile)
+	if err != nil {
+		return nil, exitCode, err
 	}
+	defer reader.Close()
 
-	mountpointPath, err = b.storage.Config.CheckOriginGetMountpoint(b.Origin)
-	if err != nil {
-		// TEST: TestGetFailedMountpointPath
-		return nil, globals.ExitMountPoint,
-			fmt.Errorf("Did not find MOUNTPOINT in common config.")
+	if getContentOnly {
+		content, err = ioutil.ReadAll(reader)
+		if err != nil {
+			if _, ok := err.(*IntegrityError); ok {
+				// TEST: TestBucketChecksumIndex
+				return nil, globals.ExitIntegrity, err
+			}
+			// TEST: TestGetFailedCopyFile
+			return nil, globals.ExitFile,
+				fmt.Errorf("We have a problem with copy file: %w", err)
+		}
+		tlog.Debug.Printf("Copied %d bytes.", len(content))
+		return content, 0, nil
 	}
 
-	// FIXME: check PATH
-	if filepath.IsAbs(originalFile) {
-		// TEST: TestGetFullFilename, TestGetShortFilename
+	// check destination file existing
+	destinationFile := destinationFilePath
+	if destinationFile == "" {
+		// TODO: HACK - we just copy file into application directory
+		destinationFile, _ = filepath.Abs(filepath.Base(originalFile))
+	}
+	if _, err = os.Stat(destinationFile); err == nil {
+		// TEST: TestGetExistingDestinationFile
 		return nil, globals.ExitFile,
-			fmt.Errorf("FILE argument (%s) is absolute path to file.", originalFile)
+			newError(ErrAlreadyExists, "Destination FILE (%s) is exist.", destinationFile)
 	}
 
-	// FIXME: get Base?
-	originalFileBase := filepath.Base(originalFile)
-
-	// check original file existing
-	originalFile = mountpointPath + "/" + originalFileBase
-	if _, err = os.Stat(originalFile); os.IsNotExist(err) {
-		// TEST: TestGetNotExistingFile
+	// copy file from mountpointPath
+	err = copyToFile(destinationFile, reader)
+	if _, ok := err.(*IntegrityError); ok {
+		// the corrupted content is not left on the local filesystem
+		os.Remove(destinationFile)
+		return nil, globals.ExitIntegrity, err
+	}
+	if err != nil {
+		// TEST: TestGetFailedCopyFile
 		return nil, globals.ExitFile,
-			fmt.Errorf("Original FILE (%s) does not exist.", originalFile)
+			fmt.Errorf("We have a problem with copy file: %w", err)
 	}
 
-	// check destination file existing
-	var destinationFile string = ""
-	if !getContentOnly {
-		if destinationFilePath != "" {
-			destinationFile = destinationFilePath
-		} else {
-			// TODO: HACK - we just copy file into application directory
-			destinationFile, _ = filepath.Abs(originalFileBase)
-		}
-		if _, err = os.Stat(destinationFile); os.IsExist(err) {
-			// TEST: TestGetExistingDestinationFile
-			return nil, globals.ExitFile,
-				fmt.Errorf("Destination FILE (%s) is exist.", destinationFile)
-		}
+	return nil, 0, nil
+}
+
+// PutFileStream copies the content from reader into the new file of the
+// Bucket. The content is never kept in memory as a whole, so it works for
+// files of any size.
+func (b *Bucket) PutFileStream(originalFile string, reader io.Reader) (exitCode int, err error) {
+	return b.PutFileStreamWithOptions(originalFile, reader, PutOptions{})
+}
+
+// PutFileStreamWithOptions is PutFileStream that replaces the existing file
+// by opts
+func (b *Bucket) PutFileStreamWithOptions(originalFile string, reader io.Reader,
+	opts PutOptions) (exitCode int, err error) {
+	root, release, exitCode, err := b.root()
+	if err != nil {
+		return
 	}
+	defer release()
 
-	// copy (replace?) file to mountpointPath
-	content, err = b.copyFile(originalFile, destinationFile, nil)
+	return b.putFile(root, originalFile, reader, opts)
+}
+
+// GetFileStream opens the file of the Bucket for reading. The caller must
+// close the returned reader. If the content doesn't match the checksum
+// stored by Put, the last Read fails with IntegrityError.
+func (b *Bucket) GetFileStream(originalFile string) (reader io.ReadCloser, exitCode int, err error) {
+	root, release, exitCode, err := b.root()
 	if err != nil {
-		// TEST: TestGetFailedCopyFile
-		return nil, globals.ExitFile,
-			fmt.Errorf("We have a problem with copy file: %v", err)
+		return
 	}
 
-	return content, 0, nil
+	reader, exitCode, err = b.openFile(root, originalFile)
+	if err != nil {
+		release()
+		return nil, exitCode, err
+	}
+	// an auto-mounted Bucket is released when the reader is closed
+	return &releaseReader{ReadCloser: reader, release: release}, 0, nil
 }
 
 func (b *Bucket) RemoveFile(originalFile string) (exitCode int, err error) {
+	// check origin via config file (database) and get mountpoint if it
+	// exists, the Bucket is mounted by its auto-mount policy if it's not
 	// TEST: TestRemoveNotExistingOrigin, TestRemoveNotMounted
+	root, release, exitCode, err := b.root()
+	if err != nil {
+		return
+	}
+	defer release()
+
+	// remove file from mountpointPath
+	// TEST: TestRemoveFullFilename, TestRemoveNotExistingFile
+	return b.removeFile(root, originalFile)
+}
+
+// mountpointPath checks that the Bucket is mounted and returns its mountpoint
+func (b *Bucket) mountpointPath() (mountpointPath string, exitCode int, err error) {
 	exitCode, err = b.storage.Config.Check(b.Origin, false, false)
 	if err != nil {
 		return
 	}
 
-	var mountpointPath string
-	//originPath := OriginDir + origin
+	mountpointPath, err = b.storage.Config.mountpointPath(b.Origin)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrNotMounted) {
+			return "", globals.ExitLoadConf, err
+		}
+		return "", globals.ExitMountPoint,
+			newError(ErrNotMounted, "Did not find MOUNTPOINT in common config.")
+	}
 
-	// check origin via config file (database) and get mountpoint if it exists
-	// TODO: auto-mount if (Filesystem is not mounted!)?
+	return mountpointPath, 0, nil
+}
 
-	// TODO: HACK for gRPC methods
-	if b.storage.Config == nil {
-		tlog.Info.Println("CommonConfig == nil")
-		//config.InitWizeConfig()
+// fragments returns the fragment store of the Bucket or nil if files are
+// stored as a whole
+func (b *Bucket) fragments(root bucketFS) *fragmentStore {
+	if b.Config.FragmentSize == 0 {
+		return nil
+	}
+	store := newFragmentStore(root, b.Config.FragmentSize)
+	if b.Config.MicroFragmentSize != 0 {
+		store.withMicroFragments(b.Config.MicroFragmentSize)
 	}
+	return store
+}
 
-	mountpointPath, err = b.storage.Config.CheckOriginGetMountpoint(b.Origin)
+// filePath checks the path of a file inside the Bucket and returns its clean
+// form, see cleanPath
+func (b *Bucket) filePath(root bucketFS, originalFile string) (name string, exitCode int, err error) {
+	name, err = cleanPath(originalFile)
 	if err != nil {
-		// TEST: TestRemoveFailedMountpointPath
-		return globals.ExitMountPoint,
-			fmt.Errorf("Did not find MOUNTPOINT in common config.")
+		return "", globals.ExitFile, err
 	}
-
-	// FIXME: check PATH
-	if filepath.IsAbs(originalFile) {
-		// TEST: TestRemoveFullFilename, TestRemoveShortFilename
-		return globals.ExitFile,
-			fmt.Errorf("FILE argument (%s) is absolute path to file.", originalFile)
+	if err = root.Check(name); err != nil {
+		return "", globals.ExitFile,
+			newError(ErrInvalid, "FILE argument (%s) is not allowed: %v", originalFile, err)
 	}
+	return name, 0, nil
+}
 
-	// FIXME: get Base?
-	originalFileBase := filepath.Base(originalFile)
+// exists checks if the file is stored as a whole or as fragments
+func (b *Bucket) exists(root bucketFS, name string) bool {
+	if _, err := root.Stat(name); err == nil {
+		return true
+	}
+	return newFragmentStore(root, 0).Exists(name)
+}
 
-	// check original file existing
-	originalFile = mountpointPath + "/" + originalFileBase
-	if _, err = os.Stat(originalFile); os.IsNotExist(err) {
-		// TEST: TestRemoveNotExistingFile
-		return globals.ExitFile,
-			fmt.Errorf("Original FILE (%s) does not exist.", originalFile)
+// openFile opens the file that is stored as a whole or as fragments; files
+// stored before fragmentation was turned on are still readable. The content
+// of the indexed files is verified by their checksums, see verifyReader.
+func (b *Bucket) openFile(root bucketFS, name string) (reader io.ReadCloser, exitCode int, err error) {
+	name, exitCode, err = b.filePath(root, name)
+	if err != nil {
+		return
 	}
 
-	// remove file from mountpointPath
-	err = os.Remove(originalFile)
+	reader, err = newFragmentStore(root, 0).Open(name)
+	if err == errNoManifest {
+		if info, serr := root.Stat(name); serr == nil && info.IsDir() {
+			return nil, globals.ExitFile,
+				newError(ErrInvalid, "Original FILE (%s) is a directory.", name)
+		}
+		reader, err = root.Open(name)
+	}
 	if err != nil {
-		// TEST: TestRemoveFailedRemoveFile
-		return globals.ExitFile,
-			fmt.Errorf("We have a problem with removing file: %v", err)
+		if os.IsNotExist(err) {
+			return nil, globals.ExitFile,
+				newError(ErrNotFound, "Original FILE (%s) does not exist.", name)
+		}
+		return nil, globals.ExitFile,
+			fmt.Errorf("We have a problem with opening file: %w", err)
 	}
 
-	return 0, nil
+	checksum, err := newChecksumIndex(root).Get(name)
+	if err == errNoChecksum {
+		return reader, 0, nil
+	}
+	if err != nil {
+		reader.Close()
+		return nil, globals.ExitFile,
+			fmt.Errorf("We have a problem with reading checksum: %w", err)
+	}
+	return newVerifyReader(reader, name, checksum), 0, nil
 }
 
-// TODO: add replace
-// TEST: TestCopyFile (several tests)
-func (b Bucket) copyFile(origFile, destFile string, origContent []byte) (destContent []byte, err error) {
-	var oldFile, newFile *os.File
-	var bytes64 int64
-	var bytesWritten int
-
-	destContent = nil
-
-	// CLI & gRPC/Get
-	if origContent == nil {
-		// Open original file
-		oldFile, err = os.Open(origFile)
-		defer oldFile.Close()
-		if err != nil {
-			tlog.Warn.Printf("Open: %v", err)
-			return
-		}
+func (b *Bucket) removeFile(root bucketFS, name string) (exitCode int, err error) {
+	name, exitCode, err = b.filePath(root, name)
+	if err != nil {
+		return
 	}
 
-	// CLI & gRPC/Put
-	if destFile != "" {
-		// Create new file
-		newFile, err = os.Create(destFile)
-		defer newFile.Close()
-		if err != nil {
-			tlog.Warn.Printf("Create: %v", err)
-			return
+	err = newFragmentStore(root, 0).Remove(name)
+	if err == errNoManifest {
+		var info os.FileInfo
+		info, err = root.Stat(name)
+		if os.IsNotExist(err) {
+			return globals.ExitFile,
+				newError(ErrNotFound, "Original FILE (%s) does not exist.", name)
+		}
+		if err == nil && info.IsDir() {
+			return globals.ExitFile,
+				newError(ErrInvalid, "Original FILE (%s) is a directory.", name)
 		}
+		err = root.Remove(name)
+	}
+	if err != nil {
+		return globals.ExitFile,
+			fmt.Errorf("We have a problem with removing file: %w", err)
 	}
 
-	switch {
-	// CLI
-	case origContent == nil && destFile != "":
-		bytes64, err = io.Copy(newFile, oldFile)
-		bytesWritten = int(bytes64)
-		if err != nil {
-			tlog.Warn.Printf("Copy: %v", err)
-			return
-		}
+	// the file is removed already, so the entry is stale at worst
+	if err = newChecksumIndex(root).Remove(name); err != nil {
+		tlog.Warn.Printf("Removing checksum of %s failed: %v", name, err)
+	}
 
-	// gRPC/Put
-	case origContent != nil && destFile != "":
-		bytesWritten, err = newFile.Write(origContent)
-		if err != nil {
-			tlog.Warn.Printf("Copy: %v", err)
-			return
-		}
+	return 0, nil
+}
 
-	// gRPC/Get
-	case destFile == "":
-		destContent, err = ioutil.ReadAll(oldFile)
-		if err != nil {
-			tlog.Warn.Printf("Copy: %v", err)
-			return
-		}
-		bytesWritten = len(destContent)
+// copyToFile creates the new file on the local filesystem
+func copyToFile(destFile string, reader io.Reader) error {
+	newFile, err := os.Create(destFile)
+	if err != nil {
+		tlog.Warn.Printf("Create: %v", err)
+		return err
+	}
+	defer newFile.Close()
 
-	default:
+	written, err := io.Copy(newFile, reader)
+	if err != nil {
+		tlog.Warn.Printf("Copy: %v", err)
+		return err
 	}
 
-	// CLI & gRPC/Put
-	if destFile != "" {
-		// Commit the file contents
-		// Flushes memory to disk
-		err = newFile.Sync()
-		if err != nil {
-			tlog.Warn.Printf("Sync: %v", err)
-			return
-		}
+	// Commit the file contents
+	// Flushes memory to disk
+	err = newFile.Sync()
+	if err != nil {
+		tlog.Warn.Printf("Sync: %v", err)
+		return err
 	}
 
-	tlog.Debug.Printf("Copied %d bytes.", bytesWritten)
+	tlog.Debug.Printf("Copied %d bytes.", written)
 
-	return
+	return nil
 }
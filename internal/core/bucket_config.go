@@ -2,13 +2,16 @@ package core
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
 
+	"bitbucket.org/udt/wizefs/internal/cryptocore"
 	"bitbucket.org/udt/wizefs/internal/globals"
 	"bitbucket.org/udt/wizefs/internal/tlog"
+	"bitbucket.org/udt/wizefs/internal/util"
 )
 
 const (
@@ -24,6 +27,19 @@ type BucketConfig struct {
 	Origin     string         `json:"origin"`
 	OriginPath string         `json:"originpath"`
 	Type       globals.FSType `json:"type"`
+	// FragmentSize is the size of file fragments in bytes,
+	// 0 - files are stored as a whole
+	FragmentSize int `json:"fragmentsize,omitempty"`
+	// MicroFragmentSize is the size of micro-fragments every fragment is
+	// split into, 0 - micro-fragmentation is turned off
+	MicroFragmentSize int `json:"microfragmentsize,omitempty"`
+	// Encryption holds the wrapped master key of an encrypted Bucket,
+	// nil - files are stored unencrypted
+	Encryption *BucketEncryption `json:"encryption,omitempty"`
+	// Codec and CodecLevel compress the LZFS zip archive,
+	// "" and 0 - the default codec and level, see util.Codecs
+	Codec      string `json:"codec,omitempty"`
+	CodecLevel int    `json:"codeclevel,omitempty"`
 
 	filename string
 	mutex    sync.Mutex
@@ -41,6 +57,191 @@ func NewBucketConfig(origin, originPath string, itype globals.FSType) *BucketCon
 	}
 }
 
+// BucketOptions are the settings of a new Bucket; they are kept in its
+// BucketConfig
+type BucketOptions struct {
+	// FragmentSize is the size of file fragments in bytes,
+	// 0 - files are stored as a whole
+	FragmentSize int
+	// MicroFragmentSize is the size of micro-fragments every fragment is
+	// split into, 0 - micro-fragmentation is turned off
+	MicroFragmentSize int
+	// Password turns encryption on, the master key of the Bucket is wrapped
+	// with the key derived from it
+	Password string
+	// Codec and CodecLevel compress the LZFS zip archive
+	Codec      string
+	CodecLevel int
+	// AutoMount lets file operations use the Bucket when it's not mounted,
+	// it's kept in the Storage config
+	AutoMount AutoMountPolicy
+	// ACL grants the principals of the REST service access to the Bucket,
+	// it's kept in the Storage config; nil - only admins have access
+	ACL *BucketACL
+}
+
+// Check validates the options
+func (o BucketOptions) Check() error {
+	if o.FragmentSize != 0 &&
+		(o.FragmentSize < MinFragmentSize || o.FragmentSize > MaxFragmentSize) {
+		return fmt.Errorf("Fragment size should be between %d and %d bytes",
+			MinFragmentSize, MaxFragmentSize)
+	}
+	if o.MicroFragmentSize != 0 {
+		if o.FragmentSize == 0 {
+			return fmt.Errorf("Micro-fragmentation requires fragment size")
+		}
+		if o.MicroFragmentSize < MinMicroFragmentSize ||
+			o.FragmentSize%o.MicroFragmentSize != 0 {
+			return fmt.Errorf("Micro-fragment size should be at least %d bytes "+
+				"and divide fragment size %d evenly",
+				MinMicroFragmentSize, o.FragmentSize)
+		}
+	}
+	if err := o.AutoMount.Check(); err != nil {
+		return err
+	}
+	if o.ACL != nil {
+		if err := o.ACL.Check(); err != nil {
+			return err
+		}
+	}
+	if o.Codec != "" || o.CodecLevel != 0 {
+		codec, err := util.LookupCodec(o.Codec)
+		if err != nil {
+			return err
+		}
+		if _, err = codec.Level(o.CodecLevel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply sets the options to the config, it generates the master key of an
+// encrypted Bucket
+func (o BucketOptions) Apply(c *BucketConfig) (err error) {
+	c.FragmentSize = o.FragmentSize
+	c.MicroFragmentSize = o.MicroFragmentSize
+	c.Codec = o.Codec
+	c.CodecLevel = o.CodecLevel
+	if o.Password != "" {
+		c.Encryption, err = newBucketEncryption(o.Password, cryptocore.ScryptDefaultLogN)
+	}
+	return err
+}
+
+// ACLPermission is the access to the Bucket, every permission includes the
+// previous ones
+type ACLPermission int
+
+const (
+	// ACLRead lets the principal get, list and stat the files and see the
+	// state of the Bucket
+	ACLRead ACLPermission = iota + 1
+	// ACLWrite lets the principal put and remove the files and mount and
+	// unmount the Bucket
+	ACLWrite
+	// ACLOwner lets the principal delete the Bucket and change its ACL
+	ACLOwner
+)
+
+func (p ACLPermission) String() string {
+	switch p {
+	case ACLRead:
+		return "read"
+	case ACLWrite:
+		return "write"
+	case ACLOwner:
+		return "owner"
+	}
+	return fmt.Sprintf("ACLPermission(%d)", int(p))
+}
+
+// AnyPrincipal in Read and Write grants the permission to every
+// authenticated principal
+const AnyPrincipal = "*"
+
+// BucketACL is the access control list of the Bucket. Principals are the
+// names of the users of the REST service, see rest/auth. The ACL is kept in
+// the Storage config next to the auto-mount policy, so it's known without
+// mounting the Bucket.
+type BucketACL struct {
+	Owner string   `json:"owner"`
+	Read  []string `json:"read,omitempty"`
+	Write []string `json:"write,omitempty"`
+}
+
+// Check validates the ACL
+func (a *BucketACL) Check() error {
+	if a.Owner == "" || a.Owner == AnyPrincipal {
+		return fmt.Errorf("ACL should have an owner")
+	}
+	return nil
+}
+
+// Allows checks if the principal has the permission
+func (a *BucketACL) Allows(principal string, perm ACLPermission) bool {
+	if a == nil || principal == "" {
+		return false
+	}
+	if principal == a.Owner {
+		return true
+	}
+	switch perm {
+	case ACLRead:
+		return containsPrincipal(a.Read, principal) || containsPrincipal(a.Write, principal)
+	case ACLWrite:
+		return containsPrincipal(a.Write, principal)
+	}
+	return false
+}
+
+func containsPrincipal(principals []string, principal string) bool {
+	for _, p := range principals {
+		if p == principal || p == AnyPrincipal {
+			return true
+		}
+	}
+	return false
+}
+
+// BucketEncryption is the master key of an encrypted Bucket wrapped with
+// the key derived from the password by scrypt
+type BucketEncryption struct {
+	EncryptedKey []byte               `json:"encryptedkey"`
+	ScryptObject cryptocore.ScryptKDF `json:"scryptobject"`
+}
+
+func newBucketEncryption(password string, logN int) (*BucketEncryption, error) {
+	masterKey, err := cryptocore.RandBytes(cryptocore.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, kdf, err := cryptocore.EncryptKey(masterKey, password, logN)
+	if err != nil {
+		return nil, err
+	}
+	return &BucketEncryption{
+		EncryptedKey: encryptedKey,
+		ScryptObject: kdf,
+	}, nil
+}
+
+// DecryptMasterKey unwraps the master key, it returns
+// cryptocore.ErrWrongPassword if the password doesn't match
+func (e *BucketEncryption) DecryptMasterKey(password string) ([]byte, error) {
+	return cryptocore.DecryptKey(e.EncryptedKey, password, e.ScryptObject)
+}
+
+// PackOptions returns the options of packing the LZFS archive
+func (c *BucketConfig) PackOptions() util.PackOptions {
+	return util.PackOptions{
+		Codec: c.Codec,
+		Level: c.CodecLevel,
+	}
+}
+
 // TEST: TestBucketConfigSave
 func (c *BucketConfig) Save() error {
 	c.mutex.Lock()
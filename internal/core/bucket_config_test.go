@@ -0,0 +1,79 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+func TestBucketEncryptionMasterKey(t *testing.T) {
+	encryption, err := newBucketEncryption("secret", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := &Bucket{
+		Origin: "CRYPT",
+		Config: &BucketConfig{Encryption: encryption},
+	}
+	s := &Storage{buckets: map[string]*Bucket{"CRYPT": bucket}}
+
+	if _, exitCode, err := s.masterKey("CRYPT", ""); err == nil || exitCode != globals.ExitPassword {
+		t.Errorf("Missing password: expected exit code %d, got %d (%v)",
+			globals.ExitPassword, exitCode, err)
+	}
+	if _, exitCode, err := s.masterKey("CRYPT", "wrong"); err == nil || exitCode != globals.ExitPassword {
+		t.Errorf("Wrong password: expected exit code %d, got %d (%v)",
+			globals.ExitPassword, exitCode, err)
+	}
+
+	key1, _, err := s.masterKey("CRYPT", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := encryption.DecryptMasterKey("secret")
+	if err != nil || !bytes.Equal(key1, key2) || len(key1) == 0 {
+		t.Errorf("Master key was not unwrapped: %v", err)
+	}
+
+	bucket.Config.Encryption = nil
+	if key, _, err := s.masterKey("CRYPT", ""); key != nil || err != nil {
+		t.Errorf("Unencrypted Bucket should not have a key: %v", err)
+	}
+}
+
+func TestBucketACL(t *testing.T) {
+	acl := &BucketACL{Owner: "alice", Read: []string{"bob"}, Write: []string{"carol"}}
+	tests := []struct {
+		principal string
+		perm      ACLPermission
+		want      bool
+	}{
+		{"alice", ACLOwner, true},
+		{"alice", ACLWrite, true},
+		{"bob", ACLRead, true},
+		{"bob", ACLWrite, false},
+		{"carol", ACLRead, true},
+		{"carol", ACLWrite, true},
+		{"carol", ACLOwner, false},
+		{"dave", ACLRead, false},
+		{"", ACLRead, false},
+	}
+	for _, test := range tests {
+		if got := acl.Allows(test.principal, test.perm); got != test.want {
+			t.Errorf("Allows(%q, %v) = %v, want %v", test.principal, test.perm, got, test.want)
+		}
+	}
+
+	acl.Read = []string{AnyPrincipal}
+	if !acl.Allows("dave", ACLRead) || acl.Allows("dave", ACLWrite) {
+		t.Errorf("%s in Read should grant read only", AnyPrincipal)
+	}
+	if (*BucketACL)(nil).Allows("alice", ACLRead) {
+		t.Errorf("Bucket without ACL allows access")
+	}
+	if err := (&BucketACL{Read: []string{"bob"}}).Check(); err == nil {
+		t.Errorf("ACL without owner is valid")
+	}
+}
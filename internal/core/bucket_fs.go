@@ -0,0 +1,146 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// bucketFS is the set of file operations that a Bucket performs inside its
+// root directory. Names are slash-separated paths relative to the root.
+type bucketFS interface {
+	Open(name string) (io.ReadCloser, error)
+	// Create creates a new file, it fails if the file exists already
+	Create(name string) (bucketFile, error)
+	Stat(name string) (os.FileInfo, error)
+	Remove(name string) error
+	RemoveAll(name string) error
+	Rename(oldname, newname string) error
+	MkdirAll(name string) error
+	ReadDir(name string) ([]os.FileInfo, error)
+	// Check fails if name leads outside of the root, e.g. through a symlink
+	Check(name string) error
+}
+
+// bucketFile is a file opened for writing
+type bucketFile interface {
+	io.Writer
+	Sync() error
+	Close() error
+}
+
+// dirFS implements bucketFS on top of a directory of the local filesystem,
+// usually it's the mountpoint of the Bucket.
+type dirFS string
+
+var _ bucketFS = dirFS("") // Verify that interface is implemented.
+
+func (d dirFS) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+func (d dirFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(d.path(name))
+}
+
+func (d dirFS) Create(name string) (bucketFile, error) {
+	return os.OpenFile(d.path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+}
+
+func (d dirFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(d.path(name))
+}
+
+func (d dirFS) Remove(name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d dirFS) RemoveAll(name string) error {
+	return os.RemoveAll(d.path(name))
+}
+
+func (d dirFS) Rename(oldname, newname string) error {
+	return os.Rename(d.path(oldname), d.path(newname))
+}
+
+func (d dirFS) MkdirAll(name string) error {
+	return os.MkdirAll(d.path(name), 0755)
+}
+
+func (d dirFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(d.path(name))
+}
+
+// Check resolves symlinks of the longest existing part of name and fails if
+// the result is outside of the directory
+func (d dirFS) Check(name string) error {
+	root, err := filepath.EvalSymlinks(string(d))
+	if err != nil {
+		return err
+	}
+
+	resolved := d.path(name)
+	for {
+		target, err := filepath.EvalSymlinks(resolved)
+		if err == nil {
+			resolved = target
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// the rest of the path doesn't exist yet, e.g. it's created by Put
+		parent := filepath.Dir(resolved)
+		if parent == resolved {
+			break
+		}
+		resolved = parent
+	}
+
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return fmt.Errorf("%s leads outside of the Bucket", name)
+	}
+	return nil
+}
+
+// writeFile writes the new file of the Bucket and syncs it
+func writeFile(fs bucketFS, name string, data []byte) error {
+	file, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// cleanPath converts the path of a file inside the Bucket to the clean
+// slash-separated form used by bucketFS. It rejects absolute paths, escapes
+// with ".." and the names that are reserved by the Bucket itself.
+// TEST: TestCleanPath
+func cleanPath(name string) (string, error) {
+	name = filepath.ToSlash(name)
+	if name == "" || path.IsAbs(name) || filepath.IsAbs(name) {
+		return "", newError(ErrInvalid, "FILE argument (%s) should be a relative path.", name)
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", newError(ErrInvalid, "FILE argument (%s) is outside of the Bucket.", name)
+	}
+
+	first := strings.SplitN(cleaned, "/", 2)[0]
+	if first == fragmentStoreDir || cleaned == BucketConfigFilename {
+		return "", newError(ErrInvalid, "FILE argument (%s) is reserved by the Bucket.", name)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"test.txt", "test.txt", true},
+		{"docs/a.txt", "docs/a.txt", true},
+		{"docs//./b/../a.txt", "docs/a.txt", true},
+		{"", "", false},
+		{".", "", false},
+		{"/etc/passwd", "", false},
+		{"..", "", false},
+		{"../a.txt", "", false},
+		{"docs/../../a.txt", "", false},
+		{".wizefs/manifests/x", "", false},
+		{"wizefs.conf", "", false},
+		{"docs/wizefs.conf", "docs/wizefs.conf", true},
+	}
+	for _, test := range tests {
+		got, err := cleanPath(test.name)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("cleanPath(%q) = %q, %v; want %q, ok = %v",
+				test.name, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestBucketHierarchicalPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootPath := filepath.Join(dir, "root")
+	outsidePath := filepath.Join(dir, "outside")
+	for _, path := range []string{rootPath, outsidePath} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outsidePath, filepath.Join(rootPath, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	root := dirFS(rootPath)
+	for _, fragmentSize := range []int{0, MinFragmentSize} {
+		bucket := &Bucket{Config: &BucketConfig{FragmentSize: fragmentSize}}
+		content := []byte("nested")
+
+		if _, err := bucket.putFile(root, "docs/2018/a.txt", bytes.NewReader(content), PutOptions{}); err != nil {
+			t.Fatalf("Put with fragment size %d: %v", fragmentSize, err)
+		}
+		if fragmentSize == 0 {
+			if _, err := os.Stat(filepath.Join(rootPath, "docs", "2018", "a.txt")); err != nil {
+				t.Errorf("Intermediate directories were not created: %v", err)
+			}
+		}
+		// the same name in another directory is another file
+		if _, err := bucket.putFile(root, "a.txt", bytes.NewReader(nil), PutOptions{}); err != nil {
+			t.Errorf("Put a.txt: %v", err)
+		}
+
+		reader, _, err := bucket.openFile(root, "docs/2018/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(reader)
+		reader.Close()
+		if !bytes.Equal(got, content) {
+			t.Errorf("Get: %q, want %q", got, content)
+		}
+
+		for _, name := range []string{"docs/2018/a.txt", "a.txt"} {
+			if _, err := bucket.removeFile(root, name); err != nil {
+				t.Errorf("Remove %s: %v", name, err)
+			}
+		}
+	}
+
+	bucket := &Bucket{Config: &BucketConfig{}}
+	if _, err := bucket.putFile(root, "link/escaped.txt", bytes.NewReader(nil), PutOptions{}); err == nil {
+		t.Errorf("Put through a symlink leading outside of the Bucket succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(outsidePath, "escaped.txt")); err == nil {
+		t.Errorf("File was written outside of the Bucket")
+	}
+	if _, err := bucket.putFile(root, "../escaped.txt", bytes.NewReader(nil), PutOptions{}); err == nil {
+		t.Errorf("Put with .. escape succeeded")
+	}
+	if _, err := bucket.removeFile(root, "docs"); err == nil {
+		t.Errorf("Remove of a directory succeeded")
+	}
+}
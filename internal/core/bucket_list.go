@@ -0,0 +1,383 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+// ListPageSize is the largest number of entries returned by one List call
+const ListPageSize = 1000
+
+// FileInfo describes a file or a directory of the Bucket
+type FileInfo struct {
+	// Name is the slash-separated path inside the Bucket
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	IsDir   bool        `json:"isdir"`
+	// Checksum is the hex SHA-256 of the content, it's empty for directories
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// List returns the files of the Bucket whose paths start with prefix, sorted
+// by name. If recursive is false, paths are cut at the first slash after
+// prefix and returned as directories, like "ls". At most ListPageSize
+// entries are returned at once; nextPageToken is empty on the last page,
+// otherwise it's passed as pageToken to get the next one.
+//
+// The directories are walked in name order starting after pageToken, so a
+// page doesn't read the whole Bucket. Checksum is set only for the files
+// whose checksum is indexed, List doesn't read the content. Entries that
+// can't be read are skipped.
+// TEST: TestBucketList
+func (b *Bucket) List(prefix string, recursive bool, pageToken string) (files []FileInfo,
+	nextPageToken string, exitCode int, err error) {
+
+	root, release, exitCode, err := b.root()
+	if err != nil {
+		return
+	}
+	defer release()
+
+	if prefix != "" {
+		cleaned, err := cleanPath(prefix)
+		if err != nil {
+			return nil, "", globals.ExitFile, err
+		}
+		if strings.HasSuffix(prefix, "/") {
+			cleaned += "/"
+		}
+		prefix = cleaned
+	}
+
+	l := &bucketLister{
+		root:      root,
+		prefix:    prefix,
+		recursive: recursive,
+		pageToken: pageToken,
+		entries:   make(map[string]FileInfo),
+	}
+	if err = l.walk(); err != nil {
+		return nil, "", globals.ExitFile,
+			fmt.Errorf("We have a problem with listing files: %w", err)
+	}
+	// fragmented files are known by their manifests only, they are named by
+	// the hash of the file name, so all of them are read
+	manifests, err := newFragmentStore(root, 0).List()
+	if err != nil {
+		return nil, "", globals.ExitFile,
+			fmt.Errorf("We have a problem with listing files: %w", err)
+	}
+	for _, manifest := range manifests {
+		l.add(FileInfo{
+			Name:    manifest.Name,
+			Size:    manifest.Size,
+			Mode:    0644,
+			ModTime: manifest.modTime,
+		})
+	}
+
+	for _, info := range l.entries {
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	if len(files) > ListPageSize {
+		files = files[:ListPageSize]
+		nextPageToken = files[len(files)-1].Name
+	}
+
+	index := newChecksumIndex(root)
+	for i := range files {
+		if !files[i].IsDir {
+			files[i].Checksum, _ = index.Get(files[i].Name)
+		}
+	}
+	return files, nextPageToken, 0, nil
+}
+
+// bucketLister collects one page of List. The walk stops when it has found
+// one entry more than a page, the entries of the fragment store may come
+// before them and push the last ones to the next page.
+type bucketLister struct {
+	root      bucketFS
+	prefix    string
+	recursive bool
+	pageToken string
+	// entries are the files found so far, the directories which paths are
+	// cut at are added once
+	entries map[string]FileInfo
+	walked  int
+}
+
+// errPageFull stops the walk of bucketLister
+var errPageFull = errors.New("page is full")
+
+// walk reads the directory which contains prefix, and its subdirectories if
+// the listing is recursive
+func (l *bucketLister) walk() error {
+	dir := "."
+	if i := strings.LastIndex(l.prefix, "/"); i >= 0 {
+		dir = l.prefix[:i]
+	}
+	if fi, err := l.root.Stat(dir); os.IsNotExist(err) || err == nil && !fi.IsDir() {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err := l.walkDir(dir)
+	if err == errPageFull {
+		return nil
+	}
+	return err
+}
+
+func (l *bucketLister) walkDir(dir string) error {
+	infos, err := l.root.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	// names of the walked directories are compared with the slash, so "a/b"
+	// comes after "a.txt" like in the result
+	key := func(fi os.FileInfo) string {
+		if fi.IsDir() && l.recursive {
+			return fi.Name() + "/"
+		}
+		return fi.Name()
+	}
+	sort.Slice(infos, func(i, j int) bool { return key(infos[i]) < key(infos[j]) })
+
+	for _, fi := range infos {
+		name := path.Join(dir, fi.Name())
+		if dir == "." && (name == fragmentStoreDir || name == BucketConfigFilename) {
+			continue
+		}
+		switch {
+		case fi.IsDir():
+			if !strings.HasPrefix(name+"/", l.prefix) && !strings.HasPrefix(l.prefix, name+"/") {
+				continue
+			}
+			// the whole directory was listed on the previous pages
+			if l.pageToken > name+"/" && !strings.HasPrefix(l.pageToken, name+"/") {
+				continue
+			}
+			if !l.recursive && len(name) >= len(l.prefix) {
+				if l.add(newFileInfo(name, fi)) {
+					return errPageFull
+				}
+				continue
+			}
+			if err = l.walkDir(name); err == errPageFull {
+				return err
+			} else if err != nil {
+				tlog.Warn.Printf("Listing directory %s failed: %v", name, err)
+			}
+		case fi.Mode().IsRegular():
+			if l.add(newFileInfo(name, fi)) {
+				return errPageFull
+			}
+		}
+	}
+	return nil
+}
+
+// add keeps the entry if it belongs to the page and returns true when the
+// walk found enough entries
+func (l *bucketLister) add(info FileInfo) bool {
+	if !strings.HasPrefix(info.Name, l.prefix) {
+		return false
+	}
+	if !l.recursive {
+		rest := info.Name[len(l.prefix):]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			info = FileInfo{Name: l.prefix + rest[:i], Mode: os.ModeDir | 0755, IsDir: true}
+		}
+	}
+	if info.Name <= l.pageToken {
+		return false
+	}
+	if _, ok := l.entries[info.Name]; ok {
+		return false
+	}
+	l.entries[info.Name] = info
+	l.walked++
+	return l.walked > ListPageSize
+}
+
+// Stat returns the description of the file or the directory of the Bucket
+// TEST: TestBucketList
+func (b *Bucket) Stat(originalFile string) (info FileInfo, exitCode int, err error) {
+	root, release, exitCode, err := b.root()
+	if err != nil {
+		return
+	}
+	defer release()
+
+	name, exitCode, err := b.filePath(root, originalFile)
+	if err != nil {
+		return
+	}
+
+	store := newFragmentStore(root, 0)
+	manifest, err := store.readManifest(name)
+	switch {
+	case err == nil:
+		info = FileInfo{Name: name, Size: manifest.Size, Mode: 0644}
+		if fi, err := root.Stat(store.manifestPath(name)); err == nil {
+			info.ModTime = fi.ModTime()
+		}
+
+	case err == errNoManifest:
+		fi, err := root.Stat(name)
+		if os.IsNotExist(err) && b.isDir(root, name) {
+			// the directory of fragmented files exists in the manifests only
+			return FileInfo{Name: name, Mode: os.ModeDir | 0755, IsDir: true}, 0, nil
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				return info, globals.ExitFile,
+					newError(ErrNotFound, "Original FILE (%s) does not exist.", name)
+			}
+			return info, globals.ExitFile,
+				fmt.Errorf("We have a problem with stat of file: %w", err)
+		}
+		info = newFileInfo(name, fi)
+		if info.IsDir {
+			return info, 0, nil
+		}
+
+	default:
+		return info, globals.ExitFile,
+			fmt.Errorf("We have a problem with reading manifest: %w", err)
+	}
+
+	info.Checksum, exitCode, err = b.checksum(root, name)
+	return info, exitCode, err
+}
+
+// Checksum returns the hex SHA-256 of the file if it's in the checksum index
+// of the Bucket, otherwise it's empty. The content is not read.
+// TEST: TestBucketList
+func (b *Bucket) Checksum(originalFile string) (checksum string, exitCode int, err error) {
+	root, release, exitCode, err := b.root()
+	if err != nil {
+		return
+	}
+	defer release()
+
+	name, exitCode, err := b.filePath(root, originalFile)
+	if err != nil {
+		return
+	}
+	checksum, err = newChecksumIndex(root).Get(name)
+	switch {
+	case err == errNoChecksum:
+		return "", 0, nil
+	case err != nil:
+		return "", globals.ExitFile,
+			fmt.Errorf("We have a problem with reading checksum: %w", err)
+	}
+	return checksum, 0, nil
+}
+
+func newFileInfo(name string, fi os.FileInfo) FileInfo {
+	return FileInfo{
+		Name:    name,
+		Size:    fi.Size(),
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}
+}
+
+// walkBucket returns all files and directories of the Bucket, files stored
+// as fragments are described by their manifests. The fragment store and the
+// Bucket config are skipped, so are symlinks and special files.
+func walkBucket(root bucketFS) (all []FileInfo, err error) {
+	var walkDir func(dir string) error
+	walkDir = func(dir string) error {
+		infos, err := root.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range infos {
+			name := path.Join(dir, fi.Name())
+			if dir == "." && (name == fragmentStoreDir || name == BucketConfigFilename) {
+				continue
+			}
+			switch {
+			case fi.IsDir():
+				all = append(all, newFileInfo(name, fi))
+				if err = walkDir(name); err != nil {
+					return err
+				}
+			case fi.Mode().IsRegular():
+				all = append(all, newFileInfo(name, fi))
+			}
+		}
+		return nil
+	}
+	if err = walkDir("."); err != nil {
+		return nil, err
+	}
+
+	manifests, err := newFragmentStore(root, 0).List()
+	if err != nil {
+		return nil, err
+	}
+	for _, manifest := range manifests {
+		all = append(all, FileInfo{
+			Name:    manifest.Name,
+			Size:    manifest.Size,
+			Mode:    0644,
+			ModTime: manifest.modTime,
+		})
+	}
+	return all, nil
+}
+
+// isDir checks if some file of the Bucket is inside the directory name
+func (b *Bucket) isDir(root bucketFS, name string) bool {
+	all, err := walkBucket(root)
+	if err != nil {
+		return false
+	}
+	for _, info := range all {
+		if strings.HasPrefix(info.Name, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checksum returns the hex SHA-256 of the content of the file, it's read
+// from the checksum index if the file is indexed
+func (b *Bucket) checksum(root bucketFS, name string) (checksum string, exitCode int, err error) {
+	if checksum, err = newChecksumIndex(root).Get(name); err == nil {
+		return checksum, 0, nil
+	}
+
+	reader, exitCode, err := b.openFile(root, name)
+	if err != nil {
+		return "", exitCode, err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, reader); err != nil {
+		return "", globals.ExitFile,
+			fmt.Errorf("We have a problem with reading file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), 0, nil
+}
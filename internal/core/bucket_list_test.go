@@ -0,0 +1,151 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func listNames(t *testing.T, bucket *Bucket, prefix string, recursive bool) []string {
+	files, nextPageToken, _, err := bucket.List(prefix, recursive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextPageToken != "" {
+		t.Errorf("Unexpected next page of %q", prefix)
+	}
+	var names []string
+	for _, info := range files {
+		if info.IsDir {
+			names = append(names, info.Name+"/")
+		} else {
+			names = append(names, info.Name)
+		}
+	}
+	return names
+}
+
+func TestBucketList(t *testing.T) {
+	bucket, originPath := newTestBucket(t)
+	setAutoMount(t, bucket, AutoMountDirect)
+
+	content := []byte("content")
+	bucket.PutFile("a.txt", content)
+	bucket.PutFile("docs/b.txt", content)
+	bucket.PutFile("docs/2018/c.txt", content)
+	// fragmented files live in the manifests only
+	bucket.Config.FragmentSize = MinFragmentSize
+	bucket.PutFile("frag/d.txt", content)
+	bucket.Config.FragmentSize = 0
+	os.Mkdir(filepath.Join(originPath, "empty"), 0755)
+	// not put, so its checksum is not indexed
+	ioutil.WriteFile(filepath.Join(originPath, "docs.txt"), content, 0644)
+	// a manifest that can't be read is skipped
+	ioutil.WriteFile(filepath.Join(originPath, manifestsDir, "broken"), []byte("{"), 0644)
+
+	tests := []struct {
+		prefix    string
+		recursive bool
+		want      string
+	}{
+		{"", false, "[a.txt docs/ docs.txt empty/ frag/]"},
+		{"", true, "[a.txt docs.txt docs/2018/c.txt docs/b.txt frag/d.txt]"},
+		{"docs/", false, "[docs/2018/ docs/b.txt]"},
+		{"docs", false, "[docs/ docs.txt]"},
+		{"frag/", true, "[frag/d.txt]"},
+		{"nothing", true, "[]"},
+	}
+	for _, test := range tests {
+		got := fmt.Sprint(listNames(t, bucket, test.prefix, test.recursive))
+		if got != test.want {
+			t.Errorf("List(%q, %v) = %s, want %s", test.prefix, test.recursive, got, test.want)
+		}
+	}
+	if _, _, _, err := bucket.List("../", true, ""); err == nil {
+		t.Errorf("List with .. escape succeeded")
+	}
+
+	sum := sha256.Sum256(content)
+	files, _, _, err := bucket.List("", true, "docs.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[0].Name != "docs/2018/c.txt" {
+		t.Fatalf("List after docs.txt = %+v", files)
+	}
+	for _, info := range files {
+		if info.Checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("Checksum of %s is %q", info.Name, info.Checksum)
+		}
+	}
+	if files, _, _, _ = bucket.List("docs.txt", false, ""); len(files) != 1 || files[0].Checksum != "" {
+		t.Errorf("List of not indexed file = %+v, want no checksum", files)
+	}
+	if checksum, _, err := bucket.Checksum("docs.txt"); err != nil || checksum != "" {
+		t.Errorf("Checksum of not indexed file = %q, %v", checksum, err)
+	}
+	if checksum, _, err := bucket.Checksum("frag/d.txt"); err != nil || checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("Checksum of frag/d.txt = %q, %v", checksum, err)
+	}
+
+	for _, name := range []string{"docs/2018/c.txt", "frag/d.txt"} {
+		info, _, err := bucket.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size != int64(len(content)) || info.IsDir || info.ModTime.IsZero() ||
+			info.Checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("Stat(%s) = %+v", name, info)
+		}
+	}
+	for _, name := range []string{"docs", "frag"} {
+		if info, _, err := bucket.Stat(name); err != nil || !info.IsDir {
+			t.Errorf("Stat(%s) = %+v, %v; want directory", name, info, err)
+		}
+	}
+	if _, _, err := bucket.Stat("nothing"); err == nil {
+		t.Errorf("Stat of not existing file succeeded")
+	}
+}
+
+func TestBucketListPages(t *testing.T) {
+	bucket, originPath := newTestBucket(t)
+	setAutoMount(t, bucket, AutoMountDirect)
+
+	count := ListPageSize + ListPageSize/2
+	for i := 0; i < count; i++ {
+		name := filepath.Join(originPath, fmt.Sprintf("%05d", i))
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var all []FileInfo
+	pageToken := ""
+	for pages := 1; ; pages++ {
+		files, nextPageToken, _, err := bucket.List("", false, pageToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, files...)
+		if nextPageToken == "" {
+			if pages != 2 {
+				t.Errorf("Got %d pages, want 2", pages)
+			}
+			break
+		}
+		pageToken = nextPageToken
+	}
+	if len(all) != count {
+		t.Fatalf("Got %d files, want %d", len(all), count)
+	}
+	for i, info := range all {
+		if want := fmt.Sprintf("%05d", i); info.Name != want {
+			t.Fatalf("File %d is %s, want %s", i, info.Name, want)
+		}
+	}
+}
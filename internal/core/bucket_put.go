@@ -0,0 +1,255 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+// tempDir keeps the files that are being written by Put, they are renamed
+// to their names when they are complete
+const tempDir = fragmentStoreDir + "/tmp"
+
+// AnyETag matches any existing file in IfMatch and IfNoneMatch
+const AnyETag = "*"
+
+// PutOptions select what Put does with an existing file. The ETag of a file
+// is the hex SHA-256 of its content, see FileInfo.Checksum.
+type PutOptions struct {
+	// Overwrite replaces the existing file, by default Put fails if the
+	// file exists
+	Overwrite bool
+	// IfMatch puts the file only if it exists and its ETag is IfMatch,
+	// AnyETag - if it exists; it replaces the file without Overwrite
+	IfMatch string
+	// IfNoneMatch puts the file only if its ETag is not IfNoneMatch, it
+	// replaces the file without Overwrite; AnyETag - only if it doesn't exist
+	IfNoneMatch string
+}
+
+// Check validates the options
+func (o PutOptions) Check() error {
+	if o.IfMatch != "" && o.IfNoneMatch != "" {
+		return fmt.Errorf("IfMatch and IfNoneMatch can't be used together")
+	}
+	if o.IfNoneMatch == AnyETag && o.Overwrite {
+		return fmt.Errorf("IfNoneMatch %s and Overwrite can't be used together", AnyETag)
+	}
+	return nil
+}
+
+// checkPut checks if the file may be put by opts. The caller holds the put
+// lock, see lockPut.
+func (b *Bucket) checkPut(root bucketFS, name string, opts PutOptions) (exitCode int, err error) {
+	if !b.exists(root, name) {
+		if opts.IfMatch != "" {
+			return globals.ExitPrecondition,
+				newError(ErrPrecondition, "Destination FILE (%s) does not exist.", name)
+		}
+		return 0, nil
+	}
+
+	switch {
+	case opts.IfNoneMatch == AnyETag:
+		return globals.ExitPrecondition,
+			newError(ErrPrecondition, "Destination FILE (%s) is exist.", name)
+	case opts.IfMatch == AnyETag:
+		return 0, nil
+	case opts.IfMatch != "" || opts.IfNoneMatch != "":
+		etag, exitCode, err := b.checksum(root, name)
+		if err != nil {
+			return exitCode, err
+		}
+		if opts.IfMatch != "" && etag != opts.IfMatch {
+			return globals.ExitPrecondition,
+				newError(ErrPrecondition, "ETag of Destination FILE (%s) does not match.", name)
+		}
+		if opts.IfNoneMatch != "" && etag == opts.IfNoneMatch {
+			return globals.ExitPrecondition,
+				newError(ErrPrecondition, "ETag of Destination FILE (%s) matches.", name)
+		}
+		return 0, nil
+	case opts.Overwrite:
+		return 0, nil
+	}
+	return globals.ExitFile,
+		newError(ErrAlreadyExists, "Destination FILE (%s) is exist.", name)
+}
+
+// putFile stores the content of reader as name. Whole files are written to
+// a temp file which is synced and renamed to name, fragmented files are
+// replaced by their manifests, so readers see either the old or the new
+// content. Puts of a Bucket are serialized when they replace files.
+//
+// The SHA-256 of the content replaces the entry of the checksum index after
+// the file is replaced. A failed put keeps the old file verified, and the old
+// entry left by a crash is stale for the new file, so it's not used.
+func (b *Bucket) putFile(root bucketFS, name string, reader io.Reader,
+	opts PutOptions) (exitCode int, err error) {
+
+	if err = opts.Check(); err != nil {
+		return globals.ExitUsage, err
+	}
+	name, exitCode, err = b.filePath(root, name)
+	if err != nil {
+		return
+	}
+
+	// fail fast before the content is copied
+	unlock, exitCode, err := b.lockPut()
+	if err != nil {
+		return
+	}
+	exitCode, err = b.checkPut(root, name, opts)
+	unlock()
+	if err != nil {
+		return
+	}
+
+	hash := sha256.New()
+	reader = io.TeeReader(reader, hash)
+	index := newChecksumIndex(root)
+
+	if store := b.fragments(root); store != nil {
+		var manifest *fragmentManifest
+		if manifest, err = store.stage(name, reader); err != nil {
+			return globals.ExitFile,
+				fmt.Errorf("We have a problem with storing fragments: %w", err)
+		}
+
+		unlock, exitCode, err = b.lockPut()
+		if err != nil {
+			store.discard(manifest)
+			return
+		}
+		defer unlock()
+		if exitCode, err = b.checkPut(root, name, opts); err != nil {
+			store.discard(manifest)
+			return
+		}
+
+		old, _ := store.readManifest(name)
+		// the readers must not verify the new file with the old checksum
+		index.Remove(name)
+		if err = store.commit(manifest); err != nil {
+			store.discard(manifest)
+			return globals.ExitFile,
+				fmt.Errorf("We have a problem with storing fragments: %w", err)
+		}
+		if old != nil {
+			store.removeFragments(old)
+		} else {
+			// the file stored as a whole before fragmentation was turned on
+			root.Remove(name)
+		}
+		b.indexChecksum(index, name, hex.EncodeToString(hash.Sum(nil)))
+		return 0, nil
+	}
+
+	if dir := path.Dir(name); dir != "." {
+		if err = root.MkdirAll(dir); err != nil {
+			return globals.ExitFile,
+				fmt.Errorf("We have a problem with creating directory: %w", err)
+		}
+	}
+
+	tmp, exitCode, err := b.writeTemp(root, reader)
+	if err != nil {
+		return
+	}
+
+	unlock, exitCode, err = b.lockPut()
+	if err != nil {
+		root.Remove(tmp)
+		return
+	}
+	defer unlock()
+	if exitCode, err = b.checkPut(root, name, opts); err != nil {
+		root.Remove(tmp)
+		return
+	}
+	index.Remove(name)
+	if err = root.Rename(tmp, name); err != nil {
+		root.Remove(tmp)
+		return globals.ExitFile,
+			fmt.Errorf("We have a problem with replacing file: %w", err)
+	}
+	// the file stored as fragments before fragmentation was turned off
+	if err = newFragmentStore(root, 0).Remove(name); err != nil && err != errNoManifest {
+		tlog.Warn.Printf("Removing old fragments of %s failed: %v", name, err)
+	}
+	b.indexChecksum(index, name, hex.EncodeToString(hash.Sum(nil)))
+
+	return 0, nil
+}
+
+// indexChecksum stores the checksum of the file that has just been put. The
+// file is stored already, so a failure leaves it not verified on read.
+func (b *Bucket) indexChecksum(index *checksumIndex, name, checksum string) {
+	if err := index.Set(name, checksum); err != nil {
+		tlog.Warn.Printf("Indexing checksum of %s failed: %v", name, err)
+		// the entry of the old file must not be used for the new one
+		index.Remove(name)
+	}
+}
+
+// lockPut serializes the checks and the renames of Put with the other
+// goroutines and processes
+func (b *Bucket) lockPut() (unlock func(), exitCode int, err error) {
+	b.putMutex.Lock()
+	if b.storage == nil {
+		return b.putMutex.Unlock, 0, nil
+	}
+	lock, exitCode, err := b.storage.lock(putLockName(b.Origin), LockExclusive)
+	if err != nil {
+		b.putMutex.Unlock()
+		return nil, exitCode, err
+	}
+	return func() {
+		lock.unlock()
+		b.putMutex.Unlock()
+	}, 0, nil
+}
+
+// writeTemp copies the content of reader into a new temp file and syncs it
+func (b *Bucket) writeTemp(root bucketFS, reader io.Reader) (tmp string, exitCode int, err error) {
+	var id [16]byte
+	if _, err = rand.Read(id[:]); err != nil {
+		return "", globals.ExitFile, err
+	}
+	tmp = path.Join(tempDir, hex.EncodeToString(id[:]))
+
+	if err = root.MkdirAll(tempDir); err != nil {
+		return "", globals.ExitFile,
+			fmt.Errorf("We have a problem with creating directory: %w", err)
+	}
+	file, err := root.Create(tmp)
+	if err != nil {
+		return "", globals.ExitFile,
+			fmt.Errorf("We have a problem with creating file: %w", err)
+	}
+
+	written, err := io.Copy(file, reader)
+	if err == nil {
+		// Commit the file contents
+		// Flushes memory to disk
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		// don't leave a partially written file in the Bucket
+		root.Remove(tmp)
+		return "", globals.ExitFile,
+			fmt.Errorf("We have a problem with copy file: %w", err)
+	}
+
+	tlog.Debug.Printf("Copied %d bytes.", written)
+
+	return tmp, 0, nil
+}
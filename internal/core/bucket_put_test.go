@@ -0,0 +1,100 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+func etag(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestBucketPutModes(t *testing.T) {
+	bucket, originPath := newTestBucket(t)
+	setAutoMount(t, bucket, AutoMountDirect)
+
+	for _, fragmentSize := range []int{0, MinFragmentSize} {
+		bucket.Config.FragmentSize = fragmentSize
+		name := "docs/a.txt"
+
+		tests := []struct {
+			opts     PutOptions
+			content  string
+			exitCode int
+		}{
+			{PutOptions{}, "v1", 0},
+			{PutOptions{}, "v2", globals.ExitFile},
+			{PutOptions{IfNoneMatch: AnyETag}, "v2", globals.ExitPrecondition},
+			{PutOptions{Overwrite: true}, "v2", 0},
+			{PutOptions{IfMatch: etag("v1")}, "v3", globals.ExitPrecondition},
+			{PutOptions{IfMatch: etag("v2")}, "v3", 0},
+			{PutOptions{IfNoneMatch: etag("v3")}, "v4", globals.ExitPrecondition},
+			{PutOptions{IfNoneMatch: etag("v1")}, "v4", 0},
+			{PutOptions{IfMatch: AnyETag}, "v5", 0},
+			{PutOptions{IfMatch: AnyETag, IfNoneMatch: AnyETag}, "v6", globals.ExitUsage},
+		}
+		want := ""
+		for _, test := range tests {
+			exitCode, err := bucket.PutFileWithOptions(name, []byte(test.content), test.opts)
+			if exitCode != test.exitCode {
+				t.Errorf("Fragment size %d, put %s with %+v: exit code %d (%v), want %d",
+					fragmentSize, test.content, test.opts, exitCode, err, test.exitCode)
+			}
+			if exitCode == 0 {
+				want = test.content
+			}
+			content, _, err := bucket.GetFile(name, "", true)
+			if err != nil || string(content) != want {
+				t.Errorf("Fragment size %d, after put %s with %+v: content %q (%v), want %q",
+					fragmentSize, test.content, test.opts, content, err, want)
+			}
+		}
+
+		if exitCode, _ := bucket.PutFileWithOptions("b.txt", []byte("v1"),
+			PutOptions{IfMatch: AnyETag}); exitCode != globals.ExitPrecondition {
+			t.Errorf("IfMatch of not existing file: exit code %d, want %d",
+				exitCode, globals.ExitPrecondition)
+		}
+		if _, err := bucket.RemoveFile(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if infos, _ := ioutil.ReadDir(filepath.Join(originPath, filepath.FromSlash(tempDir))); len(infos) != 0 {
+		t.Errorf("%d temp files are left", len(infos))
+	}
+}
+
+func TestBucketPutReplacesStorageForm(t *testing.T) {
+	bucket, originPath := newTestBucket(t)
+	setAutoMount(t, bucket, AutoMountDirect)
+
+	bucket.PutFile("a.txt", []byte("whole"))
+	bucket.Config.FragmentSize = MinFragmentSize
+	if _, err := bucket.PutFileWithOptions("a.txt", []byte("fragments"),
+		PutOptions{Overwrite: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(originPath, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("The whole file was not removed: %v", err)
+	}
+
+	bucket.Config.FragmentSize = 0
+	if _, err := bucket.PutFileWithOptions("a.txt", []byte("whole again"),
+		PutOptions{Overwrite: true}); err != nil {
+		t.Fatal(err)
+	}
+	if newFragmentStore(dirFS(originPath), 0).Exists("a.txt") {
+		t.Errorf("The manifest was not removed")
+	}
+	if content, _, _ := bucket.GetFile("a.txt", "", true); string(content) != "whole again" {
+		t.Errorf("Content %q, want %q", content, "whole again")
+	}
+}
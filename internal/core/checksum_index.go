@@ -0,0 +1,192 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// checksumsDir keeps the checksum index of the Bucket
+const checksumsDir = fragmentStoreDir + "/checksums"
+
+var (
+	errNoChecksum = errors.New("checksum is not indexed")
+)
+
+// IntegrityError is returned by the readers of the Bucket when the content
+// of the file does not match the checksum stored by Put
+type IntegrityError struct {
+	Name     string
+	Checksum string
+	Want     string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("Content of FILE (%s) is corrupted: SHA-256 is %s, stored %s.",
+		e.Name, e.Checksum, e.Want)
+}
+
+// Is reports that IntegrityError is ErrIntegrity
+func (e *IntegrityError) Is(target error) bool {
+	return target == ErrIntegrity
+}
+
+// checksumEntry is the SHA-256 of the file computed by Put. Size and
+// ModTime are taken from the stored file (the manifest of the fragmented
+// one) when the entry is written: if the file was changed later in another
+// way, e.g. through the mountpoint, the entry is stale and is not used.
+type checksumEntry struct {
+	Name     string `json:"name"`
+	Checksum string `json:"sha256"`
+	Size     int64  `json:"size"`
+	// ModTime is in Unix seconds, archives don't keep more
+	ModTime int64 `json:"mtime"`
+	// ModTimeNano is in Unix nanoseconds, it tells apart the changes made
+	// within the same second
+	ModTimeNano int64 `json:"mtime_ns,omitempty"`
+}
+
+// matches checks if the entry describes the stored file. The nanoseconds
+// are compared only if both sides have them: the file unpacked from an
+// archive has whole seconds, the entries of older versions have none.
+func (e *checksumEntry) matches(fi os.FileInfo) bool {
+	if e.Size != fi.Size() || e.ModTime != fi.ModTime().Unix() {
+		return false
+	}
+	return e.ModTimeNano == 0 || fi.ModTime().Nanosecond() == 0 ||
+		e.ModTimeNano == fi.ModTime().UnixNano()
+}
+
+// checksumIndex keeps an entry per file of the Bucket as
+// .wizefs/checksums/SHA256(name), like the manifests of the fragment store.
+// The index is a part of the Bucket, so LZFS archives carry it.
+type checksumIndex struct {
+	fs bucketFS
+}
+
+func newChecksumIndex(fs bucketFS) *checksumIndex {
+	return &checksumIndex{fs: fs}
+}
+
+func (x *checksumIndex) entryPath(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return path.Join(checksumsDir, hex.EncodeToString(sum[:]))
+}
+
+// storedInfo returns the stat of the manifest of the fragmented file or of
+// the file itself
+func (x *checksumIndex) storedInfo(name string) (os.FileInfo, error) {
+	if fi, err := x.fs.Stat(newFragmentStore(x.fs, 0).manifestPath(name)); err == nil {
+		return fi, nil
+	}
+	return x.fs.Stat(name)
+}
+
+// Get returns the checksum of the file, errNoChecksum if it's not indexed
+// or the entry is stale
+func (x *checksumIndex) Get(name string) (checksum string, err error) {
+	file, err := x.fs.Open(x.entryPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errNoChecksum
+		}
+		return "", err
+	}
+	js, err := ioutil.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	entry := &checksumEntry{}
+	if err = json.Unmarshal(js, entry); err != nil {
+		return "", fmt.Errorf("failed to unmarshal checksum of %s: %w", name, err)
+	}
+	fi, err := x.storedInfo(name)
+	if os.IsNotExist(err) {
+		return "", errNoChecksum
+	}
+	if err != nil {
+		return "", err
+	}
+	if entry.Name != name || !entry.matches(fi) {
+		return "", errNoChecksum
+	}
+	return entry.Checksum, nil
+}
+
+// Set indexes the checksum of the file that has just been stored
+func (x *checksumIndex) Set(name, checksum string) error {
+	fi, err := x.storedInfo(name)
+	if err != nil {
+		return err
+	}
+	if err = x.fs.MkdirAll(checksumsDir); err != nil {
+		return err
+	}
+
+	js, err := json.MarshalIndent(&checksumEntry{
+		Name:        name,
+		Checksum:    checksum,
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime().Unix(),
+		ModTimeNano: fi.ModTime().UnixNano(),
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	target := x.entryPath(name)
+	tmp := target + ".tmp"
+	x.fs.Remove(tmp)
+	if err = writeFile(x.fs, tmp, append(js, '\n')); err != nil {
+		x.fs.Remove(tmp)
+		return err
+	}
+	return x.fs.Rename(tmp, target)
+}
+
+// Remove removes the entry of the file, it's not an error if there is none
+func (x *checksumIndex) Remove(name string) error {
+	err := x.fs.Remove(x.entryPath(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// verifyReader computes the SHA-256 of the content read and fails with
+// IntegrityError at the end of the file if it's not the indexed one
+type verifyReader struct {
+	io.ReadCloser
+	name     string
+	checksum string
+	hash     hash.Hash
+}
+
+func newVerifyReader(reader io.ReadCloser, name, checksum string) *verifyReader {
+	return &verifyReader{
+		ReadCloser: reader,
+		name:       name,
+		checksum:   checksum,
+		hash:       sha256.New(),
+	}
+}
+
+func (r *verifyReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if sum := hex.EncodeToString(r.hash.Sum(nil)); sum != r.checksum {
+			return n, &IntegrityError{Name: r.name, Checksum: sum, Want: r.checksum}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+// corrupt flips a byte of the file keeping its size and modification time,
+// like bit rot does
+func corrupt(t *testing.T, path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err = ioutil.WriteFile(path, data, fi.Mode()); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, fi.ModTime(), fi.ModTime())
+}
+
+func TestBucketChecksumIndex(t *testing.T) {
+	bucket, originPath := newTestBucket(t)
+	setAutoMount(t, bucket, AutoMountDirect)
+
+	for _, fragmentSize := range []int{0, MinFragmentSize} {
+		bucket.Config.FragmentSize = fragmentSize
+		if _, err := bucket.PutFile("docs/a.txt", []byte("content")); err != nil {
+			t.Fatal(err)
+		}
+		root := dirFS(originPath)
+		if checksum, err := newChecksumIndex(root).Get("docs/a.txt"); err != nil || checksum != etag("content") {
+			t.Errorf("Fragment size %d: indexed checksum %q (%v), want %q",
+				fragmentSize, checksum, err, etag("content"))
+		}
+		if info, _, err := bucket.Stat("docs/a.txt"); err != nil || info.Checksum != etag("content") {
+			t.Errorf("Fragment size %d: Stat checksum %q (%v)", fragmentSize, info.Checksum, err)
+		}
+		if content, _, err := bucket.GetFile("docs/a.txt", "", true); err != nil || string(content) != "content" {
+			t.Errorf("Fragment size %d: GetFile %q (%v)", fragmentSize, content, err)
+		}
+
+		if _, err := bucket.RemoveFile("docs/a.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newChecksumIndex(root).Get("docs/a.txt"); err != errNoChecksum {
+			t.Errorf("Fragment size %d: checksum of removed file: %v", fragmentSize, err)
+		}
+	}
+
+	// silent corruption of the stored file
+	bucket.Config.FragmentSize = 0
+	if _, err := bucket.PutFile("b.txt", []byte("original content")); err != nil {
+		t.Fatal(err)
+	}
+	corrupt(t, filepath.Join(originPath, "b.txt"))
+
+	if _, exitCode, err := bucket.GetFile("b.txt", "", true); exitCode != globals.ExitIntegrity {
+		t.Errorf("GetFile of corrupted file: exit code %d (%v), want %d",
+			exitCode, err, globals.ExitIntegrity)
+	}
+	dest := filepath.Join(originPath, "..", "b.txt")
+	if _, exitCode, _ := bucket.GetFile("b.txt", dest, false); exitCode != globals.ExitIntegrity {
+		t.Errorf("GetFile of corrupted file into %s: exit code %d", dest, exitCode)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("Corrupted content is left in %s: %v", dest, err)
+	}
+	reader, _, err := bucket.GetFileStream("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(reader)
+	reader.Close()
+	if _, ok := err.(*IntegrityError); !ok {
+		t.Errorf("GetFileStream of corrupted file: %v", err)
+	}
+
+	// the file changed through the mountpoint is not verified
+	path := filepath.Join(originPath, "b.txt")
+	if err := ioutil.WriteFile(path, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if content, _, err := bucket.GetFile("b.txt", "", true); err != nil || string(content) != "changed" {
+		t.Errorf("GetFile of changed file: %q (%v)", content, err)
+	}
+	if info, _, _ := bucket.Stat("b.txt"); info.Checksum != etag("changed") {
+		t.Errorf("Stat checksum of changed file %q, want %q", info.Checksum, etag("changed"))
+	}
+
+	// the same size within the same second
+	if _, err := bucket.PutFile("c.txt", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(originPath, "c.txt")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path, []byte("CONTENT"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := fi.ModTime().Truncate(time.Second).Add(1)
+	if mtime.Equal(fi.ModTime()) {
+		mtime = mtime.Add(1)
+	}
+	os.Chtimes(path, mtime, mtime)
+	if content, _, err := bucket.GetFile("c.txt", "", true); err != nil || string(content) != "CONTENT" {
+		t.Errorf("GetFile of file changed within a second: %q (%v)", content, err)
+	}
+}
+
+func TestBucketChecksumIndexFailedPut(t *testing.T) {
+	bucket, originPath := newTestBucket(t)
+	setAutoMount(t, bucket, AutoMountDirect)
+
+	for _, fragmentSize := range []int{0, MinFragmentSize} {
+		bucket.Config.FragmentSize = fragmentSize
+		if _, err := bucket.PutFile("a.txt", []byte("content")); err != nil {
+			t.Fatal(err)
+		}
+
+		reader := io.MultiReader(strings.NewReader("new content"),
+			iotest.ErrReader(errors.New("connection reset")))
+		if _, err := bucket.PutFileStreamWithOptions("a.txt", reader,
+			PutOptions{Overwrite: true}); err == nil {
+			t.Fatalf("Fragment size %d: put of broken stream succeeded", fragmentSize)
+		}
+		checksum, err := newChecksumIndex(dirFS(originPath)).Get("a.txt")
+		if err != nil || checksum != etag("content") {
+			t.Errorf("Fragment size %d: checksum of old file %q (%v) after failed put",
+				fragmentSize, checksum, err)
+		}
+
+		if _, err := bucket.RemoveFile("a.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of the Storage errors. The errors returned by the Storage keep
+// their messages and exit codes, errors.Is reports their kind:
+//
+//	if errors.Is(err, core.ErrNotFound) {
+//		...
+//	}
+var (
+	// ErrNotFound - the Bucket or the file does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists - the Bucket or the file exists already
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrNotMounted - the Bucket should be mounted
+	ErrNotMounted = errors.New("not mounted")
+	// ErrMounted - the Bucket should not be mounted
+	ErrMounted = errors.New("mounted")
+	// ErrBusy - the lock is held by another process for longer than the lock
+	// timeout
+	ErrBusy = errors.New("busy")
+	// ErrIntegrity - the content does not match its checksum
+	ErrIntegrity = errors.New("integrity check failed")
+	// ErrInvalid - the argument or the options are invalid
+	ErrInvalid = errors.New("invalid argument")
+	// ErrPassword - the password of the encrypted Bucket is missing or wrong
+	ErrPassword = errors.New("wrong password")
+	// ErrPrecondition - the condition of the conditional put failed
+	ErrPrecondition = errors.New("precondition failed")
+)
+
+// Error is the Storage error of the kind, the message is the message of Err
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports if the error is of the kind, see errors.Is
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// newError formats the error of the kind like fmt.Errorf, %w keeps the kind
+// of the wrapped error
+func newError(kind error, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, a...)}
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestErrorKinds(t *testing.T) {
+	bucket, originPath := newTestBucket(t)
+	setAutoMount(t, bucket, AutoMountDirect)
+	s := bucket.storage
+	s.LockTimeout = 100 * time.Millisecond
+
+	if _, err := bucket.PutFile("a.txt", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Delete("MISSING")
+	checkKind(t, "Delete of unknown Bucket", err, ErrNotFound)
+	_, err = s.SetACL("MISSING", &BucketACL{Owner: "alice"})
+	checkKind(t, "SetACL of unknown Bucket", err, ErrNotFound)
+	_, err = s.SetACL("ORIGIN", &BucketACL{})
+	checkKind(t, "SetACL without owner", err, ErrInvalid)
+	_, err = s.CreateWithOptions("ORIGIN", BucketOptions{})
+	checkKind(t, "Create of existing Bucket", err, ErrAlreadyExists)
+	for _, origin := range []string{"../escape", "a/b", "..", ".hidden", MetadataStoreFilename, quarantineDir, lzfsTempDir} {
+		_, err = s.CreateWithOptions(origin, BucketOptions{})
+		checkKind(t, "Create of Bucket "+origin, err, ErrInvalid)
+	}
+	if _, err = os.Stat(filepath.Join(s.DirPath, "..", "escape")); !os.IsNotExist(err) {
+		t.Errorf("Create of Bucket outside of the Storage: %v", err)
+	}
+	_, err = s.SetACL("../escape", &BucketACL{Owner: "alice"})
+	checkKind(t, "SetACL of Bucket ../escape", err, ErrInvalid)
+	_, _, err = s.ACL("../escape")
+	checkKind(t, "ACL of Bucket ../escape", err, ErrInvalid)
+	_, _, err = s.Session("../escape")
+	checkKind(t, "Session of Bucket ../escape", err, ErrInvalid)
+	_, _, err = s.Verify("../escape")
+	checkKind(t, "Verify of Bucket ../escape", err, ErrInvalid)
+	_, err = s.Unmount("ORIGIN")
+	checkKind(t, "Unmount of unmounted Bucket", err, ErrNotMounted)
+
+	_, _, err = bucket.GetFile("missing.txt", "", true)
+	checkKind(t, "GetFile of unknown file", err, ErrNotFound)
+	_, err = bucket.PutFile("a.txt", []byte("other"))
+	checkKind(t, "PutFile of existing file", err, ErrAlreadyExists)
+	_, err = bucket.PutFileWithOptions("a.txt", []byte("other"), PutOptions{IfMatch: etag("other")})
+	checkKind(t, "PutFile with stale ETag", err, ErrPrecondition)
+	_, err = bucket.PutFile("../a.txt", []byte("other"))
+	checkKind(t, "PutFile outside of the Bucket", err, ErrInvalid)
+
+	corrupt(t, filepath.Join(originPath, "a.txt"))
+	_, _, err = bucket.GetFile("a.txt", "", true)
+	checkKind(t, "GetFile of corrupted file", err, ErrIntegrity)
+
+	unlock, _, err := s.lockBucket("ORIGIN", LockShared, LockExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = bucket.RemoveFile("a.txt")
+	checkKind(t, "RemoveFile of locked Bucket", err, ErrBusy)
+	unlock()
+}
+
+func checkKind(t *testing.T, op string, err, kind error) {
+	if !errors.Is(err, kind) {
+		t.Errorf("%s: %v, want %v error", op, err, kind)
+	}
+}
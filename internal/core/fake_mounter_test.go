@@ -0,0 +1,318 @@
+package core
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+)
+
+// memMounter is the mounter of the tests: the filesystem is served in
+// memory, the file operations call it directly like the kernel does, so the
+// tests need no /dev/fuse. err fails the mounts, onMount is called by
+// the successful ones. Like the kernel, the server can't be unmounted while
+// its files are open.
+type memMounter struct {
+	err     error
+	onMount func(mountpointPath string)
+	mutex   sync.Mutex
+	calls   int
+}
+
+var _ mounter = &memMounter{} // Verify that interface is implemented.
+
+func (m *memMounter) Mount(root mountRoot, mountpointPath string) (mountedServer, error) {
+	m.mutex.Lock()
+	m.calls++
+	m.mutex.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.onMount != nil {
+		m.onMount(mountpointPath)
+	}
+	return &memServer{fs: pathFS{fs: root.FS, open: new(int32)}, unmounted: make(chan struct{})}, nil
+}
+
+// mounts returns the number of Mount calls
+func (m *memMounter) mounts() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.calls
+}
+
+type memServer struct {
+	fs        pathFS
+	unmounted chan struct{}
+	once      sync.Once
+}
+
+func (srv *memServer) Serve() {
+	<-srv.unmounted
+}
+
+func (srv *memServer) WaitMount() error {
+	return nil
+}
+
+func (srv *memServer) Unmount() error {
+	if atomic.LoadInt32(srv.fs.open) > 0 {
+		return syscall.EBUSY
+	}
+	srv.once.Do(func() { close(srv.unmounted) })
+	return nil
+}
+
+func (srv *memServer) FS() bucketFS {
+	return srv.fs
+}
+
+// fuseContext is the caller of the file operations, the current process
+var fuseContext = &fuse.Context{
+	Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())},
+}
+
+// pathFS implements bucketFS on top of pathfs.FileSystem. Symlinks are not
+// followed, so no path leads outside of the Bucket.
+type pathFS struct {
+	fs pathfs.FileSystem
+	// open counts the open files
+	open *int32
+}
+
+var _ bucketFS = pathFS{} // Verify that interface is implemented.
+
+// pathError converts the FUSE status to the error of os functions
+func pathError(op, name string, code fuse.Status) error {
+	if code.Ok() {
+		return nil
+	}
+	return &os.PathError{Op: op, Path: name, Err: syscall.Errno(code)}
+}
+
+// name converts the bucketFS name to the pathfs one, the root is ""
+func (p pathFS) name(name string) string {
+	name = path.Clean(name)
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+func (p pathFS) Open(name string) (io.ReadCloser, error) {
+	file, code := p.fs.Open(p.name(name), uint32(os.O_RDONLY), fuseContext)
+	if err := pathError("open", name, code); err != nil {
+		return nil, err
+	}
+	return p.newFile(file), nil
+}
+
+// newFile counts the file as open until it's closed
+func (p pathFS) newFile(file nodefs.File) *pathFile {
+	if p.open != nil {
+		atomic.AddInt32(p.open, 1)
+	}
+	return &pathFile{file: file, open: p.open}
+}
+
+func (p pathFS) Create(name string) (bucketFile, error) {
+	// the kernel looks the file up before it creates it with O_EXCL
+	if _, code := p.fs.GetAttr(p.name(name), fuseContext); code.Ok() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EEXIST}
+	}
+	file, code := p.fs.Create(p.name(name), uint32(os.O_WRONLY|os.O_CREATE|os.O_EXCL), 0644, fuseContext)
+	if err := pathError("open", name, code); err != nil {
+		return nil, err
+	}
+	return p.newFile(file), nil
+}
+
+func (p pathFS) Stat(name string) (os.FileInfo, error) {
+	attr, code := p.fs.GetAttr(p.name(name), fuseContext)
+	if err := pathError("stat", name, code); err != nil {
+		return nil, err
+	}
+	return attrInfo{name: path.Base(name), attr: attr}, nil
+}
+
+func (p pathFS) Remove(name string) error {
+	attr, code := p.fs.GetAttr(p.name(name), fuseContext)
+	if err := pathError("remove", name, code); err != nil {
+		return err
+	}
+	if attr.IsDir() {
+		code = p.fs.Rmdir(p.name(name), fuseContext)
+	} else {
+		code = p.fs.Unlink(p.name(name), fuseContext)
+	}
+	return pathError("remove", name, code)
+}
+
+func (p pathFS) RemoveAll(name string) error {
+	attr, code := p.fs.GetAttr(p.name(name), fuseContext)
+	if code == fuse.ENOENT {
+		return nil
+	}
+	if err := pathError("remove", name, code); err != nil {
+		return err
+	}
+	if attr.IsDir() {
+		entries, code := p.fs.OpenDir(p.name(name), fuseContext)
+		if err := pathError("open", name, code); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := p.RemoveAll(path.Join(name, e.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	return p.Remove(name)
+}
+
+func (p pathFS) Rename(oldname, newname string) error {
+	code := p.fs.Rename(p.name(oldname), p.name(newname), fuseContext)
+	return pathError("rename", oldname, code)
+}
+
+func (p pathFS) MkdirAll(name string) error {
+	if p.name(name) == "" {
+		return nil
+	}
+	if attr, code := p.fs.GetAttr(p.name(name), fuseContext); code.Ok() {
+		if attr.IsDir() {
+			return nil
+		}
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	if err := p.MkdirAll(path.Dir(p.name(name))); err != nil {
+		return err
+	}
+	return pathError("mkdir", name, p.fs.Mkdir(p.name(name), 0755, fuseContext))
+}
+
+func (p pathFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, code := p.fs.OpenDir(p.name(name), fuseContext)
+	if err := pathError("open", name, code); err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, e := range entries {
+		info, err := p.Stat(path.Join(name, e.Name))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (p pathFS) Check(name string) error {
+	return nil
+}
+
+// pathFile reads or writes the nodefs.File sequentially
+type pathFile struct {
+	file nodefs.File
+	off  int64
+	open *int32
+}
+
+func (f *pathFile) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	res, code := f.file.Read(p, f.off)
+	if !code.Ok() {
+		return 0, syscall.Errno(code)
+	}
+	data, code := res.Bytes(p)
+	n := copy(p, data)
+	res.Done()
+	if !code.Ok() {
+		return 0, syscall.Errno(code)
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *pathFile) Write(p []byte) (int, error) {
+	written, code := f.file.Write(p, f.off)
+	f.off += int64(written)
+	if !code.Ok() {
+		return int(written), syscall.Errno(code)
+	}
+	if int(written) < len(p) {
+		return int(written), io.ErrShortWrite
+	}
+	return int(written), nil
+}
+
+// Sync succeeds if the filesystem doesn't implement fsync, like the kernel
+func (f *pathFile) Sync() error {
+	if code := f.file.Fsync(0); !code.Ok() && code != fuse.ENOSYS {
+		return syscall.Errno(code)
+	}
+	return nil
+}
+
+func (f *pathFile) Close() error {
+	code := f.file.Flush()
+	f.file.Release()
+	if f.open != nil {
+		atomic.AddInt32(f.open, -1)
+	}
+	if !code.Ok() && code != fuse.ENOSYS {
+		return syscall.Errno(code)
+	}
+	return nil
+}
+
+// attrInfo is os.FileInfo of fuse.Attr
+type attrInfo struct {
+	name string
+	attr *fuse.Attr
+}
+
+func (a attrInfo) Name() string {
+	return a.name
+}
+
+func (a attrInfo) Size() int64 {
+	return int64(a.attr.Size)
+}
+
+func (a attrInfo) Mode() os.FileMode {
+	mode := os.FileMode(a.attr.Mode & 0777)
+	switch {
+	case a.attr.IsDir():
+		mode |= os.ModeDir
+	case a.attr.IsSymlink():
+		mode |= os.ModeSymlink
+	}
+	return mode
+}
+
+func (a attrInfo) ModTime() time.Time {
+	return a.attr.ModTime()
+}
+
+func (a attrInfo) IsDir() bool {
+	return a.attr.IsDir()
+}
+
+func (a attrInfo) Sys() interface{} {
+	return nil
+}
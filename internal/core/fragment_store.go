@@ -0,0 +1,499 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	mathrand "math/rand"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+const (
+	// DefaultFragmentSize is the fragment size suggested by the LZFS design
+	DefaultFragmentSize = 8192
+	// MinFragmentSize and MaxFragmentSize are the limits of FragmentSize
+	MinFragmentSize = 512
+	MaxFragmentSize = 64 << 20
+	// DefaultMicroFragmentSize is the micro-fragment size suggested by the
+	// LZFS design; micro-fragmentation is turned off by default
+	DefaultMicroFragmentSize = 2048
+	// MinMicroFragmentSize is the lower limit of MicroFragmentSize
+	MinMicroFragmentSize = 128
+
+	// fragmentStoreDir is the root of the fragment store inside a Bucket
+	fragmentStoreDir = ".wizefs"
+	fragmentsDir     = fragmentStoreDir + "/fragments"
+	manifestsDir     = fragmentStoreDir + "/manifests"
+	microDir         = fragmentStoreDir + "/micro"
+
+	fragmentMagic      = "WZFG"
+	fragmentVersion    = 1
+	fragmentHeaderSize = 4 + 2 + 16 + 4 + 4 + 4
+)
+
+var (
+	errNoManifest = errors.New("manifest does not exist")
+)
+
+// fragmentHeader precedes the data of every fragment on disk
+type fragmentHeader struct {
+	FileID [16]byte
+	Index  uint32
+	Length uint32
+	// Checksum is CRC-32 (IEEE) of the fragment data
+	Checksum uint32
+}
+
+func (h *fragmentHeader) marshal() []byte {
+	buf := make([]byte, fragmentHeaderSize)
+	copy(buf[0:4], fragmentMagic)
+	binary.BigEndian.PutUint16(buf[4:6], fragmentVersion)
+	copy(buf[6:22], h.FileID[:])
+	binary.BigEndian.PutUint32(buf[22:26], h.Index)
+	binary.BigEndian.PutUint32(buf[26:30], h.Length)
+	binary.BigEndian.PutUint32(buf[30:34], h.Checksum)
+	return buf
+}
+
+func (h *fragmentHeader) unmarshal(buf []byte) error {
+	if len(buf) < fragmentHeaderSize || string(buf[0:4]) != fragmentMagic {
+		return fmt.Errorf("bad fragment header")
+	}
+	if version := binary.BigEndian.Uint16(buf[4:6]); version != fragmentVersion {
+		return fmt.Errorf("unsupported fragment version %d", version)
+	}
+	copy(h.FileID[:], buf[6:22])
+	h.Index = binary.BigEndian.Uint32(buf[22:26])
+	h.Length = binary.BigEndian.Uint32(buf[26:30])
+	h.Checksum = binary.BigEndian.Uint32(buf[30:34])
+	return nil
+}
+
+// fragmentInfo describes one fragment in the manifest
+type fragmentInfo struct {
+	Index    uint32 `json:"index"`
+	Length   uint32 `json:"length"`
+	Checksum uint32 `json:"checksum"`
+	// Micro is the ordered list of micro-fragments of the fragment,
+	// it's empty if micro-fragmentation is turned off
+	Micro []string `json:"micro,omitempty"`
+}
+
+// fragmentManifest describes how a file is split into fragments
+type fragmentManifest struct {
+	ID                string         `json:"id"`
+	Name              string         `json:"name"`
+	Size              int64          `json:"size"`
+	FragmentSize      int            `json:"fragmentsize"`
+	MicroFragmentSize int            `json:"microfragmentsize,omitempty"`
+	Fragments         []fragmentInfo `json:"fragments"`
+
+	// modTime is the time the manifest was written, it's set by List
+	modTime time.Time
+}
+
+// fragmentStore keeps files as sets of fixed-size fragments plus a manifest
+// per file. Fragments of the file with id ID are stored as
+// .wizefs/fragments/ID/INDEX, manifests as .wizefs/manifests/SHA256(name).
+//
+// If micro-fragmentation is turned on, the data of every fragment is split
+// again into micro-fragments. They are stored without headers under random
+// names in the flat .wizefs/micro directory and are written in random order,
+// so only the manifest knows how to put them together.
+type fragmentStore struct {
+	fs                bucketFS
+	fragmentSize      int
+	microFragmentSize int
+}
+
+func newFragmentStore(fs bucketFS, fragmentSize int) *fragmentStore {
+	return &fragmentStore{
+		fs:           fs,
+		fragmentSize: fragmentSize,
+	}
+}
+
+// withMicroFragments turns micro-fragmentation on
+func (s *fragmentStore) withMicroFragments(microFragmentSize int) *fragmentStore {
+	s.microFragmentSize = microFragmentSize
+	return s
+}
+
+func (s *fragmentStore) manifestPath(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return path.Join(manifestsDir, hex.EncodeToString(sum[:]))
+}
+
+func (s *fragmentStore) fragmentPath(id string, index uint32) string {
+	return path.Join(fragmentsDir, id, fmt.Sprintf("%08x", index))
+}
+
+// Exists returns true if the file is stored in the fragment store
+func (s *fragmentStore) Exists(name string) bool {
+	_, err := s.fs.Stat(s.manifestPath(name))
+	return err == nil
+}
+
+// Put splits the content of reader into fragments and writes the manifest
+// when all fragments are stored.
+func (s *fragmentStore) Put(name string, reader io.Reader) (*fragmentManifest, error) {
+	manifest, err := s.stage(name, reader)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.commit(manifest); err != nil {
+		s.discard(manifest)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// stage stores the fragments and writes the manifest beside the current one,
+// the file is not replaced until the manifest is committed
+func (s *fragmentStore) stage(name string, reader io.Reader) (manifest *fragmentManifest, err error) {
+	if s.fragmentSize < MinFragmentSize || s.fragmentSize > MaxFragmentSize {
+		return nil, fmt.Errorf("invalid fragment size %d", s.fragmentSize)
+	}
+	if s.microFragmentSize != 0 && (s.microFragmentSize < MinMicroFragmentSize ||
+		s.fragmentSize%s.microFragmentSize != 0) {
+		return nil, fmt.Errorf("invalid micro-fragment size %d", s.microFragmentSize)
+	}
+
+	var fileID [16]byte
+	if _, err = rand.Read(fileID[:]); err != nil {
+		return nil, err
+	}
+	manifest = &fragmentManifest{
+		ID:                hex.EncodeToString(fileID[:]),
+		Name:              name,
+		FragmentSize:      s.fragmentSize,
+		MicroFragmentSize: s.microFragmentSize,
+	}
+
+	if s.microFragmentSize != 0 {
+		err = s.fs.MkdirAll(microDir)
+	} else {
+		err = s.fs.MkdirAll(path.Join(fragmentsDir, manifest.ID))
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored := manifest
+	defer func() {
+		if err != nil {
+			s.removeFragments(stored)
+		}
+	}()
+
+	buf := make([]byte, s.fragmentSize)
+	for index := uint32(0); ; index++ {
+		n, rerr := io.ReadFull(reader, buf)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return nil, rerr
+		}
+		if n == 0 {
+			break
+		}
+
+		header := fragmentHeader{
+			FileID:   fileID,
+			Index:    index,
+			Length:   uint32(n),
+			Checksum: crc32.ChecksumIEEE(buf[:n]),
+		}
+		info := fragmentInfo{
+			Index:    header.Index,
+			Length:   header.Length,
+			Checksum: header.Checksum,
+		}
+		// the manifest is updated before writing, so a failed fragment is
+		// removed too
+		manifest.Fragments = append(manifest.Fragments, info)
+		last := &manifest.Fragments[len(manifest.Fragments)-1]
+
+		if s.microFragmentSize != 0 {
+			err = s.writeMicroFragments(last, buf[:n])
+		} else {
+			err = s.writeFragment(manifest.ID, &header, buf[:n])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		manifest.Size += int64(n)
+
+		if rerr != nil {
+			break
+		}
+	}
+
+	if err = s.writeManifest(manifest); err != nil {
+		return nil, err
+	}
+
+	tlog.Debug.Printf("Stored %s as %d fragments.", name, len(manifest.Fragments))
+
+	return manifest, nil
+}
+
+// commit replaces the manifest of the file by the staged one
+func (s *fragmentStore) commit(manifest *fragmentManifest) error {
+	return s.fs.Rename(s.stagedPath(manifest), s.manifestPath(manifest.Name))
+}
+
+// discard removes the staged manifest and its fragments
+func (s *fragmentStore) discard(manifest *fragmentManifest) {
+	s.fs.Remove(s.stagedPath(manifest))
+	s.removeFragments(manifest)
+}
+
+// Open returns the reader that rebuilds the file from its fragments
+func (s *fragmentStore) Open(name string) (io.ReadCloser, error) {
+	manifest, err := s.readManifest(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fragmentReader{
+		store:    s,
+		manifest: manifest,
+	}, nil
+}
+
+// Remove removes the manifest and all fragments of the file
+func (s *fragmentStore) Remove(name string) error {
+	manifest, err := s.readManifest(name)
+	if err != nil {
+		return err
+	}
+
+	err = s.fs.Remove(s.manifestPath(name))
+	if err != nil {
+		return err
+	}
+
+	return s.removeFragments(manifest)
+}
+
+func (s *fragmentStore) removeFragments(manifest *fragmentManifest) error {
+	for _, info := range manifest.Fragments {
+		for _, id := range info.Micro {
+			s.fs.Remove(path.Join(microDir, id))
+		}
+	}
+
+	err := s.fs.RemoveAll(path.Join(fragmentsDir, manifest.ID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// writeMicroFragments splits the data of the fragment into micro-fragments
+// and writes them in random order
+func (s *fragmentStore) writeMicroFragments(info *fragmentInfo, data []byte) error {
+	count := (len(data) + s.microFragmentSize - 1) / s.microFragmentSize
+	info.Micro = make([]string, count)
+	for i := range info.Micro {
+		var id [16]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return err
+		}
+		info.Micro[i] = hex.EncodeToString(id[:])
+	}
+
+	for _, i := range mathrand.Perm(count) {
+		start := i * s.microFragmentSize
+		end := start + s.microFragmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := s.writeObject(path.Join(microDir, info.Micro[i]), data[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fragmentStore) readMicroFragments(manifest *fragmentManifest, info fragmentInfo) ([]byte, error) {
+	data := make([]byte, 0, info.Length)
+	for _, id := range info.Micro {
+		file, err := s.fs.Open(path.Join(microDir, id))
+		if err != nil {
+			return nil, err
+		}
+		micro, err := ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, micro...)
+	}
+
+	if len(data) != int(info.Length) || crc32.ChecksumIEEE(data) != info.Checksum {
+		return nil, fmt.Errorf("fragment %d of %s is corrupted", info.Index, manifest.Name)
+	}
+	return data, nil
+}
+
+func (s *fragmentStore) writeFragment(id string, header *fragmentHeader, data []byte) error {
+	return s.writeObject(s.fragmentPath(id, header.Index), append(header.marshal(), data...))
+}
+
+// writeObject writes the new file of the fragment store
+func (s *fragmentStore) writeObject(name string, data []byte) error {
+	return writeFile(s.fs, name, data)
+}
+
+func (s *fragmentStore) readFragment(manifest *fragmentManifest, info fragmentInfo) ([]byte, error) {
+	if len(info.Micro) > 0 {
+		return s.readMicroFragments(manifest, info)
+	}
+
+	file, err := s.fs.Open(s.fragmentPath(manifest.ID, info.Index))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buf, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var header fragmentHeader
+	if err = header.unmarshal(buf); err != nil {
+		return nil, fmt.Errorf("fragment %d of %s: %w", info.Index, manifest.Name, err)
+	}
+	data := buf[fragmentHeaderSize:]
+
+	if hex.EncodeToString(header.FileID[:]) != manifest.ID ||
+		header.Index != info.Index ||
+		header.Length != info.Length ||
+		int(header.Length) != len(data) ||
+		header.Checksum != info.Checksum ||
+		crc32.ChecksumIEEE(data) != header.Checksum {
+		return nil, fmt.Errorf("fragment %d of %s is corrupted", info.Index, manifest.Name)
+	}
+
+	return data, nil
+}
+
+func (s *fragmentStore) writeManifest(manifest *fragmentManifest) error {
+	if err := s.fs.MkdirAll(manifestsDir); err != nil {
+		return err
+	}
+
+	js, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.stagedPath(manifest)
+	err = s.writeObject(tmp, append(js, '\n'))
+	if err != nil {
+		s.fs.Remove(tmp)
+	}
+	return err
+}
+
+// stagedPath returns the path of the manifest that is not committed yet, the
+// ID keeps concurrent puts of the same file apart
+func (s *fragmentStore) stagedPath(manifest *fragmentManifest) string {
+	return s.manifestPath(manifest.Name) + "." + manifest.ID + ".tmp"
+}
+
+func (s *fragmentStore) readManifest(name string) (*fragmentManifest, error) {
+	return s.readManifestFile(s.manifestPath(name), name)
+}
+
+// List returns the manifests of all files of the store, the manifests that
+// can't be read are skipped
+func (s *fragmentStore) List() ([]*fragmentManifest, error) {
+	infos, err := s.fs.ReadDir(manifestsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var manifests []*fragmentManifest
+	for _, info := range infos {
+		// skip manifests that are being written
+		if info.IsDir() || strings.HasSuffix(info.Name(), ".tmp") {
+			continue
+		}
+		manifest, err := s.readManifestFile(path.Join(manifestsDir, info.Name()), info.Name())
+		if err != nil {
+			tlog.Warn.Printf("Reading manifest %s failed: %v", info.Name(), err)
+			continue
+		}
+		manifest.modTime = info.ModTime()
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+func (s *fragmentStore) readManifestFile(manifestPath, name string) (*fragmentManifest, error) {
+	file, err := s.fs.Open(manifestPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errNoManifest
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	js, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &fragmentManifest{}
+	if err = json.Unmarshal(js, manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest of %s: %w", name, err)
+	}
+	return manifest, nil
+}
+
+// fragmentReader reads fragments of the file one by one
+type fragmentReader struct {
+	store    *fragmentStore
+	manifest *fragmentManifest
+	next     int
+	data     *bytes.Reader
+}
+
+func (r *fragmentReader) Read(p []byte) (n int, err error) {
+	for r.data == nil || r.data.Len() == 0 {
+		if r.next >= len(r.manifest.Fragments) {
+			return 0, io.EOF
+		}
+		data, err := r.store.readFragment(r.manifest, r.manifest.Fragments[r.next])
+		if err != nil {
+			return 0, err
+		}
+		r.data = bytes.NewReader(data)
+		r.next++
+	}
+
+	return r.data.Read(p)
+}
+
+func (r *fragmentReader) Close() error {
+	return nil
+}
@@ -0,0 +1,201 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestFragmentStore(t *testing.T, fragmentSize int) (*fragmentStore, string) {
+	dir, err := ioutil.TempDir("", "wizefs-fragments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newFragmentStore(dirFS(dir), fragmentSize), dir
+}
+
+func TestFragmentStorePutOpen(t *testing.T) {
+	store, dir := newTestFragmentStore(t, MinFragmentSize)
+	defer os.RemoveAll(dir)
+
+	for _, size := range []int{0, 1, MinFragmentSize - 1, MinFragmentSize, 5*MinFragmentSize + 7} {
+		name := "file" + string(rune('a'+size%26))
+		content := make([]byte, size)
+		rand.Read(content)
+
+		manifest, err := store.Put(name, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("Put %d bytes: %v", size, err)
+		}
+		if manifest.Size != int64(size) {
+			t.Errorf("Expected size %d, got %d", size, manifest.Size)
+		}
+		if want := (size + MinFragmentSize - 1) / MinFragmentSize; len(manifest.Fragments) != want {
+			t.Errorf("Expected %d fragments, got %d", want, len(manifest.Fragments))
+		}
+
+		reader, err := store.Open(name)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		got, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("Content of %d bytes was not rebuilt", size)
+		}
+
+		if err = store.Remove(name); err != nil {
+			t.Fatalf("Remove: %v", err)
+		}
+		if store.Exists(name) {
+			t.Errorf("File %s exists after Remove", name)
+		}
+	}
+}
+
+func TestFragmentStoreCorruption(t *testing.T) {
+	store, dir := newTestFragmentStore(t, MinFragmentSize)
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("wizefs"), MinFragmentSize)
+	manifest, err := store.Put("corrupted", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flip one byte of the data of the second fragment
+	fragment := filepath.Join(dir, filepath.FromSlash(store.fragmentPath(manifest.ID, 1)))
+	data, err := ioutil.ReadFile(fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[fragmentHeaderSize] ^= 0xff
+	if err = ioutil.WriteFile(fragment, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := store.Open("corrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err = ioutil.ReadAll(reader); err == nil {
+		t.Errorf("Corrupted fragment was not detected")
+	}
+}
+
+func TestFragmentStoreNoManifest(t *testing.T) {
+	store, dir := newTestFragmentStore(t, MinFragmentSize)
+	defer os.RemoveAll(dir)
+
+	if _, err := store.Open("absent"); err != errNoManifest {
+		t.Errorf("Expected errNoManifest, got %v", err)
+	}
+}
+
+func TestFragmentStoreMicroFragments(t *testing.T) {
+	store, dir := newTestFragmentStore(t, MinFragmentSize)
+	defer os.RemoveAll(dir)
+	store.withMicroFragments(MinMicroFragmentSize)
+
+	content := make([]byte, 3*MinFragmentSize+MinMicroFragmentSize+5)
+	rand.Read(content)
+	manifest, err := store.Put("micro", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	micro := 0
+	for _, info := range manifest.Fragments {
+		micro += len(info.Micro)
+	}
+	if want := 3*(MinFragmentSize/MinMicroFragmentSize) + 2; micro != want {
+		t.Errorf("Expected %d micro-fragments, got %d", want, micro)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, filepath.FromSlash(microDir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != micro {
+		t.Errorf("Expected %d micro-fragment files, got %d", micro, len(files))
+	}
+
+	reader, err := store.Open("micro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Content was not rebuilt from micro-fragments")
+	}
+
+	if err = store.Remove("micro"); err != nil {
+		t.Fatal(err)
+	}
+	files, _ = ioutil.ReadDir(filepath.Join(dir, filepath.FromSlash(microDir)))
+	if len(files) != 0 {
+		t.Errorf("%d micro-fragment files left after Remove", len(files))
+	}
+}
+
+func TestFragmentStoreMicroFragmentSize(t *testing.T) {
+	store, dir := newTestFragmentStore(t, MinFragmentSize)
+	defer os.RemoveAll(dir)
+	store.withMicroFragments(MinFragmentSize/2 + 1)
+
+	if _, err := store.Put("file", bytes.NewReader([]byte("data"))); err == nil {
+		t.Errorf("Micro-fragment size that doesn't divide fragment size was accepted")
+	}
+}
+
+func TestFragmentStoreStage(t *testing.T) {
+	store, dir := newTestFragmentStore(t, MinFragmentSize)
+	defer os.RemoveAll(dir)
+
+	if _, err := store.Put("file", bytes.NewReader([]byte("old"))); err != nil {
+		t.Fatal(err)
+	}
+	staged, err := store.stage("file", bytes.NewReader([]byte("new")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	discarded, err := store.stage("file", bytes.NewReader([]byte("discarded")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 1 || manifests[0].Size != 3 {
+		t.Fatalf("Staged manifests are listed: %+v", manifests)
+	}
+
+	store.discard(discarded)
+	if err = store.commit(staged); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := store.Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	if string(got) != "new" {
+		t.Errorf("Expected committed content, got %q", got)
+	}
+	if _, err = os.Stat(filepath.Join(dir, filepath.FromSlash(fragmentsDir), discarded.ID)); !os.IsNotExist(err) {
+		t.Errorf("Fragments of the discarded file are left: %v", err)
+	}
+}
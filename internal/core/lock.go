@@ -0,0 +1,307 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+// LockMode is the mode of the storage and Bucket locks
+type LockMode int
+
+const (
+	// LockShared is held by the file operations of the Bucket, many
+	// processes may hold it at once
+	LockShared LockMode = iota
+	// LockExclusive is held by the operations that change the Bucket
+	// itself: create, delete, mount and unmount
+	LockExclusive
+)
+
+func (m LockMode) String() string {
+	if m == LockExclusive {
+		return "exclusive"
+	}
+	return "shared"
+}
+
+// MarshalText writes the mode as a string, e.g. in the JSON output of the
+// locks command
+func (m LockMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+const (
+	// locksDir keeps the lock files inside the Storage directory
+	locksDir = ".locks"
+	// storageLockName is the lock of the Storage, it's taken before the
+	// Bucket locks
+	storageLockName = "storage"
+
+	// DefaultLockTimeout is how long an operation waits for the locks held
+	// by other processes
+	DefaultLockTimeout = 30 * time.Second
+	// lockRetryInterval is the interval between the tries to take a lock
+	lockRetryInterval = 20 * time.Millisecond
+)
+
+// fileLock is the flock(2) lock of the file. The kernel releases it when
+// the holder exits, so the locks of crashed processes never block others.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile takes the lock of the file, it fails after timeout if the file
+// is locked by another process. The lock file removed by its holder (see
+// removeLockFile) is stale, the lock is taken again on the new file.
+func lockFile(path string, mode LockMode, timeout time.Duration) (*fileLock, error) {
+	how := syscall.LOCK_SH
+	if mode == LockExclusive {
+		how = syscall.LOCK_EX
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		err = syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			if !lockFileRemoved(file, path) {
+				return &fileLock{file: file}, nil
+			}
+			// the holder removed the file before we locked it, the lock
+			// of the unlinked file protects nothing
+			file.Close()
+			continue
+		}
+		file.Close()
+		if err != syscall.EWOULDBLOCK {
+			return nil, err
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errLockTimeout{path: path, holders: lockHolders(path)}
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// lockFileRemoved checks if the locked file is still the file of path
+func lockFileRemoved(file *os.File, path string) bool {
+	locked, err := file.Stat()
+	if err != nil {
+		return true
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	return !os.SameFile(locked, current)
+}
+
+// unlock releases the lock
+func (l *fileLock) unlock() {
+	if l == nil || l.file == nil {
+		return
+	}
+	l.file.Close()
+	l.file = nil
+}
+
+// removeLockFile removes the file of the held lock, e.g. the lock of the
+// deleted Bucket; the processes waiting for it retry on the new file
+func (l *fileLock) removeLockFile() {
+	if l == nil || l.file == nil {
+		return
+	}
+	os.Remove(l.file.Name())
+}
+
+type errLockTimeout struct {
+	path    string
+	holders []LockHolder
+}
+
+func (e errLockTimeout) Error() string {
+	var pids []string
+	for _, holder := range e.holders {
+		pids = append(pids, strconv.Itoa(holder.PID))
+	}
+	name := strings.TrimSuffix(filepath.Base(e.path), ".lock")
+	if len(pids) == 0 {
+		return fmt.Sprintf("Lock %s is held by another process", name)
+	}
+	return fmt.Sprintf("Lock %s is held by another process (pid %s)", name, strings.Join(pids, ", "))
+}
+
+// Is reports that errLockTimeout is ErrBusy
+func (e errLockTimeout) Is(target error) bool {
+	return target == ErrBusy
+}
+
+// bucketLockName is the name of the Bucket lock
+func bucketLockName(origin string) string {
+	return "bucket." + origin
+}
+
+// putLockName is the name of the lock that serializes the Puts of the
+// Bucket across processes
+func putLockName(origin string) string {
+	return bucketLockName(origin) + ".put"
+}
+
+func (s *Storage) lockPath(name string) string {
+	return filepath.Join(s.DirPath, locksDir, name+".lock")
+}
+
+func (s *Storage) lockTimeout() time.Duration {
+	if s.LockTimeout > 0 {
+		return s.LockTimeout
+	}
+	return DefaultLockTimeout
+}
+
+// lock takes the lock with the name, see storageLockName and bucketLockName
+func (s *Storage) lock(name string, mode LockMode) (lock *fileLock, exitCode int, err error) {
+	if err = os.MkdirAll(filepath.Join(s.DirPath, locksDir), 0755); err != nil {
+		return nil, globals.ExitLock,
+			fmt.Errorf("Problem with creating locks directory: %w", err)
+	}
+	lock, err = lockFile(s.lockPath(name), mode, s.lockTimeout())
+	if err != nil {
+		if _, ok := err.(errLockTimeout); ok {
+			return nil, globals.ExitLock, err
+		}
+		return nil, globals.ExitLock,
+			fmt.Errorf("Problem with locking: %w", err)
+	}
+	return lock, 0, nil
+}
+
+// tryLock takes the lock if it's free, it doesn't wait for other processes
+func (s *Storage) tryLock(name string, mode LockMode) (lock *fileLock, ok bool) {
+	if err := os.MkdirAll(filepath.Join(s.DirPath, locksDir), 0755); err != nil {
+		return nil, false
+	}
+	lock, err := lockFile(s.lockPath(name), mode, 0)
+	return lock, err == nil
+}
+
+// lockBucket takes the lock of the Storage in storageMode and then the lock
+// of the Bucket in mode, the locks are released by unlock. The Storage lock
+// is exclusive for Create and Delete, which change the set of Buckets.
+func (s *Storage) lockBucket(origin string, storageMode, mode LockMode) (unlock func(),
+	exitCode int, err error) {
+
+	storageLock, exitCode, err := s.lock(storageLockName, storageMode)
+	if err != nil {
+		return nil, exitCode, err
+	}
+	bucketLock, exitCode, err := s.lock(bucketLockName(origin), mode)
+	if err != nil {
+		storageLock.unlock()
+		return nil, exitCode, err
+	}
+	return func() {
+		bucketLock.unlock()
+		storageLock.unlock()
+	}, 0, nil
+}
+
+// LockInfo describes the lock file of the Storage or of a Bucket
+type LockInfo struct {
+	// Name is "storage", "bucket.ORIGIN" or "bucket.ORIGIN.put"
+	Name    string       `json:"name"`
+	Path    string       `json:"path"`
+	Holders []LockHolder `json:"holders"`
+}
+
+// LockHolder is the process holding the lock
+type LockHolder struct {
+	PID     int      `json:"pid"`
+	Mode    LockMode `json:"mode"`
+	Command string   `json:"command"`
+}
+
+// Locks returns the lock files of the Storage and the processes holding
+// them, sorted by name. The holders are read from /proc/locks.
+// TEST: TestStorageLocks
+func (s *Storage) Locks() (locks []LockInfo, exitCode int, err error) {
+	dir := filepath.Join(s.DirPath, locksDir)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, globals.ExitLock,
+			fmt.Errorf("Problem with reading locks directory: %w", err)
+	}
+
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), ".lock") {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		locks = append(locks, LockInfo{
+			Name:    strings.TrimSuffix(info.Name(), ".lock"),
+			Path:    path,
+			Holders: lockHolders(path),
+		})
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Name < locks[j].Name })
+	return locks, 0, nil
+}
+
+// lockHolders returns the processes holding flock locks of the file
+func lockHolders(path string) (holders []LockHolder) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	dev := uint64(stat.Dev)
+	// the device of /proc/locks is MAJOR:MINOR:INODE in hex and decimal
+	id := fmt.Sprintf("%02x:%02x:%d",
+		(dev>>8)&0xfff|(dev>>32)&^0xfff, dev&0xff|(dev>>12)&^0xff, stat.Ino)
+
+	file, err := os.Open("/proc/locks")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 1: FLOCK  ADVISORY  WRITE 1234 00:2e:5678 0 EOF
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[1] != "FLOCK" || fields[5] != id {
+			// "->" lines are the waiters, they don't hold the lock
+			continue
+		}
+		pid, err := strconv.Atoi(fields[4])
+		if err != nil {
+			continue
+		}
+		holder := LockHolder{PID: pid, Mode: LockShared}
+		if fields[3] == "WRITE" {
+			holder.Mode = LockExclusive
+		}
+		if comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+			holder.Command = strings.TrimSpace(string(comm))
+		}
+		holders = append(holders, holder)
+	}
+	return holders
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+// newTestLockStorage returns the Storage in a temporary directory with a
+// short lock timeout
+func newTestLockStorage(t *testing.T) *Storage {
+	dir := testDir(t, "lock")
+	return &Storage{DirPath: dir + "/", LockTimeout: 100 * time.Millisecond}
+}
+
+func TestStorageLockModes(t *testing.T) {
+	s := newTestLockStorage(t)
+
+	// flock locks of different open files conflict in one process too
+	first, _, err := s.lock(bucketLockName("A"), LockShared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := s.lock(bucketLockName("A"), LockShared)
+	if err != nil {
+		t.Fatalf("Second shared lock: %v", err)
+	}
+	if _, exitCode, err := s.lock(bucketLockName("A"), LockExclusive); err == nil ||
+		exitCode != globals.ExitLock {
+		t.Errorf("Exclusive lock of the shared one: %v, exit code %d", err, exitCode)
+	}
+
+	// the other Buckets are not locked
+	other, _, err := s.lock(bucketLockName("B"), LockExclusive)
+	if err != nil {
+		t.Fatalf("Exclusive lock of another Bucket: %v", err)
+	}
+	other.unlock()
+
+	first.unlock()
+	second.unlock()
+	exclusive, _, err := s.lock(bucketLockName("A"), LockExclusive)
+	if err != nil {
+		t.Fatalf("Exclusive lock of the released one: %v", err)
+	}
+	if _, _, err := s.lock(bucketLockName("A"), LockShared); err == nil {
+		t.Errorf("Shared lock of the exclusive one succeeded")
+	}
+	exclusive.unlock()
+}
+
+func TestStorageLockRemoved(t *testing.T) {
+	s := newTestLockStorage(t)
+	s.LockTimeout = 5 * time.Second
+
+	holder, _, err := s.lock(bucketLockName("A"), LockExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan *fileLock)
+	go func() {
+		lock, _, err := s.lock(bucketLockName("A"), LockExclusive)
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- lock
+	}()
+
+	// the waiter takes the lock of the new file, not of the removed one
+	time.Sleep(5 * lockRetryInterval)
+	holder.removeLockFile()
+	holder.unlock()
+	waiter := <-locked
+	if waiter == nil {
+		return
+	}
+	defer waiter.unlock()
+	if lockFileRemoved(waiter.file, s.lockPath(bucketLockName("A"))) {
+		t.Errorf("Waiter holds the lock of the removed file")
+	}
+	if _, _, err := s.lock(bucketLockName("A"), LockShared); err == nil {
+		t.Errorf("Shared lock of the new file succeeded")
+	}
+}
+
+func TestStorageLocks(t *testing.T) {
+	if _, err := os.Stat("/proc/locks"); err != nil {
+		t.Skip("No /proc/locks:", err)
+	}
+	s := newTestLockStorage(t)
+
+	locks, _, err := s.Locks()
+	if err != nil || len(locks) != 0 {
+		t.Fatalf("Locks of the new Storage: %v, %v", locks, err)
+	}
+
+	unlock, _, err := s.lockBucket("A", LockShared, LockExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locks, _, err = s.Locks()
+	unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(locks) != 2 || locks[0].Name != "bucket.A" || locks[1].Name != "storage" {
+		t.Fatalf("Locks: %+v", locks)
+	}
+	for i, mode := range []LockMode{LockExclusive, LockShared} {
+		holders := locks[i].Holders
+		if len(holders) != 1 || holders[0].PID != os.Getpid() || holders[0].Mode != mode {
+			t.Errorf("Holders of %s: %+v, want pid %d in %v mode",
+				locks[i].Name, holders, os.Getpid(), mode)
+		}
+	}
+
+	locks, _, err = s.Locks()
+	if err != nil || len(locks) != 2 || len(locks[0].Holders) != 0 {
+		t.Errorf("Released locks: %+v, %v", locks, err)
+	}
+}
+
+func TestStorageLockUnknownBucket(t *testing.T) {
+	s := newTestLockStorage(t)
+	s.Config = NewStorageConfig(s.DirPath)
+	s.mounts = newMountManager()
+
+	for _, origin := range []string{"MISSING", "../../escape"} {
+		calls := map[string]func() (int, error){
+			"Mount":        func() (int, error) { return s.Mount(origin, 0) },
+			"MountManaged": func() (int, error) { return s.MountManaged(origin) },
+			"Unmount":      func() (int, error) { return s.Unmount(origin) },
+			"Delete":       func() (int, error) { return s.Delete(origin) },
+			"SetAutoMount": func() (int, error) {
+				return s.SetAutoMount(origin, AutoMountPolicy{Mode: AutoMountDirect})
+			},
+			"SetACL": func() (int, error) {
+				return s.SetACL(origin, &BucketACL{Owner: "alice"})
+			},
+		}
+		for name, call := range calls {
+			if _, err := call(); err == nil {
+				t.Errorf("%s of %s succeeded", name, origin)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(s.DirPath, locksDir)); !os.IsNotExist(err) {
+		t.Errorf("Locks directory is created for unknown Buckets: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.DirPath, "..", "escape.lock")); !os.IsNotExist(err) {
+		t.Errorf("Lock file is created outside of the Storage: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var tmpDir string
+
+func TestMain(m *testing.M) {
+	parent := "/tmp/wizefs-test-parent"
+	err := os.MkdirAll(parent, 0700)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	tmpDir, err = ioutil.TempDir(parent, "core")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// Run the tests
+	r := m.Run()
+	os.RemoveAll(tmpDir)
+	os.Exit(r)
+}
+
+// testDir creates the directory of one test, it's removed with tmpDir
+func testDir(t *testing.T, prefix string) string {
+	dir, err := ioutil.TempDir(tmpDir, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
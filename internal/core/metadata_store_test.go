@@ -0,0 +1,309 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+func TestMetadataStoreMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	legacy := `{
+	"created": {
+		"A": {"originpath": "/tmp/A", "type": 1, "mountpoint": "A_mount", "automount": {"mode": "keep"}},
+		"B": {"originpath": "/tmp/B", "type": 1, "mountpoint": ""}
+	},
+	"mounted": {
+		"A_mount": {"mountpointpath": "/tmp/A_mount", "origin": "A", "idlettl": 60}
+	}
+}
+`
+	legacyFilename := filepath.Join(dir, StorageConfigFilename)
+	if err := ioutil.WriteFile(legacyFilename, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(config *StorageConfig) {
+		t.Helper()
+		if err := config.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if len(config.Filesystems) != 2 || len(config.Mountpoints) != 1 {
+			t.Fatalf("Loaded %v and %v", config.Filesystems, config.Mountpoints)
+		}
+		a := config.Filesystems["A"]
+		if a.OriginPath != "/tmp/A" || a.MountpointKey != "A_mount" ||
+			a.AutoMount == nil || a.AutoMount.Mode != AutoMountKeep {
+			t.Errorf("Filesystem A: %+v", a)
+		}
+		if mpi := config.Mountpoints["A_mount"]; mpi.OriginKey != "A" || mpi.IdleTTL != 60 {
+			t.Errorf("Mountpoint A_mount: %+v", mpi)
+		}
+	}
+
+	check(NewStorageConfig(dir))
+	if _, err := os.Stat(legacyFilename); !os.IsNotExist(err) {
+		t.Errorf("Migrated config was not renamed: %v", err)
+	}
+	if _, err := os.Stat(legacyFilename + migratedSuffix); err != nil {
+		t.Errorf("Migrated config was removed: %v", err)
+	}
+
+	// the old config is not migrated again
+	if err := ioutil.WriteFile(legacyFilename, []byte(`{"created": {"C": {}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check(NewStorageConfig(dir))
+}
+
+func TestMetadataStoreUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewStorageConfig(dir)
+	if err := config.CreateFilesystem("A", "/tmp/A", globals.LoopbackFS, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.CreateFilesystem("A", "/tmp/A", globals.LoopbackFS, nil); err == nil {
+		t.Errorf("Second CreateFilesystem of A succeeded")
+	}
+
+	// the failed transaction is rolled back
+	failed := errors.New("failed")
+	err = config.store.Update(func(tx MetadataTx) error {
+		if err := tx.DeleteFilesystem("A"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Errorf("Update: %v, want %v", err, failed)
+	}
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config.Filesystems["A"]; !ok {
+		t.Errorf("Filesystem A was deleted by the failed transaction")
+	}
+
+	// every config opens the store by itself like the other processes do,
+	// none of the concurrent changes is lost
+	const count = 10
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			origin := fmt.Sprintf("B%d", i)
+			config := NewStorageConfig(dir)
+			if err := config.CreateFilesystem(origin, "/tmp/"+origin, globals.LoopbackFS, nil); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := config.MountFilesystem(origin, origin+"_mount", "/tmp/"+origin+"_mount", 0); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Filesystems) != count+1 || len(config.Mountpoints) != count {
+		t.Errorf("%d filesystems and %d mountpoints, want %d and %d",
+			len(config.Filesystems), len(config.Mountpoints), count+1, count)
+	}
+	for i := 0; i < count; i++ {
+		origin := fmt.Sprintf("B%d", i)
+		if config.Filesystems[origin].MountpointKey != origin+"_mount" {
+			t.Errorf("Filesystem %s: %+v", origin, config.Filesystems[origin])
+		}
+	}
+}
+
+// TestStorageConfigConcurrentCheck checks the Bucket while another goroutine
+// mounts and unmounts it, run it with -race
+func TestStorageConfigConcurrentCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewStorageConfig(dir)
+	if err := config.CreateFilesystem("A", "/tmp/A", globals.LoopbackFS, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 200
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			if err := config.MountFilesystem("A", "A_mount", "/tmp/A_mount", 0); err != nil {
+				t.Error(err)
+			}
+			if err := config.UnmountFilesystem("A_mount"); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	check := func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			if _, err := config.Check("A", false, true); err != nil && !errors.Is(err, ErrMounted) {
+				t.Errorf("Check: %v", err)
+			}
+			if _, err := config.CheckOriginGetMountpoint("A"); err != nil && !errors.Is(err, ErrNotMounted) {
+				t.Errorf("CheckOriginGetMountpoint: %v", err)
+			}
+			if _, _, err := config.GetInfoByOrigin("A"); err != nil && !errors.Is(err, ErrNotMounted) {
+				t.Errorf("GetInfoByOrigin: %v", err)
+			}
+		}
+	}
+	go check()
+	go check()
+	wg.Wait()
+}
+
+func TestStorageConfigLoadError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the store can't be opened if its file is not a database
+	if err = ioutil.WriteFile(filepath.Join(dir, MetadataStoreFilename), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := NewStorageConfig(dir)
+	if exitCode, err := config.Check("A", true, true); exitCode != globals.ExitLoadConf || err == nil {
+		t.Errorf("Check: exit code %d (%v), want %d", exitCode, err, globals.ExitLoadConf)
+	}
+	if _, err := config.CheckOriginGetMountpoint("A"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("CheckOriginGetMountpoint: %v", err)
+	}
+	if _, _, err := config.GetInfoByOrigin("A"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("GetInfoByOrigin: %v", err)
+	}
+}
+
+func TestMetadataStoreCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storageDir := filepath.Join(dir, "storage")
+	config := NewStorageConfig(storageDir)
+	// reading the empty store writes nothing
+	if err = config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Filesystems) != 0 {
+		t.Errorf("Empty store has Buckets: %v", config.Filesystems)
+	}
+	if _, err = os.Stat(storageDir); !os.IsNotExist(err) {
+		t.Errorf("Load created the store: %v", err)
+	}
+
+	// the first change creates the Storage directory
+	if err = config.CreateFilesystem("A", "/tmp/A", globals.LoopbackFS, nil); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewStorageConfig(storageDir)
+	if err = loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.filesystem("A"); !ok {
+		t.Errorf("Created Bucket is not loaded")
+	}
+}
+
+// failingCommitStore rolls back every Update after fn succeeded, like a
+// commit that failed
+type failingCommitStore struct {
+	MetadataStore
+}
+
+var errFakeCommit = errors.New("fake commit failed")
+
+func (s failingCommitStore) Update(fn func(tx MetadataTx) error) error {
+	return s.MetadataStore.Update(func(tx MetadataTx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return errFakeCommit
+	})
+}
+
+func TestStorageConfigFailedCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewStorageConfig(dir)
+	config.store = failingCommitStore{config.store}
+	if err = config.CreateFilesystem("A", "/tmp/A", globals.LoopbackFS, nil); !errors.Is(err, errFakeCommit) {
+		t.Fatalf("CreateFilesystem: %v, want %v", err, errFakeCommit)
+	}
+	if _, ok := config.filesystem("A"); ok {
+		t.Errorf("Snapshot shows the Bucket that was rolled back")
+	}
+}
+
+func TestStorageConfigActivityBatch(t *testing.T) {
+	config := NewStorageConfig(testDir(t, "activity"))
+	for _, origin := range []string{"A", "B"} {
+		if err := config.CreateFilesystem(origin, "/tmp/"+origin, globals.LoopbackFS, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := config.MountFilesystem(origin, origin+"_mount", "/tmp/"+origin+"_mount", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	last := time.Now().Add(time.Hour)
+	config.queueLastActivity("A_mount", last)
+	config.queueLastActivity("B_mount", last.Add(time.Second))
+	// unmounted before the flush
+	config.queueLastActivity("C_mount", last)
+	config.flushActivity()
+
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	_, mountpoints := config.Snapshot()
+	if mountpoints["A_mount"].LastActivity != last.Unix() ||
+		mountpoints["B_mount"].LastActivity != last.Unix()+1 {
+		t.Errorf("Saved activity: %+v", mountpoints)
+	}
+	if _, ok := mountpoints["C_mount"]; ok {
+		t.Errorf("Activity of unknown mountpoint is saved")
+	}
+	if config.activityTimer != nil || len(config.activity) != 0 {
+		t.Errorf("Flushed activity is left queued")
+	}
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+const (
+	// unmountTimeout is how long we wait for the FUSE server loop to exit
+	// after a successful unmount
+	unmountTimeout = 5 * time.Second
+)
+
+// mountManager is the registry of FUSE servers that are served by goroutines
+// of the current (long-lived) process, like the REST or gRPC daemons.
+type mountManager struct {
+	mutex  sync.Mutex
+	mounts map[string]*managedMount // key - origin
+}
+
+type managedMount struct {
+	srv            mountedServer
+	mountpointPath string
+	done           chan struct{}
+}
+
+func newMountManager() *mountManager {
+	return &mountManager{
+		mounts: make(map[string]*managedMount),
+	}
+}
+
+// start runs the server loop of srv in a new goroutine and waits until the
+// kernel finishes mounting. onExit is called when the server loop exits, also
+// if the filesystem was unmounted by another process (fusermount -u).
+func (m *mountManager) start(origin, mountpointPath string, srv mountedServer, onExit func()) error {
+	mm := &managedMount{
+		srv:            srv,
+		mountpointPath: mountpointPath,
+		done:           make(chan struct{}),
+	}
+
+	m.mutex.Lock()
+	if _, ok := m.mounts[origin]; ok {
+		m.mutex.Unlock()
+		return fmt.Errorf("Bucket %s is already served by this process", origin)
+	}
+	m.mounts[origin] = mm
+	m.mutex.Unlock()
+
+	go func() {
+		// Returns when it gets an umount request from the kernel.
+		srv.Serve()
+
+		m.mutex.Lock()
+		if m.mounts[origin] == mm {
+			delete(m.mounts, origin)
+		}
+		m.mutex.Unlock()
+
+		tlog.Debug.Printf("FUSE server of %s exited", origin)
+		if onExit != nil {
+			onExit()
+		}
+		// stop returns after onExit, e.g. after ZipFS wrote its archive
+		close(mm.done)
+	}()
+
+	return srv.WaitMount()
+}
+
+// stop unmounts the Bucket if it is served by this process. managed is false
+// if the Bucket is unknown to the manager. If lazy is set, the busy
+// mountpoint is detached by the lazy unmount, otherwise the error is
+// returned and the Bucket stays mounted.
+func (m *mountManager) stop(origin string, lazy bool) (managed bool, err error) {
+	m.mutex.Lock()
+	mm, ok := m.mounts[origin]
+	m.mutex.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	err = mm.srv.Unmount()
+	if err != nil {
+		tlog.Warn.Printf("Unmount %s: %v", mm.mountpointPath, err)
+		if !lazy {
+			return true, err
+		}
+		err = lazyUnmount(mm.mountpointPath)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	select {
+	case <-mm.done:
+	case <-time.After(unmountTimeout):
+		tlog.Warn.Printf("FUSE server of %s did not exit in %v", origin, unmountTimeout)
+	}
+
+	return true, nil
+}
+
+// server returns the server of the Bucket if it is served by this process
+func (m *mountManager) server(origin string) (srv mountedServer, ok bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	mm, ok := m.mounts[origin]
+	if !ok {
+		return nil, false
+	}
+	return mm.srv, true
+}
+
+// origins returns the sorted list of Buckets served by this process
+func (m *mountManager) origins() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	origins := make([]string, 0, len(m.mounts))
+	for origin := range m.mounts {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+	return origins
+}
+
+// lazyUnmount detaches the mountpoint even if it is busy.
+// MacOSX does not support lazy unmount.
+func lazyUnmount(mountpointPath string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("lazy unmount is not supported on %s", runtime.GOOS)
+	}
+
+	tlog.Warn.Println("Trying lazy unmount")
+	cmd := exec.Command("fusermount", "-u", "-z", mountpointPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MinIdleTTL is the shortest idle timeout of a mount session
+	MinIdleTTL = time.Second
+
+	// sessionSaveInterval is how often the last activity of a busy session
+	// is saved to the Storage config, so other processes can report the
+	// remaining time
+	sessionSaveInterval = 10 * time.Second
+)
+
+// mountSession unmounts a Bucket after a period without FUSE activity, see
+// the sessions of doc/LZFS.md. FUSE operations only store the time of the
+// activity, the session goroutine checks it on every tick.
+type mountSession struct {
+	ttl time.Duration
+	// lastActivity is the UnixNano time of the last FUSE operation, it's
+	// changed atomically by the FUSE server goroutines
+	lastActivity int64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newMountSession(ttl time.Duration) *mountSession {
+	ms := &mountSession{
+		ttl:  ttl,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	ms.touch()
+	return ms
+}
+
+// touch resets the idle timer
+func (ms *mountSession) touch() {
+	atomic.StoreInt64(&ms.lastActivity, time.Now().UnixNano())
+}
+
+func (ms *mountSession) last() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&ms.lastActivity))
+}
+
+// remaining returns the time until the session expires
+func (ms *mountSession) remaining(now time.Time) time.Duration {
+	remaining := ms.last().Add(ms.ttl).Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// run waits until the session expires or is closed. onSave is called with
+// the time of the last activity at most every sessionSaveInterval, onExpire
+// is called when the session expires. If onExpire returns false (e.g. the
+// Bucket is busy), the session is restarted and expires again after ttl.
+// TEST: TestMountSessionExpire, TestMountSessionExpireRetry
+func (ms *mountSession) run(onSave func(last time.Time), onExpire func() bool) {
+	defer close(ms.done)
+
+	interval := ms.ttl / 10
+	if interval > sessionSaveInterval {
+		interval = sessionSaveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	saved, savedAt := ms.last(), time.Now()
+	for {
+		select {
+		case <-ms.stop:
+			return
+		case now := <-ticker.C:
+			if ms.remaining(now) == 0 {
+				if onExpire() {
+					return
+				}
+				ms.touch()
+				continue
+			}
+			if last := ms.last(); last.After(saved) && now.Sub(savedAt) >= sessionSaveInterval {
+				onSave(last)
+				saved, savedAt = last, now
+			}
+		}
+	}
+}
+
+// close stops the session, it doesn't wait for run to return
+func (ms *mountSession) close() {
+	ms.stopOnce.Do(func() {
+		close(ms.stop)
+	})
+}
+
+// wait waits until run returns, e.g. until onExpire unmounted the Bucket
+func (ms *mountSession) wait() {
+	<-ms.done
+}
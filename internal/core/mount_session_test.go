@@ -0,0 +1,91 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMountSessionExpire(t *testing.T) {
+	ttl := 100 * time.Millisecond
+	session := newMountSession(ttl)
+
+	expired := make(chan time.Time, 1)
+	start := time.Now()
+	go session.run(func(time.Time) {}, func() bool {
+		expired <- time.Now()
+		return true
+	})
+
+	// activity keeps the session alive
+	for i := 0; i < 5; i++ {
+		time.Sleep(ttl / 2)
+		session.touch()
+	}
+	if remaining := session.remaining(time.Now()); remaining <= 0 || remaining > ttl {
+		t.Errorf("remaining = %v, want (0, %v]", remaining, ttl)
+	}
+
+	select {
+	case at := <-expired:
+		if at.Sub(start) < 5*ttl/2 {
+			t.Errorf("Session expired after %v in spite of activity", at.Sub(start))
+		}
+	case <-time.After(10 * ttl):
+		t.Fatalf("Session didn't expire")
+	}
+	session.wait()
+}
+
+func TestMountSessionExpireRetry(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	session := newMountSession(ttl)
+
+	var expired []time.Time
+	go session.run(func(time.Time) {}, func() bool {
+		expired = append(expired, time.Now())
+		// the first unmount fails
+		return len(expired) > 1
+	})
+
+	select {
+	case <-session.done:
+	case <-time.After(20 * ttl):
+		t.Fatalf("Session didn't expire twice")
+	}
+	if len(expired) != 2 {
+		t.Fatalf("Session expired %d times, want 2", len(expired))
+	}
+	if d := expired[1].Sub(expired[0]); d < ttl {
+		t.Errorf("Session expired again after %v, want at least %v", d, ttl)
+	}
+}
+
+func TestMountSessionClose(t *testing.T) {
+	session := newMountSession(50 * time.Millisecond)
+	go session.run(func(time.Time) {}, func() bool {
+		t.Errorf("Closed session expired")
+		return true
+	})
+
+	session.close()
+	session.close()
+	session.wait()
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestMountpointInfoIdleRemaining(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		mpi  MountpointInfo
+		want time.Duration
+	}{
+		{MountpointInfo{}, 0},
+		{MountpointInfo{IdleTTL: 60, LastActivity: 990}, 50 * time.Second},
+		{MountpointInfo{IdleTTL: 60, LastActivity: 900}, 0},
+	}
+	for _, test := range tests {
+		if got := test.mpi.IdleRemaining(now); got != test.want {
+			t.Errorf("IdleRemaining of %+v = %v, want %v", test.mpi, got, test.want)
+		}
+	}
+}
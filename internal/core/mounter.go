@@ -0,0 +1,128 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+// mountRoot is the filesystem of a Bucket that is ready to be mounted
+type mountRoot struct {
+	// Node is the root node of FS served by go-fuse
+	Node nodefs.Node
+	FS   pathfs.FileSystem
+}
+
+// mounter is the layer between the Storage and go-fuse: it mounts the root
+// of a Bucket at the mountpoint. fuseMounter mounts it through the kernel,
+// the tests serve the filesystem in memory.
+type mounter interface {
+	Mount(root mountRoot, mountpointPath string) (mountedServer, error)
+}
+
+// mountedServer serves the mounted filesystem, see fuse.Server
+type mountedServer interface {
+	// Serve runs the server loop, it returns when the filesystem is
+	// unmounted
+	Serve()
+	// WaitMount waits until the filesystem is ready after Serve is started
+	WaitMount() error
+	Unmount() error
+	// FS returns the files of the mounted Bucket
+	FS() bucketFS
+}
+
+// fuseMounter mounts the filesystems through the kernel (/dev/fuse)
+type fuseMounter struct{}
+
+var _ mounter = fuseMounter{} // Verify that interface is implemented.
+
+func (fuseMounter) Mount(root mountRoot, mountpointPath string) (mountedServer, error) {
+	fuseOpts := &nodefs.Options{
+		// These options are to be compatible with libfuse defaults,
+		// making benchmarking easier.
+		NegativeTimeout: time.Second,
+		AttrTimeout:     time.Second,
+		EntryTimeout:    time.Second,
+		Debug:           true,
+	}
+
+	conn := nodefs.NewFileSystemConnector(root.Node, fuseOpts)
+	mountOpts := fuse.MountOptions{
+		// Writes and reads are usually capped at 128kiB on Linux through
+		// the FUSE_MAX_PAGES_PER_REQ kernel constant in fuse_i.h. Our
+		// sync.Pool buffer pools are sized acc. to the default. Users may set
+		// the kernel constant higher, and Synology NAS kernels are known to
+		// have it >128kiB. We cannot handle more than 128kiB, so we tell
+		// the kernel to limit the size explicitely.
+		MaxWrite: fuse.MAX_KERNEL_WRITE,
+		Options:  []string{fmt.Sprintf("max_read=%d", fuse.MAX_KERNEL_WRITE)},
+		Debug:    fuseOpts.Debug,
+	}
+
+	// Set values shown in "df -T" and friends
+	// First column, "Filesystem"
+	mountOpts.FsName = tlog.ProgramName
+	// Second column, "Type", will be shown as "fuse." + Name
+	mountOpts.Name = tlog.ProgramName
+
+	// Add a volume name if running osxfuse. Otherwise the Finder will show it as
+	// something like "osxfuse Volume 0 (wizefs)".
+	if runtime.GOOS == "darwin" {
+		mountOpts.Options = append(mountOpts.Options, "volname="+path.Base(mountpointPath))
+	}
+
+	srv, err := fuse.NewServer(conn.RawFS(), mountpointPath, &mountOpts)
+	if err != nil {
+		tlog.Warn.Printf("fuse.NewServer failed: %v\n", err)
+		if runtime.GOOS == "darwin" {
+			tlog.Warn.Println("Maybe you should run: /Library/Filesystems/osxfuse.fs/Contents/Resources/load_osxfuse")
+		}
+		return nil, err
+	}
+
+	// All FUSE file and directory create calls carry explicit permission
+	// information. We need an unrestricted umask to create the files and
+	// directories with the requested permissions.
+	syscall.Umask(0000)
+
+	return &fuseServer{Server: srv, mountpointPath: mountpointPath}, nil
+}
+
+// fuseServer is the FUSE server of the kernel mount, the files are accessed
+// through the mountpoint
+type fuseServer struct {
+	*fuse.Server
+	mountpointPath string
+}
+
+func (srv *fuseServer) FS() bucketFS {
+	return dirFS(srv.mountpointPath)
+}
+
+// getMounter returns the mounter of the Storage, fuseMounter by default
+func (s *Storage) getMounter() mounter {
+	if s.mounter == nil {
+		return fuseMounter{}
+	}
+	return s.mounter
+}
+
+// mountFS returns the files of the mounted Bucket: the filesystem served by
+// the current process or the mountpoint of another one
+func (s *Storage) mountFS(origin, mountpointPath string) bucketFS {
+	if s.mounts != nil {
+		if srv, ok := s.mounts.server(origin); ok {
+			return srv.FS()
+		}
+	}
+	return dirFS(mountpointPath)
+}
@@ -0,0 +1,294 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+	"bitbucket.org/udt/wizefs/internal/util"
+)
+
+const (
+	// quarantineDir keeps the LZFS temp directories that can't be packed
+	// back into their archives
+	quarantineDir = "quarantine"
+)
+
+// mountInfoPath lists the mounts of the current process, tests replace it
+var mountInfoPath = "/proc/self/mountinfo"
+
+// Reconcile repairs the state left by crashed mount processes. The config
+// may say a Bucket is mounted while it's not (the process was killed and
+// the mountpoint is gone) or while its FUSE endpoint is dead ("Transport
+// endpoint is not connected"), and LZFS archives may be left unpacked in
+// the temp directory. Reconcile clears the stale mount entries, lazily
+// unmounts the dead endpoints, re-packs the orphaned LZFS temp directories
+// and moves the ones it can't pack to the quarantine directory. Every
+// action is logged and returned.
+//
+// NewStorage calls it on startup. The Storage and the Buckets locked by
+// other processes are skipped, they are busy rather than crashed.
+// TEST: TestStorageReconcile
+func (s *Storage) Reconcile() (actions []string) {
+	if _, err := os.Stat(s.DirPath); err != nil {
+		return nil
+	}
+	mounts, err := readMountInfo(mountInfoPath)
+	if err != nil {
+		// without the mount table every mount looks stale
+		tlog.Debug.Printf("Skipping reconciliation: %v", err)
+		return nil
+	}
+
+	storageLock, ok := s.tryLock(storageLockName, LockShared)
+	if !ok {
+		tlog.Debug.Printf("Skipping reconciliation: Storage is locked")
+		return nil
+	}
+	defer storageLock.unlock()
+
+	if err = s.Config.Load(); err != nil {
+		tlog.Warn.Printf("Skipping reconciliation: %v", err)
+		return nil
+	}
+
+	log := func(format string, args ...interface{}) {
+		action := fmt.Sprintf(format, args...)
+		tlog.Info.Printf("Reconcile: %s", action)
+		actions = append(actions, action)
+	}
+
+	for _, origin := range s.reconcileCandidates(mounts) {
+		lock, ok := s.tryLock(bucketLockName(origin), LockExclusive)
+		if !ok {
+			tlog.Debug.Printf("Skipping reconciliation of %s: Bucket is locked", origin)
+			continue
+		}
+		// the snapshot may be changed by the process that held the lock
+		if err = s.Config.Load(); err == nil {
+			s.reconcileBucket(origin, mounts, log)
+		}
+		lock.unlock()
+	}
+
+	// the temp directories of the deleted Buckets
+	tempDirs := make(map[string]string)
+	for _, origin := range s.sortedOrigins() {
+		if fsinfo, _ := s.Config.filesystem(origin); fsinfo.Type == globals.LZFS {
+			tempDirs[filepath.Base(s.lzfsTempPath(origin))] = origin
+		}
+	}
+	infos, _ := ioutil.ReadDir(s.DirPath + lzfsTempDir)
+	for _, info := range infos {
+		if _, ok := tempDirs[info.Name()]; ok {
+			continue
+		}
+		path := filepath.Join(s.DirPath+lzfsTempDir, info.Name())
+		if dest, err := s.quarantine(path); err != nil {
+			tlog.Warn.Printf("Reconcile: quarantine of %s failed: %v", path, err)
+		} else {
+			log("moved orphaned temp directory %s to %s", path, dest)
+		}
+	}
+
+	// the dead endpoints that are not in the config
+	for path, fstype := range mounts {
+		if !strings.HasPrefix(fstype, "fuse") ||
+			!strings.HasPrefix(path, filepath.Clean(s.DirPath)+"/") || !endpointDead(path) {
+			continue
+		}
+		if err := s.lazyUnmount(path); err != nil {
+			tlog.Warn.Printf("Reconcile: unmounting dead endpoint %s failed: %v", path, err)
+		} else {
+			log("lazily unmounted dead endpoint %s", path)
+		}
+	}
+
+	return actions
+}
+
+// reconcileCandidates returns the Buckets that look mounted by a crashed
+// process or have an LZFS temp directory without being mounted
+func (s *Storage) reconcileCandidates(mounts map[string]string) (origins []string) {
+	for _, origin := range s.sortedOrigins() {
+		fsinfo, _ := s.Config.filesystem(origin)
+		mountpointPath, mounted := s.configMountpoint(origin, fsinfo)
+		if mounted {
+			if _, ok := mounts[mountpointPath]; !ok || endpointDead(mountpointPath) {
+				origins = append(origins, origin)
+			}
+			continue
+		}
+		if fsinfo.Type == globals.LZFS {
+			if _, err := os.Stat(s.lzfsTempPath(origin)); err == nil {
+				origins = append(origins, origin)
+			}
+		}
+	}
+	return origins
+}
+
+// reconcileBucket repairs the Bucket, the caller holds its exclusive lock
+func (s *Storage) reconcileBucket(origin string, mounts map[string]string,
+	log func(format string, args ...interface{})) {
+
+	fsinfo, ok := s.Config.filesystem(origin)
+	if !ok {
+		return
+	}
+
+	if mountpointPath, mounted := s.configMountpoint(origin, fsinfo); mounted {
+		if _, ok := mounts[mountpointPath]; ok {
+			if !endpointDead(mountpointPath) {
+				// mounted by a live process
+				return
+			}
+			if err := s.lazyUnmount(mountpointPath); err != nil {
+				tlog.Warn.Printf("Reconcile: unmounting dead endpoint %s of %s failed: %v",
+					mountpointPath, origin, err)
+				return
+			}
+			log("lazily unmounted dead endpoint %s of %s", mountpointPath, origin)
+		}
+
+		if err := s.Config.ResetMount(origin); err != nil {
+			tlog.Warn.Printf("Reconcile: clearing mount entry of %s failed: %v", origin, err)
+			return
+		}
+		log("cleared stale mount entry of %s (%s)", origin, mountpointPath)
+		// the mountpoint is removed if it's empty only
+		if err := os.Remove(mountpointPath); err == nil {
+			log("removed mountpoint %s", mountpointPath)
+		}
+	}
+
+	if fsinfo.Type != globals.LZFS {
+		return
+	}
+	tempPath := s.lzfsTempPath(origin)
+	if _, err := os.Stat(tempPath); err != nil {
+		return
+	}
+
+	// LZFS archives always contain the bucket config, the temp directory
+	// without it is not a complete Bucket and is quarantined
+	bucketConfig := NewBucketConfig(origin, tempPath, fsinfo.Type)
+	err := bucketConfig.Load()
+	if err == nil {
+		err = util.PackArchive(tempPath, s.DirPath+origin, bucketConfig.PackOptions())
+	}
+	if err == nil {
+		os.RemoveAll(tempPath)
+		log("re-packed orphaned temp directory %s into %s", tempPath, s.DirPath+origin)
+		return
+	}
+
+	dest, qerr := s.quarantine(tempPath)
+	if qerr != nil {
+		tlog.Warn.Printf("Reconcile: quarantine of %s failed: %v", tempPath, qerr)
+		return
+	}
+	log("moved orphaned temp directory %s of %s to %s (%v)", tempPath, origin, dest, err)
+}
+
+// configMountpoint returns the mountpoint of the Bucket if the config says
+// it's mounted
+func (s *Storage) configMountpoint(origin string, fsinfo FilesystemInfo) (mountpointPath string, mounted bool) {
+	_, mountpoints := s.Config.Snapshot()
+	for _, mpi := range mountpoints {
+		if mpi.OriginKey == origin {
+			return filepath.Clean(mpi.MountpointPath), true
+		}
+	}
+	if fsinfo.MountpointKey != "" {
+		return filepath.Clean(s.DirPath + fsinfo.MountpointKey), true
+	}
+	return "", false
+}
+
+// quarantine moves the directory into the quarantine directory of the
+// Storage, it's never removed automatically
+func (s *Storage) quarantine(path string) (dest string, err error) {
+	dir := s.DirPath + quarantineDir
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	dest = filepath.Join(dir, filepath.Base(path)+"."+time.Now().Format("20060102T150405"))
+	return dest, os.Rename(path, dest)
+}
+
+func (s *Storage) sortedOrigins() (origins []string) {
+	filesystems, _ := s.Config.Snapshot()
+	for origin := range filesystems {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+	return origins
+}
+
+// endpointDead checks if the FUSE server of the mountpoint is gone
+func endpointDead(path string) bool {
+	_, err := os.Stat(path)
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err == syscall.ENOTCONN || pe.Err == syscall.ECONNABORTED
+	}
+	return false
+}
+
+// readMountInfo returns the mountpoints of mountinfo(5) with their
+// filesystem types
+func readMountInfo(path string) (mounts map[string]string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mounts = make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		fstype := ""
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) {
+				fstype = fields[i+1]
+				break
+			}
+		}
+		mounts[filepath.Clean(unescapeMountPath(fields[4]))] = fstype
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes (\040 is a space) of the
+// mountinfo paths
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var b []byte
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b = append(b, byte(c))
+				i += 3
+				continue
+			}
+		}
+		b = append(b, path[i])
+	}
+	return string(b)
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+	"bitbucket.org/udt/wizefs/internal/testutil"
+	"bitbucket.org/udt/wizefs/internal/util"
+)
+
+// newTestArchive packs an LZFS archive with the bucket config and a.txt
+func newTestArchive(t *testing.T, dir, origin string) {
+	archive := filepath.Join(dir, origin)
+	js, err := json.Marshal(NewBucketConfig(origin, archive, globals.LZFS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.NewArchive(t, archive, map[string]string{
+		BucketConfigFilename: string(js),
+		"a.txt":              "a",
+	})
+}
+
+func TestStorageReconcile(t *testing.T) {
+	dir := testDir(t, "reconcile")
+	s := &Storage{DirPath: dir + "/", Config: NewStorageConfig(dir),
+		LockTimeout: DefaultLockTimeout}
+
+	mountpoint := func(origin string, fstype globals.FSType) string {
+		return s.DirPath + s.getMountpoint(origin, fstype)
+	}
+	mount := func(origin string, fstype globals.FSType) {
+		originPath := s.DirPath + origin
+		if fstype == globals.LZFS {
+			originPath = s.lzfsTempPath(origin)
+		}
+		if err := s.Config.CreateFilesystem(origin, originPath, fstype, nil); err != nil {
+			t.Fatal(err)
+		}
+		mp := s.getMountpoint(origin, fstype)
+		if err := os.MkdirAll(s.DirPath+mp, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Config.MountFilesystem(origin, mp, s.DirPath+mp, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A is mounted by a live process, B was mounted by a killed one
+	mount("A", globals.LoopbackFS)
+	mount("B", globals.LoopbackFS)
+
+	// c.zip was mounted by a killed process, its temp directory has a new file
+	newTestArchive(t, dir, "c.zip")
+	mount("c.zip", globals.LZFS)
+	if err := util.UnpackArchive(s.DirPath+"c.zip", s.lzfsTempPath("c.zip")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.lzfsTempPath("c.zip"), "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// d.zip was unpacked partially, x_zip belongs to a deleted Bucket
+	newTestArchive(t, dir, "d.zip")
+	if err := s.Config.CreateFilesystem("d.zip", s.lzfsTempPath("d.zip"), globals.LZFS, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"d_zip", "x_zip"} {
+		if err := os.MkdirAll(filepath.Join(s.DirPath, lzfsTempDir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldMountInfo := mountInfoPath
+	defer func() { mountInfoPath = oldMountInfo }()
+	mountInfoPath = filepath.Join(dir, "mountinfo")
+	mountInfo := fmt.Sprintf("36 25 0:50 / %s rw,nosuid - fuse.wizefs wizefs rw\n",
+		mountpoint("A", globals.LoopbackFS))
+	if err := ioutil.WriteFile(mountInfoPath, []byte(mountInfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	actions := s.Reconcile()
+	if len(actions) == 0 {
+		t.Fatal("Nothing is reconciled")
+	}
+	for _, action := range actions {
+		t.Log(action)
+	}
+
+	if err := s.Config.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Config.Filesystems["A"].MountpointKey == "" {
+		t.Errorf("Mount entry of live A was cleared")
+	}
+	for _, origin := range []string{"B", "c.zip"} {
+		if key := s.Config.Filesystems[origin].MountpointKey; key != "" {
+			t.Errorf("Stale mount entry %s of %s is kept", key, origin)
+		}
+	}
+	if len(s.Config.Mountpoints) != 1 {
+		t.Errorf("Mountpoints: %+v, want A only", s.Config.Mountpoints)
+	}
+	if _, err := os.Stat(mountpoint("B", globals.LoopbackFS)); !os.IsNotExist(err) {
+		t.Errorf("Stale mountpoint of B was not removed: %v", err)
+	}
+
+	// c.zip is re-packed with the new file
+	check := filepath.Join(dir, "check")
+	if err := util.UnpackArchive(s.DirPath+"c.zip", check); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(check, "new.txt")); err != nil || string(content) != "new" {
+		t.Errorf("new.txt of re-packed c.zip: %q, %v", content, err)
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Join(s.DirPath, lzfsTempDir))
+	if err != nil || len(infos) != 0 {
+		t.Errorf("Temp directories are left: %v, %v", infos, err)
+	}
+	infos, err = ioutil.ReadDir(filepath.Join(s.DirPath, quarantineDir))
+	if err != nil || len(infos) != 2 {
+		t.Errorf("Quarantine has %v, %v; want d_zip and x_zip", infos, err)
+	}
+
+	// the reconciled Storage is left as it is
+	if actions := s.Reconcile(); len(actions) != 0 {
+		t.Errorf("Second Reconcile: %v", actions)
+	}
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+	tests := map[string]string{
+		`/mnt/a`:          `/mnt/a`,
+		`/mnt/a\040b`:     `/mnt/a b`,
+		`/mnt/a\134b\011`: "/mnt/a\\b\t",
+		`/mnt/a\04`:       `/mnt/a\04`,
+	}
+	for path, want := range tests {
+		if got := unescapeMountPath(path); got != want {
+			t.Errorf("unescapeMountPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -1,12 +1,18 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
+	"bitbucket.org/udt/wizefs/internal/cryptocore"
+	"bitbucket.org/udt/wizefs/internal/fusefrontend"
 	"bitbucket.org/udt/wizefs/internal/globals"
 	"bitbucket.org/udt/wizefs/internal/tlog"
 	"bitbucket.org/udt/wizefs/internal/util"
@@ -14,39 +20,107 @@ import (
 
 const (
 	storageDirPath = "/.local/share/wize/fs/"
+	// lzfsTempDir keeps the unpacked LZFS archives of the Storage
+	lzfsTempDir = "temp"
 )
 
 type StorageApi interface {
 	Create(origin string) (exitCode int, err error)
+	CreateWithOptions(origin string, opts BucketOptions) (exitCode int, err error)
 	Delete(origin string) (exitCode int, err error)
 	Mount(origin string, notifypid int) (exitCode int, err error)
+	MountWithOptions(origin string, notifypid int, opts MountOptions) (exitCode int, err error)
+	MountManaged(origin string) (exitCode int, err error)
+	MountManagedWithOptions(origin string, opts MountOptions) (exitCode int, err error)
 	Unmount(origin string) (exitCode int, err error)
+	SetAutoMount(origin string, policy AutoMountPolicy) (exitCode int, err error)
+	ACL(origin string) (acl *BucketACL, exitCode int, err error)
+	SetACL(origin string, acl *BucketACL) (exitCode int, err error)
+	Session(origin string) (info SessionInfo, exitCode int, err error)
+	List() (buckets []BucketInfo, exitCode int, err error)
+	Locks() (locks []LockInfo, exitCode int, err error)
+	Verify(origin string) (problems []Problem, exitCode int, err error)
+	Clean(dryRun bool) (problems []Problem, exitCode int, err error)
+	Close()
+}
+
+// MountOptions are the options of mounting a Bucket
+type MountOptions struct {
+	// Password unlocks an encrypted Bucket
+	Password string
+	// MemoryLimit caps the memory used by a ZipFS Bucket in bytes,
+	// 0 - fusefrontend.DefaultMemoryLimit
+	MemoryLimit int64
+	// IdleTTL unmounts the Bucket after this time without FUSE activity,
+	// 0 - never
+	IdleTTL time.Duration
+}
+
+// Check validates the options
+func (o MountOptions) Check() error {
+	if o.IdleTTL != 0 && o.IdleTTL < MinIdleTTL {
+		return fmt.Errorf("Idle TTL should be at least %v", MinIdleTTL)
+	}
+	return nil
+}
+
+// SessionInfo is the idle timeout of a mounted Bucket
+type SessionInfo struct {
+	// IdleTTL is 0 if the Bucket is never unmounted automatically
+	IdleTTL time.Duration
+	// Remaining is the time until the Bucket is unmounted
+	Remaining time.Duration
 }
 
 type Storage struct {
 	DirPath string
 	Config  *StorageConfig
+	// LockTimeout is how long the operations wait for the locks held by
+	// other processes, 0 - DefaultLockTimeout. NewStorage reads it from
+	// globals.LockTimeoutEnvVar.
+	LockTimeout time.Duration
+	// ShortLived marks the Storage of a process that exits after one
+	// operation, like the CLI. Close unmounts the Buckets auto-mounted in
+	// keep mode, so the file operations refuse to auto-mount them.
+	ShortLived bool
+	// mutex guards buckets and the mount state of the Buckets (mounted,
+	// MountPoint and session), the mount sessions and the FUSE servers
+	// change it in their own goroutines
+	mutex   sync.RWMutex
 	buckets map[string]*Bucket
+	mounts  *mountManager
+	// mounter mounts the Buckets, nil - fuseMounter (the tests serve them
+	// in memory)
+	mounter mounter
 }
 
 func NewStorage() *Storage {
 	storage := &Storage{
 		buckets: make(map[string]*Bucket),
+		mounts:  newMountManager(),
 	}
 
 	storage.DirPath = storage.userHomeDir() + storageDirPath
+	if timeout := os.Getenv(globals.LockTimeoutEnvVar); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil {
+			storage.LockTimeout = d
+		} else {
+			tlog.Warn.Printf("Invalid %s: %v", globals.LockTimeoutEnvVar, err)
+		}
+	}
 	storage.Config = NewStorageConfig(storage.DirPath)
-	err := storage.Config.Load()
-	if err != nil {
-		storage.Config.Save()
+	if err := storage.Config.Load(); err != nil {
+		tlog.Warn.Printf("Problem with loading Config: %v", err)
 	}
+	// the Buckets of crashed processes are not mounted anymore
+	storage.Reconcile()
 
 	// Now we just read WizeConfig and set Storate info and buckets
-	for origin, fsinfo := range storage.Config.Filesystems {
-		storage.buckets[origin] = NewBucket(storage, origin, fsinfo.OriginPath, fsinfo.Type)
+	filesystems, _ := storage.Config.Snapshot()
+	for origin, fsinfo := range filesystems {
+		storage.addBucket(NewBucket(storage, origin, fsinfo.OriginPath, fsinfo.Type))
 		if fsinfo.MountpointKey != "" {
-			storage.buckets[origin].MountPoint = fsinfo.MountpointKey
-			storage.buckets[origin].mounted = true
+			storage.setMounted(origin, fsinfo.MountpointKey)
 		}
 	}
 	//for origin, fsinfo := range s.config.Mountpoints {
@@ -56,15 +130,49 @@ func NewStorage() *Storage {
 }
 
 func (s *Storage) String() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	return fmt.Sprintf("Path: %s, Buckets count: %d", s.DirPath, len(s.buckets))
 }
 
 func (s *Storage) Bucket(origin string) (*Bucket, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	bucket, ok := s.buckets[origin]
 	return bucket, ok
 }
 
+// addBucket adds the created Bucket to the Storage
+func (s *Storage) addBucket(bucket *Bucket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.buckets[bucket.Origin] = bucket
+}
+
+// removeBucket removes the deleted Bucket from the Storage
+func (s *Storage) removeBucket(origin string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.buckets, origin)
+}
+
+// setMounted changes the mount state of the Bucket, the empty mountpoint
+// means it's unmounted and ends its session
+func (s *Storage) setMounted(origin, mountpoint string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if bucket, ok := s.buckets[origin]; ok {
+		bucket.mounted = mountpoint != ""
+		bucket.MountPoint = mountpoint
+		if mountpoint == "" {
+			bucket.session = nil
+		}
+	}
+}
+
 func (s *Storage) MountedBuckets() map[string]*Bucket {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 	buckets := make(map[string]*Bucket)
 	for origin, bucket := range s.buckets {
 		if bucket.mounted {
@@ -74,35 +182,81 @@ func (s *Storage) MountedBuckets() map[string]*Bucket {
 	return buckets
 }
 
+// validOrigin reports whether origin names a Bucket in the Storage directory:
+// it must not leave the directory, be hidden or be a file of the Storage
+func validOrigin(origin string) bool {
+	return origin != "" &&
+		!strings.ContainsAny(origin, "/\\") &&
+		!strings.HasPrefix(origin, ".") &&
+		!reservedNames[origin]
+}
+
+// errInvalidOrigin is the error of the origins rejected by validOrigin
+func errInvalidOrigin(origin string) error {
+	return newError(ErrInvalid, "Invalid origin: ['%s'].", origin)
+}
+
+// checkBucket checks that origin names a known Bucket before its lock is
+// taken: the lock file is named by the origin, so invalid origins would
+// create it outside the locks directory and unknown ones would leave it
+// behind. The state of the Bucket is checked again under the lock.
+func (s *Storage) checkBucket(origin string) (exitCode int, err error) {
+	if !validOrigin(origin) {
+		return globals.ExitOrigin, errInvalidOrigin(origin)
+	}
+	if err = s.Config.load(); err != nil {
+		return globals.ExitLoadConf, err
+	}
+	if _, ok := s.Config.filesystem(origin); !ok {
+		return globals.ExitOrigin,
+			newError(ErrNotFound, "Did not find ORIGIN: %s in common config.", origin)
+	}
+	return 0, nil
+}
+
 func (s *Storage) Create(origin string) (exitCode int, err error) {
+	return s.CreateWithOptions(origin, BucketOptions{})
+}
+
+func (s *Storage) CreateWithOptions(origin string, opts BucketOptions) (exitCode int, err error) {
 	//exitCode, err = checkConfig(origin, true, false)
 	//if err != nil {
 	//	return
 	//}
 
-	if origin == "" {
-		// TEST: TestCreateInvalidOrigin
-		return globals.ExitOrigin,
-			fmt.Errorf("Invalid origin: ['%s'].", origin)
+	if err = opts.Check(); err != nil {
+		return globals.ExitUsage,
+			newError(ErrInvalid, "Invalid options: %v", err)
 	}
 
+	if !validOrigin(origin) {
+		// TEST: TestCreateInvalidOrigin
+		return globals.ExitOrigin, errInvalidOrigin(origin)
+	}
 	originPath := s.DirPath + origin
 	fstype, err := s.checkOriginType(originPath)
 	if err != nil {
 		// TEST: TestCreateInvalidOrigin
 		return globals.ExitOrigin,
-			fmt.Errorf("Invalid origin: %v.", err)
+			newError(ErrInvalid, "Invalid origin: %v.", err)
+	}
+
+	unlock, exitCode, err := s.lockBucket(origin, LockExclusive, LockExclusive)
+	if err != nil {
+		return
 	}
+	defer unlock()
 
-	// TODO: create zip files
 	if fstype == globals.ZipFS {
 		// TEST: TestCreateZipFS (like _archive.zip)
-		return globals.ExitOrigin,
-			fmt.Errorf("Creating zip files are not supported now")
+		exitCode, err = s.createZipFS(originPath, opts)
+		if err != nil {
+			return exitCode, err
+		}
+		return s.addFilesystem(origin, originPath, fstype, opts)
 	}
 	if fstype == globals.LZFS {
-		originPath = s.DirPath +
-			"temp/" + strings.Replace(origin, ".", "_", -1)
+		originPath = s.lzfsTempPath(origin)
 	}
 
 	tlog.Debug.Printf("Creating new Filesystem %s on path %s...\n", origin, originPath)
@@ -114,46 +268,115 @@ func (s *Storage) Create(origin string) (exitCode int, err error) {
 	} else {
 		// TODO: what we should done when origin is exist already?
 		return globals.ExitOrigin,
-			fmt.Errorf("Directory %s is exist already!", originPath)
+			newError(ErrAlreadyExists, "Directory %s is exist already!", originPath)
+	}
+
+	// save bucket config before packing, so LZFS archive will contain it
+	bucketConfig := NewBucketConfig(origin, originPath, fstype)
+	err = opts.Apply(bucketConfig)
+	if err != nil {
+		os.RemoveAll(originPath)
+		return globals.ExitInit,
+			fmt.Errorf("Problem with initializing Bucket: %w", err)
+	}
+	err = bucketConfig.Save()
+	if err != nil {
+		return globals.ExitSaveConf,
+			fmt.Errorf("Problem with saving Bucket config: %w", err)
 	}
 
 	// create LZFS archive
 	if fstype == globals.LZFS {
 		targetFile := s.DirPath + origin
-		err = util.ZipFile(originPath, targetFile)
+		err = util.PackArchive(originPath, targetFile, bucketConfig.PackOptions())
 		if err != nil {
 			// TEST: TestCreateLZFS (like archive.zip)
 			return globals.ExitZip,
-				fmt.Errorf("LZFS file zipping failed: %v", err)
+				fmt.Errorf("LZFS archive packing failed: %w", err)
 		}
 		// remove temp directory
 		os.RemoveAll(originPath)
 	}
 
+	return s.addFilesystem(origin, originPath, fstype, opts)
+}
+
+// createZipFS writes an empty archive. ZipFS archives are plain zip or tar
+// files, so there is no Bucket config and no options.
+func (s *Storage) createZipFS(originPath string, opts BucketOptions) (exitCode int, err error) {
+	if opts != (BucketOptions{AutoMount: opts.AutoMount}) {
+		return globals.ExitUsage,
+			newError(ErrInvalid, "Invalid options: zip files have no Bucket config")
+	}
+	if _, err = os.Stat(originPath); err == nil {
+		return globals.ExitOrigin,
+			newError(ErrAlreadyExists, "File %s is exist already!", originPath)
+	}
+
+	tlog.Debug.Printf("Creating new archive %s...", originPath)
+	os.MkdirAll(filepath.Dir(originPath), 0755)
+
+	emptyDir, err := ioutil.TempDir("", "wizefs-zipfs")
+	if err != nil {
+		return globals.ExitZip,
+			fmt.Errorf("Creating archive failed: %w", err)
+	}
+	defer os.RemoveAll(emptyDir)
+
+	err = util.PackArchive(emptyDir, originPath, util.PackOptions{})
+	if err != nil {
+		return globals.ExitZip,
+			fmt.Errorf("Creating archive failed: %w", err)
+	}
+	return 0, nil
+}
+
+// addFilesystem adds the new Bucket to the Storage config and Buckets
+func (s *Storage) addFilesystem(origin, originPath string, fstype globals.FSType,
+	opts BucketOptions) (exitCode int, err error) {
 	// TODO: HACK for gRPC methods
 	if s.Config == nil {
 		tlog.Info.Println("CommonConfig == nil")
 		//config.InitWizeConfig()
 	}
-	err = s.Config.CreateFilesystem(origin, originPath, fstype)
+	autoMount := opts.AutoMount
+	err = s.Config.CreateFilesystem(origin, originPath, fstype, &autoMount)
 	if err != nil {
 		return globals.ExitChangeConf,
-			fmt.Errorf("Problem with adding Filesystem to Config: %v", err)
-	} else {
-		err = s.Config.Save()
-		if err != nil {
-			return globals.ExitSaveConf,
-				fmt.Errorf("Problem with saving Config: %v", err)
+			fmt.Errorf("Problem with adding Filesystem to Config: %w", err)
+	}
+	// the Bucket without ACL is accessible by admins only, so it's safe
+	// to fail here
+	if opts.ACL != nil {
+		if err = s.Config.SetACL(origin, opts.ACL); err != nil {
+			return globals.ExitChangeConf,
+				fmt.Errorf("Problem with setting ACL: %w", err)
 		}
 	}
 
 	// Adding to Buckets
-	s.buckets[origin] = NewBucket(s, origin, originPath, fstype)
+	s.addBucket(NewBucket(s, origin, originPath, fstype))
 
 	return 0, nil
 }
 
 func (s *Storage) Delete(origin string) (exitCode int, err error) {
+	if exitCode, err = s.checkBucket(origin); err != nil {
+		return
+	}
+
+	storageLock, exitCode, err := s.lock(storageLockName, LockExclusive)
+	if err != nil {
+		return
+	}
+	defer storageLock.unlock()
+	bucketLock, exitCode, err := s.lock(bucketLockName(origin), LockExclusive)
+	if err != nil {
+		return
+	}
+	defer bucketLock.unlock()
+
+	// another process may have changed the Bucket before we took the locks
 	// TEST: TestDeleteNotExistingOrigin, TestDeleteAlreadyMounted
 	exitCode, err = s.Config.Check(origin, false, true)
 	if err != nil {
@@ -165,19 +388,12 @@ func (s *Storage) Delete(origin string) (exitCode int, err error) {
 	if err != nil {
 		// TEST: TestDeleteInvalidOrigin
 		return globals.ExitOrigin,
-			fmt.Errorf("Invalid origin: %v", err)
-	}
-
-	// TODO: Delete zip files
-	if fstype == globals.ZipFS {
-		// TEST: TestDeleteZipFS
-		return globals.ExitOrigin,
-			fmt.Errorf("Deleting zip files are not support now")
+			newError(ErrInvalid, "Invalid origin: %v", err)
 	}
 
 	tlog.Debug.Printf("Delete existing Filesystem: %s", origin)
 
-	// delete Directory if it's exist
+	// delete Directory (or archive of ZipFS) if it's exist
 	// TODO: check permissions
 	if _, err := os.Stat(originPath); os.IsNotExist(err) {
 		// TODO: what we should done when origin is exist already?
@@ -206,52 +422,211 @@ func (s *Storage) Delete(origin string) (exitCode int, err error) {
 	err = s.Config.DeleteFilesystem(origin)
 	if err != nil {
 		return globals.ExitChangeConf,
-			fmt.Errorf("Problem with deleting Filesystem from Config: %v", err)
-	} else {
-		err = s.Config.Save()
-		if err != nil {
-			return globals.ExitSaveConf,
-				fmt.Errorf("Problem with saving Config: %v", err)
-		}
+			fmt.Errorf("Problem with deleting Filesystem from Config: %w", err)
 	}
 
 	// Removing from Buckets
-	_, ok := s.buckets[origin]
-	if ok {
-		delete(s.buckets, origin)
-	}
+	s.removeBucket(origin)
+
+	// the operations waiting for the lock of the deleted Bucket take the
+	// lock of the new file and find no Bucket
+	os.Remove(s.lockPath(putLockName(origin)))
+	bucketLock.removeLockFile()
 
 	return 0, nil
 }
 
+// Mount mounts the Bucket and serves it until it is unmounted, so it blocks.
+// It's used by the CLI applications that fork itself into the background.
 func (s *Storage) Mount(origin string, notifypid int) (exitCode int, err error) {
-	originPath := s.DirPath + origin
-	fstype, err := s.checkOriginType(originPath)
+	return s.MountWithOptions(origin, notifypid, MountOptions{})
+}
+
+// MountWithOptions is Mount with options, e.g. the password of an encrypted
+// Bucket
+func (s *Storage) MountWithOptions(origin string, notifypid int, opts MountOptions) (exitCode int, err error) {
+	if err = opts.Check(); err != nil {
+		return globals.ExitUsage,
+			newError(ErrInvalid, "Invalid options: %v", err)
+	}
+
+	if exitCode, err = s.checkBucket(origin); err != nil {
+		return
+	}
+
+	// the lock is released by doMount as soon as the Bucket is mounted
+	unlock, exitCode, err := s.lockBucket(origin, LockShared, LockExclusive)
+	if err != nil {
+		return
+	}
+	unlockOnce := &sync.Once{}
+	defer unlockOnce.Do(unlock)
+
+	// TEST: TestMountNotExistingOrigin, TestMountAlreadyMounted
+	exitCode, err = s.Config.Check(origin, false, true)
+	if err != nil {
+		return
+	}
+
+	fstype, originPath, mountpoint, mountpointPath, exitCode, err := s.prepareMount(origin)
+	if err != nil {
+		return
+	}
+
+	masterKey, exitCode, err := s.masterKey(origin, opts.Password)
+	if err != nil {
+		s.abortMount(origin, fstype, mountpointPath)
+		return
+	}
+
+	tlog.Debug.Printf("Mount Filesystem %s into %s", originPath, mountpointPath)
+
+	// Do mounting with options
+	frontendArgs := fusefrontend.Args{
+		OriginDir:   originPath,
+		Type:        fstype,
+		MasterKey:   masterKey,
+		MemoryLimit: opts.MemoryLimit,
+	}
+	session := s.newSession(opts, &frontendArgs)
+	exitCode, err = s.doMount(origin, mountpoint, mountpointPath, frontendArgs,
+		session, notifypid, func() { unlockOnce.Do(unlock) })
+	if exitCode != 0 || err != nil {
+		return exitCode, err
+	}
+
+	// FIXME: Mounting the Bucket
+	s.setMounted(origin, mountpoint)
+
+	return 0, nil
+}
+
+// MountManaged mounts the Bucket and serves it in a goroutine of the current
+// process, so it returns as soon as the mount is ready. It's used by the
+// long-lived daemons (REST, gRPC); they should call Close before exit.
+func (s *Storage) MountManaged(origin string) (exitCode int, err error) {
+	return s.MountManagedWithOptions(origin, MountOptions{})
+}
+
+// MountManagedWithOptions is MountManaged with options, e.g. the password of
+// an encrypted Bucket
+func (s *Storage) MountManagedWithOptions(origin string, opts MountOptions) (exitCode int, err error) {
+	if err = opts.Check(); err != nil {
+		return globals.ExitUsage,
+			newError(ErrInvalid, "Invalid options: %v", err)
+	}
+	if exitCode, err = s.checkBucket(origin); err != nil {
+		return
+	}
+
+	unlock, exitCode, err := s.lockBucket(origin, LockShared, LockExclusive)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	// TEST: TestMountNotExistingOrigin, TestMountAlreadyMounted
+	exitCode, err = s.Config.Check(origin, false, true)
+	if err != nil {
+		return
+	}
+
+	fstype, originPath, mountpoint, mountpointPath, exitCode, err := s.prepareMount(origin)
+	if err != nil {
+		return
+	}
+
+	masterKey, exitCode, err := s.masterKey(origin, opts.Password)
+	if err != nil {
+		s.abortMount(origin, fstype, mountpointPath)
+		return
+	}
+
+	tlog.Debug.Printf("Mount Filesystem %s into %s", originPath, mountpointPath)
+
+	frontendArgs := fusefrontend.Args{
+		OriginDir:   originPath,
+		Type:        fstype,
+		MasterKey:   masterKey,
+		MemoryLimit: opts.MemoryLimit,
+	}
+	session := s.newSession(opts, &frontendArgs)
+	srv, flush, exitCode, err := s.initFuseFrontend(frontendArgs, mountpointPath)
+	if exitCode != 0 || err != nil {
+		s.abortMount(origin, fstype, mountpointPath)
+		return exitCode, err
+	}
+
+	err = s.mounts.start(origin, mountpointPath, srv, func() {
+		// write the in-memory archive of ZipFS back
+		if flush != nil {
+			if err := flush(); err != nil {
+				tlog.Warn.Printf("Writing archive %s failed: %v", originPath, err)
+			}
+		}
+		if session != nil {
+			session.close()
+		}
+		s.setMounted(origin, "")
+	})
+	if err != nil {
+		s.mounts.stop(origin, true)
+		s.abortMount(origin, fstype, mountpointPath)
+		return globals.ExitFuseNewServer,
+			fmt.Errorf("Mounting failed: %w", err)
+	}
+
+	tlog.Debug.Println("Filesystem mounted and ready.")
+
+	err = s.Config.MountFilesystem(origin, mountpoint, mountpointPath, opts.IdleTTL)
+	if err != nil {
+		s.mounts.stop(origin, true)
+		s.abortMount(origin, fstype, mountpointPath)
+		return globals.ExitChangeConf,
+			fmt.Errorf("Problem with adding Filesystem to Config: %w", err)
+	}
+
+	s.setMounted(origin, mountpoint)
+	s.startSession(origin, mountpoint, session)
+
+	return 0, nil
+}
+
+// prepareMount unpacks LZFS archive and creates the mountpoint directory
+func (s *Storage) prepareMount(origin string) (fstype globals.FSType,
+	originPath, mountpoint, mountpointPath string, exitCode int, err error) {
+
+	originPath = s.DirPath + origin
+	fstype, err = s.checkOriginType(originPath)
 	if err != nil {
 		// TEST: TestMountInvalidOrigin
-		return globals.ExitOrigin,
-			fmt.Errorf("Invalid origin: %v", err)
+		return fstype, "", "", "", globals.ExitOrigin,
+			newError(ErrInvalid, "Invalid origin: %v", err)
 	}
 
 	if fstype == globals.LZFS {
-		// unzip to temp directory - s.DirPath + "temp/" + filename (. -> _)
-		tempPath := s.DirPath +
-			"temp/" + strings.Replace(origin, ".", "_", -1)
+		// unpack to temp directory, see lzfsTempPath
+		tempPath := s.lzfsTempPath(origin)
 
-		err = util.UnzipFile(originPath, tempPath)
+		err = util.UnpackArchive(originPath, tempPath)
 		if err != nil {
 			// TEST: TestMountLZFSUnzip
-			return globals.ExitZip,
-				fmt.Errorf("LZFS file unzipping failed: %v", err)
+			return fstype, "", "", "", globals.ExitZip,
+				fmt.Errorf("LZFS archive unpacking failed: %w", err)
 		}
 
 		originPath = tempPath
+
+		// bucket config of LZFS is stored inside the archive
+		if bucket, ok := s.Bucket(origin); ok {
+			bucket.Config.Load()
+		}
 	}
 
 	// TODO: check mountpoint
 	// TODO: HACK - create/get mountpoint internally
-	mountpoint := s.getMountpoint(origin, fstype)
-	mountpointPath := s.DirPath + mountpoint
+	mountpoint = s.getMountpoint(origin, fstype)
+	mountpointPath = s.DirPath + mountpoint
 
 	if _, err := os.Stat(mountpointPath); os.IsNotExist(err) {
 		tlog.Debug.Printf("Create new directory: %s", mountpointPath)
@@ -260,25 +635,63 @@ func (s *Storage) Mount(origin string, notifypid int) (exitCode int, err error)
 		tlog.Warn.Printf("Directory %s is exist already!", mountpointPath)
 	}
 
-	tlog.Debug.Printf("Mount Filesystem %s into %s", originPath, mountpointPath)
+	return fstype, originPath, mountpoint, mountpointPath, 0, nil
+}
 
-	// Do mounting with options
-	exitCode, err = s.doMount(fstype, origin, originPath, mountpoint, mountpointPath, notifypid)
-	if exitCode != 0 || err != nil {
-		//os.Exit(exitCode)
-		return exitCode, err
+// abortMount undoes prepareMount when the Bucket could not be mounted: it
+// removes the empty mountpoint directory and the unpacked LZFS archive, the
+// archive itself is unchanged
+func (s *Storage) abortMount(origin string, fstype globals.FSType, mountpointPath string) {
+	if err := os.Remove(mountpointPath); err != nil && !os.IsNotExist(err) {
+		tlog.Warn.Printf("Removing mountpoint %s failed: %v", mountpointPath, err)
 	}
+	if fstype == globals.LZFS {
+		os.RemoveAll(s.lzfsTempPath(origin))
+	}
+}
 
-	// FIXME: Mounting the Bucket
-	s.buckets[origin].mounted = true
-	s.buckets[origin].MountPoint = mountpoint
+// masterKey unwraps the master key of an encrypted Bucket, it returns nil
+// for unencrypted Buckets. The Bucket config should be loaded already
+// (prepareMount reloads it for LZFS).
+func (s *Storage) masterKey(origin, password string) (masterKey []byte, exitCode int, err error) {
+	bucket, ok := s.Bucket(origin)
+	if !ok || bucket.Config.Encryption == nil {
+		return nil, 0, nil
+	}
+	if password == "" {
+		return nil, globals.ExitPassword,
+			newError(ErrPassword, "Bucket %s is encrypted, password is required", origin)
+	}
 
-	// Don't call os.Exit on success to give deferred functions a chance to
-	// run
-	return 0, nil
+	masterKey, err = bucket.Config.Encryption.DecryptMasterKey(password)
+	if err == cryptocore.ErrWrongPassword {
+		return nil, globals.ExitPassword,
+			newError(ErrPassword, "Wrong password of Bucket %s", origin)
+	}
+	if err != nil {
+		return nil, globals.ExitLoadConf,
+			fmt.Errorf("Problem with unwrapping the key of Bucket %s: %w", origin, err)
+	}
+	return masterKey, 0, nil
 }
 
 func (s *Storage) Unmount(origin string) (exitCode int, err error) {
+	return s.unmount(origin, true)
+}
+
+// unmount unmounts the Bucket, the busy mountpoint of the Bucket served by
+// this process is detached lazily if lazy is set
+func (s *Storage) unmount(origin string, lazy bool) (exitCode int, err error) {
+	if exitCode, err = s.checkBucket(origin); err != nil {
+		return
+	}
+
+	unlock, exitCode, err := s.lockBucket(origin, LockShared, LockExclusive)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
 	// TEST: TestUnmountNotExistingOrigin, TestUnmountNotMounted
 	exitCode, err = s.Config.Check(origin, false, false)
 	if err != nil {
@@ -290,7 +703,7 @@ func (s *Storage) Unmount(origin string) (exitCode int, err error) {
 	if err != nil {
 		// TEST: TestUnmountInvalidOrigin
 		return globals.ExitOrigin,
-			fmt.Errorf("Invalid origin: %v", err)
+			newError(ErrInvalid, "Invalid origin: %v", err)
 	}
 
 	// TODO: check mountpoint
@@ -300,25 +713,31 @@ func (s *Storage) Unmount(origin string) (exitCode int, err error) {
 
 	tlog.Debug.Printf("Unmount Filesystem %s", mountpointPath)
 
-	err = s.doUnmount(mountpointPath)
+	managed, err := s.mounts.stop(origin, lazy)
+	if !managed {
+		err = s.doUnmount(mountpointPath)
+	}
 	if err != nil {
 		// TEST: TestUnmount
 		return globals.ExitMountPoint,
-			fmt.Errorf("doUnmount failed: %v", err)
+			fmt.Errorf("doUnmount failed: %w", err)
 	}
 
 	if fstype == globals.LZFS {
-		// zip temp directory
-		tempPath := s.DirPath +
-			"temp/" + strings.Replace(origin, ".", "_", -1)
-
-		os.Remove(originPath)
-
-		err = util.ZipFile(tempPath, originPath)
+		// pack temp directory, the old archive is replaced on success only
+		tempPath := s.lzfsTempPath(origin)
+
+		// bucket config of LZFS is stored inside the archive, it selects
+		// the codec
+		bucketConfig := NewBucketConfig(origin, tempPath, fstype)
+		if err = bucketConfig.Load(); err != nil {
+			tlog.Warn.Printf("Packing %s with the default codec: %v", origin, err)
+		}
+		err = util.PackArchive(tempPath, originPath, bucketConfig.PackOptions())
 		if err != nil {
 			// TEST: TestUnmountLZFSZip
 			return globals.ExitZip,
-				fmt.Errorf("LZFS file zipping failed: %v", err)
+				fmt.Errorf("LZFS archive packing failed: %w", err)
 		}
 
 		// remove temp directory
@@ -340,23 +759,168 @@ func (s *Storage) Unmount(origin string) (exitCode int, err error) {
 	err = s.Config.UnmountFilesystem(mountpoint)
 	if err != nil {
 		return globals.ExitChangeConf,
-			fmt.Errorf("Problem with unmounting Filesystem from Config: %v", err)
-	} else {
-		err = s.Config.Save()
-		if err != nil {
-			return globals.ExitSaveConf,
-				fmt.Errorf("Problem with saving Config: %v", err)
-		}
+			fmt.Errorf("Problem with unmounting Filesystem from Config: %w", err)
 	}
 
 	// Unmounting the Bucket
-	s.buckets[origin].mounted = false
-	s.buckets[origin].MountPoint = ""
+	s.setMounted(origin, "")
+
+	return 0, nil
+}
+
+// SetAutoMount changes the auto-mount policy of the Bucket, mode
+// AutoMountOff turns it off
+func (s *Storage) SetAutoMount(origin string, policy AutoMountPolicy) (exitCode int, err error) {
+	if err = policy.Check(); err != nil {
+		return globals.ExitUsage,
+			newError(ErrInvalid, "Invalid auto-mount policy: %v", err)
+	}
+	if exitCode, err = s.checkBucket(origin); err != nil {
+		return
+	}
+
+	unlock, exitCode, err := s.lockBucket(origin, LockShared, LockExclusive)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	err = s.Config.SetAutoMount(origin, &policy)
+	if err != nil {
+		return globals.ExitOrigin,
+			fmt.Errorf("Problem with changing auto-mount policy: %w", err)
+	}
+	return 0, nil
+}
+
+// ACL returns the access control list of the Bucket, nil if it has none
+func (s *Storage) ACL(origin string) (acl *BucketACL, exitCode int, err error) {
+	if exitCode, err = s.checkBucket(origin); err != nil {
+		return nil, exitCode, err
+	}
+	fsinfo, _ := s.Config.filesystem(origin)
+	return fsinfo.ACL, 0, nil
+}
+
+// SetACL changes the access control list of the Bucket, nil removes it and
+// leaves the Bucket to admins
+func (s *Storage) SetACL(origin string, acl *BucketACL) (exitCode int, err error) {
+	if acl != nil {
+		if err = acl.Check(); err != nil {
+			return globals.ExitUsage,
+				newError(ErrInvalid, "Invalid ACL: %v", err)
+		}
+	}
+	if exitCode, err = s.checkBucket(origin); err != nil {
+		return
+	}
+
+	unlock, exitCode, err := s.lockBucket(origin, LockShared, LockExclusive)
+	if err != nil {
+		return
+	}
+	defer unlock()
 
+	err = s.Config.SetACL(origin, acl)
+	if err != nil {
+		return globals.ExitOrigin,
+			fmt.Errorf("Problem with changing ACL: %w", err)
+	}
 	return 0, nil
 }
 
-func (s Storage) checkOriginType(origin string) (fstype globals.FSType, err error) {
+// Session returns the idle timeout of the mounted Bucket and the time until
+// it's unmounted. The time is exact for Buckets served by this process, for
+// other Buckets it's computed from the last saved activity.
+func (s *Storage) Session(origin string) (info SessionInfo, exitCode int, err error) {
+	if !validOrigin(origin) {
+		return info, globals.ExitOrigin, errInvalidOrigin(origin)
+	}
+	exitCode, err = s.Config.Check(origin, false, false)
+	if err != nil {
+		return
+	}
+
+	if session := s.session(origin); session != nil {
+		return SessionInfo{
+			IdleTTL:   session.ttl,
+			Remaining: session.remaining(time.Now()),
+		}, 0, nil
+	}
+
+	_, mpinfo, err := s.Config.GetInfoByOrigin(origin)
+	if err != nil {
+		return info, globals.ExitMountPoint,
+			fmt.Errorf("Problem with getting mountpoint of %s: %w", origin, err)
+	}
+	return SessionInfo{
+		IdleTTL:   time.Duration(mpinfo.IdleTTL) * time.Second,
+		Remaining: mpinfo.IdleRemaining(time.Now()),
+	}, 0, nil
+}
+
+// session returns the mount session of the Bucket served by this process,
+// nil if it has none
+func (s *Storage) session(origin string) *mountSession {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if bucket, ok := s.buckets[origin]; ok {
+		return bucket.session
+	}
+	return nil
+}
+
+// newSession creates the mount session if the Bucket has an idle timeout,
+// FUSE activity of the Bucket resets its timer
+func (s *Storage) newSession(opts MountOptions, frontendArgs *fusefrontend.Args) *mountSession {
+	if opts.IdleTTL == 0 {
+		return nil
+	}
+	session := newMountSession(opts.IdleTTL)
+	frontendArgs.OnActivity = session.touch
+	return session
+}
+
+// startSession runs the mount session of the Bucket, the expired session
+// unmounts the Bucket by the normal Unmount path (LZFS archives are packed)
+func (s *Storage) startSession(origin, mountpoint string, session *mountSession) {
+	if session == nil {
+		return
+	}
+	s.mutex.Lock()
+	if bucket, ok := s.buckets[origin]; ok {
+		bucket.session = session
+	}
+	s.mutex.Unlock()
+
+	go session.run(func(last time.Time) {
+		s.Config.queueLastActivity(mountpoint, last)
+	}, func() bool {
+		tlog.Info.Printf("Bucket %s is idle for %v, unmounting", origin, session.ttl)
+		// no lazy unmount: LZFS would be packed while files are still open
+		exitCode, err := s.unmount(origin, false)
+		if err == nil || errors.Is(err, ErrNotMounted) || errors.Is(err, ErrNotFound) {
+			return true
+		}
+		tlog.Warn.Printf("Auto-unmount of %s failed: %v. Exit code: %d. Retrying in %v",
+			origin, err, exitCode, session.ttl)
+		return false
+	})
+}
+
+// Close unmounts all Buckets that are served by the current process
+func (s *Storage) Close() {
+	for _, origin := range s.mounts.origins() {
+		tlog.Info.Printf("Unmounting Bucket: %s", origin)
+		if exitCode, err := s.Unmount(origin); err != nil {
+			tlog.Warn.Printf("Error: %s Exit code: %d", err.Error(), exitCode)
+		}
+	}
+	// the sessions are closed, nothing is queued anymore
+	s.Config.flushActivity()
+}
+
+func (s *Storage) checkOriginType(origin string) (fstype globals.FSType, err error) {
 	fstype, err = s.checkDirOrZip(origin)
 	if err != nil {
 		// HACK: if fstype = globals.HackFS
@@ -369,7 +933,12 @@ func (s Storage) checkOriginType(origin string) (fstype globals.FSType, err erro
 	return fstype, nil
 }
 
-func (s Storage) getMountpoint(origin string, fstype globals.FSType) string {
+// lzfsTempPath returns the directory the LZFS archive is unpacked to
+func (s *Storage) lzfsTempPath(origin string) string {
+	return s.DirPath + lzfsTempDir + "/" + strings.Replace(origin, ".", "_", -1)
+}
+
+func (s *Storage) getMountpoint(origin string, fstype globals.FSType) string {
 	mountpoint := origin
 	if fstype == globals.ZipFS || fstype == globals.LZFS {
 		mountpoint = strings.Replace(mountpoint, ".", "_", -1)
@@ -379,7 +948,7 @@ func (s Storage) getMountpoint(origin string, fstype globals.FSType) string {
 	return mountpoint
 }
 
-func (s Storage) userHomeDir() string {
+func (s *Storage) userHomeDir() string {
 	if runtime.GOOS == "windows" {
 		home := os.Getenv("HOMEDRIVE") + os.Getenv("HOMEPATH")
 		if home == "" {
@@ -391,26 +960,9 @@ func (s Storage) userHomeDir() string {
 }
 
 // TEST: TestUtilCheckDirOrZip
-func (s Storage) checkDirOrZip(dirOrZip string) (globals.FSType, error) {
-	// check on zip/tar archive
-	zips := map[string]int{
-		".zip":     0,
-		".tar":     1,
-		".tar.gz":  2,
-		".tar.bz2": 3,
-	}
-
-	getExt := func(file string) string {
-		base := filepath.Base(file)
-		idx := strings.Index(base, ".")
-		if idx == -1 {
-			return ""
-		}
-		return base[idx:]
-	}
-	ext := getExt(dirOrZip)
-	_, ok := zips[ext]
-	if ok {
+func (s *Storage) checkDirOrZip(dirOrZip string) (globals.FSType, error) {
+	// check on zip/tar archive, see util.ArchiveExtensions
+	if util.IsArchive(dirOrZip) {
 		isZipFS := func(file string) bool {
 			base := filepath.Base(file)
 			return base[0] == '_'
@@ -422,7 +974,15 @@ func (s Storage) checkDirOrZip(dirOrZip string) (globals.FSType, error) {
 	}
 
 	// check for existing another extension
-	if ext != "" {
+	getExt := func(file string) string {
+		base := filepath.Base(file)
+		idx := strings.Index(base, ".")
+		if idx == -1 {
+			return ""
+		}
+		return base[idx:]
+	}
+	if ext := getExt(dirOrZip); ext != "" {
 		return globals.NoneFS,
 			fmt.Errorf("%s isn't a directory and isn't a zip file",
 				dirOrZip)
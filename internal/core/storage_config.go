@@ -1,20 +1,20 @@
 package core
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"bitbucket.org/udt/wizefs/internal/globals"
 	"bitbucket.org/udt/wizefs/internal/tlog"
 )
 
 const (
-	StorageConfigVersion  = 1
+	StorageConfigVersion = 1
+	// StorageConfigFilename is the JSON config of the older versions, it's
+	// migrated into MetadataStoreFilename
 	StorageConfigFilename = "wizedb.conf"
 )
 
@@ -22,22 +22,56 @@ type FilesystemInfo struct {
 	OriginPath    string         `json:"originpath"`
 	Type          globals.FSType `json:"type"`
 	MountpointKey string         `json:"mountpoint"`
+	// AutoMount lets file operations use the Bucket that is not mounted,
+	// nil - they fail
+	AutoMount *AutoMountPolicy `json:"automount,omitempty"`
+	// Created is the unix time the Bucket was created, 0 for Buckets created
+	// before it was recorded
+	Created int64 `json:"created,omitempty"`
+	// ACL grants the principals of the REST service access to the Bucket,
+	// nil - only admins have access
+	ACL *BucketACL `json:"acl,omitempty"`
 }
 
 type MountpointInfo struct {
 	MountpointPath string `json:"mountpointpath"`
 	OriginKey      string `json:"origin"`
+	// IdleTTL is the idle timeout of the mount session in seconds,
+	// 0 - the Bucket is never unmounted automatically
+	IdleTTL int64 `json:"idlettl,omitempty"`
+	// LastActivity is the Unix time of the last saved FUSE activity
+	LastActivity int64 `json:"lastactivity,omitempty"`
 }
 
+// IdleRemaining returns the time until the idle Bucket is unmounted, it's
+// computed from the last saved activity
+func (mpi MountpointInfo) IdleRemaining(now time.Time) time.Duration {
+	if mpi.IdleTTL == 0 {
+		return 0
+	}
+	expires := time.Unix(mpi.LastActivity+mpi.IdleTTL, 0)
+	if now.After(expires) {
+		return 0
+	}
+	return expires.Sub(now)
+}
+
+// StorageConfig keeps the Buckets of the Storage and their mountpoints in
+// the MetadataStore. Filesystems and Mountpoints are the snapshot of the
+// store made by the last Load or change, they are replaced as a whole under
+// mutex and never changed in place, so they are read through Snapshot.
 type StorageConfig struct {
-	// header
+	Filesystems map[string]FilesystemInfo
+	Mountpoints map[string]MountpointInfo
 
-	// filesystems
-	Filesystems map[string]FilesystemInfo `json:"created"`
-	Mountpoints map[string]MountpointInfo `json:"mounted"`
+	store MetadataStore
+	mutex sync.Mutex
 
-	filename string
-	mutex    sync.Mutex
+	// activity are the last activities of the mount sessions waiting for
+	// flushActivity, it saves all of them in one transaction
+	activityMutex sync.Mutex
+	activity      map[string]time.Time
+	activityTimer *time.Timer
 }
 
 // TEST: TestWizeConfigMake
@@ -45,7 +79,8 @@ func NewStorageConfig(path string) *StorageConfig {
 	if path == "" {
 		exe, err := os.Executable()
 		if err != nil {
-			panic(err)
+			tlog.Warn.Printf("Config is kept in the current directory: %v", err)
+			exe = "."
 		}
 		path = filepath.Dir(exe)
 	}
@@ -53,177 +88,344 @@ func NewStorageConfig(path string) *StorageConfig {
 	return &StorageConfig{
 		Filesystems: make(map[string]FilesystemInfo),
 		Mountpoints: make(map[string]MountpointInfo),
-		filename:    filepath.Join(path, StorageConfigFilename),
+		store:       NewMetadataStore(path),
 	}
 }
 
+// CreateFilesystem adds the Bucket, autoMount is its auto-mount policy,
+// nil - it's off
 // TESTS: TestWizeConfig* (several tests)
-func (wc *StorageConfig) CreateFilesystem(origin, originPath string, itype globals.FSType) error {
-	// HACK: this fixed problems with gRPC methods (and GUI?)
-	wc.Load()
-
-	_, ok := wc.Filesystems[origin]
-	if ok {
-		return fmt.Errorf("This filesystem is already added!")
-	}
-
-	wc.Filesystems[origin] = FilesystemInfo{
-		OriginPath:    originPath,
-		Type:          itype,
-		MountpointKey: "",
-	}
-
-	tlog.Debug.Println("Add filesystem to the created map! ", wc)
+func (wc *StorageConfig) CreateFilesystem(origin, originPath string, itype globals.FSType,
+	autoMount *AutoMountPolicy) error {
+	return wc.update(func(tx MetadataTx) error {
+		_, ok, err := tx.Filesystem(origin)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return newError(ErrAlreadyExists, "This filesystem is already added!")
+		}
 
-	return nil
+		if autoMount != nil && autoMount.Mode == AutoMountOff {
+			autoMount = nil
+		}
+		tlog.Debug.Printf("Add filesystem %s to the created map!", origin)
+		return tx.PutFilesystem(origin, FilesystemInfo{
+			OriginPath:    originPath,
+			Type:          itype,
+			MountpointKey: "",
+			AutoMount:     autoMount,
+			Created:       time.Now().Unix(),
+		})
+	})
 }
 
 func (wc *StorageConfig) DeleteFilesystem(origin string) error {
-	// HACK: this fixed problems with gRPC methods (and GUI?)
-	wc.Load()
+	return wc.update(func(tx MetadataTx) error {
+		_, ok, err := tx.Filesystem(origin)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return newError(ErrNotFound, "This filesystem is absent!")
+		}
 
-	_, ok := wc.Filesystems[origin]
-	if !ok {
-		return fmt.Errorf("This filesystem is absent!")
-	}
+		tlog.Debug.Printf("Delete filesystem %s from the created map!", origin)
+		return tx.DeleteFilesystem(origin)
+	})
+}
 
-	delete(wc.Filesystems, origin)
+// MountFilesystem adds the mountpoint, idleTTL is the idle timeout of the
+// mount session, 0 - no session
+func (wc *StorageConfig) MountFilesystem(origin, mountpoint, mountpointpath string,
+	idleTTL time.Duration) error {
+	return wc.update(func(tx MetadataTx) error {
+		_, ok, err := tx.Mountpoint(mountpoint)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return newError(ErrMounted, "This filesystem is already mounted!")
+		}
+		fsi, ok, err := tx.Filesystem(origin)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return newError(ErrNotFound, "This filesystem is absent!")
+		}
 
-	tlog.Debug.Println("Delete filesystem from the created map! ", wc)
+		mpi := MountpointInfo{
+			MountpointPath: mountpointpath,
+			OriginKey:      origin,
+		}
+		if idleTTL > 0 {
+			mpi.IdleTTL = int64(idleTTL / time.Second)
+			mpi.LastActivity = time.Now().Unix()
+		}
+		if err = tx.PutMountpoint(mountpoint, mpi); err != nil {
+			return err
+		}
 
-	return nil
+		fsi.MountpointKey = mountpoint
+		tlog.Debug.Printf("Add filesystem %s to the mounted map!", origin)
+		return tx.PutFilesystem(origin, fsi)
+	})
 }
 
-func (wc *StorageConfig) MountFilesystem(origin, mountpoint, mountpointpath string) error {
-	// HACK: this fixed problems with gRPC methods (and GUI?)
-	// HACK2: for gRPC/Mount we don't need to Load() config, because it works via mount CLI app
-	//wc.Load()
+// SetAutoMount changes the auto-mount policy of the Bucket, nil turns it off
+func (wc *StorageConfig) SetAutoMount(origin string, policy *AutoMountPolicy) error {
+	return wc.update(func(tx MetadataTx) error {
+		fsi, ok, err := tx.Filesystem(origin)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return newError(ErrNotFound, "This filesystem is absent!")
+		}
+		if policy != nil && policy.Mode == AutoMountOff {
+			policy = nil
+		}
+		fsi.AutoMount = policy
+		return tx.PutFilesystem(origin, fsi)
+	})
+}
 
-	_, ok := wc.Mountpoints[mountpoint]
-	if ok {
-		return fmt.Errorf("This filesystem is already mounted!")
-	}
+// SetACL changes the access control list of the Bucket, nil removes it
+func (wc *StorageConfig) SetACL(origin string, acl *BucketACL) error {
+	return wc.update(func(tx MetadataTx) error {
+		fsi, ok, err := tx.Filesystem(origin)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return newError(ErrNotFound, "This filesystem is absent!")
+		}
+		fsi.ACL = acl
+		return tx.PutFilesystem(origin, fsi)
+	})
+}
 
-	wc.Mountpoints[mountpoint] = MountpointInfo{
-		MountpointPath: mountpointpath,
-		OriginKey:      origin,
+// queueLastActivity saves the time of the last FUSE activity of the mount
+// session later, together with the other sessions of the process: every
+// transaction opens and locks the store (see boltStore), so the sessions
+// don't take it one by one
+// TEST: TestStorageConfigActivityBatch
+func (wc *StorageConfig) queueLastActivity(mountpoint string, last time.Time) {
+	wc.activityMutex.Lock()
+	defer wc.activityMutex.Unlock()
+
+	if wc.activity == nil {
+		wc.activity = make(map[string]time.Time)
 	}
-
-	fsi := wc.Filesystems[origin]
-	wc.Filesystems[origin] = FilesystemInfo{
-		OriginPath:    fsi.OriginPath,
-		Type:          fsi.Type,
-		MountpointKey: mountpoint,
+	wc.activity[mountpoint] = last
+	if wc.activityTimer == nil {
+		wc.activityTimer = time.AfterFunc(sessionSaveInterval, wc.flushActivity)
 	}
-
-	tlog.Debug.Println("Add filesystem to the mounted map! ", wc)
-
-	return nil
 }
 
-func (wc *StorageConfig) UnmountFilesystem(mountpoint string) error {
-	// HACK: this fixed problems with gRPC methods (and GUI?)
-	wc.Load()
-
-	mpi, ok := wc.Mountpoints[mountpoint]
-	if !ok {
-		return fmt.Errorf("This filesystem is not mounted!")
+// flushActivity saves the queued activities, the mountpoints that are not
+// mounted anymore are skipped
+func (wc *StorageConfig) flushActivity() {
+	wc.activityMutex.Lock()
+	activity := wc.activity
+	wc.activity = nil
+	if wc.activityTimer != nil {
+		wc.activityTimer.Stop()
+		wc.activityTimer = nil
 	}
+	wc.activityMutex.Unlock()
 
-	origin := mpi.OriginKey
-	delete(wc.Mountpoints, mountpoint)
-
-	fsi := wc.Filesystems[origin]
-	wc.Filesystems[origin] = FilesystemInfo{
-		OriginPath:    fsi.OriginPath,
-		Type:          fsi.Type,
-		MountpointKey: "",
+	if len(activity) == 0 {
+		return
+	}
+	err := wc.update(func(tx MetadataTx) error {
+		for mountpoint, last := range activity {
+			mpi, ok, err := tx.Mountpoint(mountpoint)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			mpi.LastActivity = last.Unix()
+			if err = tx.PutMountpoint(mountpoint, mpi); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		tlog.Warn.Printf("Problem with saving activity of %d mounts: %v", len(activity), err)
 	}
+}
 
-	tlog.Debug.Println("Delete filesystem from the mounted map! ", wc)
+func (wc *StorageConfig) UnmountFilesystem(mountpoint string) error {
+	return wc.update(func(tx MetadataTx) error {
+		mpi, ok, err := tx.Mountpoint(mountpoint)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return newError(ErrNotMounted, "This filesystem is not mounted!")
+		}
+		if err = tx.DeleteMountpoint(mountpoint); err != nil {
+			return err
+		}
 
-	return nil
+		origin := mpi.OriginKey
+		fsi, ok, err := tx.Filesystem(origin)
+		if err != nil || !ok {
+			return err
+		}
+		fsi.MountpointKey = ""
+		tlog.Debug.Printf("Delete filesystem %s from the mounted map!", origin)
+		return tx.PutFilesystem(origin, fsi)
+	})
 }
 
-func (wc *StorageConfig) Save() error {
-	wc.mutex.Lock()
-	defer wc.mutex.Unlock()
+// ResetMount removes the mountpoints of the Bucket from the config. Unlike
+// UnmountFilesystem it doesn't fail if the entries are inconsistent, e.g.
+// the mountpoint is missing.
+func (wc *StorageConfig) ResetMount(origin string) error {
+	return wc.update(func(tx MetadataTx) error {
+		mountpoints, err := tx.Mountpoints()
+		if err != nil {
+			return err
+		}
+		for mountpoint, mpi := range mountpoints {
+			if mpi.OriginKey != origin {
+				continue
+			}
+			if err = tx.DeleteMountpoint(mountpoint); err != nil {
+				return err
+			}
+		}
 
-	tmp := wc.filename + ".tmp"
-	// 0400 permissions: wizefs.conf should be kept secret and never be written to.
-	//fd, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
-	// temporary solution
-	fd, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
-	if err != nil {
+		fsi, ok, err := tx.Filesystem(origin)
+		if err != nil || !ok {
+			return err
+		}
+		if fsi.MountpointKey != "" {
+			if err = tx.DeleteMountpoint(fsi.MountpointKey); err != nil {
+				return err
+			}
+		}
+		fsi.MountpointKey = ""
+		return tx.PutFilesystem(origin, fsi)
+	})
+}
+
+// Load reads the snapshot of the Buckets from the store
+func (wc *StorageConfig) Load() error {
+	var filesystems map[string]FilesystemInfo
+	var mountpoints map[string]MountpointInfo
+	err := wc.store.View(func(tx MetadataTx) (err error) {
+		filesystems, mountpoints, err = readSnapshot(tx)
 		return err
-	}
-	js, err := json.MarshalIndent(wc, "", "\t")
+	})
 	if err != nil {
 		return err
 	}
-	// For convenience for the user, add a newline at the end.
-	js = append(js, '\n')
-	_, err = fd.Write(js)
+	wc.setSnapshot(filesystems, mountpoints)
+	return nil
+}
+
+// update runs fn in the Update transaction of the store and replaces the
+// snapshot with the result. The snapshot is replaced after the commit, so
+// it never shows a change that was rolled back.
+func (wc *StorageConfig) update(fn func(tx MetadataTx) error) error {
+	var filesystems map[string]FilesystemInfo
+	var mountpoints map[string]MountpointInfo
+	err := wc.store.Update(func(tx MetadataTx) (err error) {
+		if err = fn(tx); err != nil {
+			return err
+		}
+		filesystems, mountpoints, err = readSnapshot(tx)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	err = fd.Sync()
+	wc.setSnapshot(filesystems, mountpoints)
+	return nil
+}
+
+func readSnapshot(tx MetadataTx) (filesystems map[string]FilesystemInfo,
+	mountpoints map[string]MountpointInfo, err error) {
+	filesystems, err = tx.Filesystems()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	err = fd.Close()
+	mountpoints, err = tx.Mountpoints()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	err = os.Rename(tmp, wc.filename)
-	return err
+	return filesystems, mountpoints, nil
 }
 
-func (wc *StorageConfig) Load() error {
+func (wc *StorageConfig) setSnapshot(filesystems map[string]FilesystemInfo,
+	mountpoints map[string]MountpointInfo) {
 	wc.mutex.Lock()
 	defer wc.mutex.Unlock()
+	wc.Filesystems = filesystems
+	wc.Mountpoints = mountpoints
+}
 
-	// Read from disk
-	js, err := ioutil.ReadFile(wc.filename)
-	if err != nil {
-		//tlog.Warn.Printf("Load config file: ReadFile: %v, %s\n", err, err.Error())
-		return err
-	}
+// Snapshot returns Filesystems and Mountpoints of the last Load or change.
+// The maps may be read after the next Load, but they must not be changed.
+func (wc *StorageConfig) Snapshot() (filesystems map[string]FilesystemInfo,
+	mountpoints map[string]MountpointInfo) {
+	wc.mutex.Lock()
+	defer wc.mutex.Unlock()
+	return wc.Filesystems, wc.Mountpoints
+}
 
-	wc.clear()
+// filesystem returns the Bucket from the snapshot
+func (wc *StorageConfig) filesystem(origin string) (fsinfo FilesystemInfo, ok bool) {
+	filesystems, _ := wc.Snapshot()
+	fsinfo, ok = filesystems[origin]
+	return fsinfo, ok
+}
 
-	// Unmarshal
-	err = json.Unmarshal(js, &wc)
-	if err != nil {
-		tlog.Warn.Printf("Failed to unmarshal config file")
-		return err
+// load is Load with the error of the Storage operations
+func (wc *StorageConfig) load() error {
+	if err := wc.Load(); err != nil {
+		return fmt.Errorf("Problem with loading Config: %w", err)
 	}
-
 	return nil
 }
 
 func (wc *StorageConfig) CheckOriginGetMountpoint(origin string) (mountpointPath string, err error) {
-	// HACK: this fixed problems with gRPC methods (and GUI?)
-	wc.Load()
+	if err = wc.load(); err != nil {
+		return "", err
+	}
+	return wc.mountpointPath(origin)
+}
+
+// mountpointPath is CheckOriginGetMountpoint on the snapshot, it's used
+// right after Check loaded it
+func (wc *StorageConfig) mountpointPath(origin string) (mountpointPath string, err error) {
+	filesystems, mountpoints := wc.Snapshot()
 
 	var ok bool
 	var fsinfo FilesystemInfo
-	fsinfo, ok = wc.Filesystems[origin]
+	fsinfo, ok = filesystems[origin]
 	if !ok {
 		tlog.Warn.Printf("Filesystem %s is not exist!", origin)
-		return "", errors.New("Filesystem is not exist!")
+		return "", newError(ErrNotFound, "Filesystem is not exist!")
 	}
 
 	if fsinfo.MountpointKey == "" {
 		tlog.Warn.Printf("Filesystem %s is not mounted!", origin)
-		return "", errors.New("Filesystem is not mounted!")
+		return "", newError(ErrNotMounted, "Filesystem is not mounted!")
 	}
 
 	var mpinfo MountpointInfo
-	mpinfo, ok = wc.Mountpoints[fsinfo.MountpointKey]
+	mpinfo, ok = mountpoints[fsinfo.MountpointKey]
 	if !ok {
 		tlog.Warn.Printf("Mounted filesystem %s is not exist!", fsinfo.MountpointKey)
-		return "", errors.New("Mounted filesystem is not exist!")
+		return "", newError(ErrNotMounted, "Mounted filesystem is not exist!")
 	}
 
 	mountpointPath = mpinfo.MountpointPath
@@ -232,33 +434,38 @@ func (wc *StorageConfig) CheckOriginGetMountpoint(origin string) (mountpointPath
 	return mountpointPath, nil
 }
 
+// Check loads the snapshot and checks the state of the Bucket. It's called
+// under the Bucket lock, so it sees the changes made by other processes and
+// they can't change the Bucket anymore.
 func (wc *StorageConfig) Check(origin string, shouldFindOrigin, shouldMounted bool) (extCode int, err error) {
-	wc.Load()
+	if err = wc.load(); err != nil {
+		return globals.ExitLoadConf, err
+	}
 
 	existOrigin, existMountpoint := wc.checkFilesystem(origin)
 
 	if shouldFindOrigin {
 		if existOrigin {
 			return globals.ExitOrigin,
-				fmt.Errorf("ORIGIN: %s is already exist in common config.", origin)
+				newError(ErrAlreadyExists, "ORIGIN: %s is already exist in common config.", origin)
 		}
 	} else {
 		if !existOrigin {
 			return globals.ExitOrigin,
-				fmt.Errorf("Did not find ORIGIN: %s in common config.", origin)
+				newError(ErrNotFound, "Did not find ORIGIN: %s in common config.", origin)
 		}
 	}
 
 	if shouldMounted {
 		if existMountpoint {
 			return globals.ExitMountPoint,
-				fmt.Errorf("This ORIGIN: %s is already mounted", origin)
+				newError(ErrMounted, "This ORIGIN: %s is already mounted", origin)
 		}
 	} else {
 		if !existMountpoint {
 			// TEST: TestUnmountNotMounted
 			return globals.ExitMountPoint,
-				fmt.Errorf("This ORIGIN: %s is not mounted yet", origin)
+				newError(ErrNotMounted, "This ORIGIN: %s is not mounted yet", origin)
 		}
 	}
 
@@ -267,37 +474,29 @@ func (wc *StorageConfig) Check(origin string, shouldFindOrigin, shouldMounted bo
 
 // just for WizeFS UI app
 func (wc *StorageConfig) GetInfoByOrigin(origin string) (fsinfo FilesystemInfo, mpinfo MountpointInfo, err error) {
-	// HACK: this fixed problems with gRPC methods (and GUI?)
-	wc.Load()
+	if err = wc.load(); err != nil {
+		return FilesystemInfo{}, MountpointInfo{}, err
+	}
+	filesystems, mountpoints := wc.Snapshot()
 
-	fsinfo, ok := wc.Filesystems[origin]
+	fsinfo, ok := filesystems[origin]
 	if !ok {
 		tlog.Warn.Printf("Filesystem %s is not exist!", origin)
-		return FilesystemInfo{}, MountpointInfo{}, errors.New("Filesystem is not exist!")
+		return FilesystemInfo{}, MountpointInfo{}, newError(ErrNotFound, "Filesystem is not exist!")
 	}
 
-	mpinfo, ok = wc.Mountpoints[fsinfo.MountpointKey]
+	mpinfo, ok = mountpoints[fsinfo.MountpointKey]
 	if !ok {
 		tlog.Warn.Printf("Mounted filesystem %s is not exist!", fsinfo.MountpointKey)
-		return fsinfo, MountpointInfo{}, errors.New("Mounted filesystem is not exist!")
+		return fsinfo, MountpointInfo{}, newError(ErrNotMounted, "Mounted filesystem is not exist!")
 	}
 
 	return fsinfo, mpinfo, nil
 }
 
-func (wc *StorageConfig) clear() {
-	// Just clear WizeConfig maps
-	for k := range wc.Filesystems {
-		delete(wc.Filesystems, k)
-	}
-	for k := range wc.Mountpoints {
-		delete(wc.Mountpoints, k)
-	}
-}
-
 func (wc *StorageConfig) checkFilesystem(origin string) (existOrigin bool, existMountpoint bool) {
 	existMountpoint = false
-	fsinfo, existOrigin := wc.Filesystems[origin]
+	fsinfo, existOrigin := wc.filesystem(origin)
 	if existOrigin {
 		if fsinfo.MountpointKey != "" {
 			existMountpoint = true
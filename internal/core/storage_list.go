@@ -0,0 +1,110 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+// BucketInfo describes a Bucket of the Storage
+type BucketInfo struct {
+	Origin string         `json:"origin"`
+	Type   globals.FSType `json:"type"`
+	// OriginPath is the directory or the archive of the Bucket
+	OriginPath string `json:"originpath"`
+	Mounted    bool   `json:"mounted"`
+	Mountpoint string `json:"mountpoint,omitempty"`
+	// Size is the size of the origin on disk in bytes
+	Size int64 `json:"size"`
+	// FileCount is the number of files inside the Bucket, -1 if it's unknown
+	// because the Bucket must be mounted to count them
+	FileCount int       `json:"filecount"`
+	Created   time.Time `json:"created"`
+	// ACL is the access control list of the REST service, nil - only admins
+	// have access
+	ACL *BucketACL `json:"acl,omitempty"`
+}
+
+// List returns the description of every Bucket of the Storage sorted by
+// origin. Problems with a single Bucket are reported as unknown values,
+// they don't fail the whole listing.
+// TEST: TestStorageList
+func (s *Storage) List() (buckets []BucketInfo, exitCode int, err error) {
+	// Buckets are not created or deleted while they are listed
+	storageLock, exitCode, err := s.lock(storageLockName, LockShared)
+	if err != nil {
+		return
+	}
+	defer storageLock.unlock()
+
+	// HACK: this fixed problems with gRPC methods (and GUI?)
+	s.Config.Load()
+
+	filesystems, mountpoints := s.Config.Snapshot()
+	for origin, fsinfo := range filesystems {
+		info := BucketInfo{
+			Origin:     origin,
+			Type:       fsinfo.Type,
+			OriginPath: fsinfo.OriginPath,
+			FileCount:  -1,
+			ACL:        fsinfo.ACL,
+		}
+		if fsinfo.Type == globals.LZFS {
+			// OriginPath of LZFS is the temp directory the archive is
+			// unpacked to
+			info.OriginPath = s.DirPath + origin
+		}
+		info.Size = diskUsage(info.OriginPath)
+		if fsinfo.Created != 0 {
+			info.Created = time.Unix(fsinfo.Created, 0)
+		} else if fi, err := os.Stat(info.OriginPath); err == nil {
+			info.Created = fi.ModTime()
+		}
+
+		var root bucketFS
+		if mpi, ok := mountpoints[fsinfo.MountpointKey]; ok && fsinfo.MountpointKey != "" {
+			info.Mounted = true
+			info.Mountpoint = mpi.MountpointPath
+			root = s.mountFS(origin, mpi.MountpointPath)
+		} else if bucket, ok := s.Bucket(origin); ok && fsinfo.Type == globals.LoopbackFS &&
+			bucket.Config != nil && bucket.Config.Encryption == nil {
+			root = dirFS(fsinfo.OriginPath)
+		}
+		if root != nil {
+			info.FileCount = countFiles(root)
+		}
+
+		buckets = append(buckets, info)
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Origin < buckets[j].Origin })
+	return buckets, 0, nil
+}
+
+// diskUsage returns the size of the regular files under path
+func diskUsage(path string) (size int64) {
+	filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// countFiles returns the number of files of the Bucket, -1 on errors
+func countFiles(root bucketFS) (count int) {
+	all, err := walkBucket(root)
+	if err != nil {
+		return -1
+	}
+	for _, info := range all {
+		if !info.IsDir {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+func TestStorageList(t *testing.T) {
+	bucket, originPath := newTestBucket(t)
+	setAutoMount(t, bucket, AutoMountDirect)
+
+	bucket.PutFile("a.txt", []byte("content"))
+	bucket.PutFile("docs/b.txt", []byte("content"))
+
+	buckets, _, err := bucket.storage.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 {
+		t.Fatalf("Got %d Buckets, want 1", len(buckets))
+	}
+	info := buckets[0]
+	if info.Origin != "ORIGIN" || info.Type != globals.LoopbackFS ||
+		info.OriginPath != originPath || info.Mounted || info.Mountpoint != "" {
+		t.Errorf("Unexpected Bucket info %+v", info)
+	}
+	if info.FileCount != 2 || info.Size < int64(2*len("content")) {
+		t.Errorf("FileCount = %d, Size = %d; want 2 files of at least %d bytes",
+			info.FileCount, info.Size, 2*len("content"))
+	}
+	if time.Since(info.Created) > time.Minute {
+		t.Errorf("Created = %v, want about now", info.Created)
+	}
+
+	// files of an encrypted Bucket can't be counted without mounting it
+	bucket.Config.Encryption = &BucketEncryption{}
+	if buckets, _, _ := bucket.storage.List(); buckets[0].FileCount != -1 {
+		t.Errorf("FileCount of encrypted Bucket = %d, want -1", buckets[0].FileCount)
+	}
+}
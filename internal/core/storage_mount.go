@@ -1,21 +1,18 @@
 package core
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
-	"path"
 	"runtime"
 	"runtime/debug"
 	"syscall"
 	"time"
 
-	"github.com/hanwen/go-fuse/fuse"
-	"github.com/hanwen/go-fuse/fuse/nodefs"
 	"github.com/hanwen/go-fuse/fuse/pathfs"
-	"github.com/hanwen/go-fuse/zipfs"
 
 	"bitbucket.org/udt/wizefs/internal/fusefrontend"
 	"bitbucket.org/udt/wizefs/internal/globals"
@@ -25,14 +22,19 @@ import (
 
 // DoMount mounts an directory.
 // Called from main.
-func (s *Storage) doMount(fstype globals.FSType,
-	origin, originPath, mountpoint, mountpointPath string,
-	notifypid int) (exitCode int, err error) {
+// session is nil if the Bucket has no idle timeout, unlock releases the
+// Bucket lock when the Bucket is mounted and added to the config.
+// The errors are returned, the process is never terminated: the mount may
+// run inside the REST or gRPC daemon.
+func (s *Storage) doMount(origin, mountpoint, mountpointPath string,
+	frontendArgs fusefrontend.Args, session *mountSession, notifypid int,
+	unlock func()) (exitCode int, err error) {
 
 	// Initialize FUSE server
-	srv, exitCode, err := s.initFuseFrontend(fstype, originPath, mountpointPath)
+	srv, flush, exitCode, err := s.initFuseFrontend(frontendArgs, mountpointPath)
 	if exitCode != 0 || err != nil {
-
+		s.abortMount(origin, frontendArgs.Type, mountpointPath)
+		return exitCode, err
 	}
 
 	tlog.Debug.Println("Filesystem mounted and ready.")
@@ -43,23 +45,37 @@ func (s *Storage) doMount(fstype globals.FSType,
 		//} else {
 		//	config.CommonConfig.Load()
 	}
-	err = s.Config.MountFilesystem(origin, mountpoint, mountpointPath)
+	idleTTL := time.Duration(0)
+	if session != nil {
+		idleTTL = session.ttl
+	}
+	err = s.Config.MountFilesystem(origin, mountpoint, mountpointPath, idleTTL)
 	if err != nil {
-		tlog.Warn.Printf("Problem with adding Filesystem to Config: %v", err)
-	} else {
-		err = s.Config.Save()
-		if err != nil {
-			tlog.Warn.Printf("Problem with saving Config: %v", err)
+		// the Bucket is not served if the config doesn't record it, like
+		// in MountManagedWithOptions
+		if err2 := srv.Unmount(); err2 != nil {
+			tlog.Warn.Printf("Unmount %s: %v", mountpointPath, err2)
+			if err2 = s.lazyUnmount(mountpointPath); err2 != nil {
+				tlog.Warn.Printf("Lazy unmount %s: %v", mountpointPath, err2)
+			}
 		}
+		s.abortMount(origin, frontendArgs.Type, mountpointPath)
+		return globals.ExitChangeConf,
+			fmt.Errorf("Problem with adding Filesystem to Config: %w", err)
 	}
 
 	tlog.Info.Printf("Filesystem added to configuration.")
 
 	// FIXME: Mounting the Bucket
-	s.buckets[origin].mounted = true
-	s.buckets[origin].MountPoint = mountpoint
+	s.setMounted(origin, mountpoint)
+
+	tlog.Info.Printf("Bucket %s is mounted at %s\n", origin, mountpoint)
 
-	tlog.Info.Printf("Bucket: %+v\n", s.buckets[origin])
+	// the Bucket is served until it's unmounted, the lock would block
+	// the file operations and Unmount
+	unlock()
+
+	s.startSession(origin, mountpoint, session)
 
 	// We have been forked into the background, as evidenced by the set
 	// "notifypid".
@@ -89,7 +105,7 @@ func (s *Storage) doMount(fstype globals.FSType,
 	// Wait for SIGINT in the background and unmount ourselves if we get it.
 	// This prevents a dangling "Transport endpoint is not connected"
 	// mountpoint if the user hits CTRL-C.
-	s.handleSigint(srv, mountpointPath)
+	stopSigint := s.handleSigint(srv, mountpointPath)
 
 	// TODO: remove this?
 	// Return memory that was allocated for scrypt (64M by default!) and other
@@ -98,6 +114,26 @@ func (s *Storage) doMount(fstype globals.FSType,
 
 	// Jump into server loop. Returns when it gets an umount request from the kernel.
 	srv.Serve()
+	interrupted := stopSigint()
+
+	// the expired session unmounts the Bucket in its goroutine, wait until
+	// it's finished (e.g. LZFS archive is packed)
+	if session != nil {
+		session.close()
+		session.wait()
+	}
+
+	// write the in-memory archive of ZipFS back
+	if flush != nil {
+		if err = flush(); err != nil {
+			return globals.ExitZip,
+				fmt.Errorf("Writing archive %s failed: %w", frontendArgs.OriginDir, err)
+		}
+	}
+	if interrupted {
+		return globals.ExitSigInt,
+			fmt.Errorf("Bucket %s was unmounted by a signal", origin)
+	}
 	return 0, nil
 }
 
@@ -126,6 +162,23 @@ func (s *Storage) unmountErr(dir string) error {
 	return cmd.Run()
 }
 
+// lazyUnmount detaches "dir" even if it's busy or its FUSE server is dead,
+// the output of the command is returned in the error
+func (s *Storage) lazyUnmount(dir string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("umount", "-f", dir)
+	} else {
+		cmd = exec.Command("fusermount", "-u", "-z", dir)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return err
+}
+
 // setOpenFileLimit tries to increase the open file limit to 4096 (the default hard
 // limit on Linux).
 func (s *Storage) setOpenFileLimit() {
@@ -147,132 +200,102 @@ func (s *Storage) setOpenFileLimit() {
 }
 
 // initFuseFrontend - initialize wizefs/fusefrontend
-// Calls os.Exit on errors
-func (s *Storage) initFuseFrontend(fstype globals.FSType, originPath, mountpointPath string) (*fuse.Server, int, error) {
-	// Reconciliate CLI and config file arguments into a fusefrontend.Args struct
-	// that is passed to the filesystem implementation
-	frontendArgs := fusefrontend.Args{
-		OriginDir: originPath,
-		Type:      fstype,
-	}
+// flush is nil unless the filesystem should be written back after the server
+// loop exits (ZipFS)
+func (s *Storage) initFuseFrontend(frontendArgs fusefrontend.Args, mountpointPath string) (
+	srv mountedServer, flush func() error, exitCode int, err error) {
 
 	jsonBytes, _ := json.MarshalIndent(frontendArgs, "", "\t")
 	tlog.Debug.Printf("frontendArgs: %s", string(jsonBytes))
 
 	// Prepare root
-	root := s.prepareRoot(frontendArgs)
-	if root == nil {
-		//os.Exit(globals.ExitType)
-		return nil, globals.ExitType, nil
-	}
-
-	fuseOpts := &nodefs.Options{
-		// These options are to be compatible with libfuse defaults,
-		// making benchmarking easier.
-		NegativeTimeout: time.Second,
-		AttrTimeout:     time.Second,
-		EntryTimeout:    time.Second,
-		Debug:           true,
-	}
-
-	conn := nodefs.NewFileSystemConnector(root, fuseOpts)
-	mountOpts := fuse.MountOptions{
-		// Writes and reads are usually capped at 128kiB on Linux through
-		// the FUSE_MAX_PAGES_PER_REQ kernel constant in fuse_i.h. Our
-		// sync.Pool buffer pools are sized acc. to the default. Users may set
-		// the kernel constant higher, and Synology NAS kernels are known to
-		// have it >128kiB. We cannot handle more than 128kiB, so we tell
-		// the kernel to limit the size explicitely.
-		MaxWrite: fuse.MAX_KERNEL_WRITE,
-		Options:  []string{fmt.Sprintf("max_read=%d", fuse.MAX_KERNEL_WRITE)},
-		Debug:    fuseOpts.Debug,
-	}
-
-	// Set values shown in "df -T" and friends
-	// First column, "Filesystem"
-	mountOpts.FsName = tlog.ProgramName
-	// Second column, "Type", will be shown as "fuse." + Name
-	mountOpts.Name = tlog.ProgramName
-
-	// Add a volume name if running osxfuse. Otherwise the Finder will show it as
-	// something like "osxfuse Volume 0 (wizefs)".
-	if runtime.GOOS == "darwin" {
-		mountOpts.Options = append(mountOpts.Options, "volname="+path.Base(mountpointPath))
+	root, flush, exitCode, err := s.prepareRoot(frontendArgs)
+	if err != nil {
+		return nil, nil, exitCode, err
 	}
 
-	srv, err := fuse.NewServer(conn.RawFS(), mountpointPath, &mountOpts)
+	srv, err = s.getMounter().Mount(root, mountpointPath)
 	if err != nil {
-		tlog.Warn.Printf("fuse.NewServer failed: %v\n", err)
-		if runtime.GOOS == "darwin" {
-			tlog.Warn.Println("Maybe you should run: /Library/Filesystems/osxfuse.fs/Contents/Resources/load_osxfuse")
-		}
-		os.Exit(globals.ExitFuseNewServer)
-		//return nil, globals.ExitFuseNewServer, err
+		// the mount may run inside the REST or gRPC daemon, don't kill it
+		return nil, nil, globals.ExitFuseNewServer,
+			fmt.Errorf("Starting FUSE server failed: %w", err)
 	}
 
-	// All FUSE file and directory create calls carry explicit permission
-	// information. We need an unrestricted umask to create the files and
-	// directories with the requested permissions.
-	syscall.Umask(0000)
-
-	return srv, 0, nil
+	return srv, flush, 0, nil
 }
 
 // TODO: move to fusefrontend?
-func (s *Storage) prepareRoot(args fusefrontend.Args) (root nodefs.Node) {
+func (s *Storage) prepareRoot(args fusefrontend.Args) (root mountRoot, flush func() error,
+	exitCode int, err error) {
+
+	// pathFsOpts are passed into go-fuse/pathfs
+	pathFsOpts := &pathfs.PathNodeFsOptions{
+		ClientInodes: true,
+	}
+
 	switch args.Type {
 	case globals.LoopbackFS, globals.LZFS:
-		var finalFs pathfs.FileSystem
-
-		// pathFsOpts are passed into go-fuse/pathfs
-		pathFsOpts := &pathfs.PathNodeFsOptions{
-			ClientInodes: true,
+		fs, err := fusefrontend.NewFS(args)
+		if err != nil {
+			return root, nil, globals.ExitInit,
+				fmt.Errorf("Initializing filesystem failed: %w", err)
 		}
 
-		fs := fusefrontend.NewFS(args)
-		finalFs = fs
-
-		pathFs := pathfs.NewPathNodeFs(finalFs, pathFsOpts)
-
-		root = pathFs.Root()
+		root = mountRoot{Node: pathfs.NewPathNodeFs(fs, pathFsOpts).Root(), FS: fs}
 
 	case globals.ZipFS:
-		// TODO: move to fusefrontend
-		var err error
-		root, err = zipfs.NewArchiveFileSystem(args.OriginDir)
+		if len(args.MasterKey) != 0 {
+			return root, nil, globals.ExitType,
+				newError(ErrInvalid, "Encryption is not supported by zip files")
+		}
+		fs, err := fusefrontend.NewFS(args)
 		if err != nil {
-			tlog.Warn.Printf("NewArchiveFileSystem failed: %v", err)
-			os.Exit(globals.ExitOrigin)
+			tlog.Warn.Printf("Loading archive failed: %v", err)
+			return root, nil, globals.ExitOrigin,
+				fmt.Errorf("Loading archive %s failed: %w", args.OriginDir, err)
 		}
 
+		root = mountRoot{Node: pathfs.NewPathNodeFs(fs, pathFsOpts).Root(), FS: fs}
+		flush = fs.Flush
+
 	default:
 		tlog.Warn.Printf("Strange type of Filesystem: %d", args.Type)
-		root = nil
+		return root, nil, globals.ExitType,
+			newError(ErrInvalid, "Strange type of Filesystem: %d", args.Type)
 	}
 
-	return root
+	return root, flush, 0, nil
 }
 
-func (s *Storage) handleSigint(srv *fuse.Server, mountpoint string) {
+// handleSigint unmounts srv on SIGINT or SIGTERM, so its server loop returns
+// and the caller finishes the mount instead of the process being killed.
+// stop ends the handling after the server loop, interrupted is true if the
+// signal unmounted the Bucket.
+func (s *Storage) handleSigint(srv mountedServer, mountpoint string) (stop func() (interrupted bool)) {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt)
 	signal.Notify(ch, syscall.SIGTERM)
+	done := make(chan struct{})
+	result := make(chan bool, 1)
 	go func() {
-		<-ch
-		err := srv.Unmount()
-		if err != nil {
-			tlog.Warn.Print(err)
-			if runtime.GOOS == "linux" {
-				// MacOSX does not support lazy unmount
-				tlog.Warn.Println("Trying lazy unmount")
-				cmd := exec.Command("fusermount", "-u", "-z", mountpoint)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				cmd.Run()
+		select {
+		case <-ch:
+			tlog.Info.Printf("Unmounting %s on signal", mountpoint)
+			err := srv.Unmount()
+			if err != nil {
+				tlog.Warn.Print(err)
+				lazyUnmount(mountpoint)
 			}
+			result <- true
+		case <-done:
+			result <- false
 		}
-		os.Exit(globals.ExitSigInt)
 	}()
+	return func() bool {
+		signal.Stop(ch)
+		close(done)
+		return <-result
+	}
 }
 
 // redirectStdFds redirects stderr and stdout to syslog; stdin to /dev/null
@@ -0,0 +1,363 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+var errFakeFuse = errors.New("fake FUSE server failed")
+
+// newTestMountStorage returns the Storage in a temporary directory, its
+// Buckets are mounted by m
+func newTestMountStorage(t *testing.T, m mounter) *Storage {
+	dir := testDir(t, "mount")
+	return &Storage{
+		DirPath:     dir + "/",
+		Config:      NewStorageConfig(dir),
+		LockTimeout: 100 * time.Millisecond,
+		buckets:     make(map[string]*Bucket),
+		mounts:      newMountManager(),
+		mounter:     m,
+	}
+}
+
+// checkNotMounted checks that the failed mount left no trace: the Bucket is
+// not mounted, its mountpoint and LZFS temp directory are removed and its
+// lock is released
+func checkNotMounted(t *testing.T, s *Storage, origin string, fstype globals.FSType) {
+	if _, err := s.Config.Check(origin, false, true); err != nil {
+		t.Errorf("%s is mounted after the failed mount: %v", origin, err)
+	}
+	if bucket, ok := s.Bucket(origin); !ok || bucket.mounted {
+		t.Errorf("%s is unknown or mounted after the failed mount", origin)
+	}
+	if _, err := os.Stat(s.DirPath + s.getMountpoint(origin, fstype)); !os.IsNotExist(err) {
+		t.Errorf("Mountpoint of %s is left: %v", origin, err)
+	}
+	if _, err := os.Stat(s.lzfsTempPath(origin)); !os.IsNotExist(err) {
+		t.Errorf("Temp directory of %s is left: %v", origin, err)
+	}
+	unlock, _, err := s.lockBucket(origin, LockShared, LockExclusive)
+	if err != nil {
+		t.Errorf("Lock of %s is not released: %v", origin, err)
+		return
+	}
+	unlock()
+}
+
+func TestMountFuseServerFailure(t *testing.T) {
+	m := &memMounter{err: errFakeFuse}
+	s := newTestMountStorage(t, m)
+
+	buckets := []struct {
+		origin string
+		fstype globals.FSType
+	}{
+		{"ORIGIN", globals.LoopbackFS},
+		{"archive.zip", globals.LZFS},
+	}
+	mounts := []struct {
+		name  string
+		mount func(origin string) (int, error)
+	}{
+		{"Mount", func(origin string) (int, error) { return s.Mount(origin, 0) }},
+		{"MountManaged", s.MountManaged},
+	}
+	for _, b := range buckets {
+		if _, err := s.Create(b.origin); err != nil {
+			t.Fatal(err)
+		}
+		for _, mount := range mounts {
+			before := m.mounts()
+			exitCode, err := mount.mount(b.origin)
+			if exitCode != globals.ExitFuseNewServer || !errors.Is(err, errFakeFuse) {
+				t.Errorf("%s of %s: expected exit code %d, got %d (%v)",
+					mount.name, b.origin, globals.ExitFuseNewServer, exitCode, err)
+			}
+			if calls := m.mounts() - before; calls != 1 {
+				t.Errorf("%s of %s: mounter is called %d times", mount.name, b.origin, calls)
+			}
+			checkNotMounted(t, s, b.origin, b.fstype)
+		}
+
+		// the Bucket is usable after the failed mounts
+		if _, err := s.Delete(b.origin); err != nil {
+			t.Errorf("Delete of %s: %v", b.origin, err)
+		}
+	}
+}
+
+func TestMountPrepareRootFailure(t *testing.T) {
+	m := &memMounter{err: errFakeFuse}
+	s := newTestMountStorage(t, m)
+
+	origin := "_archive.zip"
+	if _, err := s.Create(origin); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(s.DirPath+origin, []byte("not a zip archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if exitCode, err := s.Mount(origin, 0); exitCode != globals.ExitOrigin || err == nil {
+		t.Errorf("Mount of broken archive: expected exit code %d, got %d (%v)",
+			globals.ExitOrigin, exitCode, err)
+	}
+	if exitCode, err := s.MountManaged(origin); exitCode != globals.ExitOrigin || err == nil {
+		t.Errorf("MountManaged of broken archive: expected exit code %d, got %d (%v)",
+			globals.ExitOrigin, exitCode, err)
+	}
+	if calls := m.mounts(); calls != 0 {
+		t.Errorf("Mounter is called %d times", calls)
+	}
+	checkNotMounted(t, s, origin, globals.ZipFS)
+}
+
+func TestMountWrongPasswordCleanup(t *testing.T) {
+	m := &memMounter{err: errFakeFuse}
+	s := newTestMountStorage(t, m)
+
+	origin := "secret.zip"
+	if _, err := s.CreateWithOptions(origin, BucketOptions{Password: "password"}); err != nil {
+		t.Fatal(err)
+	}
+	exitCode, err := s.MountWithOptions(origin, 0, MountOptions{Password: "wrong"})
+	if exitCode != globals.ExitPassword || !errors.Is(err, ErrPassword) {
+		t.Errorf("Mount with wrong password: expected exit code %d, got %d (%v)",
+			globals.ExitPassword, exitCode, err)
+	}
+	if calls := m.mounts(); calls != 0 {
+		t.Errorf("Mounter is called %d times", calls)
+	}
+	checkNotMounted(t, s, origin, globals.LZFS)
+}
+
+func TestMountConfigFailureCleanup(t *testing.T) {
+	m := &memMounter{}
+	s := newTestMountStorage(t, m)
+
+	origin := "archive.zip"
+	if _, err := s.Create(origin); err != nil {
+		t.Fatal(err)
+	}
+	// another process mounts the Bucket while the FUSE server starts
+	mountpoint := s.getMountpoint(origin, globals.LZFS)
+	m.onMount = func(mountpointPath string) {
+		if err := s.Config.MountFilesystem(origin, mountpoint, mountpointPath, 0); err != nil {
+			t.Error(err)
+		}
+	}
+	checkCleanup := func() {
+		if _, err := os.Stat(s.DirPath + mountpoint); !os.IsNotExist(err) {
+			t.Errorf("Mountpoint is left: %v", err)
+		}
+		if _, err := os.Stat(s.lzfsTempPath(origin)); !os.IsNotExist(err) {
+			t.Errorf("Temp directory is left: %v", err)
+		}
+	}
+	if exitCode, err := s.MountManaged(origin); exitCode != globals.ExitChangeConf || !errors.Is(err, ErrMounted) {
+		t.Errorf("MountManaged of mounted Bucket: expected exit code %d, got %d (%v)",
+			globals.ExitChangeConf, exitCode, err)
+	}
+	checkCleanup()
+
+	// the CLI mount returns the error instead of serving the Bucket
+	if err := s.Config.UnmountFilesystem(mountpoint); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode, err := s.Mount(origin, 0); exitCode != globals.ExitChangeConf || !errors.Is(err, ErrMounted) {
+		t.Errorf("Mount of mounted Bucket: expected exit code %d, got %d (%v)",
+			globals.ExitChangeConf, exitCode, err)
+	}
+	checkCleanup()
+}
+
+// TestMountCycle runs create, mount, put, get, unmount of every Bucket type
+// with the in-memory mounter
+func TestMountCycle(t *testing.T) {
+	m := &memMounter{}
+	s := newTestMountStorage(t, m)
+
+	buckets := []struct {
+		origin   string
+		password string
+	}{
+		{"ORIGIN", ""},
+		{"secret", "password"},
+		{"archive.zip", ""},
+		{"_memory.zip", ""},
+	}
+	content := []byte("content of a.txt")
+	for _, b := range buckets {
+		if _, err := s.CreateWithOptions(b.origin, BucketOptions{Password: b.password}); err != nil {
+			t.Fatalf("Create of %s: %v", b.origin, err)
+		}
+		bucket, _ := s.Bucket(b.origin)
+
+		// the content is kept by the origin between the mounts
+		for i, put := range []bool{true, false} {
+			if _, err := s.MountManagedWithOptions(b.origin, MountOptions{Password: b.password}); err != nil {
+				t.Fatalf("Mount %d of %s: %v", i, b.origin, err)
+			}
+			if put {
+				if _, err := bucket.PutFile("docs/a.txt", content); err != nil {
+					t.Errorf("Put to %s: %v", b.origin, err)
+				}
+			}
+			// encrypted Buckets hide the names of their files
+			if b.password != "" {
+				if _, err := os.Stat(s.DirPath + b.origin + "/docs"); !os.IsNotExist(err) {
+					t.Errorf("Plain name in the origin of %s: %v", b.origin, err)
+				}
+			}
+			got, _, err := bucket.GetFile("docs/a.txt", "", true)
+			if err != nil || string(got) != string(content) {
+				t.Errorf("Get %d from %s: %q (%v)", i, b.origin, got, err)
+			}
+			files, _, _, err := bucket.List("", true, "")
+			if err != nil || len(files) != 1 || files[0].Name != "docs/a.txt" ||
+				files[0].Size != int64(len(content)) {
+				t.Errorf("List %d of %s: %+v (%v)", i, b.origin, files, err)
+			}
+			if _, err := s.Unmount(b.origin); err != nil {
+				t.Fatalf("Unmount %d of %s: %v", i, b.origin, err)
+			}
+			if _, _, err := bucket.GetFile("docs/a.txt", "", true); !errors.Is(err, ErrNotMounted) {
+				t.Errorf("Get from unmounted %s: %v", b.origin, err)
+			}
+		}
+		if _, err := s.Delete(b.origin); err != nil {
+			t.Errorf("Delete of %s: %v", b.origin, err)
+		}
+	}
+	if calls := m.mounts(); calls != 2*len(buckets) {
+		t.Errorf("Mounter is called %d times", calls)
+	}
+
+	// the files of a directory Bucket are stored in the origin, Close
+	// unmounts it
+	if _, err := s.Create("plain"); err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := s.Bucket("plain")
+	if _, err := s.MountManaged("plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.PutFile("a.txt", content); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(s.DirPath + "plain/a.txt"); err != nil || string(got) != string(content) {
+		t.Errorf("File in the origin: %q (%v)", got, err)
+	}
+	s.Close()
+	if _, err := s.Config.Check("plain", false, true); err != nil {
+		t.Errorf("plain is mounted after Close: %v", err)
+	}
+}
+
+// TestIdleUnmountConcurrentAccess reads the mount state of the Buckets
+// while the idle session unmounts one, run it with -race
+func TestIdleUnmountConcurrentAccess(t *testing.T) {
+	m := &memMounter{}
+	s := newTestMountStorage(t, m)
+
+	origin := "ORIGIN"
+	if _, err := s.Create(origin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MountManagedWithOptions(origin, MountOptions{IdleTTL: MinIdleTTL}); err != nil {
+		t.Fatal(err)
+	}
+	session := s.session(origin)
+	if session == nil {
+		t.Fatalf("%s has no session", origin)
+	}
+
+	// the daemons read the state in the goroutines of their requests
+	deadline := time.Now().Add(5 * MinIdleTTL)
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for time.Now().Before(deadline) {
+				bucket, ok := s.Bucket(origin)
+				mounted := ok && bucket.IsMounted()
+				if _, found := s.MountedBuckets()[origin]; !mounted && !found {
+					done <- true
+					return
+				}
+			}
+			done <- false
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if !<-done {
+			t.Errorf("%s is not unmounted after %v", origin, 5*MinIdleTTL)
+		}
+	}
+	// Unmount of the session finishes after the state is changed
+	session.wait()
+
+	if _, err := s.Config.Check(origin, false, true); err != nil {
+		t.Errorf("%s is mounted after the idle timeout: %v", origin, err)
+	}
+	if _, err := os.Stat(s.DirPath + s.getMountpoint(origin, globals.LoopbackFS)); !os.IsNotExist(err) {
+		t.Errorf("Mountpoint of %s is left: %v", origin, err)
+	}
+}
+
+// TestIdleUnmountBusy keeps a file open when the session expires: the
+// Bucket must stay mounted instead of being detached lazily, and it's
+// unmounted by the next expiry after the file is closed
+func TestIdleUnmountBusy(t *testing.T) {
+	m := &memMounter{}
+	s := newTestMountStorage(t, m)
+
+	origin := "ORIGIN"
+	if _, err := s.Create(origin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MountManagedWithOptions(origin, MountOptions{IdleTTL: MinIdleTTL}); err != nil {
+		t.Fatal(err)
+	}
+	session := s.session(origin)
+	if session == nil {
+		t.Fatalf("%s has no session", origin)
+	}
+	srv, ok := s.mounts.server(origin)
+	if !ok {
+		t.Fatalf("%s is not served", origin)
+	}
+	file, err := srv.FS().Create("open.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.Write([]byte("written at expiry")); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * MinIdleTTL / 2)
+	if _, err = s.Config.Check(origin, false, false); err != nil {
+		t.Fatalf("%s with the open file is unmounted at expiry: %v", origin, err)
+	}
+
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-session.done:
+	case <-time.After(5 * MinIdleTTL):
+		t.Fatalf("%s is not unmounted after the file is closed", origin)
+	}
+	if _, err = s.Config.Check(origin, false, true); err != nil {
+		t.Errorf("%s is mounted after the idle timeout: %v", origin, err)
+	}
+	data, err := ioutil.ReadFile(s.DirPath + origin + "/open.txt")
+	if err != nil || string(data) != "written at expiry" {
+		t.Errorf("File written at expiry = %q, %v", data, err)
+	}
+}
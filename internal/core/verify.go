@@ -0,0 +1,275 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+	"bitbucket.org/udt/wizefs/internal/util"
+)
+
+// Kinds of the Problems found by Verify
+const (
+	// ProblemMissingOrigin - the Bucket is in the metadata store, but its
+	// directory or archive is gone
+	ProblemMissingOrigin = "missing-origin"
+	// ProblemOrphanOrigin - a directory or an archive of the Storage is not
+	// in the metadata store
+	ProblemOrphanOrigin = "orphan-origin"
+	// ProblemMountDir - a mountpoint directory is not used by any mount
+	ProblemMountDir = "mount-dir"
+	// ProblemTempFile - a temp archive of the interrupted packing
+	ProblemTempFile = "temp-file"
+	// ProblemBucketConfig - the bucket config (wizefs.conf) is missing,
+	// invalid or belongs to another Bucket
+	ProblemBucketConfig = "bucket-config"
+	// ProblemArchive - the archive of the Bucket is corrupted
+	ProblemArchive = "archive"
+)
+
+// reservedNames are the files of the Storage directory that are not
+// Buckets
+var reservedNames = map[string]bool{
+	MetadataStoreFilename:                  true,
+	StorageConfigFilename:                  true,
+	StorageConfigFilename + migratedSuffix: true,
+	locksDir:                               true,
+	lzfsTempDir:                            true,
+	quarantineDir:                          true,
+	// the S3 gateway keeps its config and multipart uploads here
+	"s3":      true,
+	"s3.conf": true,
+	// the users of the REST Service and gRPC Server
+	"auth.conf": true,
+}
+
+// Problem is an inconsistency of the Storage found by Verify
+type Problem struct {
+	Kind string `json:"kind"`
+	// Origin is empty for the files that don't belong to a Bucket
+	Origin string `json:"origin,omitempty"`
+	Path   string `json:"path"`
+	Detail string `json:"detail"`
+	// Repair is what Clean does, empty if the problem has to be fixed by
+	// hand
+	Repair string `json:"repair,omitempty"`
+	// Repaired is set by Clean, Error is the reason it failed
+	Repaired bool   `json:"repaired,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Verify cross-checks the metadata store with the directories and archives
+// of the Storage and with the bucket configs, and reads the archives to
+// check their CRCs. If origin is empty, the whole Storage is checked
+// including the files that don't belong to any Bucket.
+// TEST: TestStorageVerify
+func (s *Storage) Verify(origin string) (problems []Problem, exitCode int, err error) {
+	if origin != "" && !validOrigin(origin) {
+		return nil, globals.ExitOrigin, errInvalidOrigin(origin)
+	}
+
+	storageLock, exitCode, err := s.lock(storageLockName, LockShared)
+	if err != nil {
+		return
+	}
+	defer storageLock.unlock()
+
+	return s.verify(origin)
+}
+
+// Clean repairs the problems found by Verify of the whole Storage: config
+// entries of missing Buckets are removed, orphaned directories and archives
+// are moved to the quarantine directory, unused mountpoints and temp
+// archives are removed. Problems of bucket configs and archives are only
+// reported. With dryRun nothing is changed.
+// TEST: TestStorageVerify
+func (s *Storage) Clean(dryRun bool) (problems []Problem, exitCode int, err error) {
+	// nothing else may use the Buckets while they are repaired
+	storageLock, exitCode, err := s.lock(storageLockName, LockExclusive)
+	if err != nil {
+		return
+	}
+	defer storageLock.unlock()
+
+	problems, exitCode, err = s.verify("")
+	if err != nil || dryRun {
+		return
+	}
+
+	for i := range problems {
+		p := &problems[i]
+		if p.Repair == "" {
+			continue
+		}
+		if err := s.repair(*p); err != nil {
+			p.Error = err.Error()
+			tlog.Warn.Printf("Clean: %s %s failed: %v", p.Repair, p.Path, err)
+			continue
+		}
+		p.Repaired = true
+		tlog.Info.Printf("Clean: %s %s", p.Repair, p.Path)
+	}
+	return problems, 0, nil
+}
+
+func (s *Storage) verify(origin string) (problems []Problem, exitCode int, err error) {
+	if err = s.Config.Load(); err != nil {
+		return nil, globals.ExitLoadConf,
+			fmt.Errorf("Problem with loading Config: %w", err)
+	}
+
+	origins := s.sortedOrigins()
+	if origin != "" {
+		if _, ok := s.Config.filesystem(origin); !ok {
+			return nil, globals.ExitOrigin,
+				newError(ErrNotFound, "Origin %s is not found in the Storage", origin)
+		}
+		origins = []string{origin}
+	}
+	for _, origin := range origins {
+		problems = append(problems, s.verifyBucket(origin)...)
+	}
+	if origin == "" {
+		problems = append(problems, s.verifyOrphans()...)
+	}
+	return problems, 0, nil
+}
+
+// verifyBucket checks the origin and the bucket config of the Bucket
+func (s *Storage) verifyBucket(origin string) (problems []Problem) {
+	fsinfo, _ := s.Config.filesystem(origin)
+	path := s.DirPath + origin
+	if _, err := os.Stat(path); err != nil {
+		return []Problem{{
+			Kind:   ProblemMissingOrigin,
+			Origin: origin,
+			Path:   path,
+			Detail: err.Error(),
+			Repair: "remove config entry",
+		}}
+	}
+	problem := func(kind, format string, args ...interface{}) {
+		problems = append(problems, Problem{
+			Kind:   kind,
+			Origin: origin,
+			Path:   path,
+			Detail: fmt.Sprintf(format, args...),
+		})
+	}
+
+	switch fsinfo.Type {
+	case globals.LoopbackFS:
+		bucketConfig := NewBucketConfig(origin, path, fsinfo.Type)
+		if err := bucketConfig.Load(); err != nil {
+			problem(ProblemBucketConfig, "%s: %v", BucketConfigFilename, err)
+		} else if bucketConfig.Origin != origin || bucketConfig.Type != fsinfo.Type {
+			problem(ProblemBucketConfig, "%s belongs to Bucket %s of type %v",
+				BucketConfigFilename, bucketConfig.Origin, bucketConfig.Type)
+		}
+
+	case globals.ZipFS, globals.LZFS:
+		names, err := util.VerifyArchive(path)
+		if err != nil {
+			problem(ProblemArchive, "%v", err)
+			return problems
+		}
+		// ZipFS archives are plain archives without bucket config
+		if fsinfo.Type == globals.LZFS && !containsName(names, BucketConfigFilename) {
+			problem(ProblemBucketConfig, "archive has no %s", BucketConfigFilename)
+		}
+	}
+	return problems
+}
+
+// verifyOrphans finds the files of the Storage directory that don't belong
+// to any Bucket
+func (s *Storage) verifyOrphans() (problems []Problem) {
+	infos, err := ioutil.ReadDir(s.DirPath)
+	if err != nil {
+		return nil
+	}
+	mounts, _ := readMountInfo(mountInfoPath)
+
+	used := make(map[string]bool)
+	filesystems, mountpoints := s.Config.Snapshot()
+	for _, mpi := range mountpoints {
+		used[filepath.Clean(mpi.MountpointPath)] = true
+	}
+
+	for _, info := range infos {
+		name := info.Name()
+		path := s.DirPath + name
+		switch {
+		case reservedNames[name]:
+		case strings.HasPrefix(name, "_mount"):
+			if _, mounted := mounts[filepath.Clean(path)]; mounted || used[filepath.Clean(path)] {
+				continue
+			}
+			problems = append(problems, Problem{
+				Kind:   ProblemMountDir,
+				Path:   path,
+				Detail: "mountpoint is not used by any mount",
+				Repair: "remove empty directory",
+			})
+		case strings.HasPrefix(name, ".tmp-") && util.IsArchive(name):
+			problems = append(problems, Problem{
+				Kind:   ProblemTempFile,
+				Path:   path,
+				Detail: "archive of an interrupted packing",
+				Repair: "remove file",
+			})
+		default:
+			if _, ok := filesystems[name]; ok {
+				continue
+			}
+			if !info.IsDir() && !util.IsArchive(name) {
+				continue
+			}
+			problems = append(problems, Problem{
+				Kind:   ProblemOrphanOrigin,
+				Path:   path,
+				Detail: "not found in the metadata store",
+				Repair: "move to quarantine",
+			})
+		}
+	}
+	return problems
+}
+
+// repair fixes the problem found by verify, the caller holds the exclusive
+// Storage lock
+func (s *Storage) repair(p Problem) error {
+	switch p.Kind {
+	case ProblemMissingOrigin:
+		if err := s.Config.ResetMount(p.Origin); err != nil {
+			return err
+		}
+		if err := s.Config.DeleteFilesystem(p.Origin); err != nil {
+			return err
+		}
+		s.removeBucket(p.Origin)
+		return nil
+	case ProblemOrphanOrigin:
+		_, err := s.quarantine(p.Path)
+		return err
+	case ProblemMountDir:
+		// a mountpoint with files is not removed, they may be data
+		return os.Remove(p.Path)
+	case ProblemTempFile:
+		return os.Remove(p.Path)
+	}
+	return fmt.Errorf("%s can't be repaired", p.Kind)
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
+	"bitbucket.org/udt/wizefs/internal/util"
+)
+
+// problemKinds returns "ORIGIN-or-file: kind" of the problems, sorted
+func problemKinds(problems []Problem) (kinds []string) {
+	for _, p := range problems {
+		kinds = append(kinds, filepath.Base(p.Path)+": "+p.Kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func TestStorageVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &Storage{DirPath: dir + "/", Config: NewStorageConfig(dir),
+		buckets: make(map[string]*Bucket)}
+
+	add := func(origin string, fstype globals.FSType) {
+		if err := s.Config.CreateFilesystem(origin, s.DirPath+origin, fstype, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mkdir := func(name string) {
+		if err := os.MkdirAll(s.DirPath+name, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A is fine, B is gone, C has no bucket config
+	add("A", globals.LoopbackFS)
+	mkdir("A")
+	if err := NewBucketConfig("A", s.DirPath+"A", globals.LoopbackFS).Save(); err != nil {
+		t.Fatal(err)
+	}
+	add("B", globals.LoopbackFS)
+	add("C", globals.LoopbackFS)
+	mkdir("C")
+
+	// d.zip is fine, e.zip is corrupted
+	newTestArchive(t, dir, "d.zip")
+	add("d.zip", globals.LZFS)
+	src := filepath.Join(dir, "src")
+	mkdir("src")
+	content := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	ioutil.WriteFile(filepath.Join(src, "data.bin"), content, 0644)
+	if err := util.PackArchive(src, s.DirPath+"e.zip", util.PackOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(src)
+	data, _ := ioutil.ReadFile(s.DirPath + "e.zip")
+	data[len(data)/2] ^= 0xff
+	ioutil.WriteFile(s.DirPath+"e.zip", data, 0644)
+	add("e.zip", globals.LZFS)
+
+	// files that don't belong to any Bucket
+	mkdir("X")
+	mkdir("_mountZ")
+	mkdir("s3/uploads")
+	for _, name := range []string{"y.zip", ".tmp-d.zip", "notes.txt"} {
+		ioutil.WriteFile(s.DirPath+name, []byte("y"), 0644)
+	}
+
+	problems, _, err := s.Verify("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		".tmp-d.zip: " + ProblemTempFile,
+		"B: " + ProblemMissingOrigin,
+		"C: " + ProblemBucketConfig,
+		"X: " + ProblemOrphanOrigin,
+		"_mountZ: " + ProblemMountDir,
+		"e.zip: " + ProblemArchive,
+		"y.zip: " + ProblemOrphanOrigin,
+	}
+	if got := problemKinds(problems); !reflect.DeepEqual(got, want) {
+		t.Errorf("Verify: %v, want %v", got, want)
+	}
+
+	// a single Bucket
+	if problems, _, _ := s.Verify("B"); len(problems) != 1 || problems[0].Kind != ProblemMissingOrigin {
+		t.Errorf("Verify B: %+v", problems)
+	}
+	if _, exitCode, err := s.Verify("nope"); err == nil || exitCode != globals.ExitOrigin {
+		t.Errorf("Verify of unknown Bucket: %v, exit code %d", err, exitCode)
+	}
+
+	// dry run changes nothing
+	problems, _, err = s.Clean(true)
+	if err != nil || len(problems) != len(want) {
+		t.Fatalf("Clean dry run: %+v, %v", problems, err)
+	}
+	for _, p := range problems {
+		if p.Repaired {
+			t.Errorf("Clean dry run repaired %s", p.Path)
+		}
+	}
+	if _, err := os.Stat(s.DirPath + "X"); err != nil {
+		t.Errorf("Clean dry run moved X: %v", err)
+	}
+
+	problems, _, err = s.Clean(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		if p.Repaired != (p.Repair != "") || p.Error != "" {
+			t.Errorf("Clean of %s: %+v", p.Path, p)
+		}
+	}
+	if _, ok := s.Config.Filesystems["B"]; ok {
+		t.Errorf("Config entry of B is kept")
+	}
+	if infos, _ := ioutil.ReadDir(s.DirPath + quarantineDir); len(infos) != 2 {
+		t.Errorf("Quarantine has %d files, want X and y.zip", len(infos))
+	}
+
+	// the problems that need a human are left
+	problems, _, _ = s.Verify("")
+	want = []string{"C: " + ProblemBucketConfig, "e.zip: " + ProblemArchive}
+	if got := problemKinds(problems); !reflect.DeepEqual(got, want) {
+		t.Errorf("Verify after Clean: %v, want %v", got, want)
+	}
+}
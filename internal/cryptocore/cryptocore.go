@@ -0,0 +1,81 @@
+// Package cryptocore wraps the ciphers used by encrypted Buckets: AES-GCM for
+// file contents and EME for file names.
+package cryptocore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/rfjakob/eme"
+)
+
+const (
+	// KeyLen is the length of the master key in bytes (AES-256)
+	KeyLen = 32
+	// IVLen is the length of the GCM nonce in bytes; 128 bits like gocryptfs
+	IVLen = 16
+	// AuthTagLen is the length of the GCM authentication tag in bytes
+	AuthTagLen = 16
+)
+
+// info strings used to derive the subkeys from the master key
+const (
+	contentKeyInfo = "AES-GCM file content encryption"
+	nameKeyInfo    = "EME filename encryption"
+)
+
+// CryptoCore holds the ciphers of one encrypted Bucket
+type CryptoCore struct {
+	// AEADCipher encrypts file contents
+	AEADCipher cipher.AEAD
+	// EMECipher encrypts file names
+	EMECipher *eme.EMECipher
+}
+
+// New creates the ciphers from the master key. The keys of AES-GCM and EME
+// are derived from the master key, so the same key is never used twice.
+func New(masterKey []byte) (*CryptoCore, error) {
+	if len(masterKey) != KeyLen {
+		return nil, fmt.Errorf("invalid key length %d", len(masterKey))
+	}
+
+	block, err := aes.NewCipher(deriveKey(masterKey, contentKeyInfo))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, IVLen)
+	if err != nil {
+		return nil, err
+	}
+
+	emeBlock, err := aes.NewCipher(deriveKey(masterKey, nameKeyInfo))
+	if err != nil {
+		return nil, err
+	}
+
+	return &CryptoCore{
+		AEADCipher: aead,
+		EMECipher:  eme.New(emeBlock),
+	}, nil
+}
+
+// deriveKey derives the subkey for info from the master key
+func deriveKey(masterKey []byte, info string) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte(info))
+	return mac.Sum(nil)
+}
+
+// RandBytes returns n random bytes from the system CSPRNG
+func RandBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read random bytes: %v", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,122 @@
+package cryptocore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"math"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// ScryptDefaultLogN is the default scrypt cost parameter, N = 2^16,
+	// it takes about 64 MB of memory
+	ScryptDefaultLogN = 16
+	// scryptMinLogN protects from configs with weakened parameters
+	scryptMinLogN = 10
+	// wrapKeyAAD binds the wrapped key to its purpose
+	wrapKeyAAD = "wizefs master key"
+)
+
+// ErrWrongPassword is returned when the master key can't be unwrapped
+var ErrWrongPassword = errors.New("wrong password")
+
+// ScryptKDF holds the parameters of the scrypt key derivation, they are kept
+// in the Bucket config next to the wrapped master key.
+type ScryptKDF struct {
+	Salt   []byte `json:"salt"`
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	KeyLen int    `json:"keylen"`
+}
+
+// NewScryptKDF returns the parameters with a new random salt, logN is the
+// cost parameter (N = 2^logN)
+func NewScryptKDF(logN int) (ScryptKDF, error) {
+	salt, err := RandBytes(KeyLen)
+	if err != nil {
+		return ScryptKDF{}, err
+	}
+	return ScryptKDF{
+		Salt:   salt,
+		N:      1 << uint(logN),
+		R:      8,
+		P:      1,
+		KeyLen: KeyLen,
+	}, nil
+}
+
+// DeriveKey derives the key from the password
+func (s *ScryptKDF) DeriveKey(password string) ([]byte, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return scrypt.Key([]byte(password), s.Salt, s.N, s.R, s.P, s.KeyLen)
+}
+
+func (s *ScryptKDF) validate() error {
+	if s.N < 1<<scryptMinLogN || s.N&(s.N-1) != 0 {
+		return fmt.Errorf("invalid scrypt N %d", s.N)
+	}
+	if s.R < 1 || s.P < 1 || s.R*s.P >= 1<<30 || s.N > math.MaxInt32/s.R/128 {
+		return fmt.Errorf("invalid scrypt parameters r=%d, p=%d", s.R, s.P)
+	}
+	if len(s.Salt) < 16 || s.KeyLen != KeyLen {
+		return fmt.Errorf("invalid scrypt salt or key length")
+	}
+	return nil
+}
+
+// EncryptKey wraps the master key with the key derived from the password
+func EncryptKey(masterKey []byte, password string, logN int) (encryptedKey []byte, kdf ScryptKDF, err error) {
+	kdf, err = NewScryptKDF(logN)
+	if err != nil {
+		return nil, kdf, err
+	}
+	key, err := kdf.DeriveKey(password)
+	if err != nil {
+		return nil, kdf, err
+	}
+	aead, err := newKeyCipher(key)
+	if err != nil {
+		return nil, kdf, err
+	}
+	nonce, err := RandBytes(IVLen)
+	if err != nil {
+		return nil, kdf, err
+	}
+	encryptedKey = aead.Seal(nonce, nonce, masterKey, []byte(wrapKeyAAD))
+	return encryptedKey, kdf, nil
+}
+
+// DecryptKey unwraps the master key, it returns ErrWrongPassword if the
+// password doesn't match
+func DecryptKey(encryptedKey []byte, password string, kdf ScryptKDF) ([]byte, error) {
+	key, err := kdf.DeriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newKeyCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(encryptedKey) != IVLen+KeyLen+AuthTagLen {
+		return nil, fmt.Errorf("invalid encrypted key length %d", len(encryptedKey))
+	}
+	masterKey, err := aead.Open(nil, encryptedKey[:IVLen], encryptedKey[IVLen:], []byte(wrapKeyAAD))
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	return masterKey, nil
+}
+
+func newKeyCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, IVLen)
+}
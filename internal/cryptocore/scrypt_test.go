@@ -0,0 +1,46 @@
+package cryptocore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecryptKey(t *testing.T) {
+	masterKey, err := RandBytes(KeyLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedKey, kdf, err := EncryptKey(masterKey, "secret", scryptMinLogN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encryptedKey, masterKey) {
+		t.Fatal("Master key is stored in plaintext")
+	}
+
+	key, err := DecryptKey(encryptedKey, "secret", kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, masterKey) {
+		t.Errorf("Unwrapped key differs from master key")
+	}
+
+	if _, err = DecryptKey(encryptedKey, "wrong", kdf); err != ErrWrongPassword {
+		t.Errorf("Expected ErrWrongPassword, got %v", err)
+	}
+}
+
+func TestScryptKDFValidate(t *testing.T) {
+	kdf, err := NewScryptKDF(scryptMinLogN)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weak := kdf
+	weak.N = 1 << (scryptMinLogN - 1)
+	if _, err = weak.DeriveKey("secret"); err == nil {
+		t.Errorf("Weak scrypt parameters were accepted")
+	}
+}
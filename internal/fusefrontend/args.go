@@ -13,4 +13,13 @@ type Args struct {
 	// 1 - directory (LoopbackFS)
 	// 2 - zip file (ZipFS)
 	Type globals.FSType
+	// MasterKey is the unwrapped key of an encrypted Bucket,
+	// nil - files are stored unencrypted
+	MasterKey []byte `json:"-"`
+	// MemoryLimit caps the file contents of a ZipFS Bucket held in memory,
+	// 0 - DefaultMemoryLimit
+	MemoryLimit int64
+	// OnActivity is called on every FUSE operation, it resets the idle timer
+	// of the mount session, nil - no session
+	OnActivity func() `json:"-"`
 }
@@ -0,0 +1,295 @@
+package fusefrontend
+
+// FUSE operations on file handles of encrypted Buckets
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+
+	"bitbucket.org/udt/wizefs/internal/contentenc"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+// cryptFile encrypts and decrypts the blocks of one open file. Operations
+// that don't touch the content (Flush, Fsync, Chmod etc) are passed to the
+// loopback file.
+type cryptFile struct {
+	nodefs.File
+	fd         *os.File
+	contentEnc *contentenc.ContentEnc
+
+	// mutex protects header and serializes block read-modify-write cycles
+	mutex sync.Mutex
+	// header is nil until the file header is read or written
+	header *contentenc.FileHeader
+}
+
+func newCryptFile(fd *os.File, contentEnc *contentenc.ContentEnc) *cryptFile {
+	return &cryptFile{
+		File:       nodefs.NewLoopbackFile(fd),
+		fd:         fd,
+		contentEnc: contentEnc,
+	}
+}
+
+func (f *cryptFile) String() string {
+	return "cryptFile(" + f.fd.Name() + ")"
+}
+
+// InnerFile returns nil, the loopback file sees only ciphertext
+func (f *cryptFile) InnerFile() nodefs.File {
+	return nil
+}
+
+// readHeader reads the file header, it returns nil header for empty files
+func (f *cryptFile) readHeader() (*contentenc.FileHeader, error) {
+	if f.header != nil {
+		return f.header, nil
+	}
+	buf := make([]byte, contentenc.HeaderLen)
+	n, err := f.fd.ReadAt(buf, 0)
+	if n == 0 && err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.header, err = contentenc.ParseHeader(buf)
+	return f.header, err
+}
+
+// createHeader writes the header of a new file
+func (f *cryptFile) createHeader() error {
+	header, err := contentenc.NewFileHeader()
+	if err != nil {
+		return err
+	}
+	if _, err = f.fd.WriteAt(header.Pack(), 0); err != nil {
+		return err
+	}
+	f.header = header
+	return nil
+}
+
+func (f *cryptFile) plainSize() (uint64, error) {
+	fi, err := f.fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return f.contentEnc.CipherSizeToPlainSize(uint64(fi.Size())), nil
+}
+
+// readBlocks decrypts the blocks from first to last inclusive, missing
+// blocks at the end of the file are skipped
+func (f *cryptFile) readBlocks(first, last uint64) ([]byte, error) {
+	header, err := f.readHeader()
+	if err != nil || header == nil {
+		return nil, err
+	}
+
+	cipherBS := f.contentEnc.CipherBS()
+	off := f.contentEnc.BlockCipherOff(first)
+	ciphertext := make([]byte, (last-first+1)*cipherBS)
+	n, err := f.fd.ReadAt(ciphertext, int64(off))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	ciphertext = ciphertext[:n]
+
+	var plain bytes.Buffer
+	for blockNo := first; len(ciphertext) > 0; blockNo++ {
+		end := cipherBS
+		if uint64(len(ciphertext)) < end {
+			end = uint64(len(ciphertext))
+		}
+		block, err := f.contentEnc.DecryptBlock(ciphertext[:end], blockNo, header.ID)
+		if err != nil {
+			tlog.Warn.Printf("%s: block %d: %v", f.fd.Name(), blockNo, err)
+			return nil, err
+		}
+		plain.Write(block)
+		ciphertext = ciphertext[end:]
+	}
+	return plain.Bytes(), nil
+}
+
+func (f *cryptFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(dest) == 0 {
+		return fuse.ReadResultData(nil), fuse.OK
+	}
+	plainBS := f.contentEnc.PlainBS()
+	first := uint64(off) / plainBS
+	last := (uint64(off) + uint64(len(dest)) - 1) / plainBS
+	plain, err := f.readBlocks(first, last)
+	if err != nil {
+		return nil, fuse.EIO
+	}
+
+	skip := uint64(off) - first*plainBS
+	if skip >= uint64(len(plain)) {
+		return fuse.ReadResultData(nil), fuse.OK
+	}
+	plain = plain[skip:]
+	if len(plain) > len(dest) {
+		plain = plain[:len(dest)]
+	}
+	return fuse.ReadResultData(plain), fuse.OK
+}
+
+func (f *cryptFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.writePlain(data, uint64(off)); err != nil {
+		tlog.Warn.Printf("%s: write at %d: %v", f.fd.Name(), off, err)
+		return 0, fuse.ToStatus(err)
+	}
+	return uint32(len(data)), fuse.OK
+}
+
+// writePlain encrypts the data and writes it at the plaintext offset. The
+// gap between the end of the file and off is filled with zeros.
+func (f *cryptFile) writePlain(data []byte, off uint64) error {
+	header, err := f.readHeader()
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		if err = f.createHeader(); err != nil {
+			return err
+		}
+		header = f.header
+	}
+
+	size, err := f.plainSize()
+	if err != nil {
+		return err
+	}
+	// fill the hole block by block, so every block of the file can be
+	// decrypted and the hole is never held in memory
+	plainBS := f.contentEnc.PlainBS()
+	var zeros []byte
+	for size < off {
+		if zeros == nil {
+			zeros = make([]byte, plainBS)
+		}
+		n := off - size
+		if n > plainBS {
+			n = plainBS
+		}
+		if n, err = f.writeBlock(header, zeros[:n], size); err != nil {
+			return err
+		}
+		size += n
+	}
+
+	for len(data) > 0 {
+		n, err := f.writeBlock(header, data, off)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		off += n
+	}
+	return nil
+}
+
+// writeBlock writes the data at the plaintext offset up to the end of its
+// block, it returns the number of bytes written
+func (f *cryptFile) writeBlock(header *contentenc.FileHeader, data []byte, off uint64) (uint64, error) {
+	plainBS := f.contentEnc.PlainBS()
+	blockNo := off / plainBS
+	skip := off - blockNo*plainBS
+
+	// read-modify-write of the block
+	block, err := f.readBlocks(blockNo, blockNo)
+	if err != nil {
+		return 0, err
+	}
+	n := plainBS - skip
+	if n > uint64(len(data)) {
+		n = uint64(len(data))
+	}
+	if uint64(len(block)) < skip+n {
+		block = append(block, make([]byte, skip+n-uint64(len(block)))...)
+	}
+	copy(block[skip:], data[:n])
+
+	ciphertext, err := f.contentEnc.EncryptBlock(block, blockNo, header.ID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = f.fd.WriteAt(ciphertext, int64(f.contentEnc.BlockCipherOff(blockNo))); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (f *cryptFile) Truncate(newSize uint64) fuse.Status {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.truncate(newSize); err != nil {
+		tlog.Warn.Printf("%s: truncate to %d: %v", f.fd.Name(), newSize, err)
+		return fuse.ToStatus(err)
+	}
+	return fuse.OK
+}
+
+func (f *cryptFile) truncate(newSize uint64) error {
+	if newSize == 0 {
+		f.header = nil
+		return f.fd.Truncate(0)
+	}
+
+	size, err := f.plainSize()
+	if err != nil {
+		return err
+	}
+	if newSize >= size {
+		if newSize > size {
+			return f.writePlain(nil, newSize)
+		}
+		return nil
+	}
+
+	// shrink: cut the last block and encrypt it again
+	plainBS := f.contentEnc.PlainBS()
+	lastBlock := (newSize - 1) / plainBS
+	block, err := f.readBlocks(lastBlock, lastBlock)
+	if err != nil {
+		return err
+	}
+	block = block[:newSize-lastBlock*plainBS]
+
+	if err = f.fd.Truncate(int64(f.contentEnc.BlockCipherOff(lastBlock))); err != nil {
+		return err
+	}
+	ciphertext, err := f.contentEnc.EncryptBlock(block, lastBlock, f.header.ID)
+	if err != nil {
+		return err
+	}
+	_, err = f.fd.WriteAt(ciphertext, int64(f.contentEnc.BlockCipherOff(lastBlock)))
+	return err
+}
+
+func (f *cryptFile) GetAttr(a *fuse.Attr) fuse.Status {
+	status := f.File.GetAttr(a)
+	if status.Ok() && a.IsRegular() {
+		a.Size = f.contentEnc.CipherSizeToPlainSize(a.Size)
+	}
+	return status
+}
+
+// Allocate is not supported: allocated space would be unencrypted zeros
+func (f *cryptFile) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
+	return fuse.ENOSYS
+}
@@ -0,0 +1,134 @@
+package fusefrontend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+
+	"bitbucket.org/udt/wizefs/internal/contentenc"
+	"bitbucket.org/udt/wizefs/internal/cryptocore"
+)
+
+func newTestCryptFile(t *testing.T) (*cryptFile, string) {
+	cc, err := cryptocore.New(make([]byte, cryptocore.KeyLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := ioutil.TempFile("", "wizefs-crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newCryptFile(fd, contentenc.New(cc, contentenc.DefaultBS)), fd.Name()
+}
+
+func readAll(t *testing.T, f *cryptFile, size int) []byte {
+	buf := make([]byte, size+1)
+	res, status := f.Read(buf, 0)
+	if !status.Ok() {
+		t.Fatalf("Read: %v", status)
+	}
+	data, status := res.Bytes(buf)
+	if !status.Ok() {
+		t.Fatalf("Read: %v", status)
+	}
+	return data
+}
+
+func TestCryptFileWriteRead(t *testing.T) {
+	f, name := newTestCryptFile(t)
+	defer os.Remove(name)
+	defer f.Release()
+
+	plain := make([]byte, 3*contentenc.DefaultBS+100)
+	rand.Read(plain)
+	// write in pieces that cross block boundaries
+	for off := 0; off < len(plain); off += 1000 {
+		end := off + 1000
+		if end > len(plain) {
+			end = len(plain)
+		}
+		if _, status := f.Write(plain[off:end], int64(off)); !status.Ok() {
+			t.Fatalf("Write at %d: %v", off, status)
+		}
+	}
+
+	if got := readAll(t, f, len(plain)); !bytes.Equal(got, plain) {
+		t.Errorf("Content differs after write")
+	}
+
+	var attr fuse.Attr
+	if status := f.GetAttr(&attr); !status.Ok() || attr.Size != uint64(len(plain)) {
+		t.Errorf("Expected size %d, got %d (%v)", len(plain), attr.Size, status)
+	}
+
+	ciphertext, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, plain[:64]) {
+		t.Errorf("Content is stored in plaintext")
+	}
+}
+
+func TestCryptFileHoleAndTruncate(t *testing.T) {
+	f, name := newTestCryptFile(t)
+	defer os.Remove(name)
+	defer f.Release()
+
+	data := []byte("wizefs")
+	off := contentenc.DefaultBS + 10
+	if _, status := f.Write(data, int64(off)); !status.Ok() {
+		t.Fatalf("Write: %v", status)
+	}
+	want := append(make([]byte, off), data...)
+	if got := readAll(t, f, len(want)); !bytes.Equal(got, want) {
+		t.Errorf("Hole is not filled with zeros")
+	}
+
+	if status := f.Truncate(uint64(off + 2)); !status.Ok() {
+		t.Fatalf("Truncate: %v", status)
+	}
+	if got := readAll(t, f, len(want)); !bytes.Equal(got, want[:off+2]) {
+		t.Errorf("Content differs after shrinking")
+	}
+
+	// growing fills several blocks with zeros one by one
+	size := 5*contentenc.DefaultBS + 7
+	if status := f.Truncate(uint64(size)); !status.Ok() {
+		t.Fatalf("Truncate: %v", status)
+	}
+	want = append(want[:off+2], make([]byte, size-off-2)...)
+	if got := readAll(t, f, size); !bytes.Equal(got, want) {
+		t.Errorf("Content differs after growing")
+	}
+
+	if status := f.Truncate(0); !status.Ok() {
+		t.Fatalf("Truncate: %v", status)
+	}
+	if got := readAll(t, f, len(want)); len(got) != 0 {
+		t.Errorf("Expected empty file, got %d bytes", len(got))
+	}
+}
+
+func TestCryptFileCorruption(t *testing.T) {
+	f, name := newTestCryptFile(t)
+	defer os.Remove(name)
+	defer f.Release()
+
+	if _, status := f.Write([]byte("secret data"), 0); !status.Ok() {
+		t.Fatalf("Write: %v", status)
+	}
+	// flip one byte of the ciphertext
+	b := make([]byte, 1)
+	f.fd.ReadAt(b, contentenc.HeaderLen+cryptocore.IVLen)
+	b[0] ^= 0xff
+	f.fd.WriteAt(b, contentenc.HeaderLen+cryptocore.IVLen)
+
+	if _, status := f.Read(make([]byte, 16), 0); status != fuse.EIO {
+		t.Errorf("Expected EIO, got %v", status)
+	}
+}
@@ -0,0 +1,88 @@
+package fusefrontend
+
+// FUSE operations on file handles of ZipFS Buckets
+
+import (
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+// memFile is an open file of MemArchiveFS. It keeps the node, so the file
+// stays usable after it's renamed or unlinked.
+type memFile struct {
+	nodefs.File // defaultFile, returns ENOSYS for the rest
+	fs          *MemArchiveFS
+	node        *memNode
+}
+
+func newMemFile(fs *MemArchiveFS, node *memNode) *memFile {
+	return &memFile{
+		File: nodefs.NewDefaultFile(),
+		fs:   fs,
+		node: node,
+	}
+}
+
+func (f *memFile) String() string {
+	return "memFile(" + f.fs.archive + ")"
+}
+
+func (f *memFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if off >= int64(len(f.node.data)) {
+		return fuse.ReadResultData(nil), fuse.OK
+	}
+	n := copy(dest, f.node.data[off:])
+	return fuse.ReadResultData(dest[:n]), fuse.OK
+}
+
+func (f *memFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	end := uint64(off) + uint64(len(data))
+	if end > uint64(len(f.node.data)) {
+		if status := f.fs.resize(f.node, end); !status.Ok() {
+			return 0, status
+		}
+	}
+	copy(f.node.data[off:], data)
+	f.node.mtime = time.Now()
+	f.fs.dirty = true
+	return uint32(len(data)), fuse.OK
+}
+
+func (f *memFile) Truncate(size uint64) fuse.Status {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	return f.fs.resize(f.node, size)
+}
+
+func (f *memFile) GetAttr(a *fuse.Attr) fuse.Status {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	f.node.fillAttr(a)
+	return fuse.OK
+}
+
+// Flush is called on every close, the archive is written on fsync only
+func (f *memFile) Flush() fuse.Status {
+	return fuse.OK
+}
+
+// Fsync writes the whole archive
+func (f *memFile) Fsync(flags int) fuse.Status {
+	if err := f.fs.Flush(); err != nil {
+		tlog.Warn.Printf("%s: flush: %v", f.fs.archive, err)
+		return fuse.EIO
+	}
+	return fuse.OK
+}
@@ -5,20 +5,57 @@ package fusefrontend
 
 import (
 	"github.com/hanwen/go-fuse/fuse/pathfs"
+
+	"bitbucket.org/udt/wizefs/internal/globals"
 )
 
 // FS implements the go-fuse virtual filesystem interface.
 type FS struct {
-	pathfs.FileSystem      // loopbackFileSystem, see go-fuse/fuse/pathfs/loopback.go
+	pathfs.FileSystem      // loopbackFileSystem, cryptFS or MemArchiveFS
 	args              Args // Stores configuration arguments
+	// mem is the in-memory archive of ZipFS, nil for other types
+	mem *MemArchiveFS
 }
 
 var _ pathfs.FileSystem = &FS{} // Verify that interface is implemented.
 
-// NewFS returns a new encrypted FUSE overlay filesystem.
-func NewFS(args Args) *FS {
+// NewFS returns a new FUSE overlay filesystem. It encrypts file names and
+// contents if args.MasterKey is set; ZipFS archives are served from memory.
+func NewFS(args Args) (*FS, error) {
+	if args.Type == globals.ZipFS {
+		mem, err := NewMemArchiveFS(args.OriginDir, args.MemoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		return &FS{
+			FileSystem: mem,
+			args:       args,
+			mem:        mem,
+		}, nil
+	}
+
+	if len(args.MasterKey) == 0 {
+		return &FS{
+			FileSystem: pathfs.NewLoopbackFileSystem(args.OriginDir),
+			args:       args,
+		}, nil
+	}
+
+	cfs, err := newCryptFS(args.OriginDir, args.MasterKey)
+	if err != nil {
+		return nil, err
+	}
 	return &FS{
-		FileSystem: pathfs.NewLoopbackFileSystem(args.OriginDir),
+		FileSystem: cfs,
 		args:       args,
+	}, nil
+}
+
+// Flush writes the in-memory archive of ZipFS back, it does nothing for
+// other types
+func (fs *FS) Flush() error {
+	if fs.mem == nil {
+		return nil
 	}
+	return fs.mem.Flush()
 }
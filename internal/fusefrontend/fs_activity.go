@@ -0,0 +1,157 @@
+package fusefrontend
+
+// FUSE operations reset the idle timer of the mount session. Every method
+// calls touch and passes the call to the wrapped filesystem.
+
+import (
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+func (fs *FS) touch() {
+	if fs.args.OnActivity != nil {
+		fs.args.OnActivity()
+	}
+}
+
+// wrapFile makes reads and writes of the open file reset the idle timer
+func (fs *FS) wrapFile(file nodefs.File, status fuse.Status) (nodefs.File, fuse.Status) {
+	if !status.Ok() || fs.args.OnActivity == nil {
+		return file, status
+	}
+	return &activityFile{File: file, onActivity: fs.args.OnActivity}, status
+}
+
+func (fs *FS) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	fs.touch()
+	return fs.FileSystem.GetAttr(name, context)
+}
+
+func (fs *FS) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Chmod(name, mode, context)
+}
+
+func (fs *FS) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Chown(name, uid, gid, context)
+}
+
+func (fs *FS) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Utimens(name, atime, mtime, context)
+}
+
+func (fs *FS) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Truncate(name, size, context)
+}
+
+func (fs *FS) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Access(name, mode, context)
+}
+
+func (fs *FS) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Link(oldName, newName, context)
+}
+
+func (fs *FS) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Mkdir(name, mode, context)
+}
+
+func (fs *FS) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Mknod(name, mode, dev, context)
+}
+
+func (fs *FS) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Rename(oldName, newName, context)
+}
+
+func (fs *FS) Rmdir(name string, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Rmdir(name, context)
+}
+
+func (fs *FS) Unlink(name string, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Unlink(name, context)
+}
+
+func (fs *FS) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	fs.touch()
+	return fs.FileSystem.GetXAttr(name, attribute, context)
+}
+
+func (fs *FS) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	fs.touch()
+	return fs.FileSystem.ListXAttr(name, context)
+}
+
+func (fs *FS) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.RemoveXAttr(name, attr, context)
+}
+
+func (fs *FS) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.SetXAttr(name, attr, data, flags, context)
+}
+
+func (fs *FS) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	fs.touch()
+	return fs.wrapFile(fs.FileSystem.Open(name, flags, context))
+}
+
+func (fs *FS) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	fs.touch()
+	return fs.wrapFile(fs.FileSystem.Create(name, flags, mode, context))
+}
+
+func (fs *FS) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	fs.touch()
+	return fs.FileSystem.OpenDir(name, context)
+}
+
+func (fs *FS) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	fs.touch()
+	return fs.FileSystem.Symlink(value, linkName, context)
+}
+
+func (fs *FS) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	fs.touch()
+	return fs.FileSystem.Readlink(name, context)
+}
+
+func (fs *FS) StatFs(name string) *fuse.StatfsOut {
+	fs.touch()
+	return fs.FileSystem.StatFs(name)
+}
+
+// activityFile resets the idle timer on reads and writes, the rest is
+// passed to the wrapped file
+type activityFile struct {
+	nodefs.File
+	onActivity func()
+}
+
+func (f *activityFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	f.onActivity()
+	return f.File.Read(dest, off)
+}
+
+func (f *activityFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+	f.onActivity()
+	return f.File.Write(data, off)
+}
+
+func (f *activityFile) Fsync(flags int) fuse.Status {
+	f.onActivity()
+	return f.File.Fsync(flags)
+}
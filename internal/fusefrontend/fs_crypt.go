@@ -0,0 +1,344 @@
+package fusefrontend
+
+// FUSE operations on paths of encrypted Buckets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+
+	"bitbucket.org/udt/wizefs/internal/contentenc"
+	"bitbucket.org/udt/wizefs/internal/cryptocore"
+	"bitbucket.org/udt/wizefs/internal/nametransform"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+// cryptFS encrypts file names and contents on their way to the loopback
+// filesystem. Every method translates the plaintext path to the encrypted
+// one before calling the loopback filesystem, so no plaintext name reaches
+// the origin directory.
+type cryptFS struct {
+	pathfs.FileSystem // loopbackFileSystem over the encrypted origin
+	root              string
+	nameTransform     *nametransform.NameTransform
+	contentEnc        *contentenc.ContentEnc
+}
+
+var _ pathfs.FileSystem = &cryptFS{} // Verify that interface is implemented.
+
+func newCryptFS(originDir string, masterKey []byte) (*cryptFS, error) {
+	cc, err := cryptocore.New(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	fs := &cryptFS{
+		FileSystem:    pathfs.NewLoopbackFileSystem(originDir),
+		root:          originDir,
+		nameTransform: nametransform.New(cc.EMECipher),
+		contentEnc:    contentenc.New(cc, contentenc.DefaultBS),
+	}
+	err = fs.initRootDirIV()
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// initRootDirIV creates the IV of the origin directory of a new Bucket. The
+// origin of a Bucket created by an older version keeps the all-zero IV if it
+// already has encrypted names.
+func (fs *cryptFS) initRootDirIV() error {
+	_, err := os.Stat(filepath.Join(fs.root, nametransform.DirIVFilename))
+	if !os.IsNotExist(err) {
+		return err
+	}
+	entries, err := ioutil.ReadDir(fs.root)
+	if err != nil {
+		return err
+	}
+	iv, _ := nametransform.ReadDirIV(fs.root)
+	for _, entry := range entries {
+		if _, err := fs.nameTransform.DecryptName(entry.Name(), iv); err == nil {
+			return nil
+		}
+	}
+	return nametransform.WriteDirIV(fs.root)
+}
+
+func (fs *cryptFS) String() string {
+	return "CryptFS(" + fs.root + ")"
+}
+
+// encryptPath returns the encrypted relative path
+func (fs *cryptFS) encryptPath(plainPath string) (string, fuse.Status) {
+	cPath, err := fs.nameTransform.EncryptPath(plainPath, fs.root)
+	if err != nil {
+		return "", fuse.ToStatus(err)
+	}
+	return cPath, fuse.OK
+}
+
+// getBackingPath returns the absolute encrypted path
+func (fs *cryptFS) getBackingPath(plainPath string) (string, fuse.Status) {
+	cPath, status := fs.encryptPath(plainPath)
+	if !status.Ok() {
+		return "", status
+	}
+	return fs.root + "/" + cPath, fuse.OK
+}
+
+func (fs *cryptFS) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	cName, status := fs.encryptPath(name)
+	if !status.Ok() {
+		return nil, status
+	}
+	a, status := fs.FileSystem.GetAttr(cName, context)
+	if !status.Ok() {
+		return nil, status
+	}
+	if a.IsRegular() {
+		a.Size = fs.contentEnc.CipherSizeToPlainSize(a.Size)
+	}
+	return a, fuse.OK
+}
+
+func (fs *cryptFS) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	cName, status := fs.encryptPath(name)
+	if !status.Ok() {
+		return nil, status
+	}
+	cipherEntries, status := fs.FileSystem.OpenDir(cName, context)
+	if !status.Ok() {
+		return nil, status
+	}
+	iv, err := nametransform.ReadDirIV(filepath.Join(fs.root, cName))
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(cipherEntries))
+	for _, entry := range cipherEntries {
+		plainName, err := fs.nameTransform.DecryptName(entry.Name, iv)
+		if err != nil {
+			// files that were not created through the mount, like the
+			// Bucket config and the directory IV, are hidden
+			tlog.Debug.Printf("OpenDir %q: skipping %q: %v", name, entry.Name, err)
+			continue
+		}
+		entry.Name = plainName
+		entries = append(entries, entry)
+	}
+	return entries, fuse.OK
+}
+
+// cipherFlags converts the open flags: encrypted blocks are read before they
+// are changed, so write-only files are opened for reading too; O_APPEND is
+// removed because the kernel passes the offset of every write.
+func cipherFlags(flags uint32) int {
+	newFlags := int(flags)
+	if newFlags&syscall.O_ACCMODE == syscall.O_WRONLY {
+		newFlags = newFlags&^syscall.O_ACCMODE | syscall.O_RDWR
+	}
+	return newFlags &^ syscall.O_APPEND
+}
+
+func (fs *cryptFS) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	cPath, status := fs.getBackingPath(name)
+	if !status.Ok() {
+		return nil, status
+	}
+	fd, err := os.OpenFile(cPath, cipherFlags(flags), 0)
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	return newCryptFile(fd, fs.contentEnc), fuse.OK
+}
+
+func (fs *cryptFS) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	cPath, status := fs.getBackingPath(name)
+	if !status.Ok() {
+		return nil, status
+	}
+	fd, err := os.OpenFile(cPath, cipherFlags(flags)|os.O_CREATE, os.FileMode(mode))
+	if err != nil {
+		return nil, fuse.ToStatus(err)
+	}
+	return newCryptFile(fd, fs.contentEnc), fuse.OK
+}
+
+func (fs *cryptFS) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	file, status := fs.Open(name, uint32(os.O_RDWR), context)
+	if !status.Ok() {
+		return status
+	}
+	status = file.Truncate(size)
+	file.Release()
+	return status
+}
+
+func (fs *cryptFS) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	cName, status := fs.encryptPath(name)
+	if !status.Ok() {
+		return status
+	}
+	return fs.FileSystem.Chmod(cName, mode, context)
+}
+
+func (fs *cryptFS) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	cName, status := fs.encryptPath(name)
+	if !status.Ok() {
+		return status
+	}
+	return fs.FileSystem.Chown(cName, uid, gid, context)
+}
+
+func (fs *cryptFS) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	cName, status := fs.encryptPath(name)
+	if !status.Ok() {
+		return status
+	}
+	return fs.FileSystem.Utimens(cName, atime, mtime, context)
+}
+
+func (fs *cryptFS) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	cName, status := fs.encryptPath(name)
+	if !status.Ok() {
+		return status
+	}
+	return fs.FileSystem.Access(cName, mode, context)
+}
+
+func (fs *cryptFS) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	cOldName, status := fs.encryptPath(oldName)
+	if !status.Ok() {
+		return status
+	}
+	cNewName, status := fs.encryptPath(newName)
+	if !status.Ok() {
+		return status
+	}
+	return fs.FileSystem.Link(cOldName, cNewName, context)
+}
+
+// Mkdir creates the directory with its IV. The directory is created
+// writable, so the IV can be written, and gets its mode afterwards.
+func (fs *cryptFS) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	cPath, status := fs.getBackingPath(name)
+	if !status.Ok() {
+		return status
+	}
+	err := os.Mkdir(cPath, os.FileMode(mode|0700))
+	if err != nil {
+		return fuse.ToStatus(err)
+	}
+	err = nametransform.WriteDirIV(cPath)
+	if err != nil {
+		os.Remove(cPath)
+		return fuse.ToStatus(err)
+	}
+	if mode|0700 != mode {
+		err = os.Chmod(cPath, os.FileMode(mode))
+		if err != nil {
+			return fuse.ToStatus(err)
+		}
+	}
+	return fuse.OK
+}
+
+func (fs *cryptFS) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	cName, status := fs.encryptPath(name)
+	if !status.Ok() {
+		return status
+	}
+	return fs.FileSystem.Mknod(cName, mode, dev, context)
+}
+
+func (fs *cryptFS) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	cOldName, status := fs.encryptPath(oldName)
+	if !status.Ok() {
+		return status
+	}
+	cNewName, status := fs.encryptPath(newName)
+	if !status.Ok() {
+		return status
+	}
+	return fs.FileSystem.Rename(cOldName, cNewName, context)
+}
+
+// Rmdir removes the IV of the directory first, it's written back if the
+// directory can't be removed (e.g. it isn't empty)
+func (fs *cryptFS) Rmdir(name string, context *fuse.Context) fuse.Status {
+	cPath, status := fs.getBackingPath(name)
+	if !status.Ok() {
+		return status
+	}
+	ivFile := filepath.Join(cPath, nametransform.DirIVFilename)
+	iv, err := ioutil.ReadFile(ivFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fuse.ToStatus(err)
+	}
+	if err == nil {
+		err = os.Remove(ivFile)
+		if err != nil {
+			return fuse.ToStatus(err)
+		}
+	}
+	err = syscall.Rmdir(cPath)
+	if err != nil {
+		if iv != nil {
+			if err2 := nametransform.RestoreDirIV(cPath, iv); err2 != nil {
+				tlog.Warn.Printf("Rmdir %q: failed to restore the directory IV: %v", name, err2)
+			}
+		}
+		return fuse.ToStatus(err)
+	}
+	return fuse.OK
+}
+
+func (fs *cryptFS) Unlink(name string, context *fuse.Context) fuse.Status {
+	cName, status := fs.encryptPath(name)
+	if !status.Ok() {
+		return status
+	}
+	return fs.FileSystem.Unlink(cName, context)
+}
+
+// Symlink is not supported: link targets would be stored in plaintext
+func (fs *cryptFS) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	return fuse.ENOSYS
+}
+
+func (fs *cryptFS) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	return "", fuse.ENOSYS
+}
+
+// Extended attributes are not supported: they would be stored in plaintext
+func (fs *cryptFS) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	return nil, fuse.ENOSYS
+}
+
+func (fs *cryptFS) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	return nil, fuse.ENOSYS
+}
+
+func (fs *cryptFS) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	return fuse.ENOSYS
+}
+
+func (fs *cryptFS) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	return fuse.ENOSYS
+}
+
+func (fs *cryptFS) StatFs(name string) *fuse.StatfsOut {
+	cName, status := fs.encryptPath(name)
+	if !status.Ok() {
+		return nil
+	}
+	return fs.FileSystem.StatFs(cName)
+}
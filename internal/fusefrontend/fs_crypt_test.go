@@ -0,0 +1,60 @@
+package fusefrontend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/udt/wizefs/internal/cryptocore"
+	"bitbucket.org/udt/wizefs/internal/nametransform"
+)
+
+func TestCryptFSDirIV(t *testing.T) {
+	originDir, err := ioutil.TempDir("", "wizefs-cryptfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(originDir)
+
+	fs, err := newCryptFS(originDir, make([]byte, cryptocore.KeyLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(originDir, nametransform.DirIVFilename)); err != nil {
+		t.Fatalf("Origin has no directory IV: %v", err)
+	}
+
+	for _, dir := range []string{"a", "b", "a/a"} {
+		if status := fs.Mkdir(dir, 0755, nil); !status.Ok() {
+			t.Fatalf("Mkdir %s: %v", dir, status)
+		}
+	}
+	cA, _ := fs.encryptPath("a")
+	cAA, _ := fs.encryptPath("a/a")
+	if filepath.Base(cAA) == cA {
+		t.Errorf("Equal names in different directories have equal ciphertexts")
+	}
+
+	entries, status := fs.OpenDir("", nil)
+	if !status.Ok() {
+		t.Fatal(status)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %v", entries)
+	}
+
+	if status = fs.Rmdir("a", nil); status.Ok() {
+		t.Errorf("Not empty directory was removed")
+	}
+	if status = fs.Rmdir("a/a", nil); !status.Ok() {
+		t.Errorf("Rmdir a/a: %v", status)
+	}
+	// the IV of a was restored after the failed Rmdir
+	if entries, status = fs.OpenDir("a", nil); !status.Ok() || len(entries) != 0 {
+		t.Errorf("OpenDir a: %v %v", entries, status)
+	}
+	if status = fs.Rmdir("a", nil); !status.Ok() {
+		t.Errorf("Rmdir a: %v", status)
+	}
+}
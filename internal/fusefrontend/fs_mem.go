@@ -0,0 +1,522 @@
+package fusefrontend
+
+// FUSE operations on paths of ZipFS Buckets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+
+	"bitbucket.org/udt/wizefs/internal/tlog"
+	"bitbucket.org/udt/wizefs/internal/util"
+)
+
+const (
+	// DefaultMemoryLimit caps the file contents of a ZipFS Bucket that are
+	// held in memory
+	DefaultMemoryLimit int64 = 256 << 20
+
+	memBlockSize = 4096
+)
+
+// memNode is a file or a directory of the in-memory archive
+type memNode struct {
+	// mode is S_IFDIR or S_IFREG with the permission bits
+	mode  uint32
+	data  []byte
+	mtime time.Time
+	// unlinked files may still be open, their data doesn't count against
+	// the memory limit
+	unlinked bool
+}
+
+func (n *memNode) isDir() bool {
+	return n.mode&syscall.S_IFMT == syscall.S_IFDIR
+}
+
+func (n *memNode) fillAttr(a *fuse.Attr) {
+	a.Mode = n.mode
+	a.Size = uint64(len(n.data))
+	a.Blocks = (a.Size + 511) / 512
+	a.Nlink = 1
+	if n.isDir() {
+		a.Nlink = 2
+	}
+	a.SetTimes(&n.mtime, &n.mtime, &n.mtime)
+	a.Owner = *fuse.CurrentOwner()
+}
+
+// MemArchiveFS serves a zip or tar archive from memory. The archive is
+// loaded when the filesystem is created; changes are written back to the
+// archive by Flush, which is called on unmount and on fsync of a file.
+type MemArchiveFS struct {
+	pathfs.FileSystem // defaultFileSystem, returns ENOSYS for the rest
+	archive           string
+	limit             int64
+
+	// mutex protects all fields below
+	mutex sync.Mutex
+	// nodes maps the paths relative to the root to the nodes, "" is the root
+	nodes map[string]*memNode
+	// used is the size of all file contents
+	used  int64
+	dirty bool
+}
+
+var _ pathfs.FileSystem = &MemArchiveFS{} // Verify that interface is implemented.
+
+// NewMemArchiveFS loads the archive into memory. limit caps the size of the
+// file contents, 0 means DefaultMemoryLimit.
+// TEST: TestMemArchiveFS
+func NewMemArchiveFS(archive string, limit int64) (*MemArchiveFS, error) {
+	if limit <= 0 {
+		limit = DefaultMemoryLimit
+	}
+	fi, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &MemArchiveFS{
+		FileSystem: pathfs.NewDefaultFileSystem(),
+		archive:    archive,
+		limit:      limit,
+		nodes: map[string]*memNode{
+			"": {mode: syscall.S_IFDIR | 0755, mtime: fi.ModTime()},
+		},
+	}
+	if err = fs.load(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *MemArchiveFS) String() string {
+	return "MemArchiveFS(" + fs.archive + ")"
+}
+
+// load reads the archive. It's unpacked into a temporary directory by the
+// archivers of util, so ZipFS reads the same formats as LZFS.
+func (fs *MemArchiveFS) load() error {
+	tmp, err := ioutil.TempDir("", "wizefs-zipfs")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err = util.UnpackArchive(fs.archive, tmp); err != nil {
+		return err
+	}
+
+	return filepath.Walk(tmp, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == tmp {
+			return err
+		}
+		name, err := filepath.Rel(tmp, path)
+		if err != nil {
+			return err
+		}
+		node := &memNode{
+			mode:  uint32(info.Mode().Perm()),
+			mtime: info.ModTime(),
+		}
+		switch {
+		case info.IsDir():
+			node.mode |= syscall.S_IFDIR
+		case info.Mode().IsRegular():
+			if fs.used+info.Size() > fs.limit {
+				return fmt.Errorf("archive %s doesn't fit into the memory limit of %d bytes",
+					filepath.Base(fs.archive), fs.limit)
+			}
+			node.mode |= syscall.S_IFREG
+			if node.data, err = ioutil.ReadFile(path); err != nil {
+				return err
+			}
+			fs.used += int64(len(node.data))
+		default:
+			tlog.Warn.Printf("%s: skipping %s: not a regular file", fs.archive, name)
+			return nil
+		}
+		fs.nodes[name] = node
+		return nil
+	})
+}
+
+// Flush writes the changes back to the archive. The old archive is kept if
+// writing fails.
+func (fs *MemArchiveFS) Flush() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if !fs.dirty {
+		return nil
+	}
+
+	tmp, err := ioutil.TempDir("", "wizefs-zipfs")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// parents sort before their children
+	names := make([]string, 0, len(fs.nodes))
+	for name := range fs.nodes {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		node := fs.nodes[name]
+		path := filepath.Join(tmp, name)
+		perm := os.FileMode(node.mode & 07777)
+		if node.isDir() {
+			err = os.Mkdir(path, perm|0700)
+		} else {
+			err = ioutil.WriteFile(path, node.data, perm|0600)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// set the times after all entries of the directories are written
+	for _, name := range names {
+		node := fs.nodes[name]
+		if err = os.Chtimes(filepath.Join(tmp, name), node.mtime, node.mtime); err != nil {
+			return err
+		}
+	}
+
+	if err = util.PackArchive(tmp, fs.archive, util.PackOptions{}); err != nil {
+		return err
+	}
+	fs.dirty = false
+	tlog.Debug.Printf("%s: %d entries written", fs.archive, len(names))
+	return nil
+}
+
+// parent returns the status of the parent directory of the new entry
+func (fs *MemArchiveFS) parent(name string) fuse.Status {
+	dir, ok := fs.nodes[parentName(name)]
+	if !ok {
+		return fuse.ENOENT
+	}
+	if !dir.isDir() {
+		return fuse.ENOTDIR
+	}
+	return fuse.OK
+}
+
+func parentName(name string) string {
+	idx := strings.LastIndex(name, "/")
+	if idx == -1 {
+		return ""
+	}
+	return name[:idx]
+}
+
+// hasChildren checks if the directory is not empty
+func (fs *MemArchiveFS) hasChildren(name string) bool {
+	for path := range fs.nodes {
+		if path != "" && path != name && parentName(path) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resize changes the size of the file content, it fails with ENOSPC if
+// the memory limit is exceeded
+func (fs *MemArchiveFS) resize(node *memNode, size uint64) fuse.Status {
+	delta := int64(size) - int64(len(node.data))
+	if !node.unlinked {
+		if delta > 0 && fs.used+delta > fs.limit {
+			return fuse.Status(syscall.ENOSPC)
+		}
+		fs.used += delta
+	}
+	if delta > 0 {
+		node.data = append(node.data, make([]byte, delta)...)
+	} else {
+		node.data = node.data[:size]
+	}
+	node.mtime = time.Now()
+	fs.dirty = true
+	return fuse.OK
+}
+
+func (fs *MemArchiveFS) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, ok := fs.nodes[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	a := &fuse.Attr{}
+	node.fillAttr(a)
+	return a, fuse.OK
+}
+
+func (fs *MemArchiveFS) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, ok := fs.nodes[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	if !node.isDir() {
+		return nil, fuse.ENOTDIR
+	}
+
+	entries := []fuse.DirEntry{}
+	for path, child := range fs.nodes {
+		if path == "" || path == name || parentName(path) != name {
+			continue
+		}
+		entries = append(entries, fuse.DirEntry{
+			Name: filepath.Base(path),
+			Mode: child.mode,
+		})
+	}
+	return entries, fuse.OK
+}
+
+func (fs *MemArchiveFS) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, ok := fs.nodes[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	if node.isDir() {
+		return nil, fuse.Status(syscall.EISDIR)
+	}
+	if flags&syscall.O_TRUNC != 0 {
+		fs.resize(node, 0)
+	}
+	return newMemFile(fs, node), fuse.OK
+}
+
+func (fs *MemArchiveFS) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if node, ok := fs.nodes[name]; ok {
+		if flags&syscall.O_EXCL != 0 {
+			return nil, fuse.Status(syscall.EEXIST)
+		}
+		if node.isDir() {
+			return nil, fuse.Status(syscall.EISDIR)
+		}
+		if flags&syscall.O_TRUNC != 0 {
+			fs.resize(node, 0)
+		}
+		return newMemFile(fs, node), fuse.OK
+	}
+	if status := fs.parent(name); !status.Ok() {
+		return nil, status
+	}
+
+	node := &memNode{
+		mode:  syscall.S_IFREG | mode&07777,
+		mtime: time.Now(),
+	}
+	fs.nodes[name] = node
+	fs.dirty = true
+	return newMemFile(fs, node), fuse.OK
+}
+
+func (fs *MemArchiveFS) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if _, ok := fs.nodes[name]; ok {
+		return fuse.Status(syscall.EEXIST)
+	}
+	if status := fs.parent(name); !status.Ok() {
+		return status
+	}
+	fs.nodes[name] = &memNode{
+		mode:  syscall.S_IFDIR | mode&07777,
+		mtime: time.Now(),
+	}
+	fs.dirty = true
+	return fuse.OK
+}
+
+func (fs *MemArchiveFS) Rmdir(name string, context *fuse.Context) fuse.Status {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, ok := fs.nodes[name]
+	if !ok {
+		return fuse.ENOENT
+	}
+	if !node.isDir() {
+		return fuse.ENOTDIR
+	}
+	if name == "" {
+		return fuse.Status(syscall.EBUSY)
+	}
+	if fs.hasChildren(name) {
+		return fuse.Status(syscall.ENOTEMPTY)
+	}
+	delete(fs.nodes, name)
+	fs.dirty = true
+	return fuse.OK
+}
+
+func (fs *MemArchiveFS) Unlink(name string, context *fuse.Context) fuse.Status {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return fs.unlink(name)
+}
+
+func (fs *MemArchiveFS) unlink(name string) fuse.Status {
+	node, ok := fs.nodes[name]
+	if !ok {
+		return fuse.ENOENT
+	}
+	if node.isDir() {
+		return fuse.Status(syscall.EISDIR)
+	}
+	fs.used -= int64(len(node.data))
+	node.unlinked = true
+	delete(fs.nodes, name)
+	fs.dirty = true
+	return fuse.OK
+}
+
+func (fs *MemArchiveFS) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, ok := fs.nodes[oldName]
+	if !ok {
+		return fuse.ENOENT
+	}
+	if oldName == newName {
+		return fuse.OK
+	}
+	if oldName == "" || strings.HasPrefix(newName+"/", oldName+"/") {
+		// a directory can't be moved into itself
+		return fuse.EINVAL
+	}
+	if status := fs.parent(newName); !status.Ok() {
+		return status
+	}
+
+	if target, ok := fs.nodes[newName]; ok {
+		switch {
+		case node.isDir() && !target.isDir():
+			return fuse.ENOTDIR
+		case !node.isDir() && target.isDir():
+			return fuse.Status(syscall.EISDIR)
+		case target.isDir():
+			if fs.hasChildren(newName) {
+				return fuse.Status(syscall.ENOTEMPTY)
+			}
+			delete(fs.nodes, newName)
+		default:
+			fs.unlink(newName)
+		}
+	}
+
+	fs.nodes[newName] = node
+	delete(fs.nodes, oldName)
+	if node.isDir() {
+		prefix := oldName + "/"
+		for path, child := range fs.nodes {
+			if strings.HasPrefix(path, prefix) {
+				fs.nodes[newName+"/"+path[len(prefix):]] = child
+				delete(fs.nodes, path)
+			}
+		}
+	}
+	fs.dirty = true
+	return fuse.OK
+}
+
+func (fs *MemArchiveFS) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, ok := fs.nodes[name]
+	if !ok {
+		return fuse.ENOENT
+	}
+	if node.isDir() {
+		return fuse.Status(syscall.EISDIR)
+	}
+	return fs.resize(node, size)
+}
+
+func (fs *MemArchiveFS) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, ok := fs.nodes[name]
+	if !ok {
+		return fuse.ENOENT
+	}
+	node.mode = node.mode&syscall.S_IFMT | mode&07777
+	fs.dirty = true
+	return fuse.OK
+}
+
+func (fs *MemArchiveFS) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, ok := fs.nodes[name]
+	if !ok {
+		return fuse.ENOENT
+	}
+	if mtime != nil {
+		node.mtime = *mtime
+		fs.dirty = true
+	}
+	return fuse.OK
+}
+
+func (fs *MemArchiveFS) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if _, ok := fs.nodes[name]; !ok {
+		return fuse.ENOENT
+	}
+	return fuse.OK
+}
+
+// StatFs reports the memory limit as the size of the filesystem
+func (fs *MemArchiveFS) StatFs(name string) *fuse.StatfsOut {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	free := uint64(fs.limit-fs.used) / memBlockSize
+	return &fuse.StatfsOut{
+		Blocks:  uint64(fs.limit) / memBlockSize,
+		Bfree:   free,
+		Bavail:  free,
+		Files:   uint64(len(fs.nodes)),
+		Bsize:   memBlockSize,
+		NameLen: 255,
+		Frsize:  memBlockSize,
+	}
+}
@@ -0,0 +1,147 @@
+package fusefrontend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+
+	"bitbucket.org/udt/wizefs/internal/testutil"
+)
+
+// newTestArchive packs a directory with one file into the archive
+func newTestArchive(t *testing.T, dir, name string) string {
+	archive := filepath.Join(dir, name)
+	testutil.NewArchive(t, archive, map[string]string{"docs/a.txt": "hello"})
+	return archive
+}
+
+func readMemFile(t *testing.T, fs *MemArchiveFS, name string) []byte {
+	f, status := fs.Open(name, uint32(os.O_RDONLY), nil)
+	if !status.Ok() {
+		t.Fatalf("Open %s: %v", name, status)
+	}
+	defer f.Release()
+
+	buf := make([]byte, 1024)
+	res, status := f.Read(buf, 0)
+	if !status.Ok() {
+		t.Fatalf("Read %s: %v", name, status)
+	}
+	data, _ := res.Bytes(buf)
+	return data
+}
+
+func TestMemArchiveFS(t *testing.T) {
+	for _, name := range []string{"_test.zip", "_test.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "wizefs-memfs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			archive := newTestArchive(t, dir, name)
+
+			fs, err := NewMemArchiveFS(archive, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readMemFile(t, fs, "docs/a.txt"); string(got) != "hello" {
+				t.Errorf("docs/a.txt = %q, want %q", got, "hello")
+			}
+
+			// create, rename and remove through the filesystem
+			f, status := fs.Create("docs/b.txt", uint32(os.O_WRONLY), 0644, nil)
+			if !status.Ok() {
+				t.Fatalf("Create: %v", status)
+			}
+			if _, status = f.Write([]byte("world"), 0); !status.Ok() {
+				t.Fatalf("Write: %v", status)
+			}
+			f.Release()
+			if status = fs.Mkdir("new", 0755, nil); !status.Ok() {
+				t.Fatalf("Mkdir: %v", status)
+			}
+			if status = fs.Rename("docs/b.txt", "new/b.txt", nil); !status.Ok() {
+				t.Fatalf("Rename: %v", status)
+			}
+			if status = fs.Unlink("docs/a.txt", nil); !status.Ok() {
+				t.Fatalf("Unlink: %v", status)
+			}
+			if status = fs.Rmdir("new", nil); status != fuse.Status(syscall.ENOTEMPTY) {
+				t.Errorf("Rmdir of non-empty directory: %v, want ENOTEMPTY", status)
+			}
+
+			if err = fs.Flush(); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+
+			// the archive has the changes
+			fs, err = NewMemArchiveFS(archive, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readMemFile(t, fs, "new/b.txt"); string(got) != "world" {
+				t.Errorf("new/b.txt = %q, want %q", got, "world")
+			}
+			if _, status = fs.GetAttr("docs/a.txt", nil); status != fuse.ENOENT {
+				t.Errorf("GetAttr of removed file: %v, want ENOENT", status)
+			}
+			entries, status := fs.OpenDir("", nil)
+			if !status.Ok() || len(entries) != 2 {
+				t.Errorf("OpenDir: %v %v, want docs and new", entries, status)
+			}
+		})
+	}
+}
+
+func TestMemArchiveFSMemoryLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-memfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := newTestArchive(t, dir, "_test.zip")
+
+	// the archive doesn't fit
+	if _, err = NewMemArchiveFS(archive, 4); err == nil {
+		t.Errorf("Loading 5 bytes with limit 4 should fail")
+	}
+
+	fs, err := NewMemArchiveFS(archive, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, status := fs.Create("big", uint32(os.O_WRONLY), 0644, nil)
+	if !status.Ok() {
+		t.Fatalf("Create: %v", status)
+	}
+	defer f.Release()
+	if _, status = f.Write(make([]byte, 96), 0); status != fuse.Status(syscall.ENOSPC) {
+		t.Errorf("Write over the limit: %v, want ENOSPC", status)
+	}
+	if _, status = f.Write(make([]byte, 95), 0); !status.Ok() {
+		t.Errorf("Write up to the limit: %v", status)
+	}
+
+	// removed files free the memory
+	if status = fs.Unlink("docs/a.txt", nil); !status.Ok() {
+		t.Fatalf("Unlink: %v", status)
+	}
+	if status = f.Truncate(100); !status.Ok() {
+		t.Errorf("Truncate up to the limit: %v", status)
+	}
+
+	// the archive is unchanged until Flush
+	fs, err = NewMemArchiveFS(archive, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readMemFile(t, fs, "docs/a.txt"); !bytes.Equal(got, []byte("hello")) {
+		t.Errorf("docs/a.txt = %q, want %q", got, "hello")
+	}
+}
@@ -22,6 +22,20 @@ const (
 	ExitZip = 10
 
 	ExitFile = 11
+	// ExitPassword - the password of an encrypted Bucket is missing or wrong
+	ExitPassword = 12
+	// ExitPrecondition - the condition of a conditional put (If-Match,
+	// If-None-Match) failed
+	ExitPrecondition = 13
+	// ExitLock - the lock of the Storage or of the Bucket is held by another
+	// process for longer than the lock timeout
+	ExitLock = 14
+	// ExitVerify - verify found problems of the Storage, or clean could not
+	// repair them
+	ExitVerify = 15
+	// ExitIntegrity - the content of the file does not match the checksum
+	// stored when it was put
+	ExitIntegrity = 16
 
 	// ExitOpenConf - the was an error opening the .conf file for reading
 	ExitOpenConf = 20
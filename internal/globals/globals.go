@@ -8,6 +8,13 @@ import (
 const (
 	ProjectName    = "WizeFS"
 	ProjectVersion = "0.2.1"
+
+	// PasswordEnvVar is the environment variable with the password of an
+	// encrypted Bucket, it's used when the password is not passed as a flag
+	PasswordEnvVar = "WIZEFS_PASSWORD"
+	// LockTimeoutEnvVar is the environment variable with the lock timeout of
+	// the Storage, e.g. "5s"
+	LockTimeoutEnvVar = "WIZEFS_LOCK_TIMEOUT"
 )
 
 type FSType int
@@ -20,6 +27,20 @@ const (
 	LZFS
 )
 
+func (t FSType) String() string {
+	switch t {
+	case LoopbackFS:
+		return "LoopbackFS"
+	case ZipFS:
+		return "ZipFS"
+	case LZFS:
+		return "LZFS"
+	case NoneFS:
+		return "NoneFS"
+	}
+	return "HackFS"
+}
+
 func userHomeDir() string {
 	if runtime.GOOS == "windows" {
 		home := os.Getenv("HOMEDRIVE") + os.Getenv("HOMEPATH")
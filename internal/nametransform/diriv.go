@@ -0,0 +1,67 @@
+package nametransform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"bitbucket.org/udt/wizefs/internal/cryptocore"
+)
+
+const (
+	// DirIVFilename is the file with the random IV of a directory, it's
+	// created in every directory of an encrypted Bucket like
+	// gocryptfs.diriv of gocryptfs
+	DirIVFilename = "wizefs.diriv"
+	// DirIVLen is the length of the directory IV in bytes (the EME tweak)
+	DirIVLen = 16
+)
+
+// zeroDirIV is the IV of the directories created before directory IVs were
+// added, their names are encrypted with the all-zero tweak
+var zeroDirIV = make([]byte, DirIVLen)
+
+// ReadDirIV returns the IV of the encrypted directory dir. Directories
+// without the IV file were created by older versions and use the all-zero IV.
+func ReadDirIV(dir string) ([]byte, error) {
+	iv, err := ioutil.ReadFile(filepath.Join(dir, DirIVFilename))
+	if os.IsNotExist(err) {
+		return zeroDirIV, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != DirIVLen {
+		return nil, fmt.Errorf("invalid length %d of the directory IV in %s", len(iv), dir)
+	}
+	return iv, nil
+}
+
+// WriteDirIV creates the IV file with the new random IV in dir
+func WriteDirIV(dir string) error {
+	iv, err := cryptocore.RandBytes(DirIVLen)
+	if err != nil {
+		return err
+	}
+	return writeDirIV(dir, iv)
+}
+
+// RestoreDirIV writes back the IV that was removed from dir
+func RestoreDirIV(dir string, iv []byte) error {
+	return writeDirIV(dir, iv)
+}
+
+func writeDirIV(dir string, iv []byte) error {
+	// 0400 permissions: the IV never changes
+	fd, err := os.OpenFile(filepath.Join(dir, DirIVFilename), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(iv)
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
@@ -0,0 +1,115 @@
+// Package nametransform encrypts and decrypts file names with EME.
+package nametransform
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/rfjakob/eme"
+)
+
+const (
+	// NameMax is the longest encrypted name the backing filesystem accepts
+	NameMax = 255
+)
+
+// ErrBadName is returned when the encrypted name can't be decrypted
+var ErrBadName = errors.New("invalid encrypted name")
+
+// NameTransform encrypts every path component separately, so directories
+// can be listed and renamed without touching their children.
+//
+// Names are encrypted with the IV of their parent directory (see DirIVFilename),
+// so equal names in different directories have unrelated ciphertexts. EME is
+// a wide-block cipher, names that differ in any byte have unrelated
+// ciphertexts too.
+type NameTransform struct {
+	emeCipher *eme.EMECipher
+	b64       *base64.Encoding
+}
+
+// New returns the name transform that uses the EME cipher
+func New(e *eme.EMECipher) *NameTransform {
+	return &NameTransform{
+		emeCipher: e,
+		b64:       base64.RawURLEncoding,
+	}
+}
+
+// EncryptName encrypts one path component with the IV of its directory
+func (n *NameTransform) EncryptName(plainName string, iv []byte) (string, error) {
+	padded := pad16([]byte(plainName))
+	cipherName := n.b64.EncodeToString(n.emeCipher.Encrypt(iv, padded))
+	if len(cipherName) > NameMax {
+		return "", syscall.ENAMETOOLONG
+	}
+	return cipherName, nil
+}
+
+// DecryptName decrypts one path component with the IV of its directory
+func (n *NameTransform) DecryptName(cipherName string, iv []byte) (string, error) {
+	bin, err := n.b64.DecodeString(cipherName)
+	if err != nil || len(bin) == 0 || len(bin)%16 != 0 {
+		return "", ErrBadName
+	}
+	plain, err := unPad16(n.emeCipher.Decrypt(iv, bin))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// EncryptPath encrypts all components of the relative slash-separated path,
+// the IVs of the directories are read from the encrypted tree in rootDir
+func (n *NameTransform) EncryptPath(plainPath string, rootDir string) (string, error) {
+	if plainPath == "" {
+		return "", nil
+	}
+	parts := strings.Split(path.Clean(plainPath), "/")
+	dir := rootDir
+	for i, part := range parts {
+		iv, err := ReadDirIV(dir)
+		if err != nil {
+			return "", err
+		}
+		cipherName, err := n.EncryptName(part, iv)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = cipherName
+		dir = filepath.Join(dir, cipherName)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// pad16 adds PKCS#7 padding up to the multiple of 16 bytes
+func pad16(orig []byte) []byte {
+	padLen := 16 - len(orig)%16
+	return append(append([]byte(nil), orig...), bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+}
+
+// unPad16 removes PKCS#7 padding
+func unPad16(padded []byte) ([]byte, error) {
+	if len(padded) == 0 || len(padded)%16 != 0 {
+		return nil, ErrBadName
+	}
+	padLen := int(padded[len(padded)-1])
+	if padLen == 0 || padLen > 16 {
+		return nil, ErrBadName
+	}
+	for _, b := range padded[len(padded)-padLen:] {
+		if int(b) != padLen {
+			return nil, ErrBadName
+		}
+	}
+	plain := padded[:len(padded)-padLen]
+	if len(plain) == 0 || bytes.IndexByte(plain, '/') >= 0 || bytes.IndexByte(plain, 0) >= 0 {
+		return nil, ErrBadName
+	}
+	return plain, nil
+}
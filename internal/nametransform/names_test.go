@@ -0,0 +1,125 @@
+package nametransform
+
+import (
+	"bytes"
+	"crypto/aes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rfjakob/eme"
+)
+
+func newTestNameTransform(t *testing.T) *NameTransform {
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(eme.New(block))
+}
+
+func TestEncryptDecryptName(t *testing.T) {
+	n := newTestNameTransform(t)
+
+	for _, name := range []string{"a", "file.txt", "exactly16bytes!!", strings.Repeat("x", 150)} {
+		cipherName, err := n.EncryptName(name, zeroDirIV)
+		if err != nil {
+			t.Fatalf("EncryptName %q: %v", name, err)
+		}
+		if strings.Contains(cipherName, "/") || cipherName == name {
+			t.Errorf("Bad encrypted name %q of %q", cipherName, name)
+		}
+		plainName, err := n.DecryptName(cipherName, zeroDirIV)
+		if err != nil {
+			t.Fatalf("DecryptName %q: %v", cipherName, err)
+		}
+		if plainName != name {
+			t.Errorf("Expected %q, got %q", name, plainName)
+		}
+	}
+}
+
+func TestEncryptPath(t *testing.T) {
+	n := newTestNameTransform(t)
+	rootDir, err := ioutil.TempDir("", "wizefs-names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	if err = WriteDirIV(rootDir); err != nil {
+		t.Fatal(err)
+	}
+	rootIV, err := ReadDirIV(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rootIV, zeroDirIV) {
+		t.Fatalf("Directory IV is all-zero")
+	}
+	cDir, err := n.EncryptName("dir", rootIV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(filepath.Join(rootDir, cDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteDirIV(filepath.Join(rootDir, cDir)); err != nil {
+		t.Fatal(err)
+	}
+
+	cipherPath, err := n.EncryptPath("dir/dir", rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(cipherPath, "/")
+	if len(parts) != 2 {
+		t.Fatalf("Expected 2 components, got %q", cipherPath)
+	}
+	if parts[0] != cDir {
+		t.Errorf("Components are not encrypted separately")
+	}
+	// the same name in another directory has another ciphertext
+	if parts[1] == parts[0] {
+		t.Errorf("Equal names in different directories have equal ciphertexts")
+	}
+
+	if _, err = n.EncryptName(strings.Repeat("x", 200), rootIV); err == nil {
+		t.Errorf("Too long name was accepted")
+	}
+}
+
+func TestReadDirIVLegacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	iv, err := ReadDirIV(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(iv, zeroDirIV) {
+		t.Errorf("Directory without the IV file should use the all-zero IV")
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, DirIVFilename), []byte("short"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadDirIV(dir); err == nil {
+		t.Errorf("Invalid directory IV was accepted")
+	}
+}
+
+func TestDecryptBadName(t *testing.T) {
+	n := newTestNameTransform(t)
+
+	for _, name := range []string{"wizefs.conf", DirIVFilename, "", "AAAA"} {
+		if _, err := n.DecryptName(name, zeroDirIV); err == nil {
+			t.Errorf("Plaintext name %q was decrypted", name)
+		}
+	}
+}
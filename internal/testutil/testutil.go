@@ -0,0 +1,35 @@
+// Package testutil keeps the helpers that the tests of several packages
+// share. The tests of util can't use it, it packs archives with util.
+package testutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/udt/wizefs/internal/util"
+)
+
+// NewArchive packs the files into the archive, its type is taken from the
+// extension like for LZFS Buckets. The names are slash-separated paths.
+func NewArchive(t testing.TB, archive string, files map[string]string) {
+	src, err := ioutil.TempDir(filepath.Dir(archive), "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	for name, content := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = util.PackArchive(src, archive, util.PackOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
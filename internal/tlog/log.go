@@ -60,7 +60,7 @@ func (l *toggledLogger) Printf(format string, v ...interface{}) {
 	if !l.Enabled {
 		return
 	}
-	l.Logger.Printf(l.prefix + fmt.Sprintf(format, v...) + l.postfix)
+	l.Logger.Print(l.prefix + fmt.Sprintf(format, v...) + l.postfix)
 	if l.Wpanic {
 		l.Logger.Panic(wpanicMsg + fmt.Sprintf(format, v...))
 	}
@@ -0,0 +1,178 @@
+package util
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	dsbzip2 "github.com/dsnet/compress/bzip2"
+)
+
+// Archiver packs a directory into an archive file and unpacks it back.
+// LZFS Buckets use the Archiver that matches the extension of ORIGIN.
+type Archiver interface {
+	Pack(source, target string, opts PackOptions) error
+	Unpack(archive, target string) error
+	// Verify reads every entry of the archive and checks its checksums, it
+	// returns the names of the entries
+	Verify(archive string) (names []string, err error)
+}
+
+// PackOptions select the codec of zip archives, tar archives are compressed
+// by the Compressor of their extension
+type PackOptions struct {
+	// Codec is the name of the registered codec, "" - DefaultCodec
+	Codec string
+	// Level is the compression level, 0 - the default level of the codec
+	Level int
+}
+
+// Compressor wraps the stream of a tar archive
+type Compressor struct {
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCompressor compresses tar archives with gzip
+var GzipCompressor = &Compressor{
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+}
+
+// Bzip2Compressor compresses tar archives with bzip2. The standard library
+// can only decompress bzip2, so github.com/dsnet/compress writes it.
+var Bzip2Compressor = &Compressor{
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return dsbzip2.NewWriter(w, &dsbzip2.WriterConfig{Level: dsbzip2.DefaultCompression})
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	},
+}
+
+// archivers maps archive extensions to their backends
+var archivers = map[string]Archiver{
+	".zip":     zipArchiver{},
+	".tar":     tarArchiver{},
+	".tar.gz":  tarArchiver{compressor: GzipCompressor},
+	".tar.bz2": tarArchiver{compressor: Bzip2Compressor},
+}
+
+// ArchiveExtensions returns the supported archive extensions
+func ArchiveExtensions() []string {
+	exts := make([]string, 0, len(archivers))
+	for ext := range archivers {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// ArchiveExt returns the longest supported archive extension of the file
+// name or "" if it's not an archive
+func ArchiveExt(name string) string {
+	found := ""
+	for ext := range archivers {
+		if strings.HasSuffix(name, ext) && len(ext) > len(found) {
+			found = ext
+		}
+	}
+	return found
+}
+
+// IsArchive checks if the file name has a supported archive extension
+func IsArchive(name string) bool {
+	return ArchiveExt(name) != ""
+}
+
+// NewArchiver returns the Archiver that matches the extension of the
+// archive file name
+func NewArchiver(name string) (Archiver, error) {
+	archiver, ok := archivers[ArchiveExt(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported archive format: %s", filepath.Base(name))
+	}
+	return archiver, nil
+}
+
+// PackArchive packs the source directory into the target archive. The
+// archive is written to a temporary file first, so the old archive is kept
+// if packing fails.
+// TEST: TestPackUnpackArchive
+func PackArchive(source, target string, opts PackOptions) error {
+	archiver, err := NewArchiver(target)
+	if err != nil {
+		return err
+	}
+
+	// keep the extension, so the archive format is the same
+	tmp := filepath.Join(filepath.Dir(target), ".tmp-"+filepath.Base(target))
+	if err = archiver.Pack(source, tmp, opts); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+// UnpackArchive unpacks the archive into the target directory
+// TEST: TestPackUnpackArchive
+func UnpackArchive(archive, target string) error {
+	archiver, err := NewArchiver(archive)
+	if err != nil {
+		return err
+	}
+	return archiver.Unpack(archive, target)
+}
+
+// VerifyArchive checks the checksums of the archive (CRC-32 of the zip
+// entries, the checksum of the gzip or bzip2 stream) without unpacking it.
+// names are the entries relative to the root of the archive.
+// TEST: TestVerifyArchive
+func VerifyArchive(archive string) (names []string, err error) {
+	archiver, err := NewArchiver(archive)
+	if err != nil {
+		return nil, err
+	}
+	names, err = archiver.Verify(archive)
+	for i, name := range names {
+		names[i] = strings.TrimPrefix(path.Clean("/"+name), "/")
+	}
+	return names, err
+}
+
+// safeJoin joins the archive entry name to the target directory, it fails
+// if the entry points outside of the target
+func safeJoin(target, name string) (string, error) {
+	path := filepath.Join(target, name)
+	if path != filepath.Clean(target) &&
+		!strings.HasPrefix(path, filepath.Clean(target)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %s is outside of the target directory", name)
+	}
+	return path, nil
+}
+
+// zipArchiver is the archive/zip backend
+type zipArchiver struct{}
+
+func (zipArchiver) Pack(source, target string, opts PackOptions) error {
+	return ZipFile(source, target, opts)
+}
+
+func (zipArchiver) Unpack(archive, target string) error {
+	return UnzipFile(archive, target)
+}
+
+func (zipArchiver) Verify(archive string) ([]string, error) {
+	return VerifyZipFile(archive)
+}
@@ -0,0 +1,143 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPackUnpackArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	os.MkdirAll(filepath.Join(source, "sub"), 0755)
+	files := map[string][]byte{
+		"wizefs.conf":  []byte("{}"),
+		"sub/data.bin": bytes.Repeat([]byte("wizefs"), 10000),
+	}
+	mtime := time.Date(2018, 3, 1, 12, 30, 15, 0, time.UTC)
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(source, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(filepath.Join(source, name), mtime, mtime)
+	}
+
+	for _, ext := range ArchiveExtensions() {
+		archive := filepath.Join(dir, "bucket"+ext)
+		if err = PackArchive(source, archive, PackOptions{}); err != nil {
+			t.Fatalf("%s: Pack: %v", ext, err)
+		}
+
+		target := filepath.Join(dir, "target"+ext)
+		if err = UnpackArchive(archive, target); err != nil {
+			t.Fatalf("%s: Unpack: %v", ext, err)
+		}
+		for name, content := range files {
+			got, err := ioutil.ReadFile(filepath.Join(target, name))
+			if err != nil {
+				t.Fatalf("%s: %v", ext, err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("%s: content of %s differs", ext, name)
+			}
+			if fi, err := os.Stat(filepath.Join(target, name)); err != nil || !fi.ModTime().Equal(mtime) {
+				t.Errorf("%s: modification time of %s is not kept: %v", ext, name, err)
+			}
+		}
+	}
+}
+
+func TestVerifyArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// random content isn't compressed, so the middle of the archive is
+	// the content of data.bin
+	content := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	source := filepath.Join(dir, "source")
+	os.MkdirAll(source, 0755)
+	ioutil.WriteFile(filepath.Join(source, "wizefs.conf"), []byte("{}"), 0644)
+	ioutil.WriteFile(filepath.Join(source, "data.bin"), content, 0644)
+
+	for _, ext := range ArchiveExtensions() {
+		archive := filepath.Join(dir, "bucket"+ext)
+		if err = PackArchive(source, archive, PackOptions{}); err != nil {
+			t.Fatalf("%s: Pack: %v", ext, err)
+		}
+		names, err := VerifyArchive(archive)
+		if err != nil {
+			t.Errorf("%s: Verify: %v", ext, err)
+		}
+		found := false
+		for _, name := range names {
+			found = found || name == "wizefs.conf"
+		}
+		if !found {
+			t.Errorf("%s: names %v have no wizefs.conf", ext, names)
+		}
+
+		// plain tar has no checksum of the content
+		if ext == ".tar" {
+			continue
+		}
+		data, _ := ioutil.ReadFile(archive)
+		data[len(data)/2] ^= 0xff
+		ioutil.WriteFile(archive, data, 0644)
+		if _, err = VerifyArchive(archive); err == nil {
+			t.Errorf("%s: Verify of corrupted archive succeeded", ext)
+		}
+	}
+}
+
+func TestArchiveExt(t *testing.T) {
+	for name, want := range map[string]string{
+		"bucket.zip":     ".zip",
+		"bucket.tar":     ".tar",
+		"bucket.tar.gz":  ".tar.gz",
+		"bucket.tar.bz2": ".tar.bz2",
+		"bucket.gz":      "",
+		"bucket":         "",
+	} {
+		if got := ArchiveExt(name); got != want {
+			t.Errorf("ArchiveExt(%s): expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestUnpackTarOutsideTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, header := range []*tar.Header{
+		{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+	} {
+		var buf bytes.Buffer
+		w := tar.NewWriter(&buf)
+		w.WriteHeader(header)
+		w.Close()
+
+		archive := filepath.Join(dir, "evil.tar")
+		ioutil.WriteFile(archive, buf.Bytes(), 0644)
+		if err = UnpackArchive(archive, filepath.Join(dir, "target")); err == nil {
+			t.Errorf("Entry %s was unpacked", header.Name)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CodecBenchResult is the result of compressing a sample with one codec
+// level
+type CodecBenchResult struct {
+	Codec          string
+	Level          int
+	Size           int64
+	CompressedSize int64
+	CompressTime   time.Duration
+	DecompressTime time.Duration
+}
+
+// Ratio returns the compression ratio, original size / compressed size
+func (r CodecBenchResult) Ratio() float64 {
+	if r.CompressedSize == 0 {
+		return 0
+	}
+	return float64(r.Size) / float64(r.CompressedSize)
+}
+
+// CompressSpeed returns the compression throughput in MB/s
+func (r CodecBenchResult) CompressSpeed() float64 {
+	return megabytesPerSecond(r.Size, r.CompressTime)
+}
+
+// DecompressSpeed returns the decompression throughput in MB/s
+func (r CodecBenchResult) DecompressSpeed() float64 {
+	return megabytesPerSecond(r.Size, r.DecompressTime)
+}
+
+func megabytesPerSecond(size int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(size) / (1 << 20) / d.Seconds()
+}
+
+// LoadBenchSample reads all regular files of the directory
+func LoadBenchSample(dir string) (files [][]byte, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, content)
+		return nil
+	})
+	if err == nil && len(files) == 0 {
+		err = fmt.Errorf("%s has no files", dir)
+	}
+	return files, err
+}
+
+// BenchCodec compresses every file of the sample separately, like zip
+// archives do, then decompresses and checks them
+func BenchCodec(codec *Codec, level int, files [][]byte) (result CodecBenchResult, err error) {
+	if level, err = codec.Level(level); err != nil {
+		return result, err
+	}
+	result.Codec = codec.Name
+	result.Level = level
+
+	compressed := make([][]byte, len(files))
+	start := time.Now()
+	for i, content := range files {
+		var buf bytes.Buffer
+		w, err := codec.NewWriter(&buf, level)
+		if err != nil {
+			return result, err
+		}
+		if _, err = w.Write(content); err != nil {
+			return result, err
+		}
+		if err = w.Close(); err != nil {
+			return result, err
+		}
+		compressed[i] = buf.Bytes()
+		result.Size += int64(len(content))
+		result.CompressedSize += int64(buf.Len())
+	}
+	result.CompressTime = time.Since(start)
+
+	start = time.Now()
+	for i, data := range compressed {
+		r := codec.NewReader(bytes.NewReader(data))
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return result, err
+		}
+		if !bytes.Equal(content, files[i]) {
+			return result, fmt.Errorf("codec %s: decompressed content differs", codec.Name)
+		}
+	}
+	result.DecompressTime = time.Since(start)
+
+	return result, nil
+}
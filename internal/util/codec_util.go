@@ -0,0 +1,198 @@
+package util
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	dsbzip2 "github.com/dsnet/compress/bzip2"
+	"github.com/golang/snappy"
+)
+
+// Codec compresses the files of zip archives. Codecs without a standard zip
+// method use private method ids, such archives can be read by WizeFS only.
+type Codec struct {
+	Name   string
+	Method uint16
+	// MinLevel, MaxLevel and DefaultLevel are the compression levels,
+	// they are all 0 if the codec has no levels
+	MinLevel     int
+	MaxLevel     int
+	DefaultLevel int
+
+	NewWriter func(w io.Writer, level int) (io.WriteCloser, error)
+	NewReader func(r io.Reader) io.ReadCloser
+}
+
+const (
+	// DefaultCodec is used by Buckets without a codec in their config
+	DefaultCodec = "deflate"
+
+	// private zip method ids (the APPNOTE doesn't reserve a range, these
+	// are far from the assigned ones)
+	methodGzip   uint16 = 0xF701
+	methodSnappy uint16 = 0xF702
+	// methodBzip2 is the standard zip method of bzip2
+	methodBzip2 uint16 = 12
+)
+
+var codecs = map[string]*Codec{}
+
+// RegisterCodec adds the codec to the registry
+func RegisterCodec(c *Codec) {
+	if _, ok := codecs[c.Name]; ok {
+		panic("codec " + c.Name + " is registered twice")
+	}
+	codecs[c.Name] = c
+}
+
+// LookupCodec returns the registered codec, "" means DefaultCodec
+func LookupCodec(name string) (*Codec, error) {
+	if name == "" {
+		name = DefaultCodec
+	}
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// Codecs returns the registered codecs sorted by name
+func Codecs() []*Codec {
+	list := make([]*Codec, 0, len(codecs))
+	for _, c := range codecs {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Levels returns all compression levels of the codec
+func (c *Codec) Levels() []int {
+	levels := []int{}
+	for level := c.MinLevel; level <= c.MaxLevel; level++ {
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+// Level checks the level, 0 means DefaultLevel
+func (c *Codec) Level(level int) (int, error) {
+	if level == 0 {
+		return c.DefaultLevel, nil
+	}
+	if level < c.MinLevel || level > c.MaxLevel {
+		if c.MaxLevel == 0 {
+			return 0, fmt.Errorf("codec %s has no compression levels", c.Name)
+		}
+		return 0, fmt.Errorf("level of codec %s should be between %d and %d",
+			c.Name, c.MinLevel, c.MaxLevel)
+	}
+	return level, nil
+}
+
+// registerZip registers the codec in the zip writer with the level
+func (c *Codec) registerZip(w *zip.Writer, level int) {
+	if c.Method == zip.Store {
+		return
+	}
+	w.RegisterCompressor(c.Method, func(out io.Writer) (io.WriteCloser, error) {
+		return c.NewWriter(out, level)
+	})
+}
+
+// registerZipDecompressors registers all codecs in the zip reader
+func registerZipDecompressors(r *zip.Reader) {
+	for _, c := range codecs {
+		if c.Method == zip.Store || c.Method == zip.Deflate {
+			continue
+		}
+		r.RegisterDecompressor(c.Method, c.NewReader)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func init() {
+	RegisterCodec(&Codec{
+		Name:   "store",
+		Method: zip.Store,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		NewReader: ioutil.NopCloser,
+	})
+	RegisterCodec(&Codec{
+		Name:         "deflate",
+		Method:       zip.Deflate,
+		MinLevel:     flate.BestSpeed,
+		MaxLevel:     flate.BestCompression,
+		DefaultLevel: 6,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		},
+		NewReader: flate.NewReader,
+	})
+	RegisterCodec(&Codec{
+		Name:         "gzip",
+		Method:       methodGzip,
+		MinLevel:     gzip.BestSpeed,
+		MaxLevel:     gzip.BestCompression,
+		DefaultLevel: 6,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		NewReader: func(r io.Reader) io.ReadCloser {
+			gr, err := gzip.NewReader(r)
+			if err != nil {
+				return errReadCloser{err}
+			}
+			return gr
+		},
+	})
+	RegisterCodec(&Codec{
+		Name:         "bzip2",
+		Method:       methodBzip2,
+		MinLevel:     dsbzip2.BestSpeed,
+		MaxLevel:     dsbzip2.BestCompression,
+		DefaultLevel: dsbzip2.DefaultCompression,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return dsbzip2.NewWriter(w, &dsbzip2.WriterConfig{Level: level})
+		},
+		NewReader: func(r io.Reader) io.ReadCloser {
+			br, err := dsbzip2.NewReader(r, nil)
+			if err != nil {
+				return errReadCloser{err}
+			}
+			return br
+		},
+	})
+	// snappy is the fast pure-Go codec, it has no levels
+	RegisterCodec(&Codec{
+		Name:   "snappy",
+		Method: methodSnappy,
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return snappy.NewBufferedWriter(w), nil
+		},
+		NewReader: func(r io.Reader) io.ReadCloser {
+			return ioutil.NopCloser(snappy.NewReader(r))
+		},
+	})
+}
+
+// errReadCloser returns the error of the failed reader constructor
+type errReadCloser struct {
+	err error
+}
+
+func (r errReadCloser) Read(p []byte) (int, error) { return 0, r.err }
+func (r errReadCloser) Close() error               { return nil }
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZipFileCodecs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wizefs-codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	os.MkdirAll(source, 0755)
+	content := bytes.Repeat([]byte("wizefs codec "), 5000)
+	if err = ioutil.WriteFile(filepath.Join(source, "data"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, codec := range Codecs() {
+		for _, level := range append(codec.Levels(), 0) {
+			archive := filepath.Join(dir, "bucket.zip")
+			if err = ZipFile(source, archive, PackOptions{Codec: codec.Name, Level: level}); err != nil {
+				t.Fatalf("%s/%d: ZipFile: %v", codec.Name, level, err)
+			}
+			target := filepath.Join(dir, "target")
+			os.RemoveAll(target)
+			if err = UnzipFile(archive, target); err != nil {
+				t.Fatalf("%s/%d: UnzipFile: %v", codec.Name, level, err)
+			}
+			got, err := ioutil.ReadFile(filepath.Join(target, "data"))
+			if err != nil || !bytes.Equal(got, content) {
+				t.Errorf("%s/%d: content differs (%v)", codec.Name, level, err)
+			}
+		}
+	}
+}
+
+func TestCodecLevel(t *testing.T) {
+	if _, err := LookupCodec("unknown"); err == nil {
+		t.Errorf("Unknown codec was found")
+	}
+
+	deflate, err := LookupCodec("")
+	if err != nil || deflate.Name != DefaultCodec {
+		t.Fatalf("Expected default codec, got %v", err)
+	}
+	if _, err = deflate.Level(10); err == nil {
+		t.Errorf("Level 10 of deflate was accepted")
+	}
+
+	snappy, _ := LookupCodec("snappy")
+	if _, err = snappy.Level(1); err == nil {
+		t.Errorf("Level of snappy was accepted")
+	}
+}
+
+func TestBenchCodec(t *testing.T) {
+	files := [][]byte{bytes.Repeat([]byte("a"), 1000), []byte("b")}
+	for _, codec := range Codecs() {
+		result, err := BenchCodec(codec, 0, files)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.Name, err)
+		}
+		if result.Size != 1001 || result.CompressedSize == 0 {
+			t.Errorf("%s: wrong sizes %d/%d", codec.Name, result.Size, result.CompressedSize)
+		}
+	}
+}
@@ -23,6 +23,8 @@ func init() {
 // forkChild - execute ourselves once again, this time with the "-fg" flag, and
 // wait for SIGUSR1 or child exit.
 // This is a workaround for the missing true fork function in Go.
+// It returns the exit code of the parent: 0 if the child has mounted the
+// filesystem (SIGUSR1), otherwise the exit code of the child.
 func ForkChild() int {
 	name := os.Args[0]
 	pid := os.Getpid()
@@ -33,7 +35,10 @@ func ForkChild() int {
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	c.Stdin = os.Stdin
-	exitOnUsr1()
+	// The child sends us USR1 if the mount was successful.
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
 	err := c.Start()
 
 	if err != nil {
@@ -43,11 +48,20 @@ func ForkChild() int {
 
 	tlog.Debug.Printf("forkChild: starting %s with PID = %d", name, pid)
 
-	err = c.Wait()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- c.Wait()
+	}()
+	select {
+	case <-usr1:
+		// the child keeps serving the filesystem
+		return 0
+	case err = <-exited:
+	}
 	if err != nil {
 		if exiterr, ok := err.(*exec.ExitError); ok {
 			if waitstat, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-				os.Exit(waitstat.ExitStatus())
+				return waitstat.ExitStatus()
 			}
 		}
 		tlog.Warn.Printf("forkChild: wait returned an unknown error: %v", err)
@@ -57,14 +71,3 @@ func ForkChild() int {
 	// The child exited with 0 - let's do the same.
 	return 0
 }
-
-// The child sends us USR1 if the mount was successful. Exit with error code
-// 0 if we get it.
-func exitOnUsr1() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGUSR1)
-	go func() {
-		<-c
-		os.Exit(0)
-	}()
-}
@@ -0,0 +1,208 @@
+package util
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tarArchiver is the archive/tar backend, compressor is nil for plain tar
+type tarArchiver struct {
+	compressor *Compressor
+}
+
+func (a tarArchiver) Pack(source, target string, opts PackOptions) (err error) {
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	var w io.Writer = file
+	if a.compressor != nil {
+		cw, err := a.compressor.NewWriter(file)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := cw.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = cw
+	}
+
+	archive := tar.NewWriter(w)
+	defer func() {
+		if cerr := archive.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(source, path)
+		if err != nil || name == "." {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err = archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(archive, file)
+		return err
+	})
+}
+
+func (a tarArchiver) Unpack(archive, target string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if a.compressor != nil {
+		cr, err := a.compressor.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer cr.Close()
+		r = cr
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := safeJoin(target, header.Name)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(path, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err = unpackTarFile(reader, path, mode); err != nil {
+				return err
+			}
+			os.Chtimes(path, header.ModTime, header.ModTime)
+		case tar.TypeSymlink:
+			// a link out of the target would let later entries escape it
+			rel, err := filepath.Rel(target, filepath.Join(filepath.Dir(path), header.Linkname))
+			if err != nil || filepath.IsAbs(header.Linkname) || strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("archive entry %s links outside of the target directory",
+					header.Name)
+			}
+			os.Remove(path)
+			if err = os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		default:
+			// devices, fifos and hard links are not stored in Buckets
+			continue
+		}
+	}
+}
+
+func (a tarArchiver) Verify(archive string) (names []string, err error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if a.compressor != nil {
+		cr, err := a.compressor.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer cr.Close()
+		r = cr
+	}
+
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return names, err
+		}
+		names = append(names, header.Name)
+		if _, err = io.Copy(ioutil.Discard, reader); err != nil {
+			return names, fmt.Errorf("%s: %v", header.Name, err)
+		}
+	}
+	// the checksum of the compressed stream is checked at its end
+	_, err = io.Copy(ioutil.Discard, r)
+	return names, err
+}
+
+func unpackTarFile(reader io.Reader, path string, mode os.FileMode) (err error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.Copy(file, reader)
+	return err
+}
@@ -2,7 +2,9 @@ package util
 
 import (
 	"archive/zip"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,13 +17,17 @@ func UnzipFile(archive, target string) (err error) {
 		return err
 	}
 	defer reader.Close()
+	registerZipDecompressors(&reader.Reader)
 
 	if err := os.MkdirAll(target, 0755); err != nil {
 		return err
 	}
 
 	for _, file := range reader.File {
-		path := filepath.Join(target, file.Name)
+		path, err := safeJoin(target, file.Name)
+		if err != nil {
+			return err
+		}
 
 		if file.FileInfo().IsDir() {
 			os.MkdirAll(path, file.Mode())
@@ -61,30 +67,77 @@ func UnzipFile(archive, target string) (err error) {
 
 		fileReader.Close()
 		targetFile.Close()
+		// the checksum index of a Bucket relies on the modification times
+		if !file.Modified.IsZero() {
+			os.Chtimes(path, file.Modified, file.Modified)
+		}
 	}
 
 	return nil
 }
 
+// VerifyZipFile reads every file of the archive, archive/zip checks its
+// CRC-32 at the end of the file
+func VerifyZipFile(archive string) (names []string, err error) {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	registerZipDecompressors(&reader.Reader)
+
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		fileReader, err := file.Open()
+		if err != nil {
+			return names, fmt.Errorf("%s: %v", file.Name, err)
+		}
+		_, err = io.Copy(ioutil.Discard, fileReader)
+		fileReader.Close()
+		if err != nil {
+			return names, fmt.Errorf("%s: %v", file.Name, err)
+		}
+	}
+	return names, nil
+}
+
+// ZipFile packs the source directory with the codec from opts
 // TEST: TestZipFile
-func ZipFile(source, target string) (err error) {
-	zipfile, err := os.Create(target)
+func ZipFile(source, target string, opts PackOptions) (err error) {
+	codec, err := LookupCodec(opts.Codec)
+	if err != nil {
+		return err
+	}
+	level, err := codec.Level(opts.Level)
 	if err != nil {
 		return err
 	}
-	defer zipfile.Close()
 
-	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
+	zipfile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := zipfile.Close(); err == nil {
+			err = cerr
+		}
+	}()
 
 	info, err := os.Stat(source)
 	if err != nil {
-		return nil
+		return err
 	}
 
+	archive := zip.NewWriter(zipfile)
+	codec.registerZip(archive, level)
+
 	sourceIsDir := info.IsDir()
 
-	filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+	// errors are returned, so a broken archive never replaces the old one
+	err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -105,7 +158,7 @@ func ZipFile(source, target string) (err error) {
 		if info.IsDir() {
 			header.Name += "/"
 		} else {
-			header.Method = zip.Deflate
+			header.Method = codec.Method
 		}
 
 		writer, err := archive.CreateHeader(header)
@@ -132,5 +185,8 @@ func ZipFile(source, target string) (err error) {
 		return err
 	})
 
+	if cerr := archive.Close(); err == nil {
+		err = cerr
+	}
 	return err
 }
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
+
+	"bitbucket.org/udt/wizefs/internal/auth"
+	"bitbucket.org/udt/wizefs/internal/core"
+	"bitbucket.org/udt/wizefs/internal/globals"
+)
+
+// ConfigPath returns the path of the config file inside the Storage
+// directory
+func ConfigPath(filename string) string {
+	return storage.DirPath + filename
+}
+
+// Authenticate is the middleware of the negroni chain that lets in only the
+// requests authenticated by authenticator, the public paths are open to
+// anyone
+func Authenticate(authenticator auth.Authenticator, public ...string) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		for _, path := range public {
+			if r.URL.Path == path {
+				next(w, r)
+				return
+			}
+		}
+		// CORS preflight requests carry no credentials
+		if r.Method == "OPTIONS" {
+			next(w, r)
+			return
+		}
+
+		identity, err := authenticator.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wizefs"`)
+			displayAppError(w, err, "Authentication failed!",
+				http.StatusUnauthorized, globals.ExitUsage)
+			return
+		}
+		next(w, auth.WithIdentity(r, identity))
+	}
+}
+
+// Authorize checks that the principal of the request has the permission
+// for the Bucket of the route; admins have every permission
+func Authorize(perm core.ACLPermission, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity := auth.FromRequest(r)
+		if identity != nil && identity.Admin {
+			handler(w, r)
+			return
+		}
+
+		origin := mux.Vars(r)["origin"]
+		acl, _, err := storage.ACL(origin)
+		if err != nil || identity == nil || !acl.Allows(identity.Name, perm) {
+			// unknown Buckets are not revealed to the users without access
+			displayAppError(w, nil,
+				fmt.Sprintf("Access to Bucket %s is denied, %s permission is required",
+					origin, perm),
+				http.StatusForbidden, globals.ExitOrigin)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// AuthorizeAdmin lets only admins use the Storage-wide route
+func AuthorizeAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if identity := auth.FromRequest(r); identity == nil || !identity.Admin {
+			displayAppError(w, nil, "Access is denied, admin is required",
+				http.StatusForbidden, globals.ExitUsage)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// GetACL returns the access control list of the Bucket
+func GetACL(w http.ResponseWriter, r *http.Request) {
+	origin := mux.Vars(r)["origin"]
+	acl, exitCode, err := storage.ACL(origin)
+	if err != nil {
+		displayStorageError(w, err, exitCode)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK,
+		&ACLResponse{
+			Success: true,
+			ACL:     acl,
+		})
+}
+
+// SetACL replaces the access control list of the Bucket
+func SetACL(w http.ResponseWriter, r *http.Request) {
+	origin := mux.Vars(r)["origin"]
+
+	var aclResource ACLResource
+	if err := json.NewDecoder(r.Body).Decode(&aclResource); err != nil {
+		displayAppError(w, err, "Invalid ACL data",
+			http.StatusBadRequest, globals.ExitUsage)
+		return
+	}
+	if exitCode, err := storage.SetACL(origin, &aclResource.Data); err != nil {
+		displayStorageError(w, err, exitCode)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK,
+		&ACLResponse{
+			Success: true,
+			ACL:     &aclResource.Data,
+		})
+}
@@ -1,13 +1,13 @@
 package controllers
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"os/exec"
-	"syscall"
+	"time"
 
+	"bitbucket.org/udt/wizefs/internal/auth"
 	"bitbucket.org/udt/wizefs/internal/core"
 	"bitbucket.org/udt/wizefs/internal/globals"
 	"github.com/gorilla/mux"
@@ -20,6 +20,11 @@ func init() {
 	fmt.Printf("storage buckets: %s\n", storage)
 }
 
+// Shutdown unmounts all Buckets that were mounted by the REST service
+func Shutdown() {
+	storage.Close()
+}
+
 func Home(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, "HOME")
 }
@@ -31,15 +36,32 @@ func CreateBucket(w http.ResponseWriter, r *http.Request) {
 	if err != nil ||
 		bucketResource.Data.Origin == "" {
 		displayAppError(w, err, "Invalid Bucket data",
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusBadRequest, globals.ExitOrigin)
 		return
 	}
 
 	// Create a Bucket
-	if exitCode, err := storage.Create(bucketResource.Data.Origin); err != nil {
-		displayAppError(w, err,
-			fmt.Sprintf("Error: %s Exit code: %d", err.Error(), exitCode),
-			http.StatusInternalServerError, exitCode)
+	opts := core.BucketOptions{
+		Password: bucketResource.Data.Password,
+	}
+	if bucketResource.Data.AutoMount != nil {
+		opts.AutoMount = *bucketResource.Data.AutoMount
+	}
+	// the user creating the Bucket owns it, admins may give it to others
+	if identity := auth.FromRequest(r); identity != nil {
+		acl := core.BucketACL{}
+		if bucketResource.Data.ACL != nil {
+			acl = *bucketResource.Data.ACL
+		}
+		if acl.Owner == "" || !identity.Admin {
+			acl.Owner = identity.Name
+		}
+		opts.ACL = &acl
+		bucketResource.Data.ACL = &acl
+	}
+	bucketResource.Data.Password = ""
+	if exitCode, err := storage.CreateWithOptions(bucketResource.Data.Origin, opts); err != nil {
+		displayStorageError(w, err, exitCode)
 		return
 	}
 
@@ -51,6 +73,33 @@ func CreateBucket(w http.ResponseWriter, r *http.Request) {
 		})
 }
 
+func ListBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, exitCode, err := storage.List()
+	if err != nil {
+		displayStorageError(w, err, exitCode)
+		return
+	}
+	// the users see the Buckets they may read
+	if identity := auth.FromRequest(r); identity != nil && !identity.Admin {
+		allowed := []core.BucketInfo{}
+		for _, bucket := range buckets {
+			if bucket.ACL.Allows(identity.Name, core.ACLRead) {
+				allowed = append(allowed, bucket)
+			}
+		}
+		buckets = allowed
+	}
+	if buckets == nil {
+		buckets = []core.BucketInfo{}
+	}
+
+	respondWithJSON(w, http.StatusOK,
+		&BucketsResponse{
+			Success: true,
+			Buckets: buckets,
+		})
+}
+
 func DeleteBucket(w http.ResponseWriter, r *http.Request) {
 	// Get origin from the incoming url
 	vars := mux.Vars(r)
@@ -59,15 +108,13 @@ func DeleteBucket(w http.ResponseWriter, r *http.Request) {
 	if origin == "" {
 		displayAppError(w, nil,
 			"Please check request URL!",
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusBadRequest, globals.ExitOrigin)
 		return
 	}
 
 	// Delete a Bucket
 	if exitCode, err := storage.Delete(origin); err != nil {
-		displayAppError(w, err,
-			fmt.Sprintf("Error: %s Exit code: %d", err.Error(), exitCode),
-			http.StatusInternalServerError, exitCode)
+		displayStorageError(w, err, exitCode)
 		return
 	}
 
@@ -88,59 +135,30 @@ func MountBucket(w http.ResponseWriter, r *http.Request) {
 	if origin == "" {
 		displayAppError(w, nil,
 			"Please check request URL!",
-			http.StatusInternalServerError, globals.ExitOrigin)
-		return
-	}
-
-	// FIXME: clean/reset buffer memory?
-	var outbuf, errbuf bytes.Buffer
-
-	// Mount a Bucket via mount App
-	appPath := projectPath + mountApp
-	fmt.Println("appPath:", appPath)
-	c := exec.Command(appPath, origin)
-	c.Stdout = &outbuf
-	c.Stderr = &errbuf
-
-	cerr := c.Start()
-	if cerr != nil {
-		displayAppError(w, cerr,
-			fmt.Sprintf("starting command failed: %v", cerr),
-			http.StatusInternalServerError, globals.ExitMountPoint)
+			http.StatusBadRequest, globals.ExitOrigin)
 		return
 	}
 
-	cerr = c.Wait()
-	if cerr != nil {
-		if exiterr, ok := cerr.(*exec.ExitError); ok {
-			if waitstat, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-				displayAppError(w, cerr,
-					fmt.Sprintf("wait returned an exit status: %d [%s]", waitstat.ExitStatus(), errbuf.String()[:errbuf.Len()-1]),
-					http.StatusInternalServerError, waitstat.ExitStatus())
-				return
-			}
-		} else {
-			displayAppError(w, cerr,
-				fmt.Sprintf("wait returned an unknown error: %v [%s]", cerr, errbuf.String()[:errbuf.Len()-1]),
-				http.StatusInternalServerError, globals.ExitMountPoint)
+	// the body is optional, it carries the password of an encrypted Bucket
+	var bucketResource BucketResource
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&bucketResource)
+		if err != nil && err != io.EOF {
+			displayAppError(w, err, "Invalid Bucket data",
+				http.StatusBadRequest, globals.ExitUsage)
 			return
 		}
 	}
-
-	// print mountApp stdout buffer
-	//fmt.Println(outbuf.String())
-
-	// FIXME: Mounting the Bucket
-	fsinfo, _, err := storage.Config.GetInfoByOrigin(origin)
-	bucket, ok := storage.Bucket(origin)
-	if err == nil {
-		if ok {
-			bucket.SetMounted(true)
-			bucket.MountPoint = fsinfo.MountpointKey
-		}
+	opts := core.MountOptions{
+		Password: bucketResource.Data.Password,
+		IdleTTL:  time.Duration(bucketResource.Data.IdleTTL) * time.Second,
 	}
 
-	//fmt.Printf("Bucket: %+v\n", bucket)
+	// Mount a Bucket inside the REST service process
+	if exitCode, err := storage.MountManagedWithOptions(origin, opts); err != nil {
+		displayStorageError(w, err, exitCode)
+		return
+	}
 
 	//w.WriteHeader(http.StatusNoContent)
 	respondWithJSON(w, http.StatusOK,
@@ -159,15 +177,13 @@ func UnmountBucket(w http.ResponseWriter, r *http.Request) {
 	if origin == "" {
 		displayAppError(w, nil,
 			"Please check request URL!",
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusBadRequest, globals.ExitOrigin)
 		return
 	}
 
 	// Unmount a Bucket
 	if exitCode, err := storage.Unmount(origin); err != nil {
-		displayAppError(w, err,
-			fmt.Sprintf("Error: %s Exit code: %d", err.Error(), exitCode),
-			http.StatusInternalServerError, exitCode)
+		displayStorageError(w, err, exitCode)
 		return
 	}
 
@@ -188,7 +204,7 @@ func StateBucket(w http.ResponseWriter, r *http.Request) {
 	if origin == "" {
 		displayAppError(w, nil,
 			"Please check request URL!",
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusBadRequest, globals.ExitOrigin)
 		return
 	}
 
@@ -199,10 +215,52 @@ func StateBucket(w http.ResponseWriter, r *http.Request) {
 		mounted = bucket.IsMounted()
 	}
 
+	response := &BucketStateResponse{
+		Success: true,
+		Created: created,
+		Mounted: mounted,
+	}
+	if mounted {
+		if info, _, err := storage.Session(origin); err == nil {
+			response.IdleTTL = int64(info.IdleTTL / time.Second)
+			response.IdleRemaining = int64(info.Remaining / time.Second)
+		}
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// VerifyStorage reports the problems of the whole Storage
+func VerifyStorage(w http.ResponseWriter, r *http.Request) {
+	problems, exitCode, err := storage.Verify("")
+	respondWithProblems(w, problems, exitCode, err)
+}
+
+// VerifyBucket reports the problems of the Bucket
+func VerifyBucket(w http.ResponseWriter, r *http.Request) {
+	problems, exitCode, err := storage.Verify(mux.Vars(r)["origin"])
+	respondWithProblems(w, problems, exitCode, err)
+}
+
+// CleanStorage repairs the problems of the Storage, with dryrun=true it
+// only reports what would be repaired
+func CleanStorage(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dryrun") == "true"
+	problems, exitCode, err := storage.Clean(dryRun)
+	respondWithProblems(w, problems, exitCode, err)
+}
+
+func respondWithProblems(w http.ResponseWriter, problems []core.Problem, exitCode int, err error) {
+	if err != nil {
+		displayStorageError(w, err, exitCode)
+		return
+	}
+	if problems == nil {
+		problems = []core.Problem{}
+	}
+
 	respondWithJSON(w, http.StatusOK,
-		&BucketStateResponse{
-			Success: true,
-			Created: created,
-			Mounted: mounted,
+		&ProblemsResponse{
+			Success:  true,
+			Problems: problems,
 		})
 }
@@ -6,9 +6,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 
+	"bitbucket.org/udt/wizefs/internal/core"
 	"bitbucket.org/udt/wizefs/internal/globals"
 )
 
@@ -20,7 +24,7 @@ func PutFile(w http.ResponseWriter, r *http.Request) {
 	if origin == "" {
 		displayAppError(w, nil,
 			"Please check request URL!",
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusBadRequest, globals.ExitOrigin)
 		return
 	}
 
@@ -28,7 +32,7 @@ func PutFile(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
 		displayAppError(w, err,
 			"Parsing multipart form was failed! Check your request, please!",
-			http.StatusInternalServerError, globals.ExitFile)
+			http.StatusBadRequest, globals.ExitFile)
 		return
 	}
 
@@ -36,12 +40,16 @@ func PutFile(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		displayAppError(w, err,
 			"Openning file was failed! Check your request, please!",
-			http.StatusInternalServerError, globals.ExitFile)
+			http.StatusBadRequest, globals.ExitFile)
 		return
 	}
 	defer file.Close()
 
+	// the optional path field puts the file into a directory of the Bucket
 	filename := header.Filename
+	if formPath := r.FormValue("path"); formPath != "" {
+		filename = formPath
+	}
 	//fmt.Println("filename:", filename)
 
 	// Copy the file data to the buffer
@@ -53,15 +61,14 @@ func PutFile(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		displayAppError(w, nil,
 			fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin),
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusNotFound, globals.ExitOrigin)
 		return
 	}
-	if exitCode, err := bucket.PutFile(filename, buf.Bytes()); err != nil {
-		displayAppError(w, err,
-			fmt.Sprintf("Error: %s. Exit code: %d", err.Error(), exitCode),
-			http.StatusInternalServerError, exitCode)
+	if exitCode, err := bucket.PutFileWithOptions(filename, buf.Bytes(), putOptions(r)); err != nil {
+		displayStorageError(w, err, exitCode)
 		return
 	}
+	setETag(w, contentChecksum(buf.Bytes()))
 
 	//w.WriteHeader(http.StatusNoContent)
 	respondWithJSON(w, http.StatusOK,
@@ -80,7 +87,7 @@ func Put(w http.ResponseWriter, r *http.Request) {
 	if origin == "" {
 		displayAppError(w, nil,
 			"Please check request URL!",
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusBadRequest, globals.ExitOrigin)
 		return
 	}
 
@@ -90,7 +97,7 @@ func Put(w http.ResponseWriter, r *http.Request) {
 	if err != nil ||
 		putResource.Data.Filename == "" {
 		displayAppError(w, err, "Invalid Put data",
-			http.StatusInternalServerError, globals.ExitFile)
+			http.StatusBadRequest, globals.ExitFile)
 		return
 	}
 
@@ -98,15 +105,15 @@ func Put(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		displayAppError(w, nil,
 			fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin),
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusNotFound, globals.ExitOrigin)
 		return
 	}
-	if exitCode, err := bucket.PutFile(putResource.Data.Filename, []byte(putResource.Data.Content)); err != nil {
-		displayAppError(w, err,
-			fmt.Sprintf("Error: %s. Exit code: %d", err.Error(), exitCode),
-			http.StatusInternalServerError, exitCode)
+	if exitCode, err := bucket.PutFileWithOptions(putResource.Data.Filename,
+		[]byte(putResource.Data.Content), putOptions(r)); err != nil {
+		displayStorageError(w, err, exitCode)
 		return
 	}
+	setETag(w, contentChecksum([]byte(putResource.Data.Content)))
 
 	//w.WriteHeader(http.StatusNoContent)
 	respondWithJSON(w, http.StatusOK,
@@ -117,6 +124,18 @@ func Put(w http.ResponseWriter, r *http.Request) {
 		})
 }
 
+// putOptions reads the put mode from the request: the If-Match and
+// If-None-Match headers with an ETag (quoted or not) or *, and the
+// overwrite=true query parameter
+func putOptions(r *http.Request) core.PutOptions {
+	overwrite, _ := strconv.ParseBool(r.URL.Query().Get("overwrite"))
+	return core.PutOptions{
+		Overwrite:   overwrite,
+		IfMatch:     strings.Trim(r.Header.Get("If-Match"), `"`),
+		IfNoneMatch: strings.Trim(r.Header.Get("If-None-Match"), `"`),
+	}
+}
+
 func GetFile(w http.ResponseWriter, r *http.Request) {
 	// Get origin and filename from the incoming url
 	vars := mux.Vars(r)
@@ -126,14 +145,14 @@ func GetFile(w http.ResponseWriter, r *http.Request) {
 	if origin == "" {
 		displayAppError(w, nil,
 			"Please check request URL!",
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusBadRequest, globals.ExitOrigin)
 		return
 	}
 
 	if filename == "" {
 		displayAppError(w, nil,
 			"Please check request URL!",
-			http.StatusInternalServerError, globals.ExitFile)
+			http.StatusBadRequest, globals.ExitFile)
 		return
 	}
 
@@ -142,19 +161,19 @@ func GetFile(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		displayAppError(w, nil,
 			fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin),
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusNotFound, globals.ExitOrigin)
 		return
 	}
 	content, exitCode, err := bucket.GetFile(filename, "", true)
 	if err != nil {
-		displayAppError(w, err,
-			fmt.Sprintf("Error: %s. Exit code: %d", err.Error(), exitCode),
-			http.StatusInternalServerError, exitCode)
+		displayStorageError(w, err, exitCode)
 		return
 	}
 
-	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	w.Header().Set("Content-Disposition", "attachment; filename="+path.Base(filename))
 	w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+	// GetFile has verified the content by its stored checksum
+	setETag(w, contentChecksum(content))
 
 	if _, err := w.Write(content); err != nil {
 		displayAppError(w, err, "", http.StatusInternalServerError, globals.ExitFile)
@@ -173,7 +192,7 @@ func RemoveFile(w http.ResponseWriter, r *http.Request) {
 	if origin == "" || filename == "" {
 		displayAppError(w, nil,
 			"Please check request URL!",
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusBadRequest, globals.ExitOrigin)
 		return
 	}
 
@@ -182,13 +201,11 @@ func RemoveFile(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		displayAppError(w, nil,
 			fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin),
-			http.StatusInternalServerError, globals.ExitOrigin)
+			http.StatusNotFound, globals.ExitOrigin)
 		return
 	}
 	if exitCode, err := bucket.RemoveFile(filename); err != nil {
-		displayAppError(w, err,
-			fmt.Sprintf("Error: %s. Exit code: %d", err.Error(), exitCode),
-			http.StatusInternalServerError, exitCode)
+		displayStorageError(w, err, exitCode)
 		return
 	}
 
@@ -200,3 +217,79 @@ func RemoveFile(w http.ResponseWriter, r *http.Request) {
 			Bucket:  BucketResource{Data: BucketModel{Origin: origin}},
 		})
 }
+
+func ListFiles(w http.ResponseWriter, r *http.Request) {
+	// Get origin from the incoming url, prefix, recursive and pagetoken
+	// from the query
+	vars := mux.Vars(r)
+	origin := vars["origin"]
+	query := r.URL.Query()
+
+	if origin == "" {
+		displayAppError(w, nil,
+			"Please check request URL!",
+			http.StatusBadRequest, globals.ExitOrigin)
+		return
+	}
+
+	bucket, ok := storage.Bucket(origin)
+	if !ok {
+		displayAppError(w, nil,
+			fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin),
+			http.StatusNotFound, globals.ExitOrigin)
+		return
+	}
+	recursive, _ := strconv.ParseBool(query.Get("recursive"))
+	files, nextPageToken, exitCode, err := bucket.List(query.Get("prefix"),
+		recursive, query.Get("pagetoken"))
+	if err != nil {
+		displayStorageError(w, err, exitCode)
+		return
+	}
+	if files == nil {
+		files = []core.FileInfo{}
+	}
+
+	respondWithJSON(w, http.StatusOK,
+		&FilesResponse{
+			Success:       true,
+			Files:         files,
+			NextPageToken: nextPageToken,
+		})
+}
+
+func StatFile(w http.ResponseWriter, r *http.Request) {
+	// Get origin and filename from the incoming url
+	vars := mux.Vars(r)
+	origin := vars["origin"]
+	filename := vars["filename"]
+
+	if origin == "" || filename == "" {
+		displayAppError(w, nil,
+			"Please check request URL!",
+			http.StatusBadRequest, globals.ExitOrigin)
+		return
+	}
+
+	bucket, ok := storage.Bucket(origin)
+	if !ok {
+		displayAppError(w, nil,
+			fmt.Sprintf("Bucket with ORIGIN: %s is not exist", origin),
+			http.StatusNotFound, globals.ExitOrigin)
+		return
+	}
+	info, exitCode, err := bucket.Stat(filename)
+	if err != nil {
+		displayStorageError(w, err, exitCode)
+		return
+	}
+
+	if !info.IsDir {
+		setETag(w, info.Checksum)
+	}
+	respondWithJSON(w, http.StatusOK,
+		&FileStatResponse{
+			Success: true,
+			File:    info,
+		})
+}
@@ -1,24 +1,30 @@
 package controllers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"runtime"
-	"strings"
-)
-
-const (
-	packagePath = "rest"
-	mountApp    = "cmd/wizefs_mount/wizefs_mount"
-)
 
-var (
-	projectPath = getProjectPath()
+	"bitbucket.org/udt/wizefs/internal/core"
 )
 
 type BucketModel struct {
 	Origin string `json:"origin"`
+	// Password encrypts the Bucket on create and unlocks it on mount,
+	// it's never sent back
+	Password string `json:"password,omitempty"`
+	// IdleTTL unmounts the Bucket after this number of seconds without
+	// activity, 0 - never
+	IdleTTL int64 `json:"idlettl,omitempty"`
+	// AutoMount lets file operations use the Bucket when it's not mounted,
+	// it's set on create
+	AutoMount *core.AutoMountPolicy `json:"automount,omitempty"`
+	// ACL is set on create, its owner is the user creating the Bucket
+	// unless it's set by an admin
+	ACL *core.BucketACL `json:"acl,omitempty"`
 }
 
 type BucketResource struct {
@@ -35,6 +41,42 @@ type BucketStateResponse struct {
 	Success bool `json:"success"`
 	Created bool `json:"created"`
 	Mounted bool `json:"mounted"`
+	// IdleTTL and IdleRemaining are the idle timeout of the mounted Bucket
+	// and the time until it's unmounted, in seconds
+	IdleTTL       int64 `json:"idlettl,omitempty"`
+	IdleRemaining int64 `json:"idleremaining,omitempty"`
+}
+
+type FilesResponse struct {
+	Success bool            `json:"success"`
+	Files   []core.FileInfo `json:"files"`
+	// NextPageToken is passed as the pagetoken parameter to get the next
+	// page, it's empty on the last one
+	NextPageToken string `json:"nextpagetoken,omitempty"`
+}
+
+type FileStatResponse struct {
+	Success bool          `json:"success"`
+	File    core.FileInfo `json:"file"`
+}
+
+type BucketsResponse struct {
+	Success bool              `json:"success"`
+	Buckets []core.BucketInfo `json:"buckets"`
+}
+
+type ProblemsResponse struct {
+	Success  bool           `json:"success"`
+	Problems []core.Problem `json:"problems"`
+}
+
+type ACLResource struct {
+	Data core.BucketACL `json:"data"`
+}
+
+type ACLResponse struct {
+	Success bool            `json:"success"`
+	ACL     *core.BucketACL `json:"acl"`
 }
 
 type PutModel struct {
@@ -57,12 +99,6 @@ type errorResource struct {
 	Data appError `json:"data"`
 }
 
-func getProjectPath() string {
-	_, testFilename, _, _ := runtime.Caller(0)
-	idx := strings.Index(testFilename, packagePath)
-	return testFilename[0:idx]
-}
-
 func displayAppError(w http.ResponseWriter, handlerError error, message string, code int, exitCode int) {
 	errObj := appError{
 		Error:      "nil",
@@ -80,6 +116,39 @@ func displayAppError(w http.ResponseWriter, handlerError error, message string,
 	respondWithJSON(w, code, errorResource{Data: errObj})
 }
 
+// errorStatuses are the HTTP statuses of the kinds of the Storage errors
+var errorStatuses = []struct {
+	kind   error
+	status int
+}{
+	{core.ErrNotFound, http.StatusNotFound},
+	{core.ErrAlreadyExists, http.StatusConflict},
+	{core.ErrNotMounted, http.StatusConflict},
+	{core.ErrMounted, http.StatusConflict},
+	{core.ErrPrecondition, http.StatusPreconditionFailed},
+	{core.ErrBusy, http.StatusLocked},
+	{core.ErrInvalid, http.StatusBadRequest},
+	{core.ErrPassword, http.StatusForbidden},
+}
+
+// errorStatus returns the HTTP status of the Storage error by its kind,
+// the errors of other kinds (including ErrIntegrity) are server errors
+func errorStatus(err error) int {
+	for _, e := range errorStatuses {
+		if errors.Is(err, e.kind) {
+			return e.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// displayStorageError responds with the error of the Storage operation
+func displayStorageError(w http.ResponseWriter, err error, exitCode int) {
+	displayAppError(w, err,
+		fmt.Sprintf("Error: %s Exit code: %d", err.Error(), exitCode),
+		errorStatus(err), exitCode)
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 
@@ -87,3 +156,15 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(response)
 }
+
+// setETag sets the ETag header to the checksum of the file, the hex SHA-256
+// of its content
+func setETag(w http.ResponseWriter, checksum string) {
+	w.Header().Set("ETag", `"`+checksum+`"`)
+}
+
+// contentChecksum returns the checksum of the content put or got
+func contentChecksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"bitbucket.org/udt/wizefs/internal/auth"
 	"bitbucket.org/udt/wizefs/rest/controllers"
 )
 
 var httpAddr string = ":13000"
 
+var authConfigPath = flag.String("auth-config", "", "The config with the users and their keys, "+
+	"default is "+auth.ConfigFilename+" in the Storage directory")
+
 var (
 	Signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGHUP}
 )
@@ -24,7 +30,17 @@ func main() {
 	//shutdown := make(chan int)
 	terminate := make(chan os.Signal, 1)
 
-	h := NewService(httpAddr)
+	flag.Parse()
+	if *authConfigPath == "" {
+		*authConfigPath = controllers.ConfigPath(auth.ConfigFilename)
+	}
+	authConfig, err := auth.LoadConfig(*authConfigPath)
+	if err != nil {
+		log.Fatalf("failed to load auth config: %v"+
+			" (create the first user with `wizefs_cli auth-key --admin USER`)", err)
+	}
+
+	h := NewService(httpAddr, auth.NewAuthenticator(authConfig))
 	if err := h.Start(); err != nil {
 		log.Fatalf("failed to start HTTP service: %s", err.Error())
 	}
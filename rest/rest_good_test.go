@@ -14,19 +14,28 @@ import (
 	"testing"
 	"time"
 
+	"bitbucket.org/udt/wizefs/internal/auth"
 	co "bitbucket.org/udt/wizefs/rest/controllers"
 )
 
 const (
 	serviceAddr string = ":13000"
 	baseURL     string = "http://localhost:13000"
+
+	testAPIKey = "test-api-key"
 )
 
 //var terminate chan os.Signal
 
 // TODO: REST API Service
 func startService() {
-	h := NewService(serviceAddr)
+	config := &auth.Config{Users: map[string]*auth.User{
+		"tester": {APIKeys: []string{auth.HashAPIKey(testAPIKey)}},
+	}}
+	if err := config.Check(); err != nil {
+		log.Fatalf("invalid auth config: %v", err)
+	}
+	h := NewService(serviceAddr, auth.NewAuthenticator(config))
 	if err := h.Start(); err != nil {
 		log.Fatalf("failed to start HTTP service: %s", err.Error())
 	}
@@ -51,6 +60,7 @@ func NewClient() *Client {
 }
 
 func (c *Client) doRequest(req *http.Request) ([]byte, error) {
+	req.Header.Set(auth.APIKeyHeader, testAPIKey)
 	resp, err := c.http.Do(req)
 	if err != nil {
 		fmt.Println("doRequest http.Do Error:", err.Error())
@@ -133,6 +143,13 @@ func (c *Client) Delete(api string) (map[string]interface{}, error) {
 	return data, nil
 }
 
+// responseStatus returns the HTTP status of the error response
+func responseStatus(resp map[string]interface{}) int {
+	data, _ := resp["data"].(map[string]interface{})
+	status, _ := data["status"].(float64)
+	return int(status)
+}
+
 // TODO: TestFullCircle
 func TestFullCircle(t *testing.T) {
 	// start REST service
@@ -160,6 +177,25 @@ func TestFullCircle(t *testing.T) {
 	}
 	t.Logf("Response: %v", resp)
 
+	// the errors of the Storage have the HTTP statuses of their kinds
+	resp, err = client.Post("/buckets",
+		bytes.NewBufferString(`{"data":{"origin":"`+origin+`"}}`), "")
+	if err != nil {
+		t.Fatalf("Error2: %v", err)
+	}
+	if status := responseStatus(resp); status != http.StatusConflict {
+		t.Errorf("Create of existing Bucket: status %d, want %d", status, http.StatusConflict)
+	}
+	resp, err = client.Post("/buckets",
+		bytes.NewBufferString(`{"data":{"origin":"../escape"}}`), "")
+	if err != nil {
+		t.Fatalf("Error2: %v", err)
+	}
+	if status := responseStatus(resp); status != http.StatusBadRequest {
+		t.Errorf("Create of Bucket outside of the Storage: status %d, want %d",
+			status, http.StatusBadRequest)
+	}
+
 	// MOUNT
 	t.Logf("Request Mount Bucket %s", origin)
 	resp, err = client.Post("/buckets/"+origin+"/mount", nil, "")
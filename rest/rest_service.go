@@ -9,20 +9,24 @@ import (
 	"github.com/rs/cors"
 	"github.com/urfave/negroni"
 
+	"bitbucket.org/udt/wizefs/internal/auth"
 	"bitbucket.org/udt/wizefs/internal/core"
 	"bitbucket.org/udt/wizefs/rest/controllers"
 )
 
 // Service provides HTTP service.
 type Service struct {
-	addr string
-	ln   net.Listener
+	addr          string
+	ln            net.Listener
+	authenticator auth.Authenticator
 }
 
-// New returns an uninitialized HTTP service.
-func NewService(addr string) *Service {
+// New returns an uninitialized HTTP service, every route except "/" and
+// "/state" requires the requests authenticated by authenticator.
+func NewService(addr string, authenticator auth.Authenticator) *Service {
 	return &Service{
-		addr: addr,
+		addr:          addr,
+		authenticator: authenticator,
 	}
 }
 
@@ -31,37 +35,72 @@ func (s *Service) Start() error {
 	// Get the mux router object
 	router := mux.NewRouter().StrictSlash(false)
 
+	// the public routes, "/state" checks the ping of the digest node itself
 	router.HandleFunc("/", controllers.Home)
 	router.HandleFunc("/state", controllers.EchoHandler).Methods("POST")
 
+	// every user sees the Buckets with read permission, admins see all
+	// curl -X GET localhost:13000/buckets
+	router.HandleFunc("/buckets", controllers.ListBuckets).Methods("GET")
+	// the user creating the Bucket is its owner
 	// curl -X POST localhost:13000/buckets -d '{"data":{"origin":"REST1"}}'
 	router.HandleFunc("/buckets", controllers.CreateBucket).Methods("POST")
 	// curl -X DELETE localhost:13000/buckets/REST1
-	router.HandleFunc("/buckets/{origin}", controllers.DeleteBucket).Methods("DELETE")
+	router.HandleFunc("/buckets/{origin}",
+		controllers.Authorize(core.ACLOwner, controllers.DeleteBucket)).Methods("DELETE")
+	// curl -X GET localhost:13000/buckets/REST1/acl
+	router.HandleFunc("/buckets/{origin}/acl",
+		controllers.Authorize(core.ACLRead, controllers.GetACL)).Methods("GET")
+	// curl -X PUT localhost:13000/buckets/REST1/acl -d '{"data":{"owner":"alice","read":["bob"]}}'
+	router.HandleFunc("/buckets/{origin}/acl",
+		controllers.Authorize(core.ACLOwner, controllers.SetACL)).Methods("PUT")
 	// curl -X POST localhost:13000/buckets/REST1/mount
-	router.HandleFunc("/buckets/{origin}/mount", controllers.MountBucket).Methods("POST")
+	router.HandleFunc("/buckets/{origin}/mount",
+		controllers.Authorize(core.ACLWrite, controllers.MountBucket)).Methods("POST")
 	// curl -X POST localhost:13000/buckets/REST1/unmount
-	router.HandleFunc("/buckets/{origin}/unmount", controllers.UnmountBucket).Methods("POST")
+	router.HandleFunc("/buckets/{origin}/unmount",
+		controllers.Authorize(core.ACLWrite, controllers.UnmountBucket)).Methods("POST")
 	// curl -X GET localhost:13000/buckets/REST1/state
-	router.HandleFunc("/buckets/{origin}/state", controllers.StateBucket).Methods("GET")
-
-	// curl -F "filename=@/home/sergey/test.txt" -X POST localhost:13000/buckets/REST1/putfile
-	router.HandleFunc("/buckets/{origin}/putfile", controllers.PutFile).Methods("POST")
+	router.HandleFunc("/buckets/{origin}/state",
+		controllers.Authorize(core.ACLRead, controllers.StateBucket)).Methods("GET")
+	// curl -X GET localhost:13000/buckets/REST1/verify
+	router.HandleFunc("/buckets/{origin}/verify",
+		controllers.Authorize(core.ACLRead, controllers.VerifyBucket)).Methods("GET")
+	// curl -X GET localhost:13000/verify
+	router.HandleFunc("/verify", controllers.AuthorizeAdmin(controllers.VerifyStorage)).Methods("GET")
+	// curl -X POST "localhost:13000/clean?dryrun=true"
+	router.HandleFunc("/clean", controllers.AuthorizeAdmin(controllers.CleanStorage)).Methods("POST")
+
+	// curl -F "filename=@/home/sergey/test.txt" [-F "path=docs/test.txt"] -X POST localhost:13000/buckets/REST1/putfile
+	router.HandleFunc("/buckets/{origin}/putfile",
+		controllers.Authorize(core.ACLWrite, controllers.PutFile)).Methods("POST")
 	// curl -X POST localhost:13000/buckets/REST1/put -d '{"data":{"name":"...","content":"..."}}'
-	router.HandleFunc("/buckets/{origin}/put", controllers.Put).Methods("POST")
-	// curl -X GET localhost:13000/buckets/REST1/files/test.txt --output test.txt
-	router.HandleFunc("/buckets/{origin}/files/{filename}", controllers.GetFile).Methods("GET")
-	// curl -X DELETE localhost:13000/buckets/REST1/files/test.txt
-	router.HandleFunc("/buckets/{origin}/files/{filename}", controllers.RemoveFile).Methods("DELETE")
+	router.HandleFunc("/buckets/{origin}/put",
+		controllers.Authorize(core.ACLWrite, controllers.Put)).Methods("POST")
+	// curl -X GET "localhost:13000/buckets/REST1/files?prefix=docs/&recursive=true&pagetoken="
+	router.HandleFunc("/buckets/{origin}/files",
+		controllers.Authorize(core.ACLRead, controllers.ListFiles)).Methods("GET")
+	// curl -X GET localhost:13000/buckets/REST1/stat/docs/test.txt
+	router.HandleFunc("/buckets/{origin}/stat/{filename:.+}",
+		controllers.Authorize(core.ACLRead, controllers.StatFile)).Methods("GET")
+	// curl -X GET localhost:13000/buckets/REST1/files/docs/test.txt --output test.txt
+	router.HandleFunc("/buckets/{origin}/files/{filename:.+}",
+		controllers.Authorize(core.ACLRead, controllers.GetFile)).Methods("GET")
+	// curl -X DELETE localhost:13000/buckets/REST1/files/docs/test.txt
+	router.HandleFunc("/buckets/{origin}/files/{filename:.+}",
+		controllers.Authorize(core.ACLWrite, controllers.RemoveFile)).Methods("DELETE")
 
 	//corsHandler := cors.Default().Handler(router)
 	c := cors.New(cors.Options{
 		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "OPTIONS", "DELETE"},
+		AllowedHeaders: []string{"Origin", "Accept", "Content-Type", "X-Requested-With",
+			"Authorization", auth.APIKeyHeader, "If-Match", "If-None-Match"},
 	})
 
 	// Create a negroni instance
 	n := negroni.Classic()
 	n.Use(c)
+	n.Use(controllers.Authenticate(s.authenticator, "/", "/state"))
 	n.UseHandler(router)
 
 	server := http.Server{
@@ -89,14 +128,7 @@ func (s *Service) Start() error {
 func (s *Service) Close() {
 	log.Println("rest closing")
 
-	storage := core.NewStorage()
-	for origin, bucket := range storage.MountedBuckets() {
-		log.Printf("Unmounting Bucket: %s [%s]", origin, bucket.MountPoint)
-		// Unmount a Bucket
-		if exitCode, err := storage.Unmount(origin); err != nil {
-			log.Printf("Error: %s Exit code: %d", err.Error(), exitCode)
-		}
-	}
+	controllers.Shutdown()
 
 	s.ln.Close()
 	return
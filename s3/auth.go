@@ -0,0 +1,482 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/auth"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+// Signature Version 4, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+const (
+	signAlgorithm      = "AWS4-HMAC-SHA256"
+	signChunkAlgorithm = "AWS4-HMAC-SHA256-PAYLOAD"
+	amzDateFormat      = "20060102T150405Z"
+	scopeDateFormat    = "20060102"
+
+	unsignedPayload        = "UNSIGNED-PAYLOAD"
+	streamingPayload       = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsignedTrail = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	// maxClockSkew is the largest difference between the request time and
+	// the server time
+	maxClockSkew = 15 * time.Minute
+	// maxPresignExpires is the longest lifetime of a presigned URL
+	maxPresignExpires = 7 * 24 * time.Hour
+)
+
+// emptySHA256 is the hex SHA-256 of the empty payload
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// credential is the parsed Credential of the request:
+// ACCESSKEY/DATE/REGION/SERVICE/aws4_request
+type credential struct {
+	accessKey string
+	date      string
+	region    string
+	service   string
+}
+
+func (c credential) scope() string {
+	return strings.Join([]string{c.date, c.region, c.service, "aws4_request"}, "/")
+}
+
+func parseCredential(value string) (c credential, err error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || parts[3] != "s3" {
+		return c, fmt.Errorf("invalid credential %q", value)
+	}
+	return credential{
+		accessKey: parts[0],
+		date:      parts[1],
+		region:    parts[2],
+		service:   parts[3],
+	}, nil
+}
+
+// signature is what the client signed: the request and the payload
+type signature struct {
+	credential    credential
+	signedHeaders []string
+	signature     string
+	amzDate       time.Time
+	payloadHash   string
+	presigned     bool
+}
+
+// parseSignature reads the signature from the Authorization header or from
+// the query of a presigned URL
+func parseSignature(r *http.Request) (sig signature, err error) {
+	query := r.URL.Query()
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, signAlgorithm+" ") {
+			return sig, errUnsupportedSignature
+		}
+		for _, field := range strings.Split(strings.TrimPrefix(auth, signAlgorithm+" "), ",") {
+			kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(kv) != 2 {
+				return sig, fmt.Errorf("invalid Authorization field %q", field)
+			}
+			switch kv[0] {
+			case "Credential":
+				sig.credential, err = parseCredential(kv[1])
+			case "SignedHeaders":
+				sig.signedHeaders = strings.Split(kv[1], ";")
+			case "Signature":
+				sig.signature = kv[1]
+			}
+			if err != nil {
+				return sig, err
+			}
+		}
+		date := r.Header.Get("X-Amz-Date")
+		if date == "" {
+			date = r.Header.Get("Date")
+			sig.amzDate, err = http.ParseTime(date)
+		} else {
+			sig.amzDate, err = time.Parse(amzDateFormat, date)
+		}
+		if err != nil {
+			return sig, fmt.Errorf("invalid request date %q", date)
+		}
+		sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if sig.payloadHash == "" {
+			return sig, fmt.Errorf("missing X-Amz-Content-Sha256 header")
+		}
+	} else if query.Get("X-Amz-Algorithm") != "" {
+		if query.Get("X-Amz-Algorithm") != signAlgorithm {
+			return sig, errUnsupportedSignature
+		}
+		sig.presigned = true
+		if sig.credential, err = parseCredential(query.Get("X-Amz-Credential")); err != nil {
+			return sig, err
+		}
+		sig.signedHeaders = strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+		sig.signature = query.Get("X-Amz-Signature")
+		if sig.amzDate, err = time.Parse(amzDateFormat, query.Get("X-Amz-Date")); err != nil {
+			return sig, fmt.Errorf("invalid X-Amz-Date %q", query.Get("X-Amz-Date"))
+		}
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpires {
+			return sig, fmt.Errorf("invalid X-Amz-Expires %q", query.Get("X-Amz-Expires"))
+		}
+		// the payload of presigned URLs is never signed, the expiry is
+		// checked by verify
+		sig.payloadHash = unsignedPayload
+	} else {
+		return sig, errAnonymous
+	}
+
+	if sig.signature == "" || len(sig.signedHeaders) == 0 {
+		return sig, fmt.Errorf("incomplete signature")
+	}
+	if sig.credential.date != sig.amzDate.UTC().Format(scopeDateFormat) {
+		return sig, fmt.Errorf("credential date %s doesn't match request date", sig.credential.date)
+	}
+	return sig, nil
+}
+
+// signsHeader reports whether the header is in the SignedHeaders
+func signsHeader(sig signature, name string) bool {
+	for _, signed := range sig.signedHeaders {
+		if signed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// signingKey derives the key of the credential scope from the secret key
+func signingKey(secretKey string, c credential) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), c.date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, c.service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalRequest builds the canonical form of the request, see
+// "Create a canonical request" of the Signature Version 4 documentation
+func canonicalRequest(r *http.Request, sig signature) string {
+	var headers bytes.Buffer
+	for _, name := range sig.signedHeaders {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = r.Header.Get("Content-Length")
+			if value == "" && r.ContentLength >= 0 {
+				value = strconv.FormatInt(r.ContentLength, 10)
+			}
+		default:
+			value = strings.Join(r.Header[http.CanonicalHeaderKey(name)], ",")
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		canonicalQuery(r.URL.Query(), sig.presigned),
+		headers.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+}
+
+func canonicalQuery(query url.Values, presigned bool) string {
+	var pairs []string
+	for key, values := range query {
+		if presigned && key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes every byte except the unreserved characters, slashes
+// are kept unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func stringToSign(amzDate time.Time, scope, hashed string) string {
+	return strings.Join([]string{
+		signAlgorithm,
+		amzDate.UTC().Format(amzDateFormat),
+		scope,
+		hashed,
+	}, "\n")
+}
+
+// verify checks the signature of the request against the secret key of its
+// access key and returns the signing key for the chunks of a streaming
+// payload
+func (g *Gateway) verify(r *http.Request, sig signature) (key []byte, err error) {
+	secretKey, ok := g.config.secretKey(sig.credential.accessKey)
+	if !ok {
+		return nil, errInvalidAccessKey
+	}
+	// the signature of another region is never valid here, and without the
+	// signed host it could be replayed to another server
+	if sig.credential.region != g.config.Region {
+		return nil, &apiError{errAuthorizationHeaderMalformed.Code,
+			fmt.Sprintf("The authorization header is malformed; the region '%s' is wrong; expecting '%s'",
+				sig.credential.region, g.config.Region),
+			errAuthorizationHeaderMalformed.Status}
+	}
+	if !signsHeader(sig, "host") {
+		return nil, errSignatureMismatch
+	}
+
+	now := g.now()
+	if sig.presigned {
+		expires, _ := strconv.Atoi(r.URL.Query().Get("X-Amz-Expires"))
+		if now.Before(sig.amzDate.Add(-maxClockSkew)) ||
+			now.After(sig.amzDate.Add(time.Duration(expires)*time.Second)) {
+			return nil, errExpired
+		}
+	} else if d := now.Sub(sig.amzDate); d > maxClockSkew || d < -maxClockSkew {
+		return nil, errTimeSkewed
+	}
+
+	key = signingKey(secretKey, sig.credential)
+	canonical := canonicalRequest(r, sig)
+	expected := hex.EncodeToString(hmacSHA256(key,
+		stringToSign(sig.amzDate, sig.credential.scope(), sha256Hex([]byte(canonical)))))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return nil, errSignatureMismatch
+	}
+	return key, nil
+}
+
+// authenticate verifies the request and replaces its body with the reader
+// that checks the payload against the signed hash. The identity is the user
+// the access key belongs to.
+func (g *Gateway) authenticate(r *http.Request) (identity *auth.Identity, err error) {
+	sig, err := parseSignature(r)
+	if err != nil {
+		return nil, err
+	}
+	key, err := g.verify(r, sig)
+	if err != nil {
+		return nil, err
+	}
+	if identity, err = g.identity(sig.credential.accessKey); err != nil {
+		return nil, err
+	}
+
+	switch sig.payloadHash {
+	case unsignedPayload:
+	case streamingPayload:
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{newChunkedReader(r.Body, key, sig), r.Body}
+	case streamingUnsignedTrail:
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{newUnsignedChunkedReader(r.Body), r.Body}
+	default:
+		if len(sig.payloadHash) != sha256.Size*2 {
+			return nil, errUnsupportedSignature
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{newHashReader(r.Body, sha256.New(), sig.payloadHash, errContentSHA256Mismatch), r.Body}
+	}
+	return identity, nil
+}
+
+// identity returns the user of the access key, the users of the access keys
+// are the users of the REST service
+func (g *Gateway) identity(accessKey string) (*auth.Identity, error) {
+	name := g.config.Users[accessKey]
+	user, ok := g.users.Users[name]
+	if !ok || user == nil {
+		tlog.Warn.Printf("S3 access key %s has no user", accessKey)
+		return nil, errAccessDenied
+	}
+	return &auth.Identity{Name: name, Admin: user.Admin}, nil
+}
+
+// hashReader fails with mismatch at EOF if the hash of the content read
+// isn't expected
+type hashReader struct {
+	reader   io.Reader
+	hash     hash.Hash
+	expected string
+	mismatch error
+}
+
+func newHashReader(reader io.Reader, h hash.Hash, expected string, mismatch error) *hashReader {
+	return &hashReader{reader: reader, hash: h, expected: expected, mismatch: mismatch}
+}
+
+func (h *hashReader) Read(p []byte) (n int, err error) {
+	n, err = h.reader.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(h.hash.Sum(nil)) != h.expected {
+		return n, h.mismatch
+	}
+	return n, err
+}
+
+// chunkedReader decodes the aws-chunked payload and verifies the signature
+// of every chunk: SIZE;chunk-signature=SIGNATURE\r\nDATA\r\n
+type chunkedReader struct {
+	reader    *bufio.Reader
+	key       []byte
+	sig       signature
+	previous  string
+	signed    bool
+	chunk     []byte
+	finished  bool
+	lastError error
+}
+
+func newChunkedReader(reader io.Reader, key []byte, sig signature) *chunkedReader {
+	return &chunkedReader{
+		reader:   bufio.NewReader(reader),
+		key:      key,
+		sig:      sig,
+		previous: sig.signature,
+		signed:   true,
+	}
+}
+
+func newUnsignedChunkedReader(reader io.Reader) *chunkedReader {
+	return &chunkedReader{reader: bufio.NewReader(reader)}
+}
+
+func (c *chunkedReader) Read(p []byte) (n int, err error) {
+	for len(c.chunk) == 0 {
+		if c.lastError != nil {
+			return 0, c.lastError
+		}
+		if c.finished {
+			return 0, io.EOF
+		}
+		if c.lastError = c.nextChunk(); c.lastError != nil && c.lastError != io.EOF {
+			return 0, c.lastError
+		}
+		if c.lastError == io.EOF {
+			c.lastError = nil
+			c.finished = true
+		}
+	}
+	n = copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// nextChunk reads and verifies the next chunk, io.EOF is returned with the
+// final empty chunk
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return errIncompleteBody
+	}
+	header := strings.SplitN(line, ";", 2)
+	size, err := strconv.ParseInt(header[0], 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errIncompleteBody
+	}
+
+	chunk := make([]byte, size)
+	if _, err = io.ReadFull(c.reader, chunk); err != nil {
+		return errIncompleteBody
+	}
+
+	if c.signed {
+		if len(header) != 2 || !strings.HasPrefix(header[1], "chunk-signature=") {
+			return errIncompleteBody
+		}
+		toSign := strings.Join([]string{
+			signChunkAlgorithm,
+			c.sig.amzDate.UTC().Format(amzDateFormat),
+			c.sig.credential.scope(),
+			c.previous,
+			emptySHA256,
+			sha256Hex(chunk),
+		}, "\n")
+		expected := hex.EncodeToString(hmacSHA256(c.key, toSign))
+		if !hmac.Equal([]byte(expected), []byte(strings.TrimPrefix(header[1], "chunk-signature="))) {
+			return errSignatureMismatch
+		}
+		c.previous = expected
+	}
+
+	if size == 0 {
+		// the final chunk is followed by the trailing headers of unsigned
+		// payloads, they end with an empty line
+		for {
+			line, err := c.readLine()
+			if err != nil && err != io.EOF || line == "" {
+				break
+			}
+		}
+		return io.EOF
+	}
+
+	if line, err := c.readLine(); err != nil || line != "" {
+		return errIncompleteBody
+	}
+	c.chunk = chunk
+	return nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// maxChunkSize limits the memory used by one chunk of aws-chunked payload
+const maxChunkSize = 16 << 20
@@ -0,0 +1,343 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/udt/wizefs/internal/auth"
+	"bitbucket.org/udt/wizefs/internal/core"
+)
+
+// maxKeys is the default and the largest number of keys of ListObjects
+const maxKeys = 1000
+
+type owner struct {
+	ID          string
+	DisplayName string
+}
+
+// gatewayOwner is the owner in the listings, the gateway has no S3 accounts,
+// its users are the principals of the Bucket ACLs
+var gatewayOwner = owner{ID: "wizefs", DisplayName: "wizefs"}
+
+type bucketEntry struct {
+	Name         string
+	CreationDate string
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, _, err := g.storage.List()
+	if err != nil {
+		writeError(w, r, storageError(err, nil))
+		return
+	}
+
+	identity := auth.FromRequest(r)
+	result := listAllMyBucketsResult{Xmlns: s3Namespace, Owner: gatewayOwner}
+	for _, bucket := range buckets {
+		if !identity.Admin && !bucket.ACL.Allows(identity.Name, core.ACLRead) {
+			continue
+		}
+		result.Buckets = append(result.Buckets, bucketEntry{
+			Name:         bucket.Origin,
+			CreationDate: timestamp(bucket.Created),
+		})
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func (g *Gateway) createBucket(w http.ResponseWriter, r *http.Request, name string) {
+	if !bucketNamePattern.MatchString(name) {
+		writeError(w, r, errInvalidBucketName)
+		return
+	}
+	// CreateBucketConfiguration has the location only, buckets are local
+	io.Copy(ioutil.Discard, r.Body)
+
+	unlock := g.locks.lock(name, true)
+	defer unlock()
+
+	identity := auth.FromRequest(r)
+	if _, ok := g.storage.Bucket(name); ok {
+		if acl, _, _ := g.storage.ACL(name); identity.Admin || acl.Allows(identity.Name, core.ACLOwner) {
+			writeError(w, r, errBucketAlreadyOwnedByYou)
+		} else {
+			writeError(w, r, errBucketAlreadyExists)
+		}
+		return
+	}
+	// the user creating the Bucket owns it
+	_, err := g.storage.CreateWithOptions(name, core.BucketOptions{
+		AutoMount: g.config.AutoMount,
+		ACL:       &core.BucketACL{Owner: identity.Name},
+	})
+	if err != nil {
+		if errors.Is(err, core.ErrInvalid) {
+			writeError(w, r, errInvalidBucketName)
+			return
+		}
+		// ErrAlreadyExists: the origin exists, but it's not a Bucket
+		writeError(w, r, storageError(err, nil))
+		return
+	}
+
+	w.Header().Set("Location", "/"+name)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) deleteBucket(w http.ResponseWriter, r *http.Request, name string) {
+	// only the writes to this Bucket wait until it's checked and deleted
+	unlock := g.locks.lock(name, true)
+	defer unlock()
+
+	bucket, err := g.bucket(name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	files, _, _, err := bucket.List("", true, "")
+	if err != nil {
+		writeError(w, r, storageError(err, nil))
+		return
+	}
+	if len(files) > 0 {
+		writeError(w, r, errBucketNotEmpty)
+		return
+	}
+
+	if bucket.IsMounted() {
+		if _, err = g.storage.Unmount(name); err != nil {
+			writeError(w, r, storageError(err, nil))
+			return
+		}
+	}
+	if _, err = g.storage.Delete(name); err != nil {
+		writeError(w, r, storageError(err, nil))
+		return
+	}
+	g.removeUploads(name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) headBucket(w http.ResponseWriter, r *http.Request, name string) {
+	if _, err := g.bucket(name); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+func (g *Gateway) getBucketLocation(w http.ResponseWriter, r *http.Request, name string) {
+	if _, err := g.bucket(name); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	region := g.config.Region
+	if region == DefaultRegion {
+		// the location of us-east-1 is empty
+		region = ""
+	}
+	writeXML(w, http.StatusOK, locationConstraint{Xmlns: s3Namespace, Region: region})
+}
+
+type object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+	Owner        *owner `xml:",omitempty"`
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+// listBucketResult is the response of both ListObjects versions, the fields
+// of the other version are omitted
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Marker                *string        `xml:"Marker,omitempty"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	KeyCount              *int           `xml:"KeyCount,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+// listObjects is ListObjectsV2 if list-type is 2 and ListObjects otherwise.
+// The keys of the bucket are listed recursively and grouped by delimiter
+// into CommonPrefixes, the marker of the next page is the last key or
+// prefix returned.
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request, name string) {
+	bucket, err := g.bucket(name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	v2 := query.Get("list-type") == "2"
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	encode := query.Get("encoding-type") == "url"
+	if query.Get("encoding-type") != "" && !encode {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+
+	limit := maxKeys
+	if value := query.Get("max-keys"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+		if limit > maxKeys {
+			limit = maxKeys
+		}
+	}
+
+	var marker string
+	result := listBucketResult{
+		Xmlns:        s3Namespace,
+		Name:         name,
+		MaxKeys:      limit,
+		EncodingType: query.Get("encoding-type"),
+	}
+	if v2 {
+		marker = query.Get("start-after")
+		result.StartAfter = encodeKey(marker, encode)
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				writeError(w, r, errInvalidArgument)
+				return
+			}
+			result.ContinuationToken = token
+			if string(decoded) > marker {
+				marker = string(decoded)
+			}
+		}
+	} else {
+		marker = query.Get("marker")
+		encoded := encodeKey(marker, encode)
+		result.Marker = &encoded
+	}
+	result.Prefix = encodeKey(prefix, encode)
+	result.Delimiter = encodeKey(delimiter, encode)
+
+	// the core lists paths, so the prefix is cut at the last slash and the
+	// rest is matched here
+	corePrefix := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		corePrefix = prefix[:i+1]
+	}
+	var last string
+	pageToken := marker
+	if !strings.HasPrefix(pageToken, corePrefix) {
+		pageToken = ""
+	}
+	for {
+		files, nextPageToken, _, err := bucket.List(corePrefix, true, pageToken)
+		if err != nil {
+			if errors.Is(err, core.ErrInvalid) {
+				// the prefix is not a valid path, so no key has it
+				break
+			}
+			writeError(w, r, storageError(err, nil))
+			return
+		}
+
+		for _, file := range files {
+			if !strings.HasPrefix(file.Name, prefix) {
+				continue
+			}
+			entry := file.Name
+			if delimiter != "" {
+				if i := strings.Index(file.Name[len(prefix):], delimiter); i >= 0 {
+					entry = file.Name[:len(prefix)+i+len(delimiter)]
+				}
+			}
+			if entry <= marker || entry == last {
+				continue
+			}
+			if len(result.Contents)+len(result.CommonPrefixes) == limit {
+				result.IsTruncated = true
+				break
+			}
+
+			last = entry
+			if entry != file.Name {
+				result.CommonPrefixes = append(result.CommonPrefixes,
+					commonPrefix{Prefix: encodeKey(entry, encode)})
+				continue
+			}
+			obj := object{
+				Key:          encodeKey(file.Name, encode),
+				LastModified: timestamp(file.ModTime),
+				ETag:         etag(file.Checksum),
+				Size:         file.Size,
+				StorageClass: "STANDARD",
+			}
+			if !v2 || query.Get("fetch-owner") == "true" {
+				obj.Owner = &gatewayOwner
+			}
+			result.Contents = append(result.Contents, obj)
+		}
+
+		if result.IsTruncated || nextPageToken == "" {
+			break
+		}
+		pageToken = nextPageToken
+	}
+
+	if result.IsTruncated {
+		if v2 {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+		} else if delimiter != "" {
+			result.NextMarker = encodeKey(last, encode)
+		}
+	}
+	if v2 {
+		count := len(result.Contents) + len(result.CommonPrefixes)
+		result.KeyCount = &count
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// encodeKey encodes the key for encoding-type=url
+func encodeKey(key string, encode bool) string {
+	if !encode {
+		return key
+	}
+	return uriEncode(key, false)
+}
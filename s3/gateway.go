@@ -0,0 +1,504 @@
+// Package s3 serves the Buckets of the Storage over the S3 REST protocol,
+// so the existing S3 clients and SDKs can be used with WizeFS. Only
+// path-style requests (http://host/BUCKET/KEY) signed with Signature
+// Version 4 are supported.
+package s3
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/auth"
+	"bitbucket.org/udt/wizefs/internal/core"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+// ConfigFilename is the config of the gateway inside the Storage directory
+const ConfigFilename = "s3.conf"
+
+// DefaultRegion is the region of the gateway if the config has none
+const DefaultRegion = "us-east-1"
+
+// Config keeps the access keys of the gateway
+type Config struct {
+	Region string `json:"region"`
+	// Credentials maps access keys to their secret keys
+	Credentials map[string]string `json:"credentials"`
+	// Users maps access keys to the names of the users of the REST service
+	// (see internal/auth), the Bucket ACLs are checked for them
+	Users map[string]string `json:"users"`
+	// AutoMount is the auto-mount policy of the Buckets created by the
+	// gateway, the objects of unmounted Buckets are not accessible without it
+	AutoMount core.AutoMountPolicy `json:"automount"`
+}
+
+// LoadConfig reads the config of the gateway from the JSON file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	if config.Region == "" {
+		config.Region = DefaultRegion
+	}
+	if len(config.Credentials) == 0 {
+		return nil, fmt.Errorf("config %s has no credentials", path)
+	}
+	for accessKey := range config.Credentials {
+		if config.Users[accessKey] == "" {
+			return nil, fmt.Errorf("config %s: access key %s has no user", path, accessKey)
+		}
+	}
+	if err = config.AutoMount.Check(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *Config) secretKey(accessKey string) (secretKey string, ok bool) {
+	secretKey, ok = c.Credentials[accessKey]
+	return
+}
+
+// Gateway is the http.Handler of the S3 REST protocol
+type Gateway struct {
+	storage *core.Storage
+	config  *Config
+	// users are the users of the access keys
+	users *auth.Config
+	// uploadsDir keeps the parts of multipart uploads
+	uploadsDir string
+	// locks keep the Buckets from being deleted while objects are written
+	// to them, see lockBucket
+	locks bucketLocks
+	// now returns the time the signatures are checked against
+	now func() time.Time
+}
+
+// NewGateway returns the gateway to the Buckets of storage, users are the
+// users of the REST service the access keys belong to
+func NewGateway(storage *core.Storage, config *Config, users *auth.Config) *Gateway {
+	return &Gateway{
+		storage:    storage,
+		config:     config,
+		users:      users,
+		uploadsDir: storage.DirPath + "s3/uploads",
+		now:        time.Now,
+	}
+}
+
+// bucketNamePattern is the bucket naming rule of S3 without dots: the
+// origins with the archive extensions are LZFS Buckets, the gateway creates
+// directories only
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
+
+// permAuthenticated - any authenticated user may send the request
+const permAuthenticated core.ACLPermission = 0
+
+// ServeHTTP authenticates the request, authorizes it by the ACL of the
+// Bucket and dispatches it by the path and the method: the service (/), a
+// bucket (/BUCKET) or an object (/BUCKET/KEY)
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tlog.Debug.Printf("S3 %s %s", r.Method, r.URL)
+
+	identity, err := g.authenticate(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	r = auth.WithIdentity(r, identity)
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, r, errMethodNotAllowed)
+			return
+		}
+		g.listBuckets(w, r)
+		return
+	}
+
+	parts := strings.SplitN(path, "/", 2)
+	bucket := parts[0]
+	object := len(parts) == 2 && parts[1] != ""
+	if err = g.authorize(identity, bucket, permission(r, object)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !object {
+		g.serveBucket(w, r, bucket)
+		return
+	}
+	g.serveObject(w, r, bucket, parts[1])
+}
+
+// permission returns the permission the request needs on the Bucket
+func permission(r *http.Request, object bool) core.ACLPermission {
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return core.ACLRead
+	case !object && r.Method == http.MethodPut:
+		// CreateBucket, the user owns the new Bucket
+		return permAuthenticated
+	case !object && r.Method == http.MethodDelete:
+		return core.ACLOwner
+	}
+	return core.ACLWrite
+}
+
+// authorize checks the permission of the identity against the ACL of the
+// Bucket, admins have every permission. Unknown Buckets are not revealed to
+// the users without access.
+func (g *Gateway) authorize(identity *auth.Identity, bucket string, perm core.ACLPermission) error {
+	if identity.Admin || perm == permAuthenticated {
+		return nil
+	}
+	acl, _, err := g.storage.ACL(bucket)
+	if err != nil || !acl.Allows(identity.Name, perm) {
+		return errAccessDenied
+	}
+	return nil
+}
+
+func (g *Gateway) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodPut:
+		g.createBucket(w, r, bucket)
+	case http.MethodDelete:
+		g.deleteBucket(w, r, bucket)
+	case http.MethodHead:
+		g.headBucket(w, r, bucket)
+	case http.MethodGet:
+		switch {
+		case hasQuery(query, "location"):
+			g.getBucketLocation(w, r, bucket)
+		case hasQuery(query, "uploads"):
+			g.listMultipartUploads(w, r, bucket)
+		default:
+			g.listObjects(w, r, bucket)
+		}
+	case http.MethodPost:
+		if !hasQuery(query, "delete") {
+			writeError(w, r, errNotImplemented)
+			return
+		}
+		g.deleteObjects(w, r, bucket)
+	default:
+		writeError(w, r, errMethodNotAllowed)
+	}
+}
+
+func (g *Gateway) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch r.Method {
+	case http.MethodPut:
+		if uploadID != "" {
+			g.uploadPart(w, r, bucket, key, uploadID)
+			return
+		}
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			writeError(w, r, errNotImplemented)
+			return
+		}
+		g.putObject(w, r, bucket, key)
+	case http.MethodGet:
+		if uploadID != "" {
+			g.listParts(w, r, bucket, key, uploadID)
+			return
+		}
+		g.getObject(w, r, bucket, key, true)
+	case http.MethodHead:
+		g.getObject(w, r, bucket, key, false)
+	case http.MethodDelete:
+		if uploadID != "" {
+			g.abortMultipartUpload(w, r, bucket, key, uploadID)
+			return
+		}
+		g.deleteObject(w, r, bucket, key)
+	case http.MethodPost:
+		switch {
+		case hasQuery(query, "uploads"):
+			g.createMultipartUpload(w, r, bucket, key)
+		case uploadID != "":
+			g.completeMultipartUpload(w, r, bucket, key, uploadID)
+		default:
+			writeError(w, r, errNotImplemented)
+		}
+	default:
+		writeError(w, r, errMethodNotAllowed)
+	}
+}
+
+// bucket returns the Bucket of the Storage by its name
+func (g *Gateway) bucket(name string) (*core.Bucket, error) {
+	bucket, ok := g.storage.Bucket(name)
+	if !ok {
+		return nil, errNoSuchBucket
+	}
+	return bucket, nil
+}
+
+// writeBucket returns the Bucket locked for writing objects, unlock should
+// be called when the object is written
+func (g *Gateway) writeBucket(name string) (bucket *core.Bucket, unlock func(), err error) {
+	unlock = g.locks.lock(name, false)
+	bucket, err = g.bucket(name)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return bucket, unlock, nil
+}
+
+// bucketLocks are the locks of the Bucket names: the writes of objects
+// share the lock of their Bucket, CreateBucket and DeleteBucket hold it
+// exclusively, so DeleteBucket never removes the object that is being
+// written. Other Buckets are not blocked.
+type bucketLocks struct {
+	mutex sync.Mutex
+	locks map[string]*bucketLock
+}
+
+type bucketLock struct {
+	sync.RWMutex
+	// refs is the number of the holders and waiters, the lock is removed
+	// when it drops to zero
+	refs int
+}
+
+// lock locks the Bucket name and returns the function that unlocks it
+func (l *bucketLocks) lock(name string, exclusive bool) (unlock func()) {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*bucketLock)
+	}
+	bl, ok := l.locks[name]
+	if !ok {
+		bl = &bucketLock{}
+		l.locks[name] = bl
+	}
+	bl.refs++
+	l.mutex.Unlock()
+
+	if exclusive {
+		bl.Lock()
+	} else {
+		bl.RLock()
+	}
+	return func() {
+		if exclusive {
+			bl.Unlock()
+		} else {
+			bl.RUnlock()
+		}
+		l.mutex.Lock()
+		bl.refs--
+		if bl.refs == 0 {
+			delete(l.locks, name)
+		}
+		l.mutex.Unlock()
+	}
+}
+
+func hasQuery(query map[string][]string, name string) bool {
+	_, ok := query[name]
+	return ok
+}
+
+// apiError is the error response of S3, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html
+type apiError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errAnonymous = &apiError{"AccessDenied",
+		"Anonymous access is forbidden.", http.StatusForbidden}
+	errAccessDenied = &apiError{"AccessDenied",
+		"Access Denied.", http.StatusForbidden}
+	errUnsupportedSignature = &apiError{"InvalidRequest",
+		"Only AWS Signature Version 4 is supported.", http.StatusBadRequest}
+	errInvalidAccessKey = &apiError{"InvalidAccessKeyId",
+		"The access key Id you provided does not exist in our records.", http.StatusForbidden}
+	errSignatureMismatch = &apiError{"SignatureDoesNotMatch",
+		"The request signature we calculated does not match the signature you provided.", http.StatusForbidden}
+	errAuthorizationHeaderMalformed = &apiError{"AuthorizationHeaderMalformed",
+		"The authorization header is malformed.", http.StatusBadRequest}
+	errTimeSkewed = &apiError{"RequestTimeTooSkewed",
+		"The difference between the request time and the server's time is too large.", http.StatusForbidden}
+	errExpired = &apiError{"AccessDenied",
+		"Request has expired.", http.StatusForbidden}
+	errContentSHA256Mismatch = &apiError{"XAmzContentSHA256Mismatch",
+		"The provided 'x-amz-content-sha256' header does not match what was computed.", http.StatusBadRequest}
+	errBadDigest = &apiError{"BadDigest",
+		"The Content-MD5 you specified did not match what we received.", http.StatusBadRequest}
+	errInvalidDigest = &apiError{"InvalidDigest",
+		"The Content-MD5 you specified is not valid.", http.StatusBadRequest}
+	errIncompleteBody = &apiError{"IncompleteBody",
+		"You did not provide the number of bytes specified by the Content-Length HTTP header.", http.StatusBadRequest}
+	errMalformedXML = &apiError{"MalformedXML",
+		"The XML you provided was not well-formed or did not validate against our published schema.", http.StatusBadRequest}
+	errInvalidArgument = &apiError{"InvalidArgument",
+		"Invalid Argument.", http.StatusBadRequest}
+	errInvalidBucketName = &apiError{"InvalidBucketName",
+		"The specified bucket is not valid.", http.StatusBadRequest}
+	errInvalidRange = &apiError{"InvalidRange",
+		"The requested range is not satisfiable.", http.StatusRequestedRangeNotSatisfiable}
+	errNoSuchBucket = &apiError{"NoSuchBucket",
+		"The specified bucket does not exist.", http.StatusNotFound}
+	errNoSuchKey = &apiError{"NoSuchKey",
+		"The specified key does not exist.", http.StatusNotFound}
+	errNoSuchUpload = &apiError{"NoSuchUpload",
+		"The specified multipart upload does not exist.", http.StatusNotFound}
+	errInvalidPart = &apiError{"InvalidPart",
+		"One or more of the specified parts could not be found.", http.StatusBadRequest}
+	errEntityTooSmall = &apiError{"EntityTooSmall",
+		"Your proposed upload is smaller than the minimum allowed object size.", http.StatusBadRequest}
+	errInvalidPartOrder = &apiError{"InvalidPartOrder",
+		"The list of parts was not in ascending order.", http.StatusBadRequest}
+	errBucketAlreadyOwnedByYou = &apiError{"BucketAlreadyOwnedByYou",
+		"Your previous request to create the named bucket succeeded and you already own it.", http.StatusConflict}
+	errBucketAlreadyExists = &apiError{"BucketAlreadyExists",
+		"The requested bucket name is not available.", http.StatusConflict}
+	errBucketNotEmpty = &apiError{"BucketNotEmpty",
+		"The bucket you tried to delete is not empty.", http.StatusConflict}
+	errInvalidBucketState = &apiError{"InvalidBucketState",
+		"The request is not valid with the current state of the bucket.", http.StatusConflict}
+	errOperationAborted = &apiError{"OperationAborted",
+		"A conflicting conditional operation is currently in progress against this resource. Please try again.", http.StatusConflict}
+	errPreconditionFailed = &apiError{"PreconditionFailed",
+		"At least one of the pre-conditions you specified did not hold.", http.StatusPreconditionFailed}
+	errMethodNotAllowed = &apiError{"MethodNotAllowed",
+		"The specified method is not allowed against this resource.", http.StatusMethodNotAllowed}
+	errNotImplemented = &apiError{"NotImplemented",
+		"A header you provided implies functionality that is not implemented.", http.StatusNotImplemented}
+	errInternal = &apiError{"InternalError",
+		"We encountered an internal error. Please try again.", http.StatusInternalServerError}
+)
+
+// storageErrors are the S3 errors of the kinds of the Storage errors,
+// ErrNotFound is mapped by storageError
+var storageErrors = []struct {
+	kind error
+	err  *apiError
+}{
+	{core.ErrAlreadyExists, errBucketAlreadyExists},
+	{core.ErrNotMounted, errInvalidBucketState},
+	{core.ErrMounted, errInvalidBucketState},
+	{core.ErrPrecondition, errPreconditionFailed},
+	{core.ErrBusy, errOperationAborted},
+	{core.ErrInvalid, errInvalidArgument},
+	{core.ErrPassword, errInvalidBucketState},
+}
+
+// storageError maps the Storage error to the error of S3 by its kind.
+// notFound is the error of a missing object, a missing Bucket is reported
+// if it's nil. The errors of other kinds (including ErrIntegrity) are
+// internal errors.
+func storageError(err error, notFound *apiError) error {
+	tlog.Debug.Printf("S3 storage error: %v", err)
+	if errors.Is(err, core.ErrNotFound) {
+		if notFound != nil {
+			return notFound
+		}
+		return errNoSuchBucket
+	}
+	for _, e := range storageErrors {
+		if errors.Is(err, e.kind) {
+			return e.err
+		}
+	}
+	return &apiError{errInternal.Code, err.Error(), errInternal.Status}
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestID string `xml:"RequestId"`
+}
+
+// writeError writes the XML error response, errors other than apiError are
+// internal errors
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr, ok := err.(*apiError)
+	if !ok {
+		tlog.Warn.Printf("S3 %s %s: %v", r.Method, r.URL.Path, err)
+		apiErr = &apiError{errInternal.Code, err.Error(), errInternal.Status}
+	}
+	if r.Method == http.MethodHead {
+		// the response of HEAD has no body
+		w.WriteHeader(apiErr.Status)
+		return
+	}
+	writeXML(w, apiErr.Status, errorResponse{
+		Code:     apiErr.Code,
+		Message:  apiErr.Message,
+		Resource: r.URL.Path,
+	})
+}
+
+func writeXML(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(response); err != nil {
+		tlog.Warn.Printf("S3 response encoding failed: %v", err)
+	}
+}
+
+// readXML decodes the XML body of the request
+func readXML(r *http.Request, request interface{}) error {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxXMLSize+1))
+	if err != nil {
+		if apiErr, ok := err.(*apiError); ok {
+			return apiErr
+		}
+		return errIncompleteBody
+	}
+	if len(data) > maxXMLSize {
+		return errMalformedXML
+	}
+	if err = xml.Unmarshal(data, request); err != nil {
+		return errMalformedXML
+	}
+	return nil
+}
+
+// maxXMLSize limits the XML bodies of the requests
+const maxXMLSize = 1 << 20
+
+// s3Namespace is the namespace of the XML responses
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// timestamp formats the time of the XML responses
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// etag quotes the checksum of the object
+func etag(checksum string) string {
+	return `"` + checksum + `"`
+}
@@ -0,0 +1,455 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/auth"
+	"bitbucket.org/udt/wizefs/internal/core"
+)
+
+type testClient struct {
+	t         *testing.T
+	server    *httptest.Server
+	storage   *core.Storage
+	accessKey string
+	secret    string
+	home      string
+	oldHome   string
+}
+
+// the example access key belongs to the admin, userAccessKey to the user
+// who isn't
+const (
+	userAccessKey = "AKIAUSEREXAMPLE"
+	userSecretKey = "userSecretKey"
+)
+
+var testUsers = &auth.Config{Users: map[string]*auth.User{
+	"admin": {Admin: true},
+	"user":  {},
+}}
+
+// newTestGateway serves the gateway of the Storage in a temporary HOME,
+// Close stops it
+func newTestGateway(t *testing.T) *testClient {
+	home, err := ioutil.TempDir("", "wizefs-s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+
+	storage := core.NewStorage()
+	gateway := NewGateway(storage, &Config{
+		Region: DefaultRegion,
+		Credentials: map[string]string{
+			exampleAccessKey: exampleSecretKey,
+			userAccessKey:    userSecretKey,
+		},
+		Users: map[string]string{
+			exampleAccessKey: "admin",
+			userAccessKey:    "user",
+		},
+		AutoMount: core.AutoMountPolicy{Mode: core.AutoMountDirect},
+	}, testUsers)
+	server := httptest.NewServer(gateway)
+
+	return &testClient{t: t, server: server, storage: storage,
+		accessKey: exampleAccessKey, secret: exampleSecretKey, home: home, oldHome: oldHome}
+}
+
+// as makes the requests with another access key
+func (c *testClient) as(accessKey, secret string) {
+	c.accessKey = accessKey
+	c.secret = secret
+}
+
+// Close stops the gateway, restores HOME and removes the temporary one
+func (c *testClient) Close() {
+	c.server.Close()
+	c.storage.Close()
+	os.Setenv("HOME", c.oldHome)
+	os.RemoveAll(c.home)
+}
+
+// do sends the request signed with the payload hash of body
+func (c *testClient) do(method, path string, body []byte, header http.Header) *http.Response {
+	r, err := http.NewRequest(method, c.server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	now := time.Now().UTC()
+	r.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	if r.Header.Get("X-Amz-Content-Sha256") == "" {
+		r.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+	}
+
+	sig := signature{
+		credential: credential{
+			accessKey: c.accessKey,
+			date:      now.Format(scopeDateFormat),
+			region:    DefaultRegion,
+			service:   "s3",
+		},
+		signedHeaders: []string{"host", "x-amz-content-sha256", "x-amz-date"},
+		amzDate:       now,
+		payloadHash:   r.Header.Get("X-Amz-Content-Sha256"),
+	}
+	r.Host = r.URL.Host
+	key := signingKey(c.secret, sig.credential)
+	signed := hex.EncodeToString(hmacSHA256(key,
+		stringToSign(now, sig.credential.scope(), sha256Hex([]byte(canonicalRequest(r, sig))))))
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		signAlgorithm, c.accessKey, sig.credential.scope(),
+		strings.Join(sig.signedHeaders, ";"), signed))
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp
+}
+
+// expect sends the request and checks the status of the response, the
+// body is decoded into result if it's not nil or copied if it's *[]byte
+func (c *testClient) expect(status int, method, path string, body []byte, header http.Header,
+	result interface{}) http.Header {
+	c.t.Helper()
+	resp := c.do(method, path, body, header)
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		c.t.Fatalf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, status, content)
+	}
+	switch result := result.(type) {
+	case nil:
+	case *[]byte:
+		*result = content
+	default:
+		if err := xml.Unmarshal(content, result); err != nil {
+			c.t.Fatalf("%s %s: %v: %s", method, path, err, content)
+		}
+	}
+	return resp.Header
+}
+
+func TestGatewayObjects(t *testing.T) {
+	c := newTestGateway(t)
+	defer c.Close()
+
+	c.expect(http.StatusNotFound, "PUT", "/bucket1/a.txt", []byte("a"), nil, nil)
+	c.expect(http.StatusOK, "PUT", "/bucket1", nil, nil, nil)
+	c.expect(http.StatusConflict, "PUT", "/bucket1", nil, nil, nil)
+	c.expect(http.StatusBadRequest, "PUT", "/Bucket_1", nil, nil, nil)
+	// the archive extensions would create LZFS Buckets
+	c.expect(http.StatusBadRequest, "PUT", "/bucket1.zip", nil, nil, nil)
+	c.expect(http.StatusBadRequest, "PUT", "/my.bucket", nil, nil, nil)
+
+	var buckets listAllMyBucketsResult
+	c.expect(http.StatusOK, "GET", "/", nil, nil, &buckets)
+	if len(buckets.Buckets) != 1 || buckets.Buckets[0].Name != "bucket1" {
+		t.Errorf("ListBuckets: %+v", buckets.Buckets)
+	}
+
+	content := []byte("0123456789")
+	header := c.expect(http.StatusOK, "PUT", "/bucket1/docs/a.txt", content, nil, nil)
+	if header.Get("ETag") != etag(sha256Hex(content)) {
+		t.Errorf("PutObject ETag: %s", header.Get("ETag"))
+	}
+	for _, key := range []string{"docs/b.txt", "docs/2018/c.txt", "d.txt"} {
+		c.expect(http.StatusOK, "PUT", "/bucket1/"+key, []byte(key), nil, nil)
+	}
+
+	var got []byte
+	c.expect(http.StatusOK, "GET", "/bucket1/docs/a.txt", nil, nil, &got)
+	if !bytes.Equal(got, content) {
+		t.Errorf("GetObject: %q, want %q", got, content)
+	}
+	header = c.expect(http.StatusPartialContent, "GET", "/bucket1/docs/a.txt", nil,
+		http.Header{"Range": {"bytes=2-4"}}, &got)
+	if string(got) != "234" || header.Get("Content-Range") != "bytes 2-4/10" {
+		t.Errorf("GetObject range: %q, %s", got, header.Get("Content-Range"))
+	}
+	header = c.expect(http.StatusOK, "HEAD", "/bucket1/docs/a.txt", nil, nil, nil)
+	if header.Get("Content-Length") != "10" {
+		t.Errorf("HeadObject Content-Length: %s", header.Get("Content-Length"))
+	}
+	c.expect(http.StatusNotFound, "GET", "/bucket1/missing.txt", nil, nil, nil)
+	c.expect(http.StatusNotModified, "GET", "/bucket1/docs/a.txt", nil,
+		http.Header{"If-None-Match": {etag(sha256Hex(content))}}, nil)
+
+	// conditional put
+	c.expect(http.StatusPreconditionFailed, "PUT", "/bucket1/d.txt", []byte("new"),
+		http.Header{"If-None-Match": {"*"}}, nil)
+	c.expect(http.StatusPreconditionFailed, "PUT", "/bucket1/d.txt", []byte("new"),
+		http.Header{"If-Match": {etag(sha256Hex([]byte("other")))}}, nil)
+	c.expect(http.StatusOK, "PUT", "/bucket1/d.txt", []byte("new"),
+		http.Header{"If-Match": {etag(sha256Hex([]byte("d.txt")))}}, nil)
+
+	// the signed payload hash doesn't match the content
+	c.expect(http.StatusBadRequest, "PUT", "/bucket1/e.txt", []byte("e"),
+		http.Header{"X-Amz-Content-Sha256": {sha256Hex([]byte("f"))}}, nil)
+	c.expect(http.StatusBadRequest, "PUT", "/bucket1/e.txt", []byte("e"),
+		http.Header{"Content-Md5": {"1B2M2Y8AsgTpgAmY7PhCfg=="}}, nil)
+	c.expect(http.StatusNotFound, "HEAD", "/bucket1/e.txt", nil, nil, nil)
+	c.expect(http.StatusBadRequest, "PUT", "/bucket1/docs//x.txt", []byte("x"), nil, nil)
+
+	var list listBucketResult
+	c.expect(http.StatusOK, "GET", "/bucket1?list-type=2&delimiter=/&prefix=docs/", nil, nil, &list)
+	if len(list.Contents) != 2 || list.Contents[0].Key != "docs/a.txt" ||
+		len(list.CommonPrefixes) != 1 || list.CommonPrefixes[0].Prefix != "docs/2018/" {
+		t.Errorf("ListObjectsV2 with delimiter: %+v", list)
+	}
+
+	var keys []string
+	token := ""
+	for {
+		list = listBucketResult{}
+		c.expect(http.StatusOK, "GET", "/bucket1?list-type=2&max-keys=2&continuation-token="+token,
+			nil, nil, &list)
+		for _, obj := range list.Contents {
+			keys = append(keys, obj.Key)
+		}
+		if !list.IsTruncated {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+	want := []string{"d.txt", "docs/2018/c.txt", "docs/a.txt", "docs/b.txt"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("ListObjectsV2 pages: %v, want %v", keys, want)
+	}
+
+	c.expect(http.StatusConflict, "DELETE", "/bucket1", nil, nil, nil)
+
+	var deleted deleteResult
+	c.expect(http.StatusOK, "POST", "/bucket1?delete",
+		[]byte(`<Delete><Object><Key>docs/a.txt</Key></Object><Object><Key>docs/b.txt</Key></Object></Delete>`),
+		nil, &deleted)
+	if len(deleted.Deleted) != 2 || len(deleted.Errors) != 0 {
+		t.Errorf("DeleteObjects: %+v", deleted)
+	}
+	for _, key := range []string{"docs/2018/c.txt", "d.txt", "missing.txt"} {
+		c.expect(http.StatusNoContent, "DELETE", "/bucket1/"+key, nil, nil, nil)
+	}
+	c.expect(http.StatusNoContent, "DELETE", "/bucket1", nil, nil, nil)
+	c.expect(http.StatusNotFound, "HEAD", "/bucket1", nil, nil, nil)
+}
+
+func TestStorageError(t *testing.T) {
+	tests := []struct {
+		err      error
+		notFound *apiError
+		want     string
+	}{
+		{core.ErrNotFound, nil, errNoSuchBucket.Code},
+		{core.ErrNotFound, errNoSuchKey, errNoSuchKey.Code},
+		{core.ErrAlreadyExists, nil, errBucketAlreadyExists.Code},
+		{core.ErrNotMounted, nil, errInvalidBucketState.Code},
+		{core.ErrMounted, errNoSuchKey, errInvalidBucketState.Code},
+		{core.ErrPrecondition, nil, errPreconditionFailed.Code},
+		{core.ErrBusy, nil, errOperationAborted.Code},
+		{core.ErrInvalid, errNoSuchKey, errInvalidArgument.Code},
+		{core.ErrPassword, nil, errInvalidBucketState.Code},
+		{core.ErrIntegrity, nil, errInternal.Code},
+		{errors.New("other"), errNoSuchKey, errInternal.Code},
+	}
+	for _, test := range tests {
+		err := storageError(fmt.Errorf("wrapped: %w", test.err), test.notFound)
+		if apiErr, ok := err.(*apiError); !ok || apiErr.Code != test.want {
+			t.Errorf("Error %v: %v, want %s", test.err, err, test.want)
+		}
+	}
+}
+
+func TestBucketLocks(t *testing.T) {
+	var locks bucketLocks
+
+	unlockDelete := locks.lock("deleted", true)
+	// the writes to other Buckets are not blocked by the delete
+	done := make(chan bool)
+	go func() {
+		unlock := locks.lock("other", false)
+		unlock()
+		unlock = locks.lock("other", true)
+		unlock()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Lock of another Bucket is blocked")
+	}
+
+	// the write to the deleted Bucket waits
+	go func() {
+		unlock := locks.lock("deleted", false)
+		unlock()
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatalf("Write is not blocked by the delete")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockDelete()
+	<-done
+
+	if len(locks.locks) != 0 {
+		t.Errorf("Unused locks are left: %v", locks.locks)
+	}
+}
+
+func TestGatewayCreateBucketErrors(t *testing.T) {
+	c := newTestGateway(t)
+	defer c.Close()
+
+	// the origin exists, but it's not a Bucket
+	if err := os.MkdirAll(os.Getenv("HOME")+"/.local/share/wize/fs/plain", 0755); err != nil {
+		t.Fatal(err)
+	}
+	var resp errorResponse
+	c.expect(http.StatusConflict, "PUT", "/plain", nil, nil, &resp)
+	if resp.Code != errBucketAlreadyExists.Code {
+		t.Errorf("Create over existing origin: %s", resp.Code)
+	}
+	c.expect(http.StatusNotFound, "GET", "/plain", nil, nil, &resp)
+	if resp.Code != errNoSuchBucket.Code {
+		t.Errorf("List of unknown bucket: %s", resp.Code)
+	}
+}
+
+func TestGatewayMultipartUpload(t *testing.T) {
+	c := newTestGateway(t)
+	defer c.Close()
+
+	c.expect(http.StatusOK, "PUT", "/bucket1", nil, nil, nil)
+
+	var initiated initiateMultipartUploadResult
+	c.expect(http.StatusOK, "POST", "/bucket1/big.bin?uploads", nil, nil, &initiated)
+	id := initiated.UploadID
+
+	parts := [][]byte{bytes.Repeat([]byte("a"), minPartSize), []byte("tail")}
+	var etags []string
+	for i, part := range parts {
+		header := c.expect(http.StatusOK, "PUT",
+			fmt.Sprintf("/bucket1/big.bin?partNumber=%d&uploadId=%s", i+1, id), part, nil, nil)
+		etags = append(etags, header.Get("ETag"))
+	}
+
+	var listed listPartsResult
+	c.expect(http.StatusOK, "GET", "/bucket1/big.bin?uploadId="+id, nil, nil, &listed)
+	if len(listed.Parts) != 2 || listed.Parts[1].Size != 4 {
+		t.Errorf("ListParts: %+v", listed.Parts)
+	}
+
+	complete := func(first, second int) []byte {
+		return []byte(fmt.Sprintf(`<CompleteMultipartUpload>`+
+			`<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>`+
+			`<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>`+
+			`</CompleteMultipartUpload>`, first, etags[first-1], second, etags[second-1]))
+	}
+	c.expect(http.StatusBadRequest, "POST", "/bucket1/big.bin?uploadId="+id, complete(2, 1), nil, nil)
+	c.expect(http.StatusBadRequest, "POST", "/bucket1/big.bin?uploadId="+id,
+		bytes.Replace(complete(1, 2), []byte(etags[1]), []byte(`"00"`), 1), nil, nil)
+
+	var result completeMultipartUploadResult
+	c.expect(http.StatusOK, "POST", "/bucket1/big.bin?uploadId="+id, complete(1, 2), nil, &result)
+	content := bytes.Join(parts, nil)
+	if result.ETag != etag(sha256Hex(content)) {
+		t.Errorf("CompleteMultipartUpload ETag: %s", result.ETag)
+	}
+
+	header := c.expect(http.StatusOK, "HEAD", "/bucket1/big.bin", nil, nil, nil)
+	if header.Get("Content-Length") != fmt.Sprint(len(content)) || header.Get("ETag") != result.ETag {
+		t.Errorf("HeadObject of the uploaded object: %v", header)
+	}
+	c.expect(http.StatusNotFound, "GET", "/bucket1/big.bin?uploadId="+id, nil, nil, nil)
+
+	// abort
+	c.expect(http.StatusOK, "POST", "/bucket1/other.bin?uploads", nil, nil, &initiated)
+	c.expect(http.StatusOK, "PUT", "/bucket1/other.bin?partNumber=1&uploadId="+initiated.UploadID,
+		[]byte("x"), nil, nil)
+	c.expect(http.StatusNoContent, "DELETE", "/bucket1/other.bin?uploadId="+initiated.UploadID, nil, nil, nil)
+	c.expect(http.StatusNotFound, "DELETE", "/bucket1/other.bin?uploadId="+initiated.UploadID, nil, nil, nil)
+	c.expect(http.StatusNotFound, "HEAD", "/bucket1/other.bin", nil, nil, nil)
+}
+
+func TestGatewayAuthentication(t *testing.T) {
+	c := newTestGateway(t)
+	defer c.Close()
+
+	resp, err := http.Get(c.server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Anonymous request: status %d", resp.StatusCode)
+	}
+
+	c.secret = "wrong"
+	var response errorResponse
+	c.expect(http.StatusForbidden, "GET", "/", nil, nil, &response)
+	if response.Code != errSignatureMismatch.Code {
+		t.Errorf("Wrong secret key: %s", response.Code)
+	}
+}
+
+func TestGatewayACL(t *testing.T) {
+	c := newTestGateway(t)
+	defer c.Close()
+
+	c.expect(http.StatusOK, "PUT", "/admin-bucket", nil, nil, nil)
+	c.expect(http.StatusOK, "PUT", "/admin-bucket/a.txt", []byte("a"), nil, nil)
+
+	c.as(userAccessKey, userSecretKey)
+	var response errorResponse
+	c.expect(http.StatusForbidden, "GET", "/admin-bucket/a.txt", nil, nil, &response)
+	if response.Code != errAccessDenied.Code {
+		t.Errorf("GetObject without access: %s", response.Code)
+	}
+	// unknown buckets are not revealed
+	c.expect(http.StatusForbidden, "GET", "/nothing", nil, nil, nil)
+	c.expect(http.StatusConflict, "PUT", "/admin-bucket", nil, nil, &response)
+	if response.Code != errBucketAlreadyExists.Code {
+		t.Errorf("CreateBucket of another user's bucket: %s", response.Code)
+	}
+
+	// the user owns the created bucket and sees only it
+	c.expect(http.StatusOK, "PUT", "/user-bucket", nil, nil, nil)
+	c.expect(http.StatusOK, "PUT", "/user-bucket/b.txt", []byte("b"), nil, nil)
+	var buckets listAllMyBucketsResult
+	c.expect(http.StatusOK, "GET", "/", nil, nil, &buckets)
+	if len(buckets.Buckets) != 1 || buckets.Buckets[0].Name != "user-bucket" {
+		t.Errorf("ListBuckets of the user: %+v", buckets.Buckets)
+	}
+
+	// read access doesn't let write
+	if _, err := c.storage.SetACL("admin-bucket",
+		&core.BucketACL{Owner: "admin", Read: []string{"user"}}); err != nil {
+		t.Fatal(err)
+	}
+	var content []byte
+	c.expect(http.StatusOK, "GET", "/admin-bucket/a.txt", nil, nil, &content)
+	if string(content) != "a" {
+		t.Errorf("GetObject with read access: %q", content)
+	}
+	c.expect(http.StatusForbidden, "PUT", "/admin-bucket/b.txt", []byte("b"), nil, nil)
+	c.expect(http.StatusForbidden, "DELETE", "/admin-bucket/a.txt", nil, nil, nil)
+	c.expect(http.StatusForbidden, "DELETE", "/admin-bucket", nil, nil, nil)
+}
@@ -0,0 +1,424 @@
+package s3
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+const (
+	// uploadFilename describes the multipart upload in its directory
+	uploadFilename = "upload.json"
+	// minPartSize is the smallest part of S3 except the last one
+	minPartSize = 5 << 20
+	// maxPartNumber is the largest part number of S3
+	maxPartNumber = 10000
+)
+
+// upload is the multipart upload in progress, its parts are the files
+// of the upload directory named by their numbers
+type upload struct {
+	ID        string    `json:"-"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Initiated time.Time `json:"initiated"`
+}
+
+func (g *Gateway) uploadDir(id string) string {
+	return filepath.Join(g.uploadsDir, id)
+}
+
+func partFilename(number int) string {
+	return fmt.Sprintf("%05d", number)
+}
+
+// upload returns the multipart upload of the object by its ID
+func (g *Gateway) upload(bucket, key, id string) (*upload, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, errNoSuchUpload
+	}
+	data, err := ioutil.ReadFile(filepath.Join(g.uploadDir(id), uploadFilename))
+	if err != nil {
+		return nil, errNoSuchUpload
+	}
+	u := &upload{ID: id}
+	if err = json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("invalid upload %s: %v", id, err)
+	}
+	if u.Bucket != bucket || key != "" && u.Key != key {
+		return nil, errNoSuchUpload
+	}
+	return u, nil
+}
+
+// uploads returns the multipart uploads of the bucket sorted by key
+func (g *Gateway) uploads(bucket string) (uploads []*upload) {
+	infos, _ := ioutil.ReadDir(g.uploadsDir)
+	for _, info := range infos {
+		if u, err := g.upload(bucket, "", info.Name()); err == nil {
+			uploads = append(uploads, u)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].Initiated.Before(uploads[j].Initiated)
+	})
+	return uploads
+}
+
+// removeUploads aborts the multipart uploads of the deleted bucket
+func (g *Gateway) removeUploads(bucket string) {
+	for _, u := range g.uploads(bucket) {
+		if err := os.RemoveAll(g.uploadDir(u.ID)); err != nil {
+			tlog.Warn.Printf("Removing upload %s failed: %v", u.ID, err)
+		}
+	}
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string
+	Key      string
+	UploadID string `xml:"UploadId"`
+}
+
+func (g *Gateway) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if err := checkKey(key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	_, unlock, err := g.writeBucket(bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer unlock()
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	u := upload{
+		ID:        hex.EncodeToString(id[:]),
+		Bucket:    bucket,
+		Key:       key,
+		Initiated: g.now().UTC(),
+	}
+	data, err := json.Marshal(u)
+	if err == nil {
+		err = os.MkdirAll(g.uploadDir(u.ID), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(g.uploadDir(u.ID), uploadFilename), data, 0600)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: u.ID,
+	})
+}
+
+func (g *Gateway) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key, id string) {
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || number < 1 || number > maxPartNumber {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	u, err := g.upload(bucket, key, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	body, err := newBodyReader(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// the part is renamed when it's complete, so Complete never sees a
+	// partially written part
+	file, err := ioutil.TempFile(g.uploadDir(u.ID), "part")
+	if err != nil {
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(g.uploadDir(u.ID), partFilename(number)))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		if body.err != nil {
+			writeError(w, r, body.error(nil))
+			return
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(body.checksum()))
+	w.WriteHeader(http.StatusOK)
+}
+
+// part is the uploaded part of the multipart upload
+type part struct {
+	Number   int
+	Size     int64
+	ModTime  time.Time
+	Checksum string
+}
+
+// parts returns the parts of the upload sorted by their numbers
+func (g *Gateway) parts(u *upload) (parts []part, err error) {
+	infos, err := ioutil.ReadDir(g.uploadDir(u.ID))
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		number, err := strconv.Atoi(info.Name())
+		if err != nil || partFilename(number) != info.Name() {
+			continue
+		}
+		checksum, err := fileChecksum(filepath.Join(g.uploadDir(u.ID), info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part{
+			Number:   number,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Checksum: checksum,
+		})
+	}
+	return parts, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type partEntry struct {
+	PartNumber   int
+	LastModified string
+	ETag         string
+	Size         int64
+}
+
+type listPartsResult struct {
+	XMLName     xml.Name `xml:"ListPartsResult"`
+	Xmlns       string   `xml:"xmlns,attr"`
+	Bucket      string
+	Key         string
+	UploadID    string `xml:"UploadId"`
+	IsTruncated bool
+	Parts       []partEntry `xml:"Part"`
+}
+
+func (g *Gateway) listParts(w http.ResponseWriter, r *http.Request, bucket, key, id string) {
+	u, err := g.upload(bucket, key, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	parts, err := g.parts(u)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	result := listPartsResult{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadID: id}
+	for _, p := range parts {
+		result.Parts = append(result.Parts, partEntry{
+			PartNumber:   p.Number,
+			LastModified: timestamp(p.ModTime),
+			ETag:         etag(p.Checksum),
+			Size:         p.Size,
+		})
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+// completeMultipartUpload puts the object made of the listed parts and
+// removes the upload. The ETag of the object is the SHA-256 of its
+// content like the ETag of PutObject.
+func (g *Gateway) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, id string) {
+	u, err := g.upload(bucket, key, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var request completeMultipartUpload
+	if err = readXML(r, &request); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(request.Parts) == 0 {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+
+	uploaded, err := g.parts(u)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	byNumber := make(map[int]part)
+	for _, p := range uploaded {
+		byNumber[p.Number] = p
+	}
+
+	var readers []io.Reader
+	for i, requested := range request.Parts {
+		if i > 0 && requested.PartNumber <= request.Parts[i-1].PartNumber {
+			writeError(w, r, errInvalidPartOrder)
+			return
+		}
+		p, ok := byNumber[requested.PartNumber]
+		if !ok || strings.Trim(requested.ETag, `"`) != p.Checksum {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+		if p.Size < minPartSize && i < len(request.Parts)-1 {
+			writeError(w, r, errEntityTooSmall)
+			return
+		}
+
+		file, err := os.Open(filepath.Join(g.uploadDir(id), partFilename(p.Number)))
+		if err != nil {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+
+	b, unlock, err := g.writeBucket(bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer unlock()
+	opts := putOptions(r)
+	if err = opts.Check(); err != nil {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	hash := sha256.New()
+	reader := io.TeeReader(io.MultiReader(readers...), hash)
+	if _, err := b.PutFileStreamWithOptions(key, reader, opts); err != nil {
+		writeError(w, r, storageError(err, nil))
+		return
+	}
+	if err = os.RemoveAll(g.uploadDir(id)); err != nil {
+		tlog.Warn.Printf("Removing upload %s failed: %v", id, err)
+	}
+
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     etag(hex.EncodeToString(hash.Sum(nil))),
+	})
+}
+
+func (g *Gateway) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, id string) {
+	u, err := g.upload(bucket, key, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = os.RemoveAll(g.uploadDir(u.ID)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type uploadEntry struct {
+	Key       string
+	UploadID  string `xml:"UploadId"`
+	Initiated string
+}
+
+type listMultipartUploadsResult struct {
+	XMLName     xml.Name `xml:"ListMultipartUploadsResult"`
+	Xmlns       string   `xml:"xmlns,attr"`
+	Bucket      string
+	IsTruncated bool
+	Uploads     []uploadEntry `xml:"Upload"`
+}
+
+func (g *Gateway) listMultipartUploads(w http.ResponseWriter, r *http.Request, bucket string) {
+	if _, err := g.bucket(bucket); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+
+	result := listMultipartUploadsResult{Xmlns: s3Namespace, Bucket: bucket}
+	for _, u := range g.uploads(bucket) {
+		if !strings.HasPrefix(u.Key, prefix) {
+			continue
+		}
+		result.Uploads = append(result.Uploads, uploadEntry{
+			Key:       u.Key,
+			UploadID:  u.ID,
+			Initiated: timestamp(u.Initiated),
+		})
+	}
+	writeXML(w, http.StatusOK, result)
+}
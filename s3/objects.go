@@ -0,0 +1,384 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/core"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+)
+
+// maxKeyLength is the longest key of S3
+const maxKeyLength = 1024
+
+// checkKey accepts the keys that are paths of the Bucket as they are, keys
+// that core would change or refuse (a//b, a/, ../a, the fragment store)
+// can't be stored.
+func checkKey(key string) error {
+	if len(key) > maxKeyLength || key != path.Clean(key) || path.IsAbs(key) ||
+		key == "." || key == ".." || strings.HasPrefix(key, "../") {
+		return errInvalidArgument
+	}
+	// the fragment store and the config of the Bucket
+	if strings.SplitN(key, "/", 2)[0] == ".wizefs" || key == core.BucketConfigFilename {
+		return errInvalidArgument
+	}
+	return nil
+}
+
+// bodyReader hashes the content of the request and keeps the first error
+// of the request body, so the errors of the content are told apart from
+// the errors of the Bucket
+type bodyReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	err    error
+}
+
+func newBodyReader(r *http.Request) (*bodyReader, error) {
+	body := &bodyReader{reader: r.Body, hash: sha256.New()}
+	if value := r.Header.Get("Content-Md5"); value != "" {
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(sum) != md5.Size {
+			return nil, errInvalidDigest
+		}
+		body.reader = newHashReader(body.reader, md5.New(), hex.EncodeToString(sum), errBadDigest)
+	}
+	return body, nil
+}
+
+func (b *bodyReader) Read(p []byte) (n int, err error) {
+	n, err = b.reader.Read(p)
+	b.hash.Write(p[:n])
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// checksum is the ETag of the content read
+func (b *bodyReader) checksum() string {
+	return hex.EncodeToString(b.hash.Sum(nil))
+}
+
+// error returns the error of the request body or the storage error
+func (b *bodyReader) error(err error) error {
+	if b.err != nil {
+		if _, ok := b.err.(*apiError); ok {
+			return b.err
+		}
+		return errIncompleteBody
+	}
+	return storageError(err, nil)
+}
+
+// putOptions replaces the object unless the request is conditional
+func putOptions(r *http.Request) core.PutOptions {
+	opts := core.PutOptions{
+		IfMatch:     strings.Trim(r.Header.Get("If-Match"), `"`),
+		IfNoneMatch: strings.Trim(r.Header.Get("If-None-Match"), `"`),
+	}
+	opts.Overwrite = opts.IfMatch == "" && opts.IfNoneMatch == ""
+	return opts
+}
+
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, name, key string) {
+	if err := checkKey(key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	bucket, unlock, err := g.writeBucket(name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer unlock()
+	opts := putOptions(r)
+	if err = opts.Check(); err != nil {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	body, err := newBodyReader(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if _, err := bucket.PutFileStreamWithOptions(key, body, opts); err != nil {
+		writeError(w, r, body.error(err))
+		return
+	}
+
+	w.Header().Set("ETag", etag(body.checksum()))
+	w.WriteHeader(http.StatusOK)
+}
+
+// getObject is GetObject, HeadObject if withContent is false
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, name, key string, withContent bool) {
+	bucket, err := g.bucket(name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if checkKey(key) != nil {
+		writeError(w, r, errNoSuchKey)
+		return
+	}
+	info, _, err := bucket.Stat(key)
+	if err != nil {
+		writeError(w, r, storageError(err, errNoSuchKey))
+		return
+	}
+	if info.IsDir {
+		writeError(w, r, errNoSuchKey)
+		return
+	}
+
+	header := w.Header()
+	header.Set("ETag", etag(info.Checksum))
+	header.Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+
+	if status := checkConditions(r, info); status != 0 {
+		if status == http.StatusPreconditionFailed {
+			writeError(w, r, errPreconditionFailed)
+			return
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	start, length := int64(0), info.Size
+	status := http.StatusOK
+	if value := r.Header.Get("Range"); value != "" {
+		var ok bool
+		if start, length, ok = parseRange(value, info.Size); !ok {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			writeError(w, r, errInvalidRange)
+			return
+		}
+		status = http.StatusPartialContent
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size))
+	}
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+
+	if !withContent {
+		w.WriteHeader(status)
+		return
+	}
+
+	reader, _, err := bucket.GetFileStream(key)
+	if err != nil {
+		writeError(w, r, storageError(err, errNoSuchKey))
+		return
+	}
+	defer reader.Close()
+	if _, err = io.CopyN(ioutil.Discard, reader, start); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	if _, err = io.CopyN(w, reader, length); err != nil {
+		// the headers are sent already, the client sees the short content
+		tlog.Warn.Printf("S3 GET %s/%s: %v", name, key, err)
+	}
+}
+
+// checkConditions checks the conditional headers of GetObject and returns
+// the status of the response if they don't hold, 0 otherwise
+func checkConditions(r *http.Request, info core.FileInfo) int {
+	modified := info.ModTime.Truncate(time.Second)
+	if value := r.Header.Get("If-Match"); value != "" {
+		if !matchETag(value, info.Checksum) {
+			return http.StatusPreconditionFailed
+		}
+	} else if value := r.Header.Get("If-Unmodified-Since"); value != "" {
+		if t, err := http.ParseTime(value); err == nil && modified.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if value := r.Header.Get("If-None-Match"); value != "" {
+		if matchETag(value, info.Checksum) {
+			return http.StatusNotModified
+		}
+	} else if value := r.Header.Get("If-Modified-Since"); value != "" {
+		if t, err := http.ParseTime(value); err == nil && !modified.After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// matchETag checks if the ETag list of the header has checksum
+func matchETag(value, checksum string) bool {
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.Trim(strings.TrimSpace(tag), `"`)
+		if tag == core.AnyETag || tag == checksum {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses the single byte range of the Range header
+func parseRange(value string, size int64) (start, length int64, ok bool) {
+	if !strings.HasPrefix(value, "bytes=") || strings.Contains(value, ",") {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(value, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+
+	if bounds[0] == "" {
+		// the last bytes
+		suffix, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil || suffix <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, true
+	}
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if bounds[1] != "" {
+		if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
+
+func (g *Gateway) deleteObject(w http.ResponseWriter, r *http.Request, name, key string) {
+	bucket, err := g.bucket(name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err = deleteKey(bucket, key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteKey removes the object, deleting a missing key succeeds like in S3
+func deleteKey(bucket *core.Bucket, key string) error {
+	if checkKey(key) != nil {
+		return nil
+	}
+	info, _, err := bucket.Stat(key)
+	if err != nil {
+		err = storageError(err, errNoSuchKey)
+		if err == errNoSuchKey {
+			return nil
+		}
+		return err
+	}
+	if info.IsDir {
+		return nil
+	}
+	if _, err = bucket.RemoveFile(key); err != nil && !errors.Is(err, core.ErrNotFound) {
+		return storageError(err, nil)
+	}
+	return nil
+}
+
+type deleteRequest struct {
+	Quiet   bool
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key string
+}
+
+type deleteError struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+// deleteObjects is DeleteObjects, the errors of single keys are reported in
+// the result
+func (g *Gateway) deleteObjects(w http.ResponseWriter, r *http.Request, name string) {
+	bucket, err := g.bucket(name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	body, err := newBodyReader(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	r.Body = ioutil.NopCloser(body)
+
+	var request deleteRequest
+	if err = readXML(r, &request); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(request.Objects) == 0 || len(request.Objects) > maxKeys {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+
+	result := deleteResult{Xmlns: s3Namespace}
+	for _, obj := range request.Objects {
+		if err := deleteKey(bucket, obj.Key); err != nil {
+			apiErr, ok := err.(*apiError)
+			if !ok {
+				apiErr = errInternal
+			}
+			result.Errors = append(result.Errors, deleteError{
+				Key:     obj.Key,
+				Code:    apiErr.Code,
+				Message: apiErr.Message,
+			})
+			continue
+		}
+		if !request.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: obj.Key})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
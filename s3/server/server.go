@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"bitbucket.org/udt/wizefs/internal/auth"
+	"bitbucket.org/udt/wizefs/internal/core"
+	"bitbucket.org/udt/wizefs/internal/tlog"
+	"bitbucket.org/udt/wizefs/s3"
+)
+
+var (
+	port       = flag.Int("port", 9000, "The server port")
+	configPath = flag.String("config", "", "The config with the access keys, "+
+		"default is "+s3.ConfigFilename+" in the Storage directory")
+	authConfigPath = flag.String("auth_config", "", "The config with the users of the access keys, "+
+		"default is "+auth.ConfigFilename+" in the Storage directory")
+)
+
+func main() {
+	flag.Parse()
+
+	storage := core.NewStorage()
+	if *configPath == "" {
+		*configPath = storage.DirPath + s3.ConfigFilename
+	}
+	config, err := s3.LoadConfig(*configPath)
+	if err != nil {
+		tlog.Fatal.Printf("failed to load config: %v", err)
+		os.Exit(1)
+	}
+	if *authConfigPath == "" {
+		*authConfigPath = storage.DirPath + auth.ConfigFilename
+	}
+	authConfig, err := auth.LoadConfig(*authConfigPath)
+	if err != nil {
+		tlog.Fatal.Printf("failed to load auth config: %v"+
+			" (create the first user with `wizefs_cli auth-key --admin USER`)", err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("localhost:%d", *port),
+		Handler: s3.NewGateway(storage, config, authConfig),
+	}
+
+	// Buckets are mounted inside this process, so we should unmount them
+	// before exit
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-terminate
+		tlog.Info.Println("s3 server exiting")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	if err = server.ListenAndServe(); err != http.ErrServerClosed {
+		tlog.Fatal.Printf("failed to serve: %v", err)
+	}
+	storage.Close()
+}